@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/handler"
+)
+
+type AuthService struct {
+	client *Client
+}
+
+func (s *AuthService) Register(ctx context.Context, u *handler.UserReq) (*handler.UserRes, error) {
+	var res handler.UserRes
+	if err := s.client.do(ctx, request{method: http.MethodPost, path: "/auth/register", body: u}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Login starts a session whose tokens sign the requests of the client from
+// then on. Users with two-factor authentication get a *MFARequiredError
+// instead and finish the login with LoginMFA.
+func (s *AuthService) Login(ctx context.Context, email, password string) (*handler.LoginUserRes, error) {
+	var res struct {
+		handler.LoginUserRes
+		handler.MFAChallengeRes
+	}
+	err := s.client.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/auth/login",
+		body:   handler.LoginUserReq{Email: email, Password: password},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.MFARequired {
+		return nil, &MFARequiredError{Challenge: res.MFAChallengeRes}
+	}
+
+	s.startSession(&res.LoginUserRes)
+	return &res.LoginUserRes, nil
+}
+
+func (s *AuthService) LoginMFA(ctx context.Context, req *handler.LoginMFAReq) (*handler.LoginUserRes, error) {
+	var res handler.LoginUserRes
+	if err := s.client.do(ctx, request{method: http.MethodPost, path: "/auth/login/mfa", body: req}, &res); err != nil {
+		return nil, err
+	}
+
+	s.startSession(&res)
+	return &res, nil
+}
+
+// Refresh replaces the tokens of the session. The client does this on its
+// own before the access token expires.
+func (s *AuthService) Refresh(ctx context.Context) error {
+	return s.client.refresh(ctx, s.client.Tokens().AccessToken)
+}
+
+// Logout ends the session and forgets its tokens.
+func (s *AuthService) Logout(ctx context.Context) error {
+	err := s.client.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/auth/logout",
+		body:   handler.LogoutUserReq{RefreshToken: s.client.Tokens().RefreshToken},
+		auth:   true,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	s.client.setTokens(Tokens{})
+	return nil
+}
+
+func (s *AuthService) startSession(res *handler.LoginUserRes) {
+	s.client.setTokens(Tokens{
+		SessionID:             res.SessionID,
+		AccessToken:           res.AccessToken,
+		AccessTokenExpiresAt:  res.AccessTokenExpiresAt,
+		RefreshToken:          res.RefreshToken,
+		RefreshTokenExpiresAt: res.RefreshTokenExpiresAt,
+	})
+}
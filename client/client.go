@@ -0,0 +1,300 @@
+// Package client is a typed client for the micropanel REST API. It signs
+// requests with the tokens of a session, refreshes them before they expire
+// and retries requests that are safe to repeat.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/handler"
+)
+
+// refreshBefore is how long before it expires an access token is replaced.
+const refreshBefore = 30 * time.Second
+
+// Tokens are the credentials of a session. They are set by Auth().Login and
+// kept up to date by the client; persist them with OnTokens to reuse the
+// session across processes.
+type Tokens struct {
+	SessionID             string
+	AccessToken           string
+	AccessTokenExpiresAt  time.Time
+	RefreshToken          string
+	RefreshTokenExpiresAt time.Time
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	tenant     string
+	apiKey     string
+	maxRetries int
+	backoff    time.Duration
+	onTokens   func(Tokens)
+
+	// refreshMu serializes refreshes so that concurrent requests do not
+	// spend the same refresh token twice.
+	refreshMu sync.Mutex
+	mu        sync.Mutex
+	tokens    Tokens
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithTenant selects the tenant with the given slug through the X-Tenant
+// header.
+func WithTenant(slug string) Option {
+	return func(c *Client) { c.tenant = slug }
+}
+
+// WithAPIKey authenticates every request with an API key instead of the
+// tokens of a session.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+func WithTokens(tokens Tokens) Option {
+	return func(c *Client) { c.tokens = tokens }
+}
+
+// WithRetries sets how often requests that are safe to repeat are retried
+// after network errors and 502, 503 and 504 responses. The wait before the
+// nth retry is backoff doubled n-1 times. Defaults to 2 retries after 100ms.
+func WithRetries(max int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = max
+		c.backoff = backoff
+	}
+}
+
+// OnTokens is called with the new tokens after every login and refresh.
+func OnTokens(fn func(Tokens)) Option {
+	return func(c *Client) { c.onTokens = fn }
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		maxRetries: 2,
+		backoff:    100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+func (c *Client) Products() *ProductService { return &ProductService{c} }
+func (c *Client) Orders() *OrderService     { return &OrderService{c} }
+func (c *Client) Auth() *AuthService        { return &AuthService{c} }
+
+// Tokens returns the tokens of the current session, if any.
+func (c *Client) Tokens() Tokens {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.tokens
+}
+
+func (c *Client) setTokens(tokens Tokens) {
+	c.mu.Lock()
+	c.tokens = tokens
+	c.mu.Unlock()
+
+	if c.onTokens != nil {
+		c.onTokens(tokens)
+	}
+}
+
+type request struct {
+	method string
+	path   string
+	body   interface{}
+	header http.Header
+	// auth signs the request with the credentials of the client.
+	auth bool
+	// retry marks requests that are safe to send more than once.
+	retry bool
+}
+
+// do sends req and decodes the response body into out, unless out is nil.
+// Responses with an error status are returned as *Error.
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return fmt.Errorf("error encoding request body: %w", err)
+		}
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		var accessToken string
+		if req.auth && c.apiKey == "" {
+			var err error
+			accessToken, err = c.accessToken(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		res, err := c.send(ctx, req, body, accessToken)
+		if err != nil {
+			if ctx.Err() == nil && req.retry && attempt < c.maxRetries {
+				if err := c.wait(ctx, attempt); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+
+		switch {
+		case res.StatusCode == http.StatusUnauthorized && accessToken != "" && !refreshed:
+			// The token may have been revoked or the clock may be off;
+			// a fresh one is worth one more try.
+			res.Body.Close()
+			if err := c.refresh(ctx, accessToken); err != nil {
+				return err
+			}
+			refreshed = true
+			attempt--
+			continue
+		case retryableStatus(res.StatusCode) && req.retry && attempt < c.maxRetries:
+			res.Body.Close()
+			if err := c.wait(ctx, attempt); err != nil {
+				return err
+			}
+			continue
+		}
+
+		defer res.Body.Close()
+		if res.StatusCode >= http.StatusBadRequest {
+			return decodeError(res)
+		}
+
+		if out == nil || res.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return fmt.Errorf("error decoding response body: %w", err)
+		}
+		return nil
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, body []byte, accessToken string) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	hr, err := http.NewRequestWithContext(ctx, req.method, c.baseURL+req.path, r)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	for k, v := range req.header {
+		hr.Header[k] = v
+	}
+	if body != nil {
+		hr.Header.Set("Content-Type", "application/json")
+	}
+	if c.tenant != "" {
+		hr.Header.Set("X-Tenant", c.tenant)
+	}
+	switch {
+	case req.auth && c.apiKey != "":
+		hr.Header.Set("Authorization", "ApiKey "+c.apiKey)
+	case accessToken != "":
+		hr.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	return c.httpClient.Do(hr)
+}
+
+func (c *Client) wait(ctx context.Context, attempt int) error {
+	t := time.NewTimer(c.backoff << attempt)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+// accessToken returns the access token of the session, refreshing it first
+// if it is about to expire. Without a session it returns an empty token and
+// the request is sent anonymously.
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	tokens := c.Tokens()
+	if tokens.AccessToken == "" || time.Until(tokens.AccessTokenExpiresAt) > refreshBefore {
+		return tokens.AccessToken, nil
+	}
+
+	if err := c.refresh(ctx, tokens.AccessToken); err != nil {
+		return "", err
+	}
+	return c.Tokens().AccessToken, nil
+}
+
+// refresh replaces the access token stale with a new one, unless another
+// request already did.
+func (c *Client) refresh(ctx context.Context, stale string) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	tokens := c.Tokens()
+	if tokens.AccessToken != stale {
+		return nil
+	}
+	if tokens.RefreshToken == "" {
+		return ErrNoSession
+	}
+
+	var res handler.RenewAccessTokenRes
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/auth/refresh",
+		body:   handler.RenewAccessTokenReq{RefreshToken: tokens.RefreshToken},
+	}, &res)
+	if err != nil {
+		return fmt.Errorf("error refreshing access token: %w", err)
+	}
+
+	c.setTokens(Tokens{
+		SessionID:             res.SessionID,
+		AccessToken:           res.AccessToken,
+		AccessTokenExpiresAt:  res.AccessTokenExpiresAt,
+		RefreshToken:          res.RefreshToken,
+		RefreshTokenExpiresAt: res.RefreshTokenExpiresAt,
+	})
+	return nil
+}
+
+// ErrNoSession is returned when an access token needs to be refreshed but
+// the client has no refresh token.
+var ErrNoSession = errors.New("no session to refresh")
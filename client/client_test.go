@@ -0,0 +1,280 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Turtel216/micro-panel/client"
+	"github.com/Turtel216/micro-panel/micropanel-api/handler"
+	"github.com/Turtel216/micro-panel/micropanel-api/handler/handlertest"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyTransport answers the first failures requests that match with a 503
+// after the server has handled them, and records the requests it sends.
+type flakyTransport struct {
+	match    func(*http.Request) bool
+	failures int
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (ft *flakyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ft.mu.Lock()
+	ft.requests = append(ft.requests, r)
+	fail := ft.match(r) && ft.failures > 0
+	if fail {
+		ft.failures--
+	}
+	ft.mu.Unlock()
+
+	res, err := http.DefaultTransport.RoundTrip(r)
+	if err != nil || !fail {
+		return res, err
+	}
+	res.Body.Close()
+
+	return &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("unavailable")),
+		Request:    r,
+	}, nil
+}
+
+func (ft *flakyTransport) count(method, path string) int {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	n := 0
+	for _, r := range ft.requests {
+		if r.Method == method && r.URL.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+func login(t *testing.T, h *handlertest.Harness, opts ...client.Option) *client.Client {
+	t.Helper()
+
+	h.CreateUser(t, "admin@example.com", "password", true)
+	c := client.New(h.Server.URL, opts...)
+	_, err := c.Auth().Login(context.Background(), "admin@example.com", "password")
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestClient(t *testing.T) {
+	h := handlertest.New(t)
+	c := login(t, h)
+	ctx := context.Background()
+
+	created, err := c.Products().Create(ctx, &handler.ProductReq{Name: "test product", Price: 9.99, CountInStock: 5})
+	require.NoError(t, err)
+	require.NotZero(t, created.ID)
+
+	got, err := c.Products().Get(ctx, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, "test product", got.Name)
+
+	list, err := c.Products().List(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	updated, err := c.Products().Update(ctx, created.ID, &handler.ProductReq{CountInStock: 4})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), updated.CountInStock)
+
+	order, err := c.Orders().Create(ctx, &handler.OrderReq{
+		PaymentMethod: "card",
+		TotalPrice:    9.99,
+		Items:         []handler.OrderItem{{Name: "test product", Quantity: 1, Price: 9.99, ProductID: created.ID}},
+	})
+	require.NoError(t, err)
+
+	gotOrder, err := c.Orders().Get(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, "card", gotOrder.PaymentMethod)
+
+	_, err = c.Orders().Get(ctx, order.ID+1)
+	require.ErrorIs(t, err, client.ErrNotFound)
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, "order not found", apiErr.Message)
+
+	tokens := c.Tokens()
+	require.NoError(t, c.Auth().Logout(ctx))
+	require.Empty(t, c.Tokens().AccessToken)
+
+	// The access token of the session is denied after logging out, which
+	// leaves nothing to retry with once the refresh token is gone too.
+	tokens.RefreshToken = ""
+	_, err = client.New(h.Server.URL, client.WithTokens(tokens)).Orders().List(ctx)
+	require.ErrorIs(t, err, client.ErrNoSession)
+}
+
+func TestClientLoginErrors(t *testing.T) {
+	h := handlertest.New(t)
+	h.CreateUser(t, "user@example.com", "password", false)
+	c := client.New(h.Server.URL)
+
+	_, err := c.Auth().Login(context.Background(), "user@example.com", "wrong")
+	require.ErrorIs(t, err, client.ErrUnauthorized)
+	require.Empty(t, c.Tokens().AccessToken)
+}
+
+func TestClientRefreshesTokens(t *testing.T) {
+	t.Run("before the access token expires", func(t *testing.T) {
+		h := handlertest.New(t)
+		tokens := login(t, h).Tokens()
+
+		var refreshed []client.Tokens
+		tokens.AccessTokenExpiresAt = time.Now().Add(time.Second)
+		c := client.New(h.Server.URL, client.WithTokens(tokens), client.OnTokens(func(t client.Tokens) {
+			refreshed = append(refreshed, t)
+		}))
+
+		_, err := c.Orders().List(context.Background())
+		require.NoError(t, err)
+		require.Len(t, refreshed, 1)
+		require.NotEqual(t, tokens.RefreshToken, c.Tokens().RefreshToken)
+
+		_, err = c.Orders().List(context.Background())
+		require.NoError(t, err)
+		require.Len(t, refreshed, 1)
+	})
+
+	t.Run("after a rejected access token", func(t *testing.T) {
+		h := handlertest.New(t)
+		tokens := login(t, h).Tokens()
+
+		tokens.AccessToken = "invalid"
+		c := client.New(h.Server.URL, client.WithTokens(tokens))
+
+		_, err := c.Orders().List(context.Background())
+		require.NoError(t, err)
+		require.NotEqual(t, "invalid", c.Tokens().AccessToken)
+	})
+
+	t.Run("once for concurrent requests", func(t *testing.T) {
+		h := handlertest.New(t)
+		tokens := login(t, h).Tokens()
+
+		tokens.AccessTokenExpiresAt = time.Now()
+		c := client.New(h.Server.URL, client.WithTokens(tokens))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := c.Orders().List(context.Background())
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("fails without a session", func(t *testing.T) {
+		h := handlertest.New(t)
+		c := client.New(h.Server.URL, client.WithTokens(client.Tokens{AccessToken: "invalid", AccessTokenExpiresAt: time.Now().Add(time.Hour)}))
+
+		_, err := c.Orders().List(context.Background())
+		require.ErrorIs(t, err, client.ErrNoSession)
+	})
+}
+
+func TestClientRetries(t *testing.T) {
+	t.Run("idempotent requests", func(t *testing.T) {
+		h := handlertest.New(t)
+		ft := &flakyTransport{match: func(r *http.Request) bool { return r.Method == http.MethodGet }, failures: 2}
+		c := login(t, h, client.WithHTTPClient(&http.Client{Transport: ft}), client.WithRetries(2, time.Millisecond))
+
+		_, err := c.Products().List(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 3, ft.count(http.MethodGet, "/products"))
+	})
+
+	t.Run("gives up after max retries", func(t *testing.T) {
+		h := handlertest.New(t)
+		ft := &flakyTransport{match: func(r *http.Request) bool { return r.Method == http.MethodGet }, failures: 3}
+		c := login(t, h, client.WithHTTPClient(&http.Client{Transport: ft}), client.WithRetries(2, time.Millisecond))
+
+		_, err := c.Products().List(context.Background())
+		var apiErr *client.Error
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	})
+
+	t.Run("not other requests", func(t *testing.T) {
+		h := handlertest.New(t)
+		ft := &flakyTransport{match: func(r *http.Request) bool { return r.URL.Path == "/products" }, failures: 1}
+		c := login(t, h, client.WithHTTPClient(&http.Client{Transport: ft}), client.WithRetries(2, time.Millisecond))
+
+		_, err := c.Products().Create(context.Background(), &handler.ProductReq{Name: "test product"})
+		require.Error(t, err)
+		require.Equal(t, 1, ft.count(http.MethodPost, "/products"))
+	})
+
+	t.Run("orders with an idempotency key", func(t *testing.T) {
+		h := handlertest.New(t)
+		ft := &flakyTransport{match: func(r *http.Request) bool { return r.URL.Path == "/orders" }, failures: 1}
+		c := login(t, h, client.WithHTTPClient(&http.Client{Transport: ft}), client.WithRetries(2, time.Millisecond))
+		p, err := h.Storer.CreateProduct(context.Background(), &storer.Product{Name: "test product"})
+		require.NoError(t, err)
+
+		_, err = c.Orders().Create(context.Background(), &handler.OrderReq{
+			PaymentMethod: "card",
+			Items:         []handler.OrderItem{{Name: "test product", Quantity: 1, ProductID: p.ID}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, ft.count(http.MethodPost, "/orders"))
+
+		orders, err := c.Orders().List(context.Background())
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+	})
+
+	t.Run("stops when the context ends", func(t *testing.T) {
+		h := handlertest.New(t)
+		ft := &flakyTransport{match: func(r *http.Request) bool { return r.Method == http.MethodGet }, failures: 3}
+		c := login(t, h, client.WithHTTPClient(&http.Client{Transport: ft}), client.WithRetries(2, time.Hour))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := c.Products().List(ctx)
+		require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	})
+}
+
+func TestClientTenant(t *testing.T) {
+	h := handlertest.New(t)
+	tenant := h.CreateTenant(t, "acme")
+	h.CreateTenantUser(t, tenant.ID, "admin@acme.example", "password", true)
+
+	c := client.New(h.Server.URL, client.WithTenant("acme"))
+	_, err := c.Auth().Login(context.Background(), "admin@acme.example", "password")
+	require.NoError(t, err)
+
+	_, err = c.Products().Create(context.Background(), &handler.ProductReq{Name: "acme product"})
+	require.NoError(t, err)
+
+	list, err := c.Products().List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	list, err = client.New(h.Server.URL).Products().List(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, list)
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/handler"
+)
+
+// Error is a response with an error status. Compare it against the Err
+// values with errors.Is to check for a status:
+//
+//	if errors.Is(err, client.ErrNotFound) { ... }
+type Error struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is set on responses to locked accounts.
+	RetryAfter time.Duration
+}
+
+var (
+	ErrBadRequest         = &Error{StatusCode: http.StatusBadRequest}
+	ErrUnauthorized       = &Error{StatusCode: http.StatusUnauthorized}
+	ErrForbidden          = &Error{StatusCode: http.StatusForbidden}
+	ErrNotFound           = &Error{StatusCode: http.StatusNotFound}
+	ErrConflict           = &Error{StatusCode: http.StatusConflict}
+	ErrPreconditionFailed = &Error{StatusCode: http.StatusPreconditionFailed}
+	ErrTooManyRequests    = &Error{StatusCode: http.StatusTooManyRequests}
+)
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("micropanel: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("micropanel: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.StatusCode == e.StatusCode
+}
+
+// decodeError reads the plain text message that the API sends with error
+// statuses.
+func decodeError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+
+	e := &Error{
+		StatusCode: res.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+	}
+	if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+
+	return e
+}
+
+// MFARequiredError is returned by Login for users with two-factor
+// authentication. Pass Challenge.MFAToken to LoginMFA with a code.
+type MFARequiredError struct {
+	Challenge handler.MFAChallengeRes
+}
+
+func (e *MFARequiredError) Error() string {
+	return "micropanel: two-factor authentication required"
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/handler"
+	"github.com/google/uuid"
+)
+
+type OrderService struct {
+	client *Client
+}
+
+// Create places an order. Requests of authenticated clients carry an
+// Idempotency-Key, so that they can be retried without placing the order
+// twice.
+func (s *OrderService) Create(ctx context.Context, o *handler.OrderReq) (*handler.OrderRes, error) {
+	req := request{method: http.MethodPost, path: "/orders", body: o, auth: true}
+	if s.client.apiKey != "" || s.client.Tokens().AccessToken != "" {
+		req.header = http.Header{"Idempotency-Key": {uuid.NewString()}}
+		req.retry = true
+	}
+
+	var res handler.OrderRes
+	if err := s.client.do(ctx, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (s *OrderService) List(ctx context.Context) ([]handler.OrderRes, error) {
+	var res []handler.OrderRes
+	err := s.client.do(ctx, request{method: http.MethodGet, path: "/orders", auth: true, retry: true}, &res)
+	return res, err
+}
+
+func (s *OrderService) Get(ctx context.Context, id int64) (*handler.OrderRes, error) {
+	var res handler.OrderRes
+	err := s.client.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/orders/%d", id), auth: true, retry: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (s *OrderService) Delete(ctx context.Context, id int64) error {
+	return s.client.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/orders/%d", id), auth: true, retry: true}, nil)
+}
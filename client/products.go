@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/handler"
+)
+
+type ProductService struct {
+	client *Client
+}
+
+func (s *ProductService) List(ctx context.Context) ([]handler.ProductRes, error) {
+	var res []handler.ProductRes
+	err := s.client.do(ctx, request{method: http.MethodGet, path: "/products", auth: true, retry: true}, &res)
+	return res, err
+}
+
+func (s *ProductService) Get(ctx context.Context, id int64) (*handler.ProductRes, error) {
+	var res handler.ProductRes
+	err := s.client.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/products/%d", id), auth: true, retry: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (s *ProductService) Create(ctx context.Context, p *handler.ProductReq) (*handler.ProductRes, error) {
+	var res handler.ProductRes
+	err := s.client.do(ctx, request{method: http.MethodPost, path: "/products", body: p, auth: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Update changes the fields of the product that are set in p.
+func (s *ProductService) Update(ctx context.Context, id int64, p *handler.ProductReq) (*handler.ProductRes, error) {
+	var res handler.ProductRes
+	err := s.client.do(ctx, request{method: http.MethodPatch, path: fmt.Sprintf("/products/%d", id), body: p, auth: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (s *ProductService) Delete(ctx context.Context, id int64) error {
+	return s.client.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/products/%d", id), auth: true, retry: true}, nil)
+}
@@ -1,21 +1,74 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	db "github.com/Turtel216/micro-panel/data"
 	"github.com/Turtel216/micro-panel/micropanel-api/handler"
+	"github.com/Turtel216/micro-panel/micropanel-api/maintenance"
 	"github.com/Turtel216/micro-panel/micropanel-api/server"
 	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/Turtel216/micro-panel/util"
 	"github.com/ianschenck/envflag"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const minSecretKeySize = 32
 
 func main() {
-	var secretKey = envflag.String("SECRET_KEY", "01234567890123456789012345678901", "secret key for JWT signing")
-	if len(*secretKey) < minSecretKeySize {
-		log.Fatalf("SECRET_KEY must be at least %d characters long", minSecretKeySize)
+	var tokenType = envflag.String("TOKEN_TYPE", "jwt", "format of issued tokens, jwt or paseto")
+	var secretKey = envflag.String("SECRET_KEY", "01234567890123456789012345678901", "secret key for JWT signing, or the 32 byte PASETO key")
+	var signingKeyFile = envflag.String("JWT_SIGNING_KEY_FILE", "", "PEM private key (ECDSA or Ed25519) used to sign tokens instead of SECRET_KEY")
+	var signingKeyID = envflag.String("JWT_SIGNING_KEY_ID", "default", "key ID of the signing key")
+	var hmacKeyID = envflag.String("JWT_HMAC_KEY_ID", "", "key ID under which SECRET_KEY stays accepted for HS256 tokens once JWT_SIGNING_KEY_FILE is set; empty rejects them")
+	var verificationKeys = envflag.String("JWT_VERIFICATION_KEYS", "", "comma separated kid=path list of additional PEM keys accepted for verification")
+	var accessTokenTTL = envflag.Duration("ACCESS_TOKEN_TTL", 15*time.Minute, "lifetime of access tokens")
+	var refreshTokenTTL = envflag.Duration("REFRESH_TOKEN_TTL", 30*24*time.Hour, "lifetime of refresh tokens")
+	var passwordResetTTL = envflag.Duration("PASSWORD_RESET_TTL", time.Hour, "lifetime of password reset tokens")
+	var emailVerificationTTL = envflag.Duration("EMAIL_VERIFICATION_TTL", 48*time.Hour, "lifetime of email verification tokens")
+	var requireVerifiedEmail = envflag.String("REQUIRE_VERIFIED_EMAIL", "", "comma separated actions refused to unverified users: login, orders")
+	var mfaChallengeTTL = envflag.Duration("MFA_CHALLENGE_TTL", 5*time.Minute, "time allowed for the second step of a two-factor login")
+	var totpIssuer = envflag.String("TOTP_ISSUER", "micro-panel", "service name shown in authenticator apps")
+	var requireAdminMFA = envflag.Bool("REQUIRE_ADMIN_MFA", false, "refuse admin routes to admins without two-factor authentication")
+	var failedLoginDelay = envflag.Duration("FAILED_LOGIN_DELAY", time.Second, "lockout after the first failed login, doubled with every further failure; 0 disables")
+	var maxFailedLogins = envflag.Int("MAX_FAILED_LOGINS", 10, "consecutive failed logins that lock an account for LOCKOUT_DURATION; 0 disables")
+	var lockoutDuration = envflag.Duration("LOCKOUT_DURATION", 15*time.Minute, "time an account stays locked after MAX_FAILED_LOGINS")
+	var passwordHasher = envflag.String("PASSWORD_HASHER", "argon2id", "algorithm for new password hashes, argon2id or bcrypt; older hashes are upgraded on login")
+	var argon2Memory = envflag.Int("ARGON2_MEMORY", int(util.DefaultArgon2idParams.Memory), "argon2id memory in KiB")
+	var argon2Iterations = envflag.Int("ARGON2_ITERATIONS", int(util.DefaultArgon2idParams.Iterations), "argon2id iterations")
+	var argon2Parallelism = envflag.Int("ARGON2_PARALLELISM", int(util.DefaultArgon2idParams.Parallelism), "argon2id parallelism")
+	var bcryptCost = envflag.Int("BCRYPT_COST", bcrypt.DefaultCost, "bcrypt cost")
+	var passwordMinLength = envflag.Int("PASSWORD_MIN_LENGTH", 8, "minimum length of new passwords")
+	var passwordMaxLength = envflag.Int("PASSWORD_MAX_LENGTH", 128, "maximum length of new passwords")
+	var passwordRejectCommon = envflag.Bool("PASSWORD_REJECT_COMMON", true, "reject new passwords found in the built-in list of common passwords")
+	var denylist = envflag.String("DENYLIST", "memory", "where revoked access tokens are kept, memory or mysql; use mysql with several instances")
+	var tenantHeader = envflag.String("TENANT_HEADER", "X-Tenant", "request header selecting the tenant by slug ahead of the host name; empty disables it")
+	var maintenanceInterval = envflag.Duration("MAINTENANCE_INTERVAL", 10*time.Minute, "interval between maintenance runs, which purge expired sessions, denied tokens, idempotency keys and events")
+	var maintenanceBatchSize = envflag.Int("MAINTENANCE_BATCH_SIZE", 1000, "maximum number of rows deleted per statement")
+	var revokedSessionRetention = envflag.Duration("REVOKED_SESSION_RETENTION", 30*24*time.Hour, "time revoked sessions are kept for auditing")
+	var eventRetention = envflag.Duration("EVENT_RETENTION", 7*24*time.Hour, "time order and inventory events are kept for resuming gRPC watch streams; 0 keeps them forever")
+	var cacheEnabled = envflag.Bool("CACHE_ENABLED", true, "enable the in-process product cache")
+	var cacheSize = envflag.Int("CACHE_SIZE", 1024, "maximum number of products held in the cache")
+	var cacheTTL = envflag.Duration("CACHE_TTL", time.Minute, "time after which cached products are refetched")
+	var metricsAddr = envflag.String("METRICS_ADDR", ":9091", "address serving /debug/vars; empty disables it")
+	envflag.Parse()
+
+	maintenanceConfig := maintenance.Config{
+		Interval:         *maintenanceInterval,
+		BatchSize:        *maintenanceBatchSize,
+		RevokedRetention: *revokedSessionRetention,
+		EventRetention:   *eventRetention,
+	}
+	if err := maintenanceConfig.Validate(); err != nil {
+		log.Fatalf("Invalid maintenance config: %v", err)
 	}
 
 	db, err := db.NewDatabase()
@@ -26,9 +79,138 @@ func main() {
 	defer db.Close()
 	log.Println("Successfully connected to database")
 
-	st := storer.NewMySQLStorer(db.GetDB())
+	var st storer.Storer = storer.NewMySQLStorer(db.GetDB())
+	worker := maintenance.NewWorker(st, maintenanceConfig)
+
+	// "micropanel-api maintenance" runs a single maintenance pass and exits.
+	if len(os.Args) > 1 && os.Args[1] == "maintenance" {
+		res, ran, err := worker.RunOnce(context.Background())
+		if err != nil {
+			log.Fatalf("Error running maintenance: %v", err)
+		}
+		if !ran {
+			log.Println("Maintenance is already running on another instance")
+			return
+		}
+		log.Printf("Purged %d sessions in %d batches, %d denied tokens, %d idempotency keys and %d events", res.SessionsDeleted, res.Batches, res.DeniedTokensDeleted, res.IdempotencyKeysDeleted, res.EventsDeleted)
+		return
+	}
+
+	var tokenMaker token.Maker
+	switch *tokenType {
+	case "jwt":
+		if (*signingKeyFile == "" || *hmacKeyID != "") && len(*secretKey) < minSecretKeySize {
+			log.Fatalf("SECRET_KEY must be at least %d characters long", minSecretKeySize)
+		}
+
+		keys, err := token.LoadKeySet(*secretKey, *signingKeyFile, *signingKeyID, *hmacKeyID, *verificationKeys)
+		if err != nil {
+			log.Fatalf("Error loading token keys: %v", err)
+		}
+		tokenMaker = token.NewJWTMakerWithKeys(keys)
+	case "paseto":
+		pasetoMaker, err := token.NewPasetoMaker(*secretKey)
+		if err != nil {
+			log.Fatalf("Error creating PASETO maker: %v", err)
+		}
+		tokenMaker = pasetoMaker
+	default:
+		log.Fatalf("Unknown TOKEN_TYPE %q, expected jwt or paseto", *tokenType)
+	}
+
+	var tokenDenylist token.Denylist
+	switch *denylist {
+	case "memory":
+		tokenDenylist = token.NewMemoryDenylist()
+	case "mysql":
+		tokenDenylist = st
+	default:
+		log.Fatalf("Unknown DENYLIST %q, expected memory or mysql", *denylist)
+	}
+
+	if *cacheEnabled {
+		cached := storer.NewCachedStorer(st, *cacheSize, *cacheTTL)
+		expvar.Publish("product_cache", expvar.Func(func() interface{} { return cached.Stats() }))
+		st = cached
+	}
+
 	srv := server.NewServer(st)
-	hdl := handler.NewHandler(srv, *secretKey)
+
+	expvar.Publish("maintenance", expvar.Func(func() interface{} { return worker.Stats() }))
+	go worker.Run(context.Background())
+
+	var hasher util.PasswordHasher
+	switch *passwordHasher {
+	case "argon2id":
+		if *argon2Memory < 0 || int64(*argon2Memory) > math.MaxUint32 {
+			log.Fatalf("ARGON2_MEMORY must be between 1 and %d, got %d", uint32(math.MaxUint32), *argon2Memory)
+		}
+		if *argon2Iterations < 0 || int64(*argon2Iterations) > math.MaxUint32 {
+			log.Fatalf("ARGON2_ITERATIONS must be between 1 and %d, got %d", uint32(math.MaxUint32), *argon2Iterations)
+		}
+		if *argon2Parallelism < 0 || *argon2Parallelism > math.MaxUint8 {
+			log.Fatalf("ARGON2_PARALLELISM must be between 1 and %d, got %d", math.MaxUint8, *argon2Parallelism)
+		}
+
+		params := util.DefaultArgon2idParams
+		params.Memory = uint32(*argon2Memory)
+		params.Iterations = uint32(*argon2Iterations)
+		params.Parallelism = uint8(*argon2Parallelism)
+		if err := params.Validate(); err != nil {
+			log.Fatalf("Invalid argon2id parameters: %v", err)
+		}
+		hasher = util.Argon2idHasher{Params: params}
+	case "bcrypt":
+		hasher = util.BcryptHasher{Cost: *bcryptCost}
+	default:
+		log.Fatalf("Unknown PASSWORD_HASHER %q, expected argon2id or bcrypt", *passwordHasher)
+	}
+
+	config := handler.Config{
+		AccessTokenTTL:       *accessTokenTTL,
+		RefreshTokenTTL:      *refreshTokenTTL,
+		PasswordResetTTL:     *passwordResetTTL,
+		EmailVerificationTTL: *emailVerificationTTL,
+		MFAChallengeTTL:      *mfaChallengeTTL,
+		TOTPIssuer:           *totpIssuer,
+		RequireAdminMFA:      *requireAdminMFA,
+		FailedLoginDelay:     *failedLoginDelay,
+		MaxFailedLogins:      *maxFailedLogins,
+		LockoutDuration:      *lockoutDuration,
+		PasswordHasher:       hasher,
+		Denylist:             tokenDenylist,
+		TenantHeader:         *tenantHeader,
+		PasswordPolicy: util.PasswordPolicy{
+			MinLength:    *passwordMinLength,
+			MaxLength:    *passwordMaxLength,
+			RejectCommon: *passwordRejectCommon,
+		},
+	}
+	for _, action := range strings.Split(*requireVerifiedEmail, ",") {
+		switch strings.TrimSpace(action) {
+		case "":
+		case "login":
+			config.RequireVerifiedEmailForLogin = true
+		case "orders":
+			config.RequireVerifiedEmailForOrders = true
+		default:
+			log.Fatalf("Unknown REQUIRE_VERIFIED_EMAIL action %q, expected login or orders", action)
+		}
+	}
+
+	hdl := handler.NewHandler(srv, tokenMaker, config)
+	expvar.Publish("handler", expvar.Func(func() interface{} { return hdl.Stats() }))
+
+	if *metricsAddr != "" {
+		go func() {
+			log.Printf("Serving metrics on %s", *metricsAddr)
+			// Importing expvar registers /debug/vars on the default mux.
+			if err := http.ListenAndServe(*metricsAddr, nil); err != nil {
+				log.Printf("Error serving metrics: %v", err)
+			}
+		}()
+	}
+
 	handler.RegisterRoutes(hdl)
 	handler.Start(":8080")
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	db "github.com/Turtel216/micro-panel/data"
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-grpc/service"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/Turtel216/micro-panel/util"
+	"github.com/ianschenck/envflag"
+)
+
+const minSecretKeySize = 32
+
+func main() {
+	var addr = envflag.String("GRPC_ADDR", ":50051", "address the gRPC server listens on")
+	var metricsAddr = envflag.String("METRICS_ADDR", ":9090", "address serving /debug/vars; empty disables it")
+	var tokenType = envflag.String("TOKEN_TYPE", "jwt", "format of accepted tokens, jwt or paseto")
+	var secretKey = envflag.String("SECRET_KEY", "01234567890123456789012345678901", "secret key for JWT verification, or the 32 byte PASETO key")
+	var signingKeyFile = envflag.String("JWT_SIGNING_KEY_FILE", "", "PEM key (ECDSA or Ed25519) that tokens are signed with instead of SECRET_KEY")
+	var signingKeyID = envflag.String("JWT_SIGNING_KEY_ID", "default", "key ID of the signing key")
+	var hmacKeyID = envflag.String("JWT_HMAC_KEY_ID", "", "key ID under which SECRET_KEY stays accepted for HS256 tokens once JWT_SIGNING_KEY_FILE is set; empty rejects them")
+	var verificationKeys = envflag.String("JWT_VERIFICATION_KEYS", "", "comma separated kid=path list of additional PEM keys accepted for verification")
+	var denylist = envflag.String("DENYLIST", "mysql", "where revoked access tokens are looked up, mysql or memory; memory does not see tokens revoked through the REST API")
+	var tenantHeader = envflag.String("TENANT_HEADER", "X-Tenant", "metadata key selecting the tenant by slug ahead of the authority; empty disables it")
+	var passwordMinLength = envflag.Int("PASSWORD_MIN_LENGTH", 8, "minimum length of new passwords")
+	var passwordMaxLength = envflag.Int("PASSWORD_MAX_LENGTH", 128, "maximum length of new passwords")
+	var passwordRejectCommon = envflag.Bool("PASSWORD_REJECT_COMMON", true, "reject new passwords found in the built-in list of common passwords")
+	var emailVerificationTTL = envflag.Duration("EMAIL_VERIFICATION_TTL", service.DefaultEmailVerificationTTL, "lifetime of email verification tokens")
+	var watchPollInterval = envflag.Duration("WATCH_POLL_INTERVAL", service.DefaultBrokerConfig.PollInterval, "interval between reads of the event log for watch streams")
+	var watchBuffer = envflag.Int("WATCH_BUFFER", service.DefaultBrokerConfig.Buffer, "events queued per watch stream before it falls back to reading the event log")
+	var watchGapTimeout = envflag.Duration("WATCH_GAP_TIMEOUT", service.DefaultBrokerConfig.GapTimeout, "time a missing event ID holds back later events")
+	envflag.Parse()
+
+	db, err := db.NewDatabase()
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+
+	defer db.Close()
+	log.Println("Successfully connected to database")
+
+	st := storer.NewMySQLStorer(db.GetDB())
+	srv := server.NewServer(st)
+
+	var tokenMaker token.Maker
+	switch *tokenType {
+	case "jwt":
+		if (*signingKeyFile == "" || *hmacKeyID != "") && len(*secretKey) < minSecretKeySize {
+			log.Fatalf("SECRET_KEY must be at least %d characters long", minSecretKeySize)
+		}
+
+		keys, err := token.LoadKeySet(*secretKey, *signingKeyFile, *signingKeyID, *hmacKeyID, *verificationKeys)
+		if err != nil {
+			log.Fatalf("Error loading token keys: %v", err)
+		}
+		tokenMaker = token.NewJWTMakerWithKeys(keys)
+	case "paseto":
+		pasetoMaker, err := token.NewPasetoMaker(*secretKey)
+		if err != nil {
+			log.Fatalf("Error creating PASETO maker: %v", err)
+		}
+		tokenMaker = pasetoMaker
+	default:
+		log.Fatalf("Unknown TOKEN_TYPE %q, expected jwt or paseto", *tokenType)
+	}
+
+	var tokenDenylist token.Denylist
+	switch *denylist {
+	case "mysql":
+		tokenDenylist = st
+	case "memory":
+		tokenDenylist = token.NewMemoryDenylist()
+	default:
+		log.Fatalf("Unknown DENYLIST %q, expected mysql or memory", *denylist)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	brokerConfig := service.DefaultBrokerConfig
+	brokerConfig.PollInterval = *watchPollInterval
+	brokerConfig.Buffer = *watchBuffer
+	brokerConfig.GapTimeout = *watchGapTimeout
+	broker := service.NewBroker(srv, brokerConfig)
+	go broker.Run(ctx)
+
+	gs := service.NewServer(srv, service.Config{
+		TokenMaker:   tokenMaker,
+		Denylist:     tokenDenylist,
+		TenantHeader: *tenantHeader,
+		PasswordPolicy: util.PasswordPolicy{
+			MinLength:    *passwordMinLength,
+			MaxLength:    *passwordMaxLength,
+			RejectCommon: *passwordRejectCommon,
+		},
+		EmailVerificationTTL: *emailVerificationTTL,
+		Broker:               broker,
+	})
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Error listening on %s: %v", *addr, err)
+	}
+
+	if *metricsAddr != "" {
+		expvar.Publish("grpc", expvar.Func(func() interface{} { return gs.Stats() }))
+		go func() {
+			log.Printf("Serving metrics on %s", *metricsAddr)
+			// Importing expvar registers /debug/vars on the default mux.
+			if err := http.ListenAndServe(*metricsAddr, nil); err != nil {
+				log.Printf("Error serving metrics: %v", err)
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		// Watch streams end with the broker, so this does not wait on them.
+		gs.GracefulStop()
+	}()
+
+	log.Printf("Serving gRPC on %s", lis.Addr())
+	if err := gs.Serve(lis); err != nil {
+		log.Fatalf("Error serving gRPC: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	db "github.com/Turtel216/micro-panel/data"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/notification-svc/admin"
+	"github.com/Turtel216/micro-panel/notification-svc/notifier"
+	"github.com/Turtel216/micro-panel/notification-svc/queue"
+	"github.com/Turtel216/micro-panel/notification-svc/worker"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/ianschenck/envflag"
+)
+
+const minSecretKeySize = 32
+
+func main() {
+	var adminAddr = envflag.String("ADMIN_ADDR", ":8081", "address the admin API and /debug/vars are served on")
+	var workers = envflag.Int("WORKERS", worker.DefaultConfig.Workers, "number of jobs run at the same time")
+	var pollInterval = envflag.Duration("POLL_INTERVAL", worker.DefaultConfig.PollInterval, "interval between claims while the queue is empty")
+	var batchSize = envflag.Int("BATCH_SIZE", worker.DefaultConfig.BatchSize, "maximum number of jobs claimed at once")
+	var visibilityTimeout = envflag.Duration("VISIBILITY_TIMEOUT", worker.DefaultConfig.Visibility, "time a claimed job is hidden from other workers, and the longest a job may run")
+	var baseBackoff = envflag.Duration("BASE_BACKOFF", worker.DefaultConfig.BaseBackoff, "delay before the first retry of a failed job, doubled for every further attempt")
+	var maxBackoff = envflag.Duration("MAX_BACKOFF", worker.DefaultConfig.MaxBackoff, "maximum delay between attempts of a job")
+	var shutdownTimeout = envflag.Duration("SHUTDOWN_TIMEOUT", worker.DefaultConfig.ShutdownTimeout, "time running jobs may take to finish on shutdown before they are cancelled")
+	var jobRetention = envflag.Duration("JOB_RETENTION", worker.DefaultConfig.Retention, "time done jobs are kept before they are deleted; 0 keeps them forever")
+	var purgeInterval = envflag.Duration("PURGE_INTERVAL", worker.DefaultConfig.PurgeInterval, "interval between deletions of done jobs past JOB_RETENTION")
+	var tokenType = envflag.String("TOKEN_TYPE", "jwt", "format of accepted tokens, jwt or paseto")
+	var secretKey = envflag.String("SECRET_KEY", "01234567890123456789012345678901", "secret key for JWT verification, or the 32 byte PASETO key")
+	var signingKeyFile = envflag.String("JWT_SIGNING_KEY_FILE", "", "PEM key (ECDSA or Ed25519) that tokens are signed with instead of SECRET_KEY")
+	var signingKeyID = envflag.String("JWT_SIGNING_KEY_ID", "default", "key ID of the signing key")
+	var hmacKeyID = envflag.String("JWT_HMAC_KEY_ID", "", "key ID under which SECRET_KEY stays accepted for HS256 tokens once JWT_SIGNING_KEY_FILE is set; empty rejects them")
+	var verificationKeys = envflag.String("JWT_VERIFICATION_KEYS", "", "comma separated kid=path list of additional PEM keys accepted for verification")
+	var denylist = envflag.String("DENYLIST", "mysql", "where revoked access tokens are looked up, mysql or memory")
+	var smtpAddr = envflag.String("SMTP_ADDR", "", "host:port of the SMTP server emails are sent through; empty writes emails to the log")
+	var smtpFrom = envflag.String("SMTP_FROM", "micropanel@localhost", "sender address of emails")
+	var smtpUsername = envflag.String("SMTP_USERNAME", "", "username for SMTP PLAIN auth; empty disables auth")
+	var smtpPassword = envflag.String("SMTP_PASSWORD", "", "password for SMTP PLAIN auth")
+	var emailRate = envflag.Float64("EMAIL_RATE", 10, "emails sent per second; 0 disables the limit")
+	var emailBurst = envflag.Int("EMAIL_BURST", 10, "emails sent at once before EMAIL_RATE applies")
+	var webhookURL = envflag.String("WEBHOOK_URL", "", "URL that webhook notifications are posted to; empty disables the webhook channel")
+	var webhookSecret = envflag.String("WEBHOOK_SECRET", "", "secret that webhook bodies are signed with in X-Signature; empty disables signing")
+	var webhookRate = envflag.Float64("WEBHOOK_RATE", 10, "webhook notifications posted per second; 0 disables the limit")
+	var webhookBurst = envflag.Int("WEBHOOK_BURST", 10, "webhook notifications posted at once before WEBHOOK_RATE applies")
+	var smsFile = envflag.String("SMS_FILE", "", "file that text messages are appended to; empty writes them to the log")
+	var smsRate = envflag.Float64("SMS_RATE", 1, "text messages sent per second; 0 disables the limit")
+	var smsBurst = envflag.Int("SMS_BURST", 5, "text messages sent at once before SMS_RATE applies")
+	envflag.Parse()
+
+	db, err := db.NewDatabase()
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+
+	defer db.Close()
+	log.Println("Successfully connected to database")
+
+	var tokenMaker token.Maker
+	switch *tokenType {
+	case "jwt":
+		if (*signingKeyFile == "" || *hmacKeyID != "") && len(*secretKey) < minSecretKeySize {
+			log.Fatalf("SECRET_KEY must be at least %d characters long", minSecretKeySize)
+		}
+
+		keys, err := token.LoadKeySet(*secretKey, *signingKeyFile, *signingKeyID, *hmacKeyID, *verificationKeys)
+		if err != nil {
+			log.Fatalf("Error loading token keys: %v", err)
+		}
+		tokenMaker = token.NewJWTMakerWithKeys(keys)
+	case "paseto":
+		pasetoMaker, err := token.NewPasetoMaker(*secretKey)
+		if err != nil {
+			log.Fatalf("Error creating PASETO maker: %v", err)
+		}
+		tokenMaker = pasetoMaker
+	default:
+		log.Fatalf("Unknown TOKEN_TYPE %q, expected jwt or paseto", *tokenType)
+	}
+
+	var tokenDenylist token.Denylist
+	switch *denylist {
+	case "mysql":
+		tokenDenylist = storer.NewMySQLStorer(db.GetDB())
+	case "memory":
+		tokenDenylist = token.NewMemoryDenylist()
+	default:
+		log.Fatalf("Unknown DENYLIST %q, expected mysql or memory", *denylist)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	q := queue.NewMySQLQueue(db.GetDB())
+	w := worker.NewWorker(q, worker.Config{
+		Workers:         *workers,
+		PollInterval:    *pollInterval,
+		BatchSize:       *batchSize,
+		Visibility:      *visibilityTimeout,
+		BaseBackoff:     *baseBackoff,
+		MaxBackoff:      *maxBackoff,
+		ShutdownTimeout: *shutdownTimeout,
+		Retention:       *jobRetention,
+		PurgeInterval:   *purgeInterval,
+	})
+
+	dispatcher := notifier.NewDispatcher()
+	emailLimit := notifier.Limit{Rate: *emailRate, Burst: *emailBurst}
+	if *smtpAddr != "" {
+		dispatcher.Register(storer.NotificationChannelEmail, notifier.NewSMTPNotifier(notifier.SMTPConfig{
+			Addr:     *smtpAddr,
+			From:     *smtpFrom,
+			Username: *smtpUsername,
+			Password: *smtpPassword,
+		}), emailLimit)
+	} else {
+		dispatcher.Register(storer.NotificationChannelEmail, notifier.NewLogNotifier(log.Writer(), "log"), emailLimit)
+	}
+	if *webhookURL != "" {
+		dispatcher.Register(storer.NotificationChannelWebhook, notifier.NewWebhookNotifier(notifier.WebhookConfig{
+			URL:    *webhookURL,
+			Secret: *webhookSecret,
+			Client: &http.Client{Timeout: 30 * time.Second},
+		}), notifier.Limit{Rate: *webhookRate, Burst: *webhookBurst})
+	}
+	smsLimit := notifier.Limit{Rate: *smsRate, Burst: *smsBurst}
+	if *smsFile != "" {
+		f, err := os.OpenFile(*smsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			log.Fatalf("Error opening SMS_FILE: %v", err)
+		}
+		defer f.Close()
+		dispatcher.Register(storer.NotificationChannelSMS, notifier.NewLogNotifier(f, *smsFile), smsLimit)
+	} else {
+		dispatcher.Register(storer.NotificationChannelSMS, notifier.NewLogNotifier(log.Writer(), "log"), smsLimit)
+	}
+
+	w.Handle(storer.JobKindNotification, dispatcher.Handle)
+	expvar.Publish("worker", expvar.Func(func() interface{} { return w.Stats() }))
+	expvar.Publish("channels", expvar.Func(func() interface{} { return dispatcher.Stats() }))
+
+	srv := &http.Server{
+		Addr:    *adminAddr,
+		Handler: admin.NewHandler(q, admin.Config{TokenMaker: tokenMaker, Denylist: tokenDenylist}),
+	}
+	go func() {
+		log.Printf("Serving admin API on %s", *adminAddr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Error serving admin API: %v", err)
+		}
+	}()
+
+	log.Printf("Running %d workers", *workers)
+	w.Run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down admin API: %v", err)
+	}
+}
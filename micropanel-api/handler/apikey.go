@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/Turtel216/micro-panel/util"
+	"github.com/go-chi/chi"
+)
+
+// Resources that API keys can be scoped to. A key needs <resource>:read for
+// GET requests and <resource>:write for everything else.
+var scopeResources = []string{"products", "orders", "users"}
+
+var errInvalidAPIKey = errors.New("invalid api key")
+
+type apiKeyKey struct{}
+
+func apiKeyFromContext(ctx context.Context) (*storer.APIKey, bool) {
+	k, ok := ctx.Value(apiKeyKey{}).(*storer.APIKey)
+	return k, ok
+}
+
+func validScope(scope string) bool {
+	for _, resource := range scopeResources {
+		if scope == resource+":read" || scope == resource+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasScope(k *storer.APIKey, scope string) bool {
+	for _, s := range strings.Split(k.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey resolves an API key to the claims of its owner and
+// records that the key was used.
+func (h *handler) authenticateAPIKey(ctx context.Context, raw string) (*token.UserClaims, *storer.APIKey, error) {
+	prefix, ok := util.APIKeyPrefix(raw)
+	if !ok {
+		return nil, nil, errInvalidAPIKey
+	}
+
+	k, err := h.server.GetAPIKeyByPrefix(ctx, prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(util.HashToken(raw)), []byte(k.KeyHash)) != 1 {
+		return nil, nil, errInvalidAPIKey
+	}
+
+	if k.RevokedAt != nil || (k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now())) {
+		return nil, nil, errInvalidAPIKey
+	}
+
+	// Keys are only valid within the tenant of their owner.
+	usr, err := h.server.GetUserByID(ctx, k.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := h.server.TouchAPIKey(ctx, k.ID); err != nil {
+		return nil, nil, err
+	}
+
+	return &token.UserClaims{ID: usr.ID, Email: usr.Email, IsAdmin: usr.IsAdmin, TenantID: usr.TenantID}, k, nil
+}
+
+// requireScope restricts callers authenticated with an API key to keys that
+// carry the scope for resource. Other callers pass through.
+func (h *handler) requireScope(resource string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k, ok := apiKeyFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scope := resource + ":write"
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = resource + ":read"
+			}
+
+			if !hasScope(k, scope) {
+				http.Error(w, "api key lacks scope "+scope, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// interactive rejects API keys on routes that manage credentials, so that a
+// leaked key cannot be used to mint further keys or end sessions.
+func (h *handler) interactive(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := apiKeyFromContext(r.Context()); ok {
+			http.Error(w, "not allowed with an api key", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "missing name", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		http.Error(w, "missing scopes", http.StatusBadRequest)
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !validScope(scope) {
+			http.Error(w, "unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	key, prefix, hash, err := util.NewAPIKey()
+	if err != nil {
+		http.Error(w, "error generating api key", http.StatusInternalServerError)
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	k, err := h.server.CreateAPIKey(r.Context(), &storer.APIKey{
+		UserID:    claims.ID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    strings.Join(req.Scopes, ","),
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		http.Error(w, "error creating api key", http.StatusInternalServerError)
+		return
+	}
+
+	res := CreateAPIKeyRes{
+		APIKeyRes: toAPIKeyRes(k),
+		Key:       key,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) listMyAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	h.listAPIKeys(r.Context(), w, claims.ID)
+}
+
+func (h *handler) revokeMyAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	h.revokeAPIKeyOf(w, r, claims.ID)
+}
+
+func (h *handler) listUserAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	h.listAPIKeys(r.Context(), w, user.ID)
+}
+
+func (h *handler) revokeUserAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	h.revokeAPIKeyOf(w, r, user.ID)
+}
+
+func (h *handler) listAPIKeys(ctx context.Context, w http.ResponseWriter, userID int64) {
+	keys, err := h.server.ListAPIKeys(ctx, userID)
+	if err != nil {
+		http.Error(w, "error listing api keys", http.StatusInternalServerError)
+		return
+	}
+
+	res := ListAPIKeyRes{APIKeys: []APIKeyRes{}}
+	for _, k := range keys {
+		res.APIKeys = append(res.APIKeys, toAPIKeyRes(&k))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) revokeAPIKeyOf(w http.ResponseWriter, r *http.Request, userID int64) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "keyID"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.server.RevokeAPIKey(r.Context(), userID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "api key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error revoking api key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
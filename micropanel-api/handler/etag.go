@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strings"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+)
+
+func productETag(p *storer.Product) string {
+	h := sha256.New()
+	writeProductVersion(h, p)
+	return quoteETag(h.Sum(nil))
+}
+
+func productsETag(products []storer.Product) string {
+	h := sha256.New()
+	for i := range products {
+		writeProductVersion(h, &products[i])
+	}
+	return quoteETag(h.Sum(nil))
+}
+
+// writeProductVersion hashes the fields that identify a product revision.
+func writeProductVersion(h hash.Hash, p *storer.Product) {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(p.ID))
+	binary.BigEndian.PutUint64(buf[8:], uint64(p.Version))
+	h.Write(buf[:])
+}
+
+func quoteETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// checkNotModified sets the ETag header and reports whether the request's
+// If-None-Match header matches it, in which case a 304 has been written.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" || !etagListMatches(inm, etag, false) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// checkPrecondition reports whether the request's If-Match header, if any,
+// matches etag. When it does not, a 412 has been written.
+func checkPrecondition(w http.ResponseWriter, r *http.Request, etag string) bool {
+	im := r.Header.Get("If-Match")
+	if im == "" || etagListMatches(im, etag, true) {
+		return true
+	}
+
+	http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+	return false
+}
+
+func etagListMatches(list, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(candidate, "W/") {
+			if strong {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
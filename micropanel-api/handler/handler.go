@@ -2,9 +2,13 @@ package handler
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Turtel216/micro-panel/micropanel-api/server"
@@ -14,20 +18,88 @@ import (
 	"github.com/go-chi/chi"
 )
 
+type Config struct {
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	PasswordResetTTL     time.Duration
+	EmailVerificationTTL time.Duration
+
+	// Unverified users are refused a login or an order when set.
+	RequireVerifiedEmailForLogin  bool
+	RequireVerifiedEmailForOrders bool
+
+	// MFAChallengeTTL bounds the time between the password and the TOTP step
+	// of a login. TOTPIssuer names the service in authenticator apps.
+	MFAChallengeTTL time.Duration
+	TOTPIssuer      string
+
+	// RequireAdminMFA refuses admin routes to admins without TOTP enabled.
+	RequireAdminMFA bool
+
+	// Every failed login delays the next attempt by FailedLoginDelay,
+	// doubling with each failure, until MaxFailedLogins locks the account
+	// for LockoutDuration. Zero values disable either mechanism.
+	FailedLoginDelay time.Duration
+	MaxFailedLogins  int
+	LockoutDuration  time.Duration
+
+	// PasswordHasher hashes new passwords. Stored hashes that it would make
+	// differently are upgraded on login. Defaults to
+	// util.DefaultPasswordHasher.
+	PasswordHasher util.PasswordHasher
+	PasswordPolicy util.PasswordPolicy
+
+	// Denylist holds access tokens revoked before they expire. Defaults to
+	// a token.MemoryDenylist, which is not shared between instances.
+	Denylist token.Denylist
+
+	// TenantHeader names a request header that selects the tenant by its
+	// slug, taking precedence over the host name. Empty disables it.
+	TenantHeader string
+}
+
+const recoveryCodeCount = 10
+
 type handler struct {
-	ctx        context.Context
 	server     *server.Server
-	tokenMaker *token.JWTMaker
+	tokenMaker token.Maker
+	config     Config
+
+	// dummyHash is checked against the password of logins for unknown
+	// emails, so that they take as long to reject as wrong passwords.
+	dummyHash func() string
+
+	idempotencyErrors atomic.Uint64
+}
+
+// Stats counts errors that were logged rather than returned to the client.
+type Stats struct {
+	IdempotencyErrors uint64 `json:"idempotency_errors"`
 }
 
-func NewHandler(server *server.Server, secretKey string) *handler {
+func NewHandler(server *server.Server, tokenMaker token.Maker, config Config) *handler {
+	if config.PasswordHasher == nil {
+		config.PasswordHasher = util.DefaultPasswordHasher
+	}
+	if config.Denylist == nil {
+		config.Denylist = token.NewMemoryDenylist()
+	}
+
 	return &handler{
-		ctx:        context.Background(),
 		server:     server,
-		tokenMaker: token.NewJWTMaker(secretKey),
+		tokenMaker: tokenMaker,
+		config:     config,
+		dummyHash: sync.OnceValue(func() string {
+			hash, _ := config.PasswordHasher.Hash("dummy password")
+			return hash
+		}),
 	}
 }
 
+func (h *handler) Stats() Stats {
+	return Stats{IdempotencyErrors: h.idempotencyErrors.Load()}
+}
+
 func (h *handler) createProduct(w http.ResponseWriter, r *http.Request) {
 	var p ProductReq
 	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
@@ -35,7 +107,7 @@ func (h *handler) createProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	product, err := h.server.CreateProduct(h.ctx, toStorerProduct(p))
+	product, err := h.server.CreateProduct(r.Context(), toStorerProduct(p))
 	if err != nil {
 		http.Error(w, "Error creating product", http.StatusInternalServerError)
 		return
@@ -43,7 +115,7 @@ func (h *handler) createProduct(w http.ResponseWriter, r *http.Request) {
 
 	res := toProductRes(product)
 
-	w.Header().Set("Context-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(res)
 }
@@ -56,31 +128,43 @@ func (h *handler) getProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	product, err := h.server.GetProduct(h.ctx, i)
+	product, err := h.server.GetProduct(r.Context(), i)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "product not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error getting product", http.StatusInternalServerError)
 		return
 	}
 
+	if checkNotModified(w, r, productETag(product)) {
+		return
+	}
+
 	res := toProductRes(product)
-	w.Header().Set("Context-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
 
 func (h *handler) listProduct(w http.ResponseWriter, r *http.Request) {
-	products, err := h.server.ListProducts(h.ctx)
+	products, err := h.server.ListProducts(r.Context())
 	if err != nil {
 		http.Error(w, "Error listening products", http.StatusInternalServerError)
 		return
 	}
 
+	if checkNotModified(w, r, productsETag(products)) {
+		return
+	}
+
 	var res []ProductRes
 	for _, p := range products {
 		res = append(res, toProductRes(&p))
 	}
 
-	w.Header().Set("Context-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
@@ -99,22 +183,34 @@ func (h *handler) updateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	product, err := h.server.GetProduct(h.ctx, i)
+	product, err := h.server.GetProduct(r.Context(), i)
 	if err != nil {
 		http.Error(w, "Error getting product", http.StatusInternalServerError)
 		return
 	}
 
+	if !checkPrecondition(w, r, productETag(product)) {
+		return
+	}
+
+	// The product may have changed since it was read, possibly from the
+	// cache, so the update is conditioned on the version that was matched.
+	version := product.Version
 	patchProductReq(product, p)
 
-	updatedProd, err := h.server.UpdateProduct(h.ctx, product)
+	updatedProd, err := h.server.UpdateProduct(r.Context(), product, version)
+	if errors.Is(err, storer.ErrProductModified) {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error updating product", http.StatusInternalServerError)
 		return
 	}
 
 	res := toProductRes(updatedProd)
-	w.Header().Set("Context-Type", "application/json")
+	w.Header().Set("ETag", productETag(updatedProd))
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
@@ -127,7 +223,26 @@ func (h *handler) deleteProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.server.DeleteProduct(h.ctx, i); err != nil {
+	var version int64
+	if r.Header.Get("If-Match") != "" {
+		product, err := h.server.GetProduct(r.Context(), i)
+		if err != nil {
+			http.Error(w, "Error getting product", http.StatusInternalServerError)
+			return
+		}
+
+		if !checkPrecondition(w, r, productETag(product)) {
+			return
+		}
+		version = product.Version
+	}
+
+	err = h.server.DeleteProduct(r.Context(), i, version)
+	if errors.Is(err, storer.ErrProductModified) {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Error deleting product", http.StatusInternalServerError)
 		return
 	}
@@ -142,7 +257,15 @@ func (h *handler) createOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	created, err := h.server.CreateOrder(h.ctx, toStorerOrder(o))
+	claims, _ := claimsFromContext(r.Context())
+	order := toStorerOrder(o)
+	order.UserID = claims.ID
+
+	created, err := h.server.CreateOrder(r.Context(), order)
+	if errors.Is(err, storer.ErrUnknownProduct) {
+		http.Error(w, "unknown product", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -162,20 +285,24 @@ func (h *handler) getOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	order, err := h.server.GetOrder(h.ctx, i)
+	order, err := h.server.GetOrder(r.Context(), i)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error getting order", http.StatusInternalServerError)
 		return
 	}
 
 	res := toOrderRes(order)
-	w.Header().Set("Context-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
 
 func (h *handler) listOrders(w http.ResponseWriter, r *http.Request) {
-	orders, err := h.server.ListOrder(h.ctx)
+	orders, err := h.server.ListOrder(r.Context())
 	if err != nil {
 		http.Error(w, "Error listening orders", http.StatusInternalServerError)
 		return
@@ -186,7 +313,7 @@ func (h *handler) listOrders(w http.ResponseWriter, r *http.Request) {
 		res = append(res, toOrderRes(&p))
 	}
 
-	w.Header().Set("Context-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
@@ -199,7 +326,7 @@ func (h *handler) deleteOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.server.DeleteOrder(h.ctx, i); err != nil {
+	if err := h.server.DeleteOrder(r.Context(), i); err != nil {
 		http.Error(w, "Error deleting order", http.StatusInternalServerError)
 		return
 	}
@@ -207,6 +334,18 @@ func (h *handler) deleteOrder(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *handler) registerUser(w http.ResponseWriter, r *http.Request) {
+	var u UserReq
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Only admins may create other admins, through POST /users.
+	u.IsAdmin = false
+	h.storeUser(r.Context(), w, u)
+}
+
 func (h *handler) createUser(w http.ResponseWriter, r *http.Request) {
 	var u UserReq
 	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
@@ -214,19 +353,34 @@ func (h *handler) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashed, err := util.HashPassword(u.Password)
+	h.storeUser(r.Context(), w, u)
+}
+
+func (h *handler) storeUser(ctx context.Context, w http.ResponseWriter, u UserReq) {
+	if err := h.config.PasswordPolicy.Validate(u.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashed, err := h.config.PasswordHasher.Hash(u.Password)
 	if err != nil {
 		http.Error(w, "error hashing password", http.StatusInternalServerError)
 		return
 	}
 	u.Password = hashed
 
-	created, err := h.server.CreateUser(h.ctx, toStorerUser(u))
+	created, err := h.server.CreateUser(ctx, toStorerUser(u))
 	if err != nil {
 		http.Error(w, "error creating user", http.StatusInternalServerError)
 		return
 	}
 
+	err = h.sendUserToken(ctx, created, storer.UserTokenEmailVerification, h.config.EmailVerificationTTL)
+	if err != nil {
+		http.Error(w, "error sending verification token", http.StatusInternalServerError)
+		return
+	}
+
 	res := toUserRes(created)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -234,7 +388,7 @@ func (h *handler) createUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *handler) listUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.server.ListUsers(h.ctx)
+	users, err := h.server.ListUsers(r.Context())
 	if err != nil {
 		http.Error(w, "error listing users", http.StatusInternalServerError)
 		return
@@ -249,41 +403,167 @@ func (h *handler) listUsers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+func (h *handler) getUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.server.GetUserByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	res := toUserRes(user)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) getMe(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+
+	user, err := h.server.GetUserByID(r.Context(), claims.ID)
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	res := toUserRes(user)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *handler) updateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var u UserReq
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.server.GetUserByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	h.patchUser(w, r, user, u)
+}
+
+func (h *handler) updateMe(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+
+	var u UserReq
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.server.GetUserByID(r.Context(), claims.ID)
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	h.patchUser(w, r, user, u)
+}
+
+// updateUserByEmail serves the legacy PATCH /orders/users route, which
+// identifies the user by the email in the request body.
+func (h *handler) updateUserByEmail(w http.ResponseWriter, r *http.Request) {
 	var u UserReq
 	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
 		http.Error(w, "error decoding request body", http.StatusBadRequest)
 		return
 	}
 
-	user, err := h.server.GetUser(h.ctx, u.Email)
+	user, err := h.server.GetUser(r.Context(), u.Email)
 	if err != nil {
 		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	if !claims.IsAdmin && claims.ID != user.ID {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	h.patchUser(w, r, user, u)
+}
+
+func (h *handler) patchUser(w http.ResponseWriter, r *http.Request, user *storer.User, u UserReq) {
+	claims, _ := claimsFromContext(r.Context())
+	if u.IsAdmin && !claims.IsAdmin {
+		http.Error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	if u.Password != "" {
+		if err := h.config.PasswordPolicy.Validate(u.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hashed, err := h.config.PasswordHasher.Hash(u.Password)
+		if err != nil {
+			http.Error(w, "error hashing password", http.StatusInternalServerError)
+			return
+		}
+		user.Password = hashed
 	}
 
+	email, wasAdmin := user.Email, user.IsAdmin
 	patchUserReq(user, u)
 
-	updated, err := h.server.UpdateUser(h.ctx, user)
+	updated, err := h.server.UpdateUser(r.Context(), user)
 	if err != nil {
 		http.Error(w, "error updating user", http.StatusInternalServerError)
 		return
 	}
 
+	// Sessions are looked up by the email they were created with, so they
+	// are revoked under the old one.
+	switch {
+	case u.Password != "" || updated.Email != email:
+		err = h.revokeSessions(r.Context(), email)
+		if err != nil {
+			http.Error(w, "error revoking sessions", http.StatusInternalServerError)
+			return
+		}
+	case updated.IsAdmin != wasAdmin:
+		// Access tokens carry the role, so make the user refresh them.
+		err = h.denyAccessTokens(r.Context(), email, nil)
+		if err != nil {
+			http.Error(w, "error denying access tokens", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	res := toUserRes(updated)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
 
-func (h handler) deleteUser(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	i, err := strconv.ParseInt(id, 10, 64)
-	if err != nil {
-		http.Error(w, "error parsing ID", http.StatusBadRequest)
+func (h *handler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDParam(w, r)
+	if !ok {
 		return
 	}
 
-	err = h.server.DeleteUser(h.ctx, i)
+	err := h.server.DeleteUser(r.Context(), id)
 	if err != nil {
 		http.Error(w, "error deleting user", http.StatusInternalServerError)
 		return
@@ -292,6 +572,24 @@ func (h handler) deleteUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// userIDParam parses the {id} URL parameter and checks that the caller is
+// either that user or an admin.
+func userIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return 0, false
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	if !claims.IsAdmin && claims.ID != id {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return 0, false
+	}
+
+	return id, true
+}
+
 func (h *handler) loginUser(w http.ResponseWriter, r *http.Request) {
 	var u LoginUserReq
 	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
@@ -299,36 +597,180 @@ func (h *handler) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	usr, err := h.server.GetUser(h.ctx, u.Email)
+	// Unknown emails and wrong passwords get the same answer, so that the
+	// login cannot be used to find out which accounts exist.
+	usr, err := h.server.GetUser(r.Context(), u.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		util.CheckPassword(u.Password, h.dummyHash())
+		http.Error(w, "invalid email or password", http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
 		http.Error(w, "error getting user", http.StatusInternalServerError)
 		return
 	}
 
+	if h.checkLocked(w, usr) {
+		return
+	}
+
 	err = util.CheckPassword(u.Password, usr.Password)
 	if err != nil {
-		http.Error(w, "wrong password", http.StatusUnauthorized)
+		h.loginFailed(r.Context(), w, usr, "invalid email or password")
+		return
+	}
+
+	if h.config.PasswordHasher.NeedsRehash(usr.Password) {
+		usr.Password, err = h.config.PasswordHasher.Hash(u.Password)
+		if err != nil {
+			http.Error(w, "error hashing password", http.StatusInternalServerError)
+			return
+		}
+
+		usr, err = h.server.UpdateUser(r.Context(), usr)
+		if err != nil {
+			http.Error(w, "error updating user", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if h.config.RequireVerifiedEmailForLogin && !usr.EmailVerified {
+		http.Error(w, "email not verified", http.StatusForbidden)
+		return
+	}
+
+	if usr.TOTPEnabled {
+		h.mfaChallenge(w, usr)
+		return
+	}
+
+	h.startSession(w, r, usr)
+}
+
+// mfaChallenge answers the password step of a login for users with TOTP
+// enabled. The returned token is only accepted by loginMFA.
+func (h *handler) mfaChallenge(w http.ResponseWriter, usr *storer.User) {
+	mfaToken, claims, err := h.tokenMaker.CreateToken(usr.ID, usr.Email, usr.IsAdmin, usr.TenantID, token.MFAToken, h.config.MFAChallengeTTL)
+	if err != nil {
+		http.Error(w, "error creating token", http.StatusInternalServerError)
+		return
+	}
+
+	res := MFAChallengeRes{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+		ExpiresAt:   claims.ExpiresAt.Time,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) loginMFA(w http.ResponseWriter, r *http.Request) {
+	var req LoginMFAReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := h.tokenMaker.VerifyToken(req.MFAToken, token.MFAToken)
+	if err != nil {
+		http.Error(w, "error verifying token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, err := tokenTenant(r.Context(), claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	r = r.WithContext(ctx)
+
+	usr, err := h.server.GetUserByID(r.Context(), claims.ID)
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	if !usr.TOTPEnabled || usr.TOTPSecret == nil {
+		http.Error(w, "two-factor authentication not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if h.checkLocked(w, usr) {
+		return
+	}
+
+	switch {
+	case req.Code != "":
+		ok, err := h.useTOTPCode(r.Context(), usr, req.Code)
+		if err != nil {
+			http.Error(w, "error using code", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			h.loginFailed(r.Context(), w, usr, "invalid code")
+			return
+		}
+	case req.RecoveryCode != "":
+		hash := util.HashToken(util.NormalizeRecoveryCode(req.RecoveryCode))
+		err = h.server.UseRecoveryCode(r.Context(), usr.ID, hash)
+		if errors.Is(err, storer.ErrInvalidRecoveryCode) {
+			h.loginFailed(r.Context(), w, usr, "invalid recovery code")
+			return
+		}
+		if err != nil {
+			http.Error(w, "error using recovery code", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "missing code", http.StatusBadRequest)
+		return
+	}
+
+	h.startSession(w, r, usr)
+}
+
+// startSession issues an access and a refresh token to usr and answers with
+// a LoginUserRes.
+func (h *handler) startSession(w http.ResponseWriter, r *http.Request, usr *storer.User) {
+	if usr.FailedLogins > 0 || usr.LockedUntil != nil {
+		if err := h.server.UnlockUser(r.Context(), usr.ID); err != nil {
+			http.Error(w, "error resetting failed logins", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := h.recordLogin(r, usr); err != nil {
+		http.Error(w, "error recording login", http.StatusInternalServerError)
 		return
 	}
 
-	accessToken, accessClaims, err := h.tokenMaker.CreateToken(usr.ID, usr.Email, usr.IsAdmin, 15*time.Minute)
+	accessToken, accessClaims, err := h.tokenMaker.CreateToken(usr.ID, usr.Email, usr.IsAdmin, usr.TenantID, token.AccessToken, h.config.AccessTokenTTL)
 	if err != nil {
 		http.Error(w, "error creating token", http.StatusInternalServerError)
 		return
 	}
 
-	refreshToken, refreshClaims, err := h.tokenMaker.CreateToken(usr.ID, usr.Email, usr.IsAdmin, 24*time.Minute)
+	refreshToken, refreshClaims, err := h.tokenMaker.CreateToken(usr.ID, usr.Email, usr.IsAdmin, usr.TenantID, token.RefreshToken, h.config.RefreshTokenTTL)
 	if err != nil {
 		http.Error(w, "error creating refresh token", http.StatusInternalServerError)
 		return
 	}
 
-	session, err := h.server.CreateSession(h.ctx, &storer.Session{
+	session, err := h.server.CreateSession(r.Context(), &storer.Session{
 		ID:           refreshClaims.RegisteredClaims.ID,
+		FamilyID:     refreshClaims.RegisteredClaims.ID,
 		UserEmail:    usr.Email,
 		RefreshToken: refreshToken,
+		UserAgent:    r.UserAgent(),
+		ClientIP:     clientIP(r),
 		IsRevoked:    false,
 		ExpiresAt:    refreshClaims.RegisteredClaims.ExpiresAt.Time,
+
+		AccessTokenID:   &accessClaims.RegisteredClaims.ID,
+		AccessExpiresAt: &accessClaims.RegisteredClaims.ExpiresAt.Time,
 	})
 	if err != nil {
 		http.Error(w, "error creating session", http.StatusInternalServerError)
@@ -350,13 +792,35 @@ func (h *handler) loginUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *handler) logoutUser(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	if id == "" {
-		http.Error(w, "missing session ID", http.StatusBadRequest)
+	var req LogoutUserReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	refreshClaims, err := h.tokenMaker.VerifyToken(req.RefreshToken, token.RefreshToken)
+	if err != nil {
+		http.Error(w, "error verifying token", http.StatusUnauthorized)
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	if refreshClaims.Email != claims.Email || refreshClaims.TenantID != claims.TenantID {
+		http.Error(w, "invalid session", http.StatusUnauthorized)
 		return
 	}
 
-	err := h.server.DeleteSession(h.ctx, id)
+	sessionID := refreshClaims.RegisteredClaims.ID
+	err = h.denyAccessTokens(r.Context(), claims.Email, func(se *storer.Session) bool { return se.ID == sessionID })
+	if err == nil && claims.RegisteredClaims.ID != "" {
+		err = h.config.Denylist.DenyToken(r.Context(), claims.RegisteredClaims.ID, claims.ExpiresAt.Time)
+	}
+	if err != nil {
+		http.Error(w, "error denying access tokens", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.server.DeleteSession(r.Context(), sessionID)
 	if err != nil {
 		http.Error(w, "error deleting session", http.StatusInternalServerError)
 		return
@@ -372,13 +836,20 @@ func (h *handler) renewAccessToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refreshClaims, err := h.tokenMaker.VerifyToken(req.RefreshToken)
+	refreshClaims, err := h.tokenMaker.VerifyToken(req.RefreshToken, token.RefreshToken)
 	if err != nil {
 		http.Error(w, "error verifying token", http.StatusUnauthorized)
 		return
 	}
 
-	session, err := h.server.GetSession(h.ctx, refreshClaims.RegisteredClaims.ID)
+	ctx, err := tokenTenant(r.Context(), refreshClaims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	r = r.WithContext(ctx)
+
+	session, err := h.server.GetSession(r.Context(), refreshClaims.RegisteredClaims.ID)
 	if err != nil {
 		http.Error(w, "error getting session", http.StatusInternalServerError)
 		return
@@ -394,15 +865,60 @@ func (h *handler) renewAccessToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, accessClaims, err := h.tokenMaker.CreateToken(refreshClaims.ID, refreshClaims.Email, refreshClaims.IsAdmin, 15*time.Minute)
+	if session.ReplacedBy != nil {
+		h.refreshTokenReused(r.Context(), w, session)
+		return
+	}
+
+	// The role may have changed since login, so take it from the user
+	// rather than from the refresh token.
+	usr, err := h.server.GetUser(r.Context(), session.UserEmail)
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	refreshToken, newRefreshClaims, err := h.tokenMaker.CreateToken(usr.ID, usr.Email, usr.IsAdmin, usr.TenantID, token.RefreshToken, h.config.RefreshTokenTTL)
+	if err != nil {
+		http.Error(w, "error creating refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, accessClaims, err := h.tokenMaker.CreateToken(usr.ID, usr.Email, usr.IsAdmin, usr.TenantID, token.AccessToken, h.config.AccessTokenTTL)
 	if err != nil {
 		http.Error(w, "error creating token", http.StatusInternalServerError)
 		return
 	}
 
+	next, err := h.server.RotateSession(r.Context(), session.ID, &storer.Session{
+		ID:           newRefreshClaims.RegisteredClaims.ID,
+		FamilyID:     session.FamilyID,
+		UserEmail:    session.UserEmail,
+		RefreshToken: refreshToken,
+		UserAgent:    r.UserAgent(),
+		ClientIP:     clientIP(r),
+		IsRevoked:    false,
+		CreatedAt:    session.CreatedAt,
+		ExpiresAt:    newRefreshClaims.RegisteredClaims.ExpiresAt.Time,
+
+		AccessTokenID:   &accessClaims.RegisteredClaims.ID,
+		AccessExpiresAt: &accessClaims.RegisteredClaims.ExpiresAt.Time,
+	})
+	if errors.Is(err, storer.ErrSessionRotated) {
+		h.refreshTokenReused(r.Context(), w, session)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error rotating session", http.StatusInternalServerError)
+		return
+	}
+
 	res := RenewAccessTokenRes{
-		AccessToken:          accessToken,
-		AccessTokenExpiresAt: accessClaims.RegisteredClaims.ExpiresAt.Time,
+		SessionID:             next.ID,
+		AccessToken:           accessToken,
+		RefreshToken:          refreshToken,
+		AccessTokenExpiresAt:  accessClaims.RegisteredClaims.ExpiresAt.Time,
+		RefreshTokenExpiresAt: newRefreshClaims.RegisteredClaims.ExpiresAt.Time,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -410,14 +926,44 @@ func (h *handler) renewAccessToken(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
-func (h *handler) revokeSession(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	if id == "" {
-		http.Error(w, "missing session ID", http.StatusBadRequest)
+// refreshTokenReused handles a refresh token that was already exchanged.
+// Either the client or an attacker holds a stolen copy, and there is no way
+// to tell which, so every session descended from the same login is revoked.
+func (h *handler) refreshTokenReused(ctx context.Context, w http.ResponseWriter, session *storer.Session) {
+	err := h.revokeSessionFamily(ctx, session)
+	if err != nil {
+		http.Error(w, "error revoking sessions", http.StatusInternalServerError)
 		return
 	}
 
-	err := h.server.RevokeSession(h.ctx, id)
+	_, err = h.server.CreateSecurityEvent(ctx, &storer.SecurityEvent{
+		UserEmail: session.UserEmail,
+		EventType: storer.SecurityEventRefreshTokenReuse,
+		SessionID: &session.ID,
+		FamilyID:  &session.FamilyID,
+	})
+	if err != nil {
+		http.Error(w, "error recording security event", http.StatusInternalServerError)
+		return
+	}
+
+	http.Error(w, "refresh token reused", http.StatusUnauthorized)
+}
+
+func (h *handler) revokeSession(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "missing session ID", http.StatusBadRequest)
+		return
+	}
+
+	session, err := h.server.GetSession(r.Context(), id)
+	if err != nil {
+		http.Error(w, "error getting session", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.revokeSessionFamily(r.Context(), session)
 	if err != nil {
 		http.Error(w, "error revoking session", http.StatusInternalServerError)
 		return
@@ -425,3 +971,523 @@ func (h *handler) revokeSession(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// forgotPassword answers 202 whether or not the email belongs to a user, so
+// that it cannot be used to discover accounts.
+func (h *handler) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Email == "" {
+		http.Error(w, "missing email", http.StatusBadRequest)
+		return
+	}
+
+	usr, err := h.server.GetUser(r.Context(), req.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.sendUserToken(r.Context(), usr, storer.UserTokenPasswordReset, h.config.PasswordResetTTL)
+	if err != nil {
+		http.Error(w, "error sending reset token", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendUserToken issues a one-time token for purpose and enqueues an email
+// that carries it to usr.
+func (h *handler) sendUserToken(ctx context.Context, usr *storer.User, purpose string, ttl time.Duration) error {
+	tok, hash, err := util.NewOneTimeToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	_, err = h.server.CreateUserToken(ctx, &storer.UserToken{
+		TokenHash: hash,
+		UserID:    usr.ID,
+		Purpose:   purpose,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"name":       usr.Name,
+		"token":      tok,
+		"expires_at": expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = h.server.CreateNotification(ctx, &storer.Notification{
+		Channel:   storer.NotificationChannelEmail,
+		Recipient: usr.Email,
+		Template:  purpose,
+		Data:      data,
+	})
+	return err
+}
+
+func (h *handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+
+	t, err := h.server.ConsumeUserToken(r.Context(), util.HashToken(req.Token), storer.UserTokenEmailVerification)
+	if errors.Is(err, storer.ErrInvalidUserToken) {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error consuming verification token", http.StatusInternalServerError)
+		return
+	}
+
+	usr, err := h.server.GetUserByID(r.Context(), t.UserID)
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	usr.EmailVerified = true
+	_, err = h.server.UpdateUser(r.Context(), usr)
+	if err != nil {
+		http.Error(w, "error updating user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resendVerification answers 202 for unknown and already verified emails
+// alike, so that it cannot be used to discover accounts.
+func (h *handler) resendVerification(w http.ResponseWriter, r *http.Request) {
+	var req ResendVerificationReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Email == "" {
+		http.Error(w, "missing email", http.StatusBadRequest)
+		return
+	}
+
+	usr, err := h.server.GetUser(r.Context(), req.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	if !usr.EmailVerified {
+		err = h.sendUserToken(r.Context(), usr, storer.UserTokenEmailVerification, h.config.EmailVerificationTTL)
+		if err != nil {
+			http.Error(w, "error sending verification token", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" || req.Password == "" {
+		http.Error(w, "missing token or password", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.config.PasswordPolicy.Validate(req.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := h.config.PasswordHasher.Hash(req.Password)
+	if err != nil {
+		http.Error(w, "error hashing password", http.StatusInternalServerError)
+		return
+	}
+
+	t, err := h.server.ResetPassword(r.Context(), util.HashToken(req.Token), hash)
+	if errors.Is(err, storer.ErrInvalidUserToken) {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error resetting password", http.StatusInternalServerError)
+		return
+	}
+
+	usr, err := h.server.GetUserByID(r.Context(), t.UserID)
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.revokeSessions(r.Context(), usr.Email)
+	if err != nil {
+		http.Error(w, "error revoking sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listMySessions(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	h.listSessions(r.Context(), w, claims.Email)
+}
+
+func (h *handler) revokeMySession(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	h.revokeSessionOf(r.Context(), w, claims.Email, chi.URLParam(r, "sessionID"))
+}
+
+// revokeMySessions logs the caller out everywhere.
+func (h *handler) revokeMySessions(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	h.revokeSessionsOf(r.Context(), w, claims.Email)
+}
+
+func (h *handler) listUserSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	h.listSessions(r.Context(), w, user.Email)
+}
+
+func (h *handler) revokeUserSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	h.revokeSessionOf(r.Context(), w, user.Email, chi.URLParam(r, "sessionID"))
+}
+
+func (h *handler) revokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	h.revokeSessionsOf(r.Context(), w, user.Email)
+}
+
+func (h *handler) userFromParam(w http.ResponseWriter, r *http.Request) (*storer.User, bool) {
+	id, ok := userIDParam(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	user, err := h.server.GetUserByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (h *handler) listSessions(ctx context.Context, w http.ResponseWriter, email string) {
+	sessions, err := h.server.ListSessions(ctx, email)
+	if err != nil {
+		http.Error(w, "error listing sessions", http.StatusInternalServerError)
+		return
+	}
+
+	res := ListSessionRes{Sessions: []SessionRes{}}
+	for _, se := range sessions {
+		res.Sessions = append(res.Sessions, toSessionRes(&se))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) revokeSessionOf(ctx context.Context, w http.ResponseWriter, email, id string) {
+	session, err := h.server.GetSession(ctx, id)
+	if err != nil {
+		http.Error(w, "error getting session", http.StatusInternalServerError)
+		return
+	}
+
+	if session.UserEmail != email {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	err = h.revokeSessionFamily(ctx, session)
+	if err != nil {
+		http.Error(w, "error revoking session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeSessionFamily revokes every session descended from the same login as
+// session, along with their access tokens.
+func (h *handler) revokeSessionFamily(ctx context.Context, session *storer.Session) error {
+	err := h.server.RevokeSessionFamily(ctx, session.FamilyID)
+	if err != nil {
+		return err
+	}
+
+	return h.denyAccessTokens(ctx, session.UserEmail, func(se *storer.Session) bool { return se.FamilyID == session.FamilyID })
+}
+
+// revokeSessions revokes every session of a user, along with their access
+// tokens.
+func (h *handler) revokeSessions(ctx context.Context, email string) error {
+	err := h.server.RevokeSessionsByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	return h.denyAccessTokens(ctx, email, nil)
+}
+
+// denyAccessTokens adds the unexpired access tokens of the user's sessions
+// that match to the denylist. A nil match denies all of them.
+func (h *handler) denyAccessTokens(ctx context.Context, email string, match func(*storer.Session) bool) error {
+	sessions, err := h.server.ListLiveAccessTokens(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	for _, se := range sessions {
+		if match != nil && !match(&se) {
+			continue
+		}
+
+		err = h.config.Denylist.DenyToken(ctx, *se.AccessTokenID, *se.AccessExpiresAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *handler) revokeSessionsOf(ctx context.Context, w http.ResponseWriter, email string) {
+	err := h.revokeSessions(ctx, email)
+	if err != nil {
+		http.Error(w, "error revoking sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// enrollTOTP generates a new secret for the caller. It only takes effect
+// once activateTOTP has seen a valid code for it.
+func (h *handler) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	usr, err := h.server.GetUserByID(r.Context(), claims.ID)
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return
+	}
+
+	if usr.TOTPEnabled {
+		http.Error(w, "two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := util.NewTOTPSecret()
+	if err != nil {
+		http.Error(w, "error generating secret", http.StatusInternalServerError)
+		return
+	}
+
+	usr.TOTPSecret = &secret
+	_, err = h.server.UpdateUser(r.Context(), usr)
+	if err != nil {
+		http.Error(w, "error updating user", http.StatusInternalServerError)
+		return
+	}
+
+	res := EnrollTOTPRes{
+		Secret:          secret,
+		ProvisioningURI: util.TOTPProvisioningURI(h.config.TOTPIssuer, usr.Email, secret),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// activateTOTP enables two-factor authentication and returns a fresh set of
+// recovery codes. The codes are only stored hashed and cannot be shown again.
+func (h *handler) activateTOTP(w http.ResponseWriter, r *http.Request) {
+	usr, ok := h.checkTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	if usr.TOTPEnabled {
+		http.Error(w, "two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := util.NewRecoveryCode()
+		if err != nil {
+			http.Error(w, "error generating recovery codes", http.StatusInternalServerError)
+			return
+		}
+		codes[i] = code
+		hashes[i] = util.HashToken(code)
+	}
+
+	err := h.server.ReplaceRecoveryCodes(r.Context(), usr.ID, hashes)
+	if err != nil {
+		http.Error(w, "error storing recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	usr.TOTPEnabled = true
+	_, err = h.server.UpdateUser(r.Context(), usr)
+	if err != nil {
+		http.Error(w, "error updating user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RecoveryCodesRes{RecoveryCodes: codes})
+}
+
+func (h *handler) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	usr, ok := h.checkTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	if !usr.TOTPEnabled {
+		http.Error(w, "two-factor authentication not enabled", http.StatusBadRequest)
+		return
+	}
+
+	err := h.server.ReplaceRecoveryCodes(r.Context(), usr.ID, nil)
+	if err != nil {
+		http.Error(w, "error deleting recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	usr.TOTPEnabled = false
+	usr.TOTPSecret = nil
+	_, err = h.server.UpdateUser(r.Context(), usr)
+	if err != nil {
+		http.Error(w, "error updating user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkTOTPCode loads the caller and checks the code in the request body
+// against their enrolled secret.
+func (h *handler) checkTOTPCode(w http.ResponseWriter, r *http.Request) (*storer.User, bool) {
+	var req TOTPCodeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	usr, err := h.server.GetUserByID(r.Context(), claims.ID)
+	if err != nil {
+		http.Error(w, "error getting user", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if usr.TOTPSecret == nil {
+		http.Error(w, "two-factor authentication not enrolled", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ok, err := h.useTOTPCode(r.Context(), usr, req.Code)
+	if err != nil {
+		http.Error(w, "error using code", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !ok {
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return usr, true
+}
+
+// useTOTPCode checks code against the secret of usr and records its time
+// step, so that each code is accepted at most once.
+func (h *handler) useTOTPCode(ctx context.Context, usr *storer.User, code string) (bool, error) {
+	step, ok := util.ValidateTOTP(*usr.TOTPSecret, code, time.Now(), usr.TOTPLastStep)
+	if !ok {
+		return false, nil
+	}
+
+	err := h.server.UseTOTPStep(ctx, usr.ID, step)
+	if errors.Is(err, storer.ErrTOTPStepUsed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	usr.TOTPLastStep = step
+
+	return true, nil
+}
+
+func (h *handler) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	// Only makers with published verification keys have anything to list.
+	set := token.JWKS{Keys: []token.JWK{}}
+	if publisher, ok := h.tokenMaker.(interface{ JWKS() token.JWKS }); ok {
+		set = publisher.JWKS()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(set)
+}
@@ -0,0 +1,3078 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/handler"
+	"github.com/Turtel216/micro-panel/micropanel-api/handler/handlertest"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/Turtel216/micro-panel/util"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type routeTest struct {
+	name   string
+	setup  func(*testing.T, *handlertest.Harness) handlertest.Request
+	status int
+	check  func(*testing.T, *handlertest.Harness, *handlertest.Response)
+}
+
+func runRouteTests(t *testing.T, tcs []routeTest) {
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			h := handlertest.New(t)
+			res := h.Do(t, tc.setup(t, h))
+			require.Equal(t, tc.status, res.StatusCode, string(res.Body))
+
+			if tc.check != nil {
+				tc.check(t, h, res)
+			}
+		})
+	}
+}
+
+func createProduct(t *testing.T, h *handlertest.Harness) *storer.Product {
+	p, err := h.Storer.CreateProduct(context.Background(), &storer.Product{
+		Name:         "test product",
+		Image:        "test.jpg",
+		Category:     "test category",
+		Description:  "test description",
+		Rating:       5,
+		NumReviews:   10,
+		Price:        100.0,
+		CountInStock: 100,
+	})
+	require.NoError(t, err)
+	return p
+}
+
+func createOrder(t *testing.T, h *handlertest.Harness) *storer.Order {
+	p := createProduct(t, h)
+	o, err := h.Storer.CreateOrder(context.Background(), &storer.Order{
+		PaymentMethod: "test payment method",
+		TaxPrice:      10.0,
+		ShippingPrice: 20.0,
+		TotalPrice:    129.99,
+		Items:         []storer.OrderItem{{Name: "test product", Quantity: 1, Price: 99.99, ProductID: p.ID}},
+	})
+	require.NoError(t, err)
+	return o
+}
+
+func productETag(t *testing.T, h *handlertest.Harness, id int64) string {
+	res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/products/%d", id)})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.NotEmpty(t, res.Header.Get("ETag"))
+	return res.Header.Get("ETag")
+}
+
+func adminToken(t *testing.T, h *handlertest.Harness) string {
+	admin := h.CreateUser(t, "admin@example.com", "password", true)
+	return h.AccessToken(t, admin)
+}
+
+// newOrderReq creates the product that the returned order is for.
+func newOrderReq(t *testing.T, h *handlertest.Harness) handler.OrderReq {
+	p := createProduct(t, h)
+	return handler.OrderReq{
+		PaymentMethod: "test payment method",
+		TaxPrice:      10.0,
+		ShippingPrice: 20.0,
+		TotalPrice:    129.99,
+		Items:         []handler.OrderItem{{Name: "test product", Quantity: 1, Price: 99.99, ProductID: p.ID}},
+	}
+}
+
+func TestProductRoutes(t *testing.T) {
+	runRouteTests(t, []routeTest{
+		{
+			name: "create product",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodPost, Path: "/products", Body: handler.ProductReq{Name: "test product", Price: 10}, Token: adminToken(t, h)}
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+				var p handler.ProductRes
+				res.Decode(t, &p)
+				require.NotZero(t, p.ID)
+				require.Equal(t, "test product", p.Name)
+			},
+		},
+		{
+			name: "create product requires authentication",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodPost, Path: "/products", Body: handler.ProductReq{Name: "test product", Price: 10}}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "create product requires admin",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodPost, Path: "/products", Body: handler.ProductReq{Name: "test product", Price: 10}, Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "create product with invalid body",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodPost, Path: "/products", Body: "{", Token: adminToken(t, h)}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "create product storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.Storer.FailOn("CreateProduct", fmt.Errorf("error inserting product"))
+				return handlertest.Request{Method: http.MethodPost, Path: "/products", Body: handler.ProductReq{Name: "test product"}, Token: adminToken(t, h)}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "get product",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				return handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/products/%d", p.ID)}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.NotEmpty(t, res.Header.Get("ETag"))
+
+				var p handler.ProductRes
+				res.Decode(t, &p)
+				require.Equal(t, "test product", p.Name)
+			},
+		},
+		{
+			name: "get product with invalid id",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodGet, Path: "/products/abc"}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "get product storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				h.Storer.FailOn("GetProduct", fmt.Errorf("error getting product"))
+				return handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/products/%d", p.ID)}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "get product not modified",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				return handlertest.Request{
+					Method: http.MethodGet,
+					Path:   fmt.Sprintf("/products/%d", p.ID),
+					Header: http.Header{"If-None-Match": {productETag(t, h, p.ID)}},
+				}
+			},
+			status: http.StatusNotModified,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Empty(t, res.Body)
+			},
+		},
+		{
+			name: "list products",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				createProduct(t, h)
+				createProduct(t, h)
+				return handlertest.Request{Method: http.MethodGet, Path: "/products"}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var ps []handler.ProductRes
+				res.Decode(t, &ps)
+				require.Len(t, ps, 2)
+			},
+		},
+		{
+			name: "list products not modified",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				createProduct(t, h)
+				res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/products"})
+				return handlertest.Request{
+					Method: http.MethodGet,
+					Path:   "/products",
+					Header: http.Header{"If-None-Match": {res.Header.Get("ETag")}},
+				}
+			},
+			status: http.StatusNotModified,
+		},
+		{
+			name: "list products changes etag after create",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				createProduct(t, h)
+				res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/products"})
+				createProduct(t, h)
+				return handlertest.Request{
+					Method: http.MethodGet,
+					Path:   "/products",
+					Header: http.Header{"If-None-Match": {res.Header.Get("ETag")}},
+				}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "list products storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.Storer.FailOn("ListProducts", fmt.Errorf("error listing products"))
+				return handlertest.Request{Method: http.MethodGet, Path: "/products"}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "update product",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				return handlertest.Request{Method: http.MethodPatch, Path: fmt.Sprintf("/products/%d", p.ID), Body: handler.ProductReq{Name: "new name"}, Token: adminToken(t, h)}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var p handler.ProductRes
+				res.Decode(t, &p)
+				require.Equal(t, "new name", p.Name)
+				require.Equal(t, "test.jpg", p.Image)
+				require.Equal(t, productETag(t, h, p.ID), res.Header.Get("ETag"))
+			},
+		},
+		{
+			name: "update product with matching etag",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   fmt.Sprintf("/products/%d", p.ID),
+					Body:   handler.ProductReq{Name: "new name"},
+					Header: http.Header{"If-Match": {productETag(t, h, p.ID)}},
+					Token:  adminToken(t, h),
+				}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "update product with stale etag",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   fmt.Sprintf("/products/%d", p.ID),
+					Body:   handler.ProductReq{Name: "new name"},
+					Header: http.Header{"If-Match": {`"stale"`}},
+					Token:  adminToken(t, h),
+				}
+			},
+			status: http.StatusPreconditionFailed,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				p, err := h.Storer.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+				require.Equal(t, "test product", p.Name)
+			},
+		},
+		{
+			name: "update product modified after the etag check",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				h.Storer.FailOn("UpdateProduct", storer.ErrProductModified)
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   fmt.Sprintf("/products/%d", p.ID),
+					Body:   handler.ProductReq{Name: "new name"},
+					Header: http.Header{"If-Match": {productETag(t, h, p.ID)}},
+					Token:  adminToken(t, h),
+				}
+			},
+			status: http.StatusPreconditionFailed,
+		},
+		{
+			name: "update product with etag of an update in the same second",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				etag := productETag(t, h, p.ID)
+				tok := adminToken(t, h)
+				res := h.Do(t, handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   fmt.Sprintf("/products/%d", p.ID),
+					Body:   handler.ProductReq{Name: "first"},
+					Header: http.Header{"If-Match": {etag}},
+					Token:  tok,
+				})
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.NotEqual(t, etag, res.Header.Get("ETag"))
+
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   fmt.Sprintf("/products/%d", p.ID),
+					Body:   handler.ProductReq{Name: "second"},
+					Header: http.Header{"If-Match": {etag}},
+					Token:  tok,
+				}
+			},
+			status: http.StatusPreconditionFailed,
+		},
+		{
+			name: "update product requires admin",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodPatch, Path: fmt.Sprintf("/products/%d", p.ID), Body: handler.ProductReq{Name: "new name"}, Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "update product with invalid body",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				return handlertest.Request{Method: http.MethodPatch, Path: fmt.Sprintf("/products/%d", p.ID), Body: "{", Token: adminToken(t, h)}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "update product storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				h.Storer.FailOn("UpdateProduct", fmt.Errorf("error updating product"))
+				return handlertest.Request{Method: http.MethodPatch, Path: fmt.Sprintf("/products/%d", p.ID), Body: handler.ProductReq{Name: "new name"}, Token: adminToken(t, h)}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "delete product",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/products/%d", p.ID), Token: adminToken(t, h)}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				_, err := h.Storer.GetProduct(context.Background(), 1)
+				require.Error(t, err)
+			},
+		},
+		{
+			name: "delete product requires authentication",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/products/%d", p.ID)}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "delete product with stale etag",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				return handlertest.Request{
+					Method: http.MethodDelete,
+					Path:   fmt.Sprintf("/products/%d", p.ID),
+					Header: http.Header{"If-Match": {`"stale"`}},
+					Token:  adminToken(t, h),
+				}
+			},
+			status: http.StatusPreconditionFailed,
+		},
+		{
+			name: "delete product storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				p := createProduct(t, h)
+				h.Storer.FailOn("DeleteProduct", fmt.Errorf("error deleting product"))
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/products/%d", p.ID), Token: adminToken(t, h)}
+			},
+			status: http.StatusInternalServerError,
+		},
+	})
+}
+
+func TestOrderRoutes(t *testing.T) {
+	runRouteTests(t, []routeTest{
+		{
+			name: "create order",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodPost, Path: "/orders", Body: newOrderReq(t, h), Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var o handler.OrderRes
+				res.Decode(t, &o)
+				require.NotZero(t, o.ID)
+				require.Len(t, o.Items, 1)
+
+				u, err := h.Storer.GetUser(context.Background(), "test@example.com")
+				require.NoError(t, err)
+				stored, err := h.Storer.GetOrder(context.Background(), o.ID)
+				require.NoError(t, err)
+				require.Equal(t, u.ID, stored.UserID)
+			},
+		},
+		{
+			name: "create order requires authentication",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodPost, Path: "/orders", Body: newOrderReq(t, h)}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "create order with invalid body",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodPost, Path: "/orders", Body: "{", Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "create order for unknown product",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				o := newOrderReq(t, h)
+				o.Items[0].ProductID = 99
+				return handlertest.Request{Method: http.MethodPost, Path: "/orders", Body: o, Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "create order storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("CreateOrder", fmt.Errorf("error creating order"))
+				return handlertest.Request{Method: http.MethodPost, Path: "/orders", Body: newOrderReq(t, h), Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "create order with invalid token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodPost, Path: "/orders", Body: newOrderReq(t, h), Token: "invalid"}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "idempotency key requires authentication",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders",
+					Body:   newOrderReq(t, h),
+					Header: http.Header{"Idempotency-Key": {"key"}},
+				}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "idempotency key replays response",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				req := handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders",
+					Body:   newOrderReq(t, h),
+					Token:  h.AccessToken(t, u),
+					Header: http.Header{"Idempotency-Key": {"key"}},
+				}
+				res := h.Do(t, req)
+				require.Equal(t, http.StatusCreated, res.StatusCode)
+				require.Empty(t, res.Header.Get("Idempotent-Replayed"))
+				return req
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, "true", res.Header.Get("Idempotent-Replayed"))
+
+				var o handler.OrderRes
+				res.Decode(t, &o)
+
+				orders, err := h.Storer.ListOrders(context.Background())
+				require.NoError(t, err)
+				require.Len(t, orders, 1)
+				require.Equal(t, orders[0].ID, o.ID)
+			},
+		},
+		{
+			name: "idempotency key reused with different body",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				req := handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders",
+					Body:   newOrderReq(t, h),
+					Token:  h.AccessToken(t, u),
+					Header: http.Header{"Idempotency-Key": {"key"}},
+				}
+				res := h.Do(t, req)
+				require.Equal(t, http.StatusCreated, res.StatusCode)
+
+				other := req.Body.(handler.OrderReq)
+				other.TotalPrice = 1
+				req.Body = other
+				return req
+			},
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "idempotency keys are scoped per user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u1 := h.CreateUser(t, "test1@example.com", "password", false)
+				u2 := h.CreateUser(t, "test2@example.com", "password", false)
+				res := h.Do(t, handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders",
+					Body:   newOrderReq(t, h),
+					Token:  h.AccessToken(t, u1),
+					Header: http.Header{"Idempotency-Key": {"key"}},
+				})
+				require.Equal(t, http.StatusCreated, res.StatusCode)
+
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders",
+					Body:   newOrderReq(t, h),
+					Token:  h.AccessToken(t, u2),
+					Header: http.Header{"Idempotency-Key": {"key"}},
+				}
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Empty(t, res.Header.Get("Idempotent-Replayed"))
+			},
+		},
+		{
+			name: "idempotency key is released after server error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				req := handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders",
+					Body:   newOrderReq(t, h),
+					Token:  h.AccessToken(t, u),
+					Header: http.Header{"Idempotency-Key": {"key"}},
+				}
+				h.Storer.FailOn("CreateOrder", fmt.Errorf("error creating order"))
+				res := h.Do(t, req)
+				require.Equal(t, http.StatusInternalServerError, res.StatusCode)
+
+				_, err := h.Storer.GetIdempotencyKey(context.Background(), u.ID, "key")
+				require.Error(t, err)
+				return req
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "idempotency key save error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("SaveIdempotencyResponse", fmt.Errorf("error saving response"))
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders",
+					Body:   newOrderReq(t, h),
+					Token:  h.AccessToken(t, u),
+					Header: http.Header{"Idempotency-Key": {"key"}},
+				}
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, uint64(1), h.Stats().IdempotencyErrors)
+			},
+		},
+		{
+			name: "idempotency key delete error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("CreateOrder", fmt.Errorf("error creating order"))
+				h.Storer.FailOn("DeleteIdempotencyKey", fmt.Errorf("error deleting key"))
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders",
+					Body:   newOrderReq(t, h),
+					Token:  h.AccessToken(t, u),
+					Header: http.Header{"Idempotency-Key": {"key"}},
+				}
+			},
+			status: http.StatusInternalServerError,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, uint64(1), h.Stats().IdempotencyErrors)
+			},
+		},
+		{
+			name: "get order",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				o := createOrder(t, h)
+				return handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/orders/%d", o.ID)}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var o handler.OrderRes
+				res.Decode(t, &o)
+				require.Equal(t, "test payment method", o.PaymentMethod)
+			},
+		},
+		{
+			name: "get order with invalid id",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodGet, Path: "/orders/abc"}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "get order storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				o := createOrder(t, h)
+				h.Storer.FailOn("GetOrder", fmt.Errorf("error getting order"))
+				return handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/orders/%d", o.ID)}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "list orders",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				createOrder(t, h)
+				return handlertest.Request{Method: http.MethodGet, Path: "/orders"}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var os []handler.OrderRes
+				res.Decode(t, &os)
+				require.Len(t, os, 1)
+			},
+		},
+		{
+			name: "list orders storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.Storer.FailOn("ListOrders", fmt.Errorf("error listing orders"))
+				return handlertest.Request{Method: http.MethodGet, Path: "/orders"}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "delete order",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				o := createOrder(t, h)
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/orders/%d", o.ID)}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "delete order storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				o := createOrder(t, h)
+				h.Storer.FailOn("DeleteOrder", fmt.Errorf("error deleting order"))
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/orders/%d", o.ID)}
+			},
+			status: http.StatusInternalServerError,
+		},
+	})
+}
+
+func TestUserRoutes(t *testing.T) {
+	runRouteTests(t, []routeTest{
+		{
+			name: "register user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/register",
+					Body:   handler.UserReq{Name: "test", Email: "test@example.com", Password: "password", IsAdmin: true},
+				}
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.NotContains(t, string(res.Body), "password")
+
+				u, err := h.Storer.GetUser(context.Background(), "test@example.com")
+				require.NoError(t, err)
+				require.NoError(t, util.CheckPassword("password", u.Password))
+				require.False(t, u.IsAdmin)
+			},
+		},
+		{
+			name: "register user with invalid body",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodPost, Path: "/auth/register", Body: "{"}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "register user storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.Storer.FailOn("CreateUser", fmt.Errorf("error inserting user"))
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/register",
+					Body:   handler.UserReq{Name: "test", Email: "test@example.com", Password: "password"},
+				}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "admin creates admin",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/users",
+					Body:   handler.UserReq{Name: "test", Email: "test@example.com", Password: "password", IsAdmin: true},
+					Token:  h.AccessToken(t, admin),
+				}
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var u handler.UserRes
+				res.Decode(t, &u)
+				require.True(t, u.IsAdmin)
+			},
+		},
+		{
+			name: "create user requires admin",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "user@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/users",
+					Body:   handler.UserReq{Name: "test", Email: "test@example.com", Password: "password"},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "list users",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodGet, Path: "/users", Token: h.AccessToken(t, admin)}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var us handler.ListUserRes
+				res.Decode(t, &us)
+				require.Len(t, us.Users, 2)
+			},
+		},
+		{
+			name: "list users requires authentication",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodGet, Path: "/users"}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "list users requires admin",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodGet, Path: "/users", Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "list users storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				h.Storer.FailOn("ListUsers", fmt.Errorf("error listing users"))
+				return handlertest.Request{Method: http.MethodGet, Path: "/users", Token: h.AccessToken(t, admin)}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "get user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/users/%d", u.ID), Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var u handler.UserRes
+				res.Decode(t, &u)
+				require.Equal(t, "test@example.com", u.Email)
+			},
+		},
+		{
+			name: "get other user as admin",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/users/%d", u.ID), Token: h.AccessToken(t, admin)}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "get other user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u1 := h.CreateUser(t, "test1@example.com", "password", false)
+				u2 := h.CreateUser(t, "test2@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/users/%d", u2.ID), Token: h.AccessToken(t, u1)}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "get user with invalid id",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodGet, Path: "/users/abc", Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "get me",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodGet, Path: "/me", Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var u handler.UserRes
+				res.Decode(t, &u)
+				require.Equal(t, "test@example.com", u.Email)
+			},
+		},
+		{
+			name: "get me requires authentication",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodGet, Path: "/me"}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "update user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   fmt.Sprintf("/users/%d", u.ID),
+					Body:   handler.UserReq{Name: "new name"},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				u, err := h.Storer.GetUser(context.Background(), "test@example.com")
+				require.NoError(t, err)
+				require.Equal(t, "new name", u.Name)
+			},
+		},
+		{
+			name: "update user cannot grant admin",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   "/me",
+					Body:   handler.UserReq{IsAdmin: true},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "update user stops after get user error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("GetUserByID", fmt.Errorf("error getting user"))
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   fmt.Sprintf("/users/%d", u.ID),
+					Body:   handler.UserReq{Name: "new name"},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusInternalServerError,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, "error getting user\n", string(res.Body))
+			},
+		},
+		{
+			name: "update user storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("UpdateUser", fmt.Errorf("error updating user"))
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   "/me",
+					Body:   handler.UserReq{Name: "new name"},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "delete user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/users/%d", u.ID), Token: h.AccessToken(t, admin)}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				_, err := h.Storer.GetUser(context.Background(), "test@example.com")
+				require.Error(t, err)
+			},
+		},
+		{
+			name: "delete other user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u1 := h.CreateUser(t, "test1@example.com", "password", false)
+				u2 := h.CreateUser(t, "test2@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/users/%d", u2.ID), Token: h.AccessToken(t, u1)}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "delete user storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("DeleteUser", fmt.Errorf("error deleting user"))
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/users/%d", u.ID), Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusInternalServerError,
+		},
+	})
+}
+
+func login(t *testing.T, h *handlertest.Harness, email, password string) handler.LoginUserRes {
+	res := h.Do(t, handlertest.Request{
+		Method: http.MethodPost,
+		Path:   "/auth/login",
+		Body:   handler.LoginUserReq{Email: email, Password: password},
+	})
+	require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+	var lr handler.LoginUserRes
+	res.Decode(t, &lr)
+	return lr
+}
+
+func refresh(t *testing.T, h *handlertest.Harness, refreshToken string) handler.RenewAccessTokenRes {
+	res := h.Do(t, handlertest.Request{
+		Method: http.MethodPost,
+		Path:   "/auth/refresh",
+		Body:   handler.RenewAccessTokenReq{RefreshToken: refreshToken},
+	})
+	require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+	var rr handler.RenewAccessTokenRes
+	res.Decode(t, &rr)
+	return rr
+}
+
+func TestAuthRoutes(t *testing.T) {
+	var sessionID string
+
+	runRouteTests(t, []routeTest{
+		{
+			name: "login",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", true)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/login",
+					Body:   handler.LoginUserReq{Email: "test@example.com", Password: "password"},
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var lr handler.LoginUserRes
+				res.Decode(t, &lr)
+
+				claims, err := h.TokenMaker.VerifyToken(lr.AccessToken, token.AccessToken)
+				require.NoError(t, err)
+				require.Equal(t, "test@example.com", claims.Email)
+				require.True(t, claims.IsAdmin)
+				require.WithinDuration(t, time.Now().Add(15*time.Minute), lr.AccessTokenExpiresAt, time.Minute)
+				require.WithinDuration(t, time.Now().Add(30*24*time.Hour), lr.RefreshTokenExpiresAt, time.Minute)
+
+				_, err = h.TokenMaker.VerifyToken(lr.RefreshToken, token.RefreshToken)
+				require.NoError(t, err)
+
+				se, err := h.Storer.GetSession(context.Background(), lr.SessionID)
+				require.NoError(t, err)
+				require.Equal(t, lr.RefreshToken, se.RefreshToken)
+			},
+		},
+		{
+			name: "refresh token is not a bearer credential",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				return handlertest.Request{Method: http.MethodGet, Path: "/me", Token: lr.RefreshToken}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "access token cannot be used to refresh",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: lr.AccessToken},
+				}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "access token cannot be used to log out",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/logout",
+					Body:   handler.LogoutUserReq{RefreshToken: lr.AccessToken},
+					Token:  lr.AccessToken,
+				}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "login with wrong password",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/login",
+					Body:   handler.LoginUserReq{Email: "test@example.com", Password: "wrong"},
+				}
+			},
+			status: http.StatusUnauthorized,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, "invalid email or password\n", string(res.Body))
+			},
+		},
+		{
+			name: "login with unknown email",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/login",
+					Body:   handler.LoginUserReq{Email: "other@example.com", Password: "wrong"},
+				}
+			},
+			status: http.StatusUnauthorized,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, "invalid email or password\n", string(res.Body))
+			},
+		},
+		{
+			name: "login with invalid body",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodPost, Path: "/auth/login", Body: "{"}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "login session storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("CreateSession", fmt.Errorf("error creating session"))
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/login",
+					Body:   handler.LoginUserReq{Email: "test@example.com", Password: "password"},
+				}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "logout",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				sessionID = lr.SessionID
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/logout",
+					Body:   handler.LogoutUserReq{RefreshToken: lr.RefreshToken},
+					Token:  lr.AccessToken,
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				_, err := h.Storer.GetSession(context.Background(), sessionID)
+				require.Error(t, err)
+			},
+		},
+		{
+			name: "logout requires authentication",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/logout",
+					Body:   handler.LogoutUserReq{RefreshToken: lr.RefreshToken},
+				}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "logout of another user's session",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test1@example.com", "password", false)
+				h.CreateUser(t, "test2@example.com", "password", false)
+				lr1 := login(t, h, "test1@example.com", "password")
+				lr2 := login(t, h, "test2@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/logout",
+					Body:   handler.LogoutUserReq{RefreshToken: lr2.RefreshToken},
+					Token:  lr1.AccessToken,
+				}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "refresh access token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var rr handler.RenewAccessTokenRes
+				res.Decode(t, &rr)
+
+				claims, err := h.TokenMaker.VerifyToken(rr.AccessToken, token.AccessToken)
+				require.NoError(t, err)
+				require.Equal(t, "test@example.com", claims.Email)
+
+				next, err := h.Storer.GetSession(context.Background(), rr.SessionID)
+				require.NoError(t, err)
+				require.Equal(t, rr.RefreshToken, next.RefreshToken)
+				require.Nil(t, next.ReplacedBy)
+
+				prev, err := h.Storer.GetSession(context.Background(), next.FamilyID)
+				require.NoError(t, err)
+				require.NotNil(t, prev.ReplacedBy)
+				require.Equal(t, next.ID, *prev.ReplacedBy)
+				require.False(t, prev.IsRevoked)
+			},
+		},
+		{
+			name: "refresh access token with rotated token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				rr := refresh(t, h, lr.RefreshToken)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: rr.RefreshToken},
+				}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "refresh token reuse revokes the session family",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				sessionID = refresh(t, h, lr.RefreshToken).SessionID
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+				}
+			},
+			status: http.StatusUnauthorized,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				se, err := h.Storer.GetSession(context.Background(), sessionID)
+				require.NoError(t, err)
+				require.True(t, se.IsRevoked)
+
+				events := h.Storer.SecurityEvents()
+				require.Len(t, events, 1)
+				require.Equal(t, storer.SecurityEventRefreshTokenReuse, events[0].EventType)
+				require.Equal(t, "test@example.com", events[0].UserEmail)
+				require.Equal(t, se.FamilyID, *events[0].FamilyID)
+			},
+		},
+		{
+			name: "refresh token reuse storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				refresh(t, h, lr.RefreshToken)
+				h.Storer.FailOn("CreateSecurityEvent", fmt.Errorf("error inserting security event"))
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+				}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "refresh access token with invalid token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: "invalid"},
+				}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "refresh access token with revoked session",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				require.NoError(t, h.Storer.RevokeSession(context.Background(), lr.SessionID))
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+				}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "refresh access token with deleted session",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				require.NoError(t, h.Storer.DeleteSession(context.Background(), lr.SessionID))
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+				}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "revoke session",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				h.CreateUser(t, "test@example.com", "password", false)
+				sessionID = login(t, h, "test@example.com", "password").SessionID
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/sessions/" + sessionID + "/revoke",
+					Token:  h.AccessToken(t, admin),
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				se, err := h.Storer.GetSession(context.Background(), sessionID)
+				require.NoError(t, err)
+				require.True(t, se.IsRevoked)
+			},
+		},
+		{
+			name: "revoke session revokes rotated sessions",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				sessionID = refresh(t, h, lr.RefreshToken).SessionID
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/sessions/" + lr.SessionID + "/revoke",
+					Token:  h.AccessToken(t, admin),
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				se, err := h.Storer.GetSession(context.Background(), sessionID)
+				require.NoError(t, err)
+				require.True(t, se.IsRevoked)
+			},
+		},
+		{
+			name: "revoke session requires admin",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/sessions/" + lr.SessionID + "/revoke",
+					Token:  lr.AccessToken,
+				}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "revoke session storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				lr := login(t, h, "admin@example.com", "password")
+				h.Storer.FailOn("RevokeSessionFamily", fmt.Errorf("error revoking session family"))
+				return handlertest.Request{Method: http.MethodPost, Path: "/auth/sessions/" + lr.SessionID + "/revoke", Token: h.AccessToken(t, admin)}
+			},
+			status: http.StatusInternalServerError,
+		},
+	})
+}
+
+func requestPasswordReset(t *testing.T, h *handlertest.Harness, email string) string {
+	res := h.Do(t, handlertest.Request{
+		Method: http.MethodPost,
+		Path:   "/auth/password/forgot",
+		Body:   handler.ForgotPasswordReq{Email: email},
+	})
+	require.Equal(t, http.StatusAccepted, res.StatusCode, string(res.Body))
+
+	return lastNotificationToken(t, h)
+}
+
+// lastNotificationToken returns the one-time token carried by the most
+// recently enqueued notification.
+func lastNotificationToken(t *testing.T, h *handlertest.Harness) string {
+	notifications := h.Storer.Notifications()
+	require.NotEmpty(t, notifications)
+
+	var data struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(notifications[len(notifications)-1].Data, &data))
+	return data.Token
+}
+
+func TestPasswordResetRoutes(t *testing.T) {
+	var lr handler.LoginUserRes
+	var resetToken string
+
+	runRouteTests(t, []routeTest{
+		{
+			name: "forgot password",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/forgot",
+					Body:   handler.ForgotPasswordReq{Email: "test@example.com"},
+				}
+			},
+			status: http.StatusAccepted,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Empty(t, res.Body)
+
+				notifications := h.Storer.Notifications()
+				require.Len(t, notifications, 1)
+				require.Equal(t, storer.NotificationChannelEmail, notifications[0].Channel)
+				require.Equal(t, "test@example.com", notifications[0].Recipient)
+				require.Equal(t, storer.UserTokenPasswordReset, notifications[0].Template)
+				require.Contains(t, string(notifications[0].Data), `"token"`)
+			},
+		},
+		{
+			name: "forgot password for unknown email",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/forgot",
+					Body:   handler.ForgotPasswordReq{Email: "nobody@example.com"},
+				}
+			},
+			status: http.StatusAccepted,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Empty(t, res.Body)
+				require.Empty(t, h.Storer.Notifications())
+			},
+		},
+		{
+			name: "forgot password storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("CreateUserToken", fmt.Errorf("error creating user token"))
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/forgot",
+					Body:   handler.ForgotPasswordReq{Email: "test@example.com"},
+				}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "reset password",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				resetToken = requestPasswordReset(t, h, "test@example.com")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/reset",
+					Body:   handler.ResetPasswordReq{Token: resetToken, Password: "new password"},
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				login(t, h, "test@example.com", "new password")
+
+				res = h.Do(t, handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/login",
+					Body:   handler.LoginUserReq{Email: "test@example.com", Password: "password"},
+				})
+				require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+				se, err := h.Storer.GetSession(context.Background(), lr.SessionID)
+				require.NoError(t, err)
+				require.True(t, se.IsRevoked)
+
+				res = h.Do(t, handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/reset",
+					Body:   handler.ResetPasswordReq{Token: resetToken, Password: "another password"},
+				})
+				require.Equal(t, http.StatusBadRequest, res.StatusCode, "reset tokens are single use")
+			},
+		},
+		{
+			name: "reset password with superseded token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				first := requestPasswordReset(t, h, "test@example.com")
+				requestPasswordReset(t, h, "test@example.com")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/reset",
+					Body:   handler.ResetPasswordReq{Token: first, Password: "new password"},
+				}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "reset password with expired token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				tok, hash, err := util.NewOneTimeToken()
+				require.NoError(t, err)
+				_, err = h.Storer.CreateUserToken(context.Background(), &storer.UserToken{
+					TokenHash: hash,
+					UserID:    u.ID,
+					Purpose:   storer.UserTokenPasswordReset,
+					ExpiresAt: time.Now().Add(-time.Minute),
+				})
+				require.NoError(t, err)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/reset",
+					Body:   handler.ResetPasswordReq{Token: tok, Password: "new password"},
+				}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "reset password with invalid token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/reset",
+					Body:   handler.ResetPasswordReq{Token: "invalid", Password: "new password"},
+				}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "reset password storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				tok := requestPasswordReset(t, h, "test@example.com")
+				h.Storer.FailOn("ResetPassword", fmt.Errorf("error updating user"))
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/reset",
+					Body:   handler.ResetPasswordReq{Token: tok, Password: "new password"},
+				}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "reset password without password",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/reset",
+					Body:   handler.ResetPasswordReq{Token: requestPasswordReset(t, h, "test@example.com")},
+				}
+			},
+			status: http.StatusBadRequest,
+		},
+	})
+}
+
+func TestEmailVerificationRoutes(t *testing.T) {
+	runRouteTests(t, []routeTest{
+		{
+			name: "register sends verification email",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/register",
+					Body:   handler.UserReq{Name: "test", Email: "test@example.com", Password: "password"},
+				}
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var u handler.UserRes
+				res.Decode(t, &u)
+				require.False(t, u.EmailVerified)
+
+				notifications := h.Storer.Notifications()
+				require.Len(t, notifications, 1)
+				require.Equal(t, "test@example.com", notifications[0].Recipient)
+				require.Equal(t, storer.UserTokenEmailVerification, notifications[0].Template)
+			},
+		},
+		{
+			name: "verify email",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				res := h.Do(t, handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/register",
+					Body:   handler.UserReq{Name: "test", Email: "test@example.com", Password: "password"},
+				})
+				require.Equal(t, http.StatusCreated, res.StatusCode)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/email/verify",
+					Body:   handler.VerifyEmailReq{Token: lastNotificationToken(t, h)},
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				u, err := h.Storer.GetUser(context.Background(), "test@example.com")
+				require.NoError(t, err)
+				require.True(t, u.EmailVerified)
+			},
+		},
+		{
+			name: "verify email with password reset token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/email/verify",
+					Body:   handler.VerifyEmailReq{Token: requestPasswordReset(t, h, "test@example.com")},
+				}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "verify email with invalid token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/email/verify",
+					Body:   handler.VerifyEmailReq{Token: "invalid"},
+				}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "resend verification",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/email/resend",
+					Body:   handler.ResendVerificationReq{Email: "test@example.com"},
+				}
+			},
+			status: http.StatusAccepted,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				notifications := h.Storer.Notifications()
+				require.Len(t, notifications, 1)
+				require.Equal(t, storer.UserTokenEmailVerification, notifications[0].Template)
+			},
+		},
+		{
+			name: "resend verification for verified email",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				u.EmailVerified = true
+				_, err := h.Storer.UpdateUser(context.Background(), u)
+				require.NoError(t, err)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/email/resend",
+					Body:   handler.ResendVerificationReq{Email: "test@example.com"},
+				}
+			},
+			status: http.StatusAccepted,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Empty(t, h.Storer.Notifications())
+			},
+		},
+		{
+			name: "resend verification for unknown email",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/email/resend",
+					Body:   handler.ResendVerificationReq{Email: "nobody@example.com"},
+				}
+			},
+			status: http.StatusAccepted,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Empty(t, h.Storer.Notifications())
+			},
+		},
+		{
+			name: "changing email clears verification",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				u.EmailVerified = true
+				_, err := h.Storer.UpdateUser(context.Background(), u)
+				require.NoError(t, err)
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   "/me",
+					Body:   handler.UserReq{Email: "new@example.com"},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var u handler.UserRes
+				res.Decode(t, &u)
+				require.Equal(t, "new@example.com", u.Email)
+				require.False(t, u.EmailVerified)
+			},
+		},
+	})
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	config := handlertest.Config
+	config.RequireVerifiedEmailForLogin = true
+	config.RequireVerifiedEmailForOrders = true
+
+	verify := func(t *testing.T, h *handlertest.Harness, u *storer.User) {
+		u.EmailVerified = true
+		_, err := h.Storer.UpdateUser(context.Background(), u)
+		require.NoError(t, err)
+	}
+
+	t.Run("login", func(t *testing.T) {
+		h := handlertest.NewWithConfig(t, config)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+
+		req := handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/login",
+			Body:   handler.LoginUserReq{Email: "test@example.com", Password: "password"},
+		}
+		res := h.Do(t, req)
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		verify(t, h, u)
+		res = h.Do(t, req)
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+	})
+
+	t.Run("orders", func(t *testing.T) {
+		h := handlertest.NewWithConfig(t, config)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+
+		res := h.Do(t, handlertest.Request{Method: http.MethodPost, Path: "/orders", Body: newOrderReq(t, h)})
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		req := handlertest.Request{Method: http.MethodPost, Path: "/orders", Body: newOrderReq(t, h), Token: h.AccessToken(t, u)}
+		res = h.Do(t, req)
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		verify(t, h, u)
+		res = h.Do(t, req)
+		require.Equal(t, http.StatusCreated, res.StatusCode, string(res.Body))
+	})
+
+	t.Run("not required by default", func(t *testing.T) {
+		h := handlertest.New(t)
+		h.CreateUser(t, "test@example.com", "password", false)
+		login(t, h, "test@example.com", "password")
+	})
+}
+
+func createAPIKey(t *testing.T, h *handlertest.Harness, accessToken string, scopes ...string) handler.CreateAPIKeyRes {
+	res := h.Do(t, handlertest.Request{
+		Method: http.MethodPost,
+		Path:   "/me/api-keys",
+		Body:   handler.CreateAPIKeyReq{Name: "erp", Scopes: scopes},
+		Token:  accessToken,
+	})
+	require.Equal(t, http.StatusCreated, res.StatusCode, string(res.Body))
+
+	var kr handler.CreateAPIKeyRes
+	res.Decode(t, &kr)
+	return kr
+}
+
+func apiKeyHeader(key string) http.Header {
+	return http.Header{"Authorization": {"ApiKey " + key}}
+}
+
+func TestAPIKeyRoutes(t *testing.T) {
+	var kr handler.CreateAPIKeyRes
+
+	runRouteTests(t, []routeTest{
+		{
+			name: "create",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/me/api-keys",
+					Body:   handler.CreateAPIKeyReq{Name: "erp", Scopes: []string{"products:read", "orders:write"}},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				res.Decode(t, &kr)
+				require.True(t, strings.HasPrefix(kr.Key, "mpk_"+kr.Prefix+"_"))
+				require.Equal(t, []string{"products:read", "orders:write"}, kr.Scopes)
+				require.Nil(t, kr.ExpiresAt)
+
+				k, err := h.Storer.GetAPIKeyByPrefix(context.Background(), kr.Prefix)
+				require.NoError(t, err)
+				require.Equal(t, util.HashToken(kr.Key), k.KeyHash)
+			},
+		},
+		{
+			name: "create with unknown scope",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/me/api-keys",
+					Body:   handler.CreateAPIKeyReq{Name: "erp", Scopes: []string{"orders:delete"}},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "create without scopes",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/me/api-keys",
+					Body:   handler.CreateAPIKeyReq{Name: "erp"},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "create with past expiry",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				expiresAt := time.Now().Add(-time.Minute)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/me/api-keys",
+					Body:   handler.CreateAPIKeyReq{Name: "erp", Scopes: []string{"orders:read"}, ExpiresAt: &expiresAt},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "list",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				createAPIKey(t, h, h.AccessToken(t, u), "orders:read")
+				kr = createAPIKey(t, h, h.AccessToken(t, u), "orders:write")
+
+				res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/orders", Header: apiKeyHeader(kr.Key)})
+				require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+				return handlertest.Request{Method: http.MethodGet, Path: "/me/api-keys", Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.NotContains(t, string(res.Body), kr.Key)
+
+				var lr handler.ListAPIKeyRes
+				res.Decode(t, &lr)
+				require.Len(t, lr.APIKeys, 2)
+				require.Equal(t, kr.ID, lr.APIKeys[0].ID)
+				require.NotNil(t, lr.APIKeys[0].LastUsedAt)
+				require.Nil(t, lr.APIKeys[1].LastUsedAt)
+			},
+		},
+		{
+			name: "revoke",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				kr = createAPIKey(t, h, h.AccessToken(t, u), "orders:read")
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/me/api-keys/%d", kr.ID), Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/orders", Header: apiKeyHeader(kr.Key)})
+				require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+			},
+		},
+		{
+			name: "revoke key of other user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				other := h.CreateUser(t, "other@example.com", "password", false)
+				kr = createAPIKey(t, h, h.AccessToken(t, u), "orders:read")
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/me/api-keys/%d", kr.ID), Token: h.AccessToken(t, other)}
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "admin lists user keys",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				createAPIKey(t, h, h.AccessToken(t, u), "orders:read")
+				return handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/users/%d/api-keys", u.ID), Token: h.AccessToken(t, admin)}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var lr handler.ListAPIKeyRes
+				res.Decode(t, &lr)
+				require.Len(t, lr.APIKeys, 1)
+			},
+		},
+		{
+			name: "admin revokes user key",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				kr = createAPIKey(t, h, h.AccessToken(t, u), "orders:read")
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/users/%d/api-keys/%d", u.ID, kr.ID), Token: h.AccessToken(t, admin)}
+			},
+			status: http.StatusNoContent,
+		},
+	})
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	setup := func(t *testing.T, scopes ...string) (*handlertest.Harness, *storer.User, string) {
+		h := handlertest.New(t)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+		return h, u, createAPIKey(t, h, h.AccessToken(t, u), scopes...).Key
+	}
+
+	t.Run("scopes", func(t *testing.T) {
+		h, u, key := setup(t, "products:read", "orders:write")
+		p := createProduct(t, h)
+
+		tcs := []struct {
+			method string
+			path   string
+			body   interface{}
+			status int
+		}{
+			{http.MethodGet, "/products", nil, http.StatusOK},
+			{http.MethodGet, fmt.Sprintf("/products/%d", p.ID), nil, http.StatusOK},
+			{http.MethodDelete, fmt.Sprintf("/products/%d", p.ID), nil, http.StatusForbidden},
+			{http.MethodPost, "/orders", newOrderReq(t, h), http.StatusCreated},
+			{http.MethodGet, "/orders", nil, http.StatusForbidden},
+			{http.MethodGet, fmt.Sprintf("/users/%d", u.ID), nil, http.StatusForbidden},
+			{http.MethodGet, "/me", nil, http.StatusForbidden},
+		}
+		for _, tc := range tcs {
+			res := h.Do(t, handlertest.Request{Method: tc.method, Path: tc.path, Body: tc.body, Header: apiKeyHeader(key)})
+			require.Equal(t, tc.status, res.StatusCode, "%s %s: %s", tc.method, tc.path, res.Body)
+		}
+	})
+
+	t.Run("acts as owner", func(t *testing.T) {
+		h, u, key := setup(t, "users:read")
+
+		res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/me", Header: apiKeyHeader(key)})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var ur handler.UserRes
+		res.Decode(t, &ur)
+		require.Equal(t, u.ID, ur.ID)
+	})
+
+	t.Run("cannot manage credentials", func(t *testing.T) {
+		h, _, key := setup(t, "users:read", "users:write")
+
+		for _, path := range []string{"/me/api-keys", "/me/sessions"} {
+			res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: path, Header: apiKeyHeader(key)})
+			require.Equal(t, http.StatusForbidden, res.StatusCode, path)
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		h, u, key := setup(t, "orders:read")
+
+		raw, prefix, hash, err := util.NewAPIKey()
+		require.NoError(t, err)
+		expiresAt := time.Now().Add(-time.Minute)
+		_, err = h.Storer.CreateAPIKey(context.Background(), &storer.APIKey{
+			UserID:    u.ID,
+			Name:      "expired",
+			Prefix:    prefix,
+			KeyHash:   hash,
+			Scopes:    "orders:read",
+			ExpiresAt: &expiresAt,
+		})
+		require.NoError(t, err)
+
+		keyPrefix, _ := util.APIKeyPrefix(key)
+		for _, k := range []string{"garbage", "mpk_unknown_secret", "mpk_" + keyPrefix + "_wrongsecret", raw} {
+			res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/orders", Header: apiKeyHeader(k)})
+			require.Equal(t, http.StatusUnauthorized, res.StatusCode, k)
+		}
+
+		res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/orders", Header: apiKeyHeader(key)})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+	})
+}
+
+func TestPasswordRehash(t *testing.T) {
+	h := handlertest.New(t)
+
+	hashed, err := util.BcryptHasher{Cost: bcrypt.MinCost}.Hash("password")
+	require.NoError(t, err)
+	u, err := h.Storer.CreateUser(context.Background(), &storer.User{
+		Name:     "test",
+		Email:    "test@example.com",
+		Password: hashed,
+	})
+	require.NoError(t, err)
+
+	login(t, h, "test@example.com", "password")
+
+	usr, err := h.Storer.GetUserByID(context.Background(), u.ID)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(usr.Password, "$argon2id$v=19$m=1024,t=1,p=1$"), usr.Password)
+	require.False(t, handlertest.Config.PasswordHasher.NeedsRehash(usr.Password))
+
+	login(t, h, "test@example.com", "password")
+
+	again, err := h.Storer.GetUserByID(context.Background(), u.ID)
+	require.NoError(t, err)
+	require.Equal(t, usr.Password, again.Password)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	config := handlertest.Config
+	config.PasswordPolicy = util.PasswordPolicy{MinLength: 10, MaxLength: 64, RejectCommon: true}
+
+	register := func(t *testing.T, h *handlertest.Harness, password string) *handlertest.Response {
+		return h.Do(t, handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/register",
+			Body:   handler.UserReq{Name: "test", Email: "new@example.com", Password: password},
+		})
+	}
+
+	t.Run("register", func(t *testing.T) {
+		h := handlertest.NewWithConfig(t, config)
+
+		for _, password := range []string{"short", "password123", strings.Repeat("x", 65)} {
+			res := register(t, h, password)
+			require.Equal(t, http.StatusBadRequest, res.StatusCode, password)
+		}
+
+		res := register(t, h, "correct horse battery")
+		require.Equal(t, http.StatusCreated, res.StatusCode, string(res.Body))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		h := handlertest.NewWithConfig(t, config)
+		h.CreateUser(t, "test@example.com", "old password", false)
+		tok := requestPasswordReset(t, h, "test@example.com")
+
+		req := handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/password/reset",
+			Body:   handler.ResetPasswordReq{Token: tok, Password: "qwertyuiop"},
+		}
+		res := h.Do(t, req)
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+		require.Contains(t, string(res.Body), "password too common")
+
+		req.Body = handler.ResetPasswordReq{Token: tok, Password: "correct horse battery"}
+		res = h.Do(t, req)
+		require.Equal(t, http.StatusNoContent, res.StatusCode, string(res.Body))
+	})
+
+	t.Run("change", func(t *testing.T) {
+		h := handlertest.NewWithConfig(t, config)
+		u := h.CreateUser(t, "test@example.com", "old password", false)
+
+		req := handlertest.Request{
+			Method: http.MethodPatch,
+			Path:   "/me",
+			Body:   handler.UserReq{Password: "short"},
+			Token:  h.AccessToken(t, u),
+		}
+		res := h.Do(t, req)
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+		req.Body = handler.UserReq{Password: "correct horse battery"}
+		res = h.Do(t, req)
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+		login(t, h, "test@example.com", "correct horse battery")
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	config := handlertest.Config
+	config.FailedLoginDelay = time.Minute
+	config.MaxFailedLogins = 3
+	config.LockoutDuration = time.Hour
+
+	attempt := func(t *testing.T, h *handlertest.Harness, password string) *handlertest.Response {
+		return h.Do(t, handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/login",
+			Body:   handler.LoginUserReq{Email: "test@example.com", Password: password},
+		})
+	}
+
+	// expire lifts the current lock without resetting the counter, as if
+	// the delay had passed.
+	expire := func(t *testing.T, h *handlertest.Harness, u *storer.User) {
+		require.NoError(t, h.Storer.LockUser(context.Background(), u.ID, time.Now().Add(-time.Second)))
+	}
+
+	lockedFor := func(t *testing.T, h *handlertest.Harness, u *storer.User) time.Duration {
+		usr, err := h.Storer.GetUserByID(context.Background(), u.ID)
+		require.NoError(t, err)
+		require.NotNil(t, usr.LockedUntil)
+		return time.Until(*usr.LockedUntil).Round(time.Minute)
+	}
+
+	t.Run("progressive delays and lockout", func(t *testing.T) {
+		h := handlertest.NewWithConfig(t, config)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+		admin := h.CreateUser(t, "admin@example.com", "password", true)
+
+		res := attempt(t, h, "wrong")
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		require.Equal(t, time.Minute, lockedFor(t, h, u))
+
+		res = attempt(t, h, "password")
+		require.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+		require.Equal(t, "60", res.Header.Get("Retry-After"))
+
+		expire(t, h, u)
+		res = attempt(t, h, "wrong")
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		require.Equal(t, 2*time.Minute, lockedFor(t, h, u))
+		require.Empty(t, h.Storer.SecurityEvents())
+
+		expire(t, h, u)
+		res = attempt(t, h, "wrong")
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		require.Equal(t, time.Hour, lockedFor(t, h, u))
+
+		events := h.Storer.SecurityEvents()
+		require.Len(t, events, 1)
+		require.Equal(t, storer.SecurityEventAccountLocked, events[0].EventType)
+		require.Equal(t, u.Email, events[0].UserEmail)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodPost, Path: fmt.Sprintf("/users/%d/unlock", u.ID), Token: h.AccessToken(t, u)})
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodPost, Path: fmt.Sprintf("/users/%d/unlock", u.ID), Token: h.AccessToken(t, admin)})
+		require.Equal(t, http.StatusNoContent, res.StatusCode, string(res.Body))
+
+		login(t, h, "test@example.com", "password")
+	})
+
+	t.Run("success resets failures", func(t *testing.T) {
+		h := handlertest.NewWithConfig(t, config)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+
+		attempt(t, h, "wrong")
+		expire(t, h, u)
+		attempt(t, h, "wrong")
+		expire(t, h, u)
+		login(t, h, "test@example.com", "password")
+
+		usr, err := h.Storer.GetUserByID(context.Background(), u.ID)
+		require.NoError(t, err)
+		require.Zero(t, usr.FailedLogins)
+		require.Nil(t, usr.LockedUntil)
+
+		res := attempt(t, h, "wrong")
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		require.Equal(t, time.Minute, lockedFor(t, h, u))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		h := handlertest.New(t)
+		h.CreateUser(t, "test@example.com", "password", false)
+
+		for i := 0; i < 20; i++ {
+			attempt(t, h, "wrong")
+		}
+		login(t, h, "test@example.com", "password")
+	})
+}
+
+func TestLoginHistory(t *testing.T) {
+	h := handlertest.New(t)
+	h.CreateUser(t, "test@example.com", "password", false)
+
+	loginAs := func(userAgent string) handler.LoginUserRes {
+		res := h.Do(t, handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/login",
+			Body:   handler.LoginUserReq{Email: "test@example.com", Password: "password"},
+			Header: http.Header{"User-Agent": {userAgent}},
+		})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var lr handler.LoginUserRes
+		res.Decode(t, &lr)
+		return lr
+	}
+
+	loginAs("laptop")
+	loginAs("laptop")
+	require.Empty(t, h.Storer.Notifications())
+
+	lr := loginAs("phone")
+	notifications := h.Storer.Notifications()
+	require.Len(t, notifications, 1)
+	require.Equal(t, "new_login", notifications[0].Template)
+	require.Equal(t, "test@example.com", notifications[0].Recipient)
+
+	var data map[string]interface{}
+	require.NoError(t, json.Unmarshal(notifications[0].Data, &data))
+	require.Equal(t, "phone", data["user_agent"])
+	require.Equal(t, "127.0.0.1", data["client_ip"])
+
+	loginAs("phone")
+	require.Len(t, h.Storer.Notifications(), 1)
+
+	res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/me/logins", Token: lr.AccessToken})
+	require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+	var ll handler.ListLoginRes
+	res.Decode(t, &ll)
+	require.Len(t, ll.Logins, 4)
+	require.Equal(t, "phone", ll.Logins[0].UserAgent)
+	require.Equal(t, "laptop", ll.Logins[3].UserAgent)
+}
+
+func TestTwoFactorRoutes(t *testing.T) {
+	enroll := func(t *testing.T, h *handlertest.Harness, accessToken string) []string {
+		res := h.Do(t, handlertest.Request{Method: http.MethodPost, Path: "/me/2fa/enroll", Token: accessToken})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var er handler.EnrollTOTPRes
+		res.Decode(t, &er)
+		require.Contains(t, er.ProvisioningURI, "otpauth://totp/micro-panel:")
+		require.Contains(t, er.ProvisioningURI, "secret="+er.Secret)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodPost, Path: "/me/2fa/activate", Body: handler.TOTPCodeReq{Code: "000000"}, Token: accessToken})
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+		code, err := util.TOTPCode(er.Secret, time.Now())
+		require.NoError(t, err)
+		res = h.Do(t, handlertest.Request{Method: http.MethodPost, Path: "/me/2fa/activate", Body: handler.TOTPCodeReq{Code: code}, Token: accessToken})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var rc handler.RecoveryCodesRes
+		res.Decode(t, &rc)
+		require.Len(t, rc.RecoveryCodes, 10)
+		return rc.RecoveryCodes
+	}
+
+	challenge := func(t *testing.T, h *handlertest.Harness) string {
+		res := h.Do(t, handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/login",
+			Body:   handler.LoginUserReq{Email: "test@example.com", Password: "password"},
+		})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var mr handler.MFAChallengeRes
+		res.Decode(t, &mr)
+		require.True(t, mr.MFARequired)
+		require.NotEmpty(t, mr.MFAToken)
+		require.NotContains(t, string(res.Body), "access_token")
+		return mr.MFAToken
+	}
+
+	loginMFA := func(t *testing.T, h *handlertest.Harness, req handler.LoginMFAReq) *handlertest.Response {
+		return h.Do(t, handlertest.Request{Method: http.MethodPost, Path: "/auth/login/mfa", Body: req})
+	}
+
+	// nextCode returns the code for the next time step, since enroll has
+	// already used up the current one.
+	nextCode := func(t *testing.T, h *handlertest.Harness, userID int64) string {
+		usr, err := h.Storer.GetUserByID(context.Background(), userID)
+		require.NoError(t, err)
+		code, err := util.TOTPCode(*usr.TOTPSecret, time.Now().Add(30*time.Second))
+		require.NoError(t, err)
+		return code
+	}
+
+	t.Run("login with code", func(t *testing.T) {
+		h := handlertest.New(t)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+		enroll(t, h, h.AccessToken(t, u))
+
+		usr, err := h.Storer.GetUserByID(context.Background(), u.ID)
+		require.NoError(t, err)
+		require.True(t, usr.TOTPEnabled)
+
+		mfaToken := challenge(t, h)
+
+		res := loginMFA(t, h, handler.LoginMFAReq{MFAToken: mfaToken, Code: "000000"})
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = loginMFA(t, h, handler.LoginMFAReq{MFAToken: mfaToken, Code: nextCode(t, h, u.ID)})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var lr handler.LoginUserRes
+		res.Decode(t, &lr)
+		require.NotEmpty(t, lr.AccessToken)
+		require.NotEmpty(t, lr.RefreshToken)
+		require.True(t, lr.User.TOTPEnabled)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/me", Token: lr.AccessToken})
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("replayed code", func(t *testing.T) {
+		h := handlertest.New(t)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+		enroll(t, h, h.AccessToken(t, u))
+
+		code := nextCode(t, h, u.ID)
+		res := loginMFA(t, h, handler.LoginMFAReq{MFAToken: challenge(t, h), Code: code})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		res = loginMFA(t, h, handler.LoginMFAReq{MFAToken: challenge(t, h), Code: code})
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodDelete, Path: "/me/2fa", Body: handler.TOTPCodeReq{Code: code}, Token: h.AccessToken(t, u)})
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("login with recovery code", func(t *testing.T) {
+		h := handlertest.New(t)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+		codes := enroll(t, h, h.AccessToken(t, u))
+
+		req := handler.LoginMFAReq{MFAToken: challenge(t, h), RecoveryCode: strings.ToUpper(codes[3])}
+		res := loginMFA(t, h, req)
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		res = loginMFA(t, h, req)
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("mfa token is not an access token", func(t *testing.T) {
+		h := handlertest.New(t)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+		enroll(t, h, h.AccessToken(t, u))
+
+		res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/me", Token: challenge(t, h)})
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = loginMFA(t, h, handler.LoginMFAReq{MFAToken: h.AccessToken(t, u), Code: "000000"})
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("enroll twice", func(t *testing.T) {
+		h := handlertest.New(t)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+		enroll(t, h, h.AccessToken(t, u))
+
+		res := h.Do(t, handlertest.Request{Method: http.MethodPost, Path: "/me/2fa/enroll", Token: h.AccessToken(t, u)})
+		require.Equal(t, http.StatusConflict, res.StatusCode)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		h := handlertest.New(t)
+		u := h.CreateUser(t, "test@example.com", "password", false)
+		codes := enroll(t, h, h.AccessToken(t, u))
+
+		res := h.Do(t, handlertest.Request{Method: http.MethodDelete, Path: "/me/2fa", Body: handler.TOTPCodeReq{Code: nextCode(t, h, u.ID)}, Token: h.AccessToken(t, u)})
+		require.Equal(t, http.StatusNoContent, res.StatusCode, string(res.Body))
+
+		login(t, h, "test@example.com", "password")
+		require.ErrorIs(t, h.Storer.UseRecoveryCode(context.Background(), u.ID, util.HashToken(codes[0])), storer.ErrInvalidRecoveryCode)
+	})
+
+	t.Run("admin policy", func(t *testing.T) {
+		config := handlertest.Config
+		config.RequireAdminMFA = true
+		h := handlertest.NewWithConfig(t, config)
+		u := h.CreateUser(t, "test@example.com", "password", true)
+
+		req := handlertest.Request{Method: http.MethodGet, Path: "/users", Token: h.AccessToken(t, u)}
+		res := h.Do(t, req)
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		enroll(t, h, h.AccessToken(t, u))
+		res = h.Do(t, req)
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+	})
+}
+
+func TestSessionRoutes(t *testing.T) {
+	var lr handler.LoginUserRes
+	var rr handler.RenewAccessTokenRes
+
+	runRouteTests(t, []routeTest{
+		{
+			name: "list my sessions",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				other := login(t, h, "test@example.com", "password")
+				require.NoError(t, h.Storer.RevokeSession(context.Background(), other.SessionID))
+				rr = refresh(t, h, lr.RefreshToken)
+				return handlertest.Request{
+					Method: http.MethodGet,
+					Path:   "/me/sessions",
+					Token:  rr.AccessToken,
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var ls handler.ListSessionRes
+				res.Decode(t, &ls)
+				require.Len(t, ls.Sessions, 1)
+
+				se := ls.Sessions[0]
+				require.Equal(t, rr.SessionID, se.ID)
+				require.Equal(t, "127.0.0.1", se.ClientIP)
+				require.Equal(t, "Go-http-client/1.1", se.UserAgent)
+
+				first, err := h.Storer.GetSession(context.Background(), lr.SessionID)
+				require.NoError(t, err)
+				require.True(t, first.CreatedAt.Equal(se.CreatedAt))
+			},
+		},
+		{
+			name: "list my sessions storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("ListSessions", fmt.Errorf("error listing sessions"))
+				return handlertest.Request{Method: http.MethodGet, Path: "/me/sessions", Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "touch session storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				h.Storer.FailOn("TouchSession", fmt.Errorf("error updating session"))
+				return handlertest.Request{Method: http.MethodGet, Path: "/me/sessions", Token: lr.AccessToken}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "revoke my session",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodDelete,
+					Path:   "/me/sessions/" + lr.SessionID,
+					Token:  lr.AccessToken,
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				se, err := h.Storer.GetSession(context.Background(), lr.SessionID)
+				require.NoError(t, err)
+				require.True(t, se.IsRevoked)
+			},
+		},
+		{
+			name: "revoke another user's session",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.CreateUser(t, "other@example.com", "password", false)
+				lr = login(t, h, "other@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodDelete,
+					Path:   "/me/sessions/" + lr.SessionID,
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusNotFound,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				se, err := h.Storer.GetSession(context.Background(), lr.SessionID)
+				require.NoError(t, err)
+				require.False(t, se.IsRevoked)
+			},
+		},
+		{
+			name: "log out everywhere",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodDelete,
+					Path:   "/me/sessions",
+					Token:  lr.AccessToken,
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				sessions, err := h.Storer.ListSessions(context.Background(), "test@example.com")
+				require.NoError(t, err)
+				require.Empty(t, sessions)
+
+				res = h.Do(t, handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+				})
+				require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+			},
+		},
+		{
+			name: "admin lists user sessions",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				login(t, h, "test@example.com", "password")
+				login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodGet,
+					Path:   fmt.Sprintf("/users/%d/sessions", u.ID),
+					Token:  h.AccessToken(t, admin),
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var ls handler.ListSessionRes
+				res.Decode(t, &ls)
+				require.Len(t, ls.Sessions, 2)
+			},
+		},
+		{
+			name: "user sessions require admin",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodGet,
+					Path:   fmt.Sprintf("/users/%d/sessions", u.ID),
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "admin revokes user session",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodDelete,
+					Path:   fmt.Sprintf("/users/%d/sessions/%s", u.ID, lr.SessionID),
+					Token:  h.AccessToken(t, admin),
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				se, err := h.Storer.GetSession(context.Background(), lr.SessionID)
+				require.NoError(t, err)
+				require.True(t, se.IsRevoked)
+			},
+		},
+		{
+			name: "admin revokes session of a different user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.CreateUser(t, "other@example.com", "password", false)
+				lr = login(t, h, "other@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodDelete,
+					Path:   fmt.Sprintf("/users/%d/sessions/%s", u.ID, lr.SessionID),
+					Token:  h.AccessToken(t, admin),
+				}
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "admin revokes all user sessions",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				login(t, h, "test@example.com", "password")
+				login(t, h, "test@example.com", "password")
+				login(t, h, "admin@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodDelete,
+					Path:   fmt.Sprintf("/users/%d/sessions", u.ID),
+					Token:  h.AccessToken(t, admin),
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				sessions, err := h.Storer.ListSessions(context.Background(), "test@example.com")
+				require.NoError(t, err)
+				require.Empty(t, sessions)
+
+				sessions, err = h.Storer.ListSessions(context.Background(), "admin@example.com")
+				require.NoError(t, err)
+				require.Len(t, sessions, 1)
+			},
+		},
+		{
+			name: "admin revokes all user sessions storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("RevokeSessionsByEmail", fmt.Errorf("error revoking sessions"))
+				return handlertest.Request{
+					Method: http.MethodDelete,
+					Path:   fmt.Sprintf("/users/%d/sessions", u.ID),
+					Token:  h.AccessToken(t, admin),
+				}
+			},
+			status: http.StatusInternalServerError,
+		},
+	})
+}
+
+// meStatus reports the status of GET /me with accessToken.
+func meStatus(t *testing.T, h *handlertest.Harness, accessToken string) int {
+	res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/me", Token: accessToken})
+	return res.StatusCode
+}
+
+func TestAccessTokenRevocation(t *testing.T) {
+	var lr, other handler.LoginUserRes
+	var rr handler.RenewAccessTokenRes
+
+	runRouteTests(t, []routeTest{
+		{
+			name: "logout denies the access token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				other = login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/logout",
+					Body:   handler.LogoutUserReq{RefreshToken: lr.RefreshToken},
+					Token:  lr.AccessToken,
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/me", Token: lr.AccessToken})
+				require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+				require.Equal(t, "token revoked\n", string(res.Body))
+
+				require.Equal(t, http.StatusOK, meStatus(t, h, other.AccessToken))
+			},
+		},
+		{
+			name: "revoking a session denies its access tokens",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				rr = refresh(t, h, lr.RefreshToken)
+				other = login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodDelete,
+					Path:   "/me/sessions/" + rr.SessionID,
+					Token:  other.AccessToken,
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, http.StatusUnauthorized, meStatus(t, h, lr.AccessToken))
+				require.Equal(t, http.StatusUnauthorized, meStatus(t, h, rr.AccessToken))
+				require.Equal(t, http.StatusOK, meStatus(t, h, other.AccessToken))
+			},
+		},
+		{
+			name: "log out everywhere denies all access tokens",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				other = login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodDelete,
+					Path:   "/me/sessions",
+					Token:  lr.AccessToken,
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, http.StatusUnauthorized, meStatus(t, h, lr.AccessToken))
+				require.Equal(t, http.StatusUnauthorized, meStatus(t, h, other.AccessToken))
+			},
+		},
+		{
+			name: "refresh token reuse denies the family's access tokens",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				rr = refresh(t, h, lr.RefreshToken)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+				}
+			},
+			status: http.StatusUnauthorized,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, http.StatusUnauthorized, meStatus(t, h, rr.AccessToken))
+			},
+		},
+		{
+			name: "password reset denies all access tokens",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/password/reset",
+					Body: handler.ResetPasswordReq{
+						Token:    requestPasswordReset(t, h, "test@example.com"),
+						Password: "new password",
+					},
+				}
+			},
+			status: http.StatusNoContent,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, http.StatusUnauthorized, meStatus(t, h, lr.AccessToken))
+			},
+		},
+		{
+			name: "role change denies access tokens",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				admin := h.CreateUser(t, "admin@example.com", "password", true)
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   fmt.Sprintf("/users/%d", u.ID),
+					Body:   handler.UserReq{IsAdmin: true},
+					Token:  h.AccessToken(t, admin),
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, http.StatusUnauthorized, meStatus(t, h, lr.AccessToken))
+
+				rr = refresh(t, h, lr.RefreshToken)
+				claims, err := h.TokenMaker.VerifyToken(rr.AccessToken, token.AccessToken)
+				require.NoError(t, err)
+				require.True(t, claims.IsAdmin)
+
+				res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/users", Token: rr.AccessToken})
+				require.Equal(t, http.StatusOK, res.StatusCode)
+			},
+		},
+		{
+			name: "email change revokes sessions",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   "/me",
+					Body:   handler.UserReq{Email: "new@example.com"},
+					Token:  lr.AccessToken,
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, http.StatusUnauthorized, meStatus(t, h, lr.AccessToken))
+
+				res = h.Do(t, handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+				})
+				require.Equal(t, http.StatusUnauthorized, res.StatusCode, string(res.Body))
+
+				login(t, h, "new@example.com", "password")
+			},
+		},
+		{
+			name: "password change revokes sessions",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr = login(t, h, "test@example.com", "password")
+				other = login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   "/me",
+					Body:   handler.UserReq{Password: "new password"},
+					Token:  lr.AccessToken,
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, http.StatusUnauthorized, meStatus(t, h, lr.AccessToken))
+				require.Equal(t, http.StatusUnauthorized, meStatus(t, h, other.AccessToken))
+
+				res = h.Do(t, handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/auth/refresh",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: other.RefreshToken},
+				})
+				require.Equal(t, http.StatusUnauthorized, res.StatusCode, string(res.Body))
+
+				login(t, h, "test@example.com", "new password")
+			},
+		},
+		{
+			name: "denylist storer error",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				h.Storer.FailOn("IsTokenDenied", fmt.Errorf("error checking token"))
+				return handlertest.Request{Method: http.MethodGet, Path: "/me", Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusInternalServerError,
+		},
+	})
+}
+
+func TestJWKSRoute(t *testing.T) {
+	runRouteTests(t, []routeTest{
+		{
+			name: "hmac keys are not published",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{Method: http.MethodGet, Path: "/.well-known/jwks.json"}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				var jwks token.JWKS
+				res.Decode(t, &jwks)
+				require.NotNil(t, jwks.Keys)
+				require.Empty(t, jwks.Keys)
+			},
+		},
+	})
+}
+
+func TestPasetoTokens(t *testing.T) {
+	maker, err := token.NewPasetoMaker(handlertest.SecretKey)
+	require.NoError(t, err)
+
+	h := handlertest.NewWithMaker(t, maker)
+	h.CreateUser(t, "test@example.com", "password", false)
+
+	lr := login(t, h, "test@example.com", "password")
+	require.True(t, strings.HasPrefix(lr.AccessToken, "v4.local."))
+
+	res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/me", Token: lr.AccessToken})
+	require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+	res = h.Do(t, handlertest.Request{Method: http.MethodPost, Path: "/auth/refresh", Body: handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken}})
+	require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+	res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/.well-known/jwks.json"})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var jwks token.JWKS
+	res.Decode(t, &jwks)
+	require.Empty(t, jwks.Keys)
+}
+
+func TestDeprecatedRoutes(t *testing.T) {
+	runRouteTests(t, []routeTest{
+		{
+			name: "register user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders/users",
+					Body:   handler.UserReq{Name: "test", Email: "test@example.com", Password: "password"},
+				}
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, "true", res.Header.Get("Deprecation"))
+				require.Equal(t, `</auth/register>; rel="successor-version"`, res.Header.Get("Link"))
+			},
+		},
+		{
+			name: "login",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders/users/login",
+					Body:   handler.LoginUserReq{Email: "test@example.com", Password: "password"},
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, "true", res.Header.Get("Deprecation"))
+			},
+		},
+		{
+			name: "update user by email",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   "/orders/users",
+					Body:   handler.UserReq{Email: "test@example.com", Name: "new name"},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				u, err := h.Storer.GetUser(context.Background(), "test@example.com")
+				require.NoError(t, err)
+				require.Equal(t, "new name", u.Name)
+			},
+		},
+		{
+			name: "update other user by email",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test1@example.com", "password", false)
+				h.CreateUser(t, "test2@example.com", "password", false)
+				return handlertest.Request{
+					Method: http.MethodPatch,
+					Path:   "/orders/users",
+					Body:   handler.UserReq{Email: "test2@example.com", Name: "new name"},
+					Token:  h.AccessToken(t, u),
+				}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "delete user",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				u := h.CreateUser(t, "test@example.com", "password", false)
+				return handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/orders/users/%d", u.ID), Token: h.AccessToken(t, u)}
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "logout",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/orders/users/logout",
+					Body:   handler.LogoutUserReq{RefreshToken: lr.RefreshToken},
+					Token:  lr.AccessToken,
+				}
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "renew access token",
+			setup: func(t *testing.T, h *handlertest.Harness) handlertest.Request {
+				h.CreateUser(t, "test@example.com", "password", false)
+				lr := login(t, h, "test@example.com", "password")
+				return handlertest.Request{
+					Method: http.MethodPost,
+					Path:   "/tokens/renew",
+					Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, h *handlertest.Harness, res *handlertest.Response) {
+				require.Equal(t, `</auth/refresh>; rel="successor-version"`, res.Header.Get("Link"))
+			},
+		},
+	})
+}
+
+func TestTenantIsolation(t *testing.T) {
+	h := handlertest.New(t)
+	acme := h.CreateTenant(t, "acme")
+	acmeCtx := storer.WithTenant(context.Background(), acme.ID)
+
+	defaultAdmin := h.CreateUser(t, "admin@example.com", "password", true)
+	acmeAdmin := h.CreateTenantUser(t, acme.ID, "admin@example.com", "other password", true)
+
+	defaultProduct := createProduct(t, h)
+	acmeProduct, err := h.Storer.CreateProduct(acmeCtx, &storer.Product{Name: "acme product", Price: 1.0})
+	require.NoError(t, err)
+
+	defaultOrder, err := h.Storer.CreateOrder(context.Background(), &storer.Order{UserID: defaultAdmin.ID, Status: storer.Pending})
+	require.NoError(t, err)
+
+	t.Run("products", func(t *testing.T) {
+		listNames := func(tenant string) []string {
+			res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/products", Tenant: tenant})
+			require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+			var products []handler.ProductRes
+			res.Decode(t, &products)
+
+			var names []string
+			for _, p := range products {
+				names = append(names, p.Name)
+			}
+			return names
+		}
+
+		require.Equal(t, []string{"acme product"}, listNames("acme"))
+		require.Equal(t, []string{defaultProduct.Name}, listNames(""))
+
+		res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/products/%d", defaultProduct.ID), Tenant: "acme"})
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/products/%d", acmeProduct.ID), Tenant: "acme"})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+	})
+
+	t.Run("orders", func(t *testing.T) {
+		res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/orders/%d", defaultOrder.ID), Token: h.AccessToken(t, acmeAdmin)})
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/orders/%d", defaultOrder.ID), Token: h.AccessToken(t, defaultAdmin)})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+	})
+
+	t.Run("login is per tenant", func(t *testing.T) {
+		res := h.Do(t, handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/login",
+			Body:   handler.LoginUserReq{Email: "admin@example.com", Password: "password"},
+			Tenant: "acme",
+		})
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = h.Do(t, handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/login",
+			Body:   handler.LoginUserReq{Email: "admin@example.com", Password: "other password"},
+			Tenant: "acme",
+		})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var lr handler.LoginUserRes
+		res.Decode(t, &lr)
+		require.Equal(t, acmeAdmin.ID, lr.User.ID)
+
+		claims, err := h.TokenMaker.VerifyToken(lr.AccessToken, token.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, acme.ID, claims.TenantID)
+
+		// The token selects its tenant when the request names none.
+		res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/me", Token: lr.AccessToken})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var me handler.UserRes
+		res.Decode(t, &me)
+		require.Equal(t, acmeAdmin.ID, me.ID)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/me", Token: lr.AccessToken, Tenant: "default"})
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = h.Do(t, handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/refresh",
+			Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+			Tenant: "default",
+		})
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = h.Do(t, handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/refresh",
+			Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+		})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+	})
+
+	t.Run("users", func(t *testing.T) {
+		res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/users", Token: h.AccessToken(t, acmeAdmin)})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var lu handler.ListUserRes
+		res.Decode(t, &lu)
+		require.Len(t, lu.Users, 1)
+		require.Equal(t, acmeAdmin.ID, lu.Users[0].ID)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: fmt.Sprintf("/users/%d", defaultAdmin.ID), Token: h.AccessToken(t, acmeAdmin)})
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodDelete, Path: fmt.Sprintf("/users/%d/sessions", defaultAdmin.ID), Token: h.AccessToken(t, acmeAdmin)})
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+
+	t.Run("security events", func(t *testing.T) {
+		res := h.Do(t, handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/login",
+			Body:   handler.LoginUserReq{Email: "admin@example.com", Password: "other password"},
+			Tenant: "acme",
+		})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var lr handler.LoginUserRes
+		res.Decode(t, &lr)
+		req := handlertest.Request{
+			Method: http.MethodPost,
+			Path:   "/auth/refresh",
+			Body:   handler.RenewAccessTokenReq{RefreshToken: lr.RefreshToken},
+			Tenant: "acme",
+		}
+		require.Equal(t, http.StatusOK, h.Do(t, req).StatusCode)
+		require.Equal(t, http.StatusUnauthorized, h.Do(t, req).StatusCode)
+
+		events := h.Storer.SecurityEvents()
+		require.NotEmpty(t, events)
+		require.Equal(t, storer.SecurityEventRefreshTokenReuse, events[len(events)-1].EventType)
+		require.Equal(t, acme.ID, events[len(events)-1].TenantID)
+	})
+
+	t.Run("api keys", func(t *testing.T) {
+		key := createAPIKey(t, h, h.AccessToken(t, acmeAdmin), "products:read").Key
+
+		res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/products", Header: apiKeyHeader(key)})
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/products", Header: apiKeyHeader(key), Tenant: "acme"})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+	})
+
+	t.Run("unknown tenant", func(t *testing.T) {
+		res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/products", Tenant: "nope"})
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+
+	t.Run("host", func(t *testing.T) {
+		host := "shop.example"
+		shop, err := h.Storer.CreateTenant(context.Background(), &storer.Tenant{Name: "Shop", Slug: "shop", Host: &host})
+		require.NoError(t, err)
+
+		_, err = h.Storer.CreateProduct(storer.WithTenant(context.Background(), shop.ID), &storer.Product{Name: "shop product"})
+		require.NoError(t, err)
+
+		res := h.Do(t, handlertest.Request{Method: http.MethodGet, Path: "/products", Host: "Shop.Example:8080"})
+		require.Equal(t, http.StatusOK, res.StatusCode, string(res.Body))
+
+		var products []handler.ProductRes
+		res.Decode(t, &products)
+		require.Len(t, products, 1)
+		require.Equal(t, "shop product", products[0].Name)
+	})
+}
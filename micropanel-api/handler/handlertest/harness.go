@@ -0,0 +1,237 @@
+// Package handlertest runs the real micropanel-api router against an
+// in-memory storer so that handlers can be exercised end to end over HTTP.
+package handlertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/handler"
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer/storertest"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/Turtel216/micro-panel/util"
+)
+
+const SecretKey = "01234567890123456789012345678901"
+
+var Config = handler.Config{
+	AccessTokenTTL:       15 * time.Minute,
+	RefreshTokenTTL:      30 * 24 * time.Hour,
+	PasswordResetTTL:     time.Hour,
+	EmailVerificationTTL: 48 * time.Hour,
+	MFAChallengeTTL:      5 * time.Minute,
+	TOTPIssuer:           "micro-panel",
+	TenantHeader:         "X-Tenant",
+	// Cheap parameters keep the tests fast; they are not fit for production.
+	PasswordHasher: util.Argon2idHasher{Params: util.Argon2idParams{
+		Memory:      1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}},
+}
+
+type Harness struct {
+	Storer     *storertest.Storer
+	Server     *httptest.Server
+	TokenMaker token.Maker
+
+	hasher util.PasswordHasher
+	stats  func() handler.Stats
+}
+
+func New(t testing.TB) *Harness {
+	t.Helper()
+
+	return newHarness(t, token.NewJWTMaker(SecretKey), Config)
+}
+
+func NewWithMaker(t testing.TB, tokenMaker token.Maker) *Harness {
+	t.Helper()
+
+	return newHarness(t, tokenMaker, Config)
+}
+
+// NewWithConfig starts a harness whose handler uses config instead of the
+// package level Config.
+func NewWithConfig(t testing.TB, config handler.Config) *Harness {
+	t.Helper()
+
+	return newHarness(t, token.NewJWTMaker(SecretKey), config)
+}
+
+func newHarness(t testing.TB, tokenMaker token.Maker, config handler.Config) *Harness {
+	t.Helper()
+
+	st := storertest.New()
+	// Deny tokens through the storer so that tests can inspect and fail it.
+	if config.Denylist == nil {
+		config.Denylist = st
+	}
+	hdl := handler.NewHandler(server.NewServer(st), tokenMaker, config)
+	ts := httptest.NewServer(handler.RegisterRoutes(hdl))
+	t.Cleanup(ts.Close)
+
+	hasher := config.PasswordHasher
+	if hasher == nil {
+		hasher = util.DefaultPasswordHasher
+	}
+
+	return &Harness{
+		Storer:     st,
+		Server:     ts,
+		TokenMaker: tokenMaker,
+		hasher:     hasher,
+		stats:      hdl.Stats,
+	}
+}
+
+// Stats returns the stats of the handler under test.
+func (h *Harness) Stats() handler.Stats {
+	return h.stats()
+}
+
+// CreateUser stores a user of the default tenant whose password is hashed
+// the same way the register endpoint hashes it.
+func (h *Harness) CreateUser(t testing.TB, email, password string, isAdmin bool) *storer.User {
+	t.Helper()
+
+	return h.CreateTenantUser(t, storer.DefaultTenantID, email, password, isAdmin)
+}
+
+// CreateTenant stores a tenant that requests select with the X-Tenant header
+// set to slug.
+func (h *Harness) CreateTenant(t testing.TB, slug string) *storer.Tenant {
+	t.Helper()
+
+	tenant, err := h.Storer.CreateTenant(context.Background(), &storer.Tenant{Name: slug, Slug: slug})
+	if err != nil {
+		t.Fatalf("error creating tenant: %v", err)
+	}
+
+	return tenant
+}
+
+func (h *Harness) CreateTenantUser(t testing.TB, tenantID int64, email, password string, isAdmin bool) *storer.User {
+	t.Helper()
+
+	hashed, err := h.hasher.Hash(password)
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+
+	u, err := h.Storer.CreateUser(storer.WithTenant(context.Background(), tenantID), &storer.User{
+		Name:     email,
+		Email:    email,
+		Password: hashed,
+		IsAdmin:  isAdmin,
+	})
+	if err != nil {
+		t.Fatalf("error creating user: %v", err)
+	}
+
+	return u
+}
+
+func (h *Harness) AccessToken(t testing.TB, u *storer.User) string {
+	t.Helper()
+
+	tok, _, err := h.TokenMaker.CreateToken(u.ID, u.Email, u.IsAdmin, u.TenantID, token.AccessToken, Config.AccessTokenTTL)
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+
+	return tok
+}
+
+// Request is sent by Do. Tenant sets the X-Tenant header and Host overrides
+// the host name of the test server.
+type Request struct {
+	Method string
+	Path   string
+	Body   interface{}
+	Token  string
+	Header http.Header
+	Tenant string
+	Host   string
+}
+
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Decode unmarshals the response body into v and fails the test on error.
+func (r *Response) Decode(t testing.TB, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		t.Fatalf("error decoding response body %q: %v", r.Body, err)
+	}
+}
+
+// Do sends req to the test server. A string or []byte Body is sent as is,
+// anything else is encoded as JSON.
+func (h *Harness) Do(t testing.TB, req Request) *Response {
+	t.Helper()
+
+	var body io.Reader
+	switch b := req.Body.(type) {
+	case nil:
+	case string:
+		body = bytes.NewBufferString(b)
+	case []byte:
+		body = bytes.NewBuffer(b)
+	default:
+		buf, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("error encoding request body: %v", err)
+		}
+		body = bytes.NewBuffer(buf)
+	}
+
+	r, err := http.NewRequest(req.Method, h.Server.URL+req.Path, body)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+
+	for k, vs := range req.Header {
+		r.Header[k] = vs
+	}
+	if req.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+req.Token)
+	}
+	if req.Tenant != "" {
+		r.Header.Set("X-Tenant", req.Tenant)
+	}
+	if req.Host != "" {
+		r.Host = req.Host
+	}
+
+	res, err := h.Server.Client().Do(r)
+	if err != nil {
+		t.Fatalf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("error reading response body: %v", err)
+	}
+
+	return &Response{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       resBody,
+	}
+}
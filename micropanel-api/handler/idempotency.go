@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+)
+
+const (
+	idempotencyKeyHeader   = "Idempotency-Key"
+	idempotencyKeyMaxLen   = 255
+	idempotencyKeyTTL      = 24 * time.Hour
+	idempotentReplayHeader = "Idempotent-Replayed"
+)
+
+// idempotent replays the stored response of a previous request made by the
+// same user with the same Idempotency-Key. Requests without the header are
+// passed through unchanged.
+func (h *handler) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > idempotencyKeyMaxLen {
+			http.Error(w, "idempotency key too long", http.StatusBadRequest)
+			return
+		}
+
+		claims, ok := claimsFromContext(r.Context())
+		if !ok {
+			http.Error(w, "idempotency key requires authentication", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "error reading request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := requestFingerprint(r, body)
+
+		existing, err := h.server.GetIdempotencyKey(r.Context(), claims.ID, key)
+		switch {
+		case err == nil && existing.ExpiresAt.Before(time.Now()):
+			if err := h.server.DeleteIdempotencyKey(r.Context(), claims.ID, key); err != nil {
+				http.Error(w, "error deleting idempotency key", http.StatusInternalServerError)
+				return
+			}
+		case err == nil:
+			if existing.RequestHash != hash {
+				http.Error(w, "idempotency key reused with a different request", http.StatusUnprocessableEntity)
+				return
+			}
+
+			if existing.ResponseCode == nil {
+				http.Error(w, "request with this idempotency key is still in progress", http.StatusConflict)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set(idempotentReplayHeader, "true")
+			w.WriteHeader(*existing.ResponseCode)
+			w.Write(existing.ResponseBody)
+			return
+		case !errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "error getting idempotency key", http.StatusInternalServerError)
+			return
+		}
+
+		_, err = h.server.CreateIdempotencyKey(r.Context(), &storer.IdempotencyKey{
+			UserID:      claims.ID,
+			Key:         key,
+			RequestHash: hash,
+			ExpiresAt:   time.Now().Add(idempotencyKeyTTL),
+		})
+		if errors.Is(err, storer.ErrIdempotencyKeyExists) {
+			http.Error(w, "request with this idempotency key is still in progress", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "error creating idempotency key", http.StatusInternalServerError)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// The outcome is stored even if the client went away in the meantime,
+		// since it is the retry that needs it.
+		ctx := context.WithoutCancel(r.Context())
+
+		// Server errors are not cached so that the client can retry them.
+		if rec.status >= http.StatusInternalServerError {
+			if err := h.server.DeleteIdempotencyKey(ctx, claims.ID, key); err != nil {
+				h.idempotencyError(err)
+			}
+			return
+		}
+
+		err = h.server.SaveIdempotencyResponse(ctx, &storer.IdempotencyKey{
+			UserID:       claims.ID,
+			Key:          key,
+			ResponseCode: &rec.status,
+			ResponseBody: rec.body,
+		})
+		if err != nil {
+			h.idempotencyError(err)
+		}
+	})
+}
+
+// idempotencyError records a failure to store the outcome of a request that
+// was already answered. Retries with the same key are refused as in progress
+// until the key expires.
+func (h *handler) idempotencyError(err error) {
+	h.idempotencyErrors.Add(1)
+	log.Printf("Error storing idempotency key: %v", err)
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
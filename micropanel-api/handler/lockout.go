@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+)
+
+const (
+	// loginHistoryLimit is both the number of logins listed to users and the
+	// window in which a login location counts as known.
+	loginHistoryLimit = 100
+)
+
+// loginDelay returns how long an account stays locked after its failures-th
+// consecutive failed login.
+func (h *handler) loginDelay(failures int) time.Duration {
+	if h.config.MaxFailedLogins > 0 && failures >= h.config.MaxFailedLogins {
+		return h.config.LockoutDuration
+	}
+
+	if h.config.FailedLoginDelay <= 0 || failures < 1 {
+		return 0
+	}
+
+	limit := h.config.LockoutDuration
+	if limit <= 0 {
+		limit = time.Hour
+	}
+
+	delay := h.config.FailedLoginDelay
+	for i := 1; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+
+	return delay
+}
+
+// checkLocked answers 429 with a Retry-After header while usr is locked.
+func (h *handler) checkLocked(w http.ResponseWriter, usr *storer.User) bool {
+	if usr.LockedUntil == nil {
+		return false
+	}
+
+	wait := time.Until(*usr.LockedUntil)
+	if wait <= 0 {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+	http.Error(w, "account temporarily locked", http.StatusTooManyRequests)
+	return true
+}
+
+// loginFailed counts a failed login against usr, locks the account for the
+// resulting delay and answers 401 with msg.
+func (h *handler) loginFailed(ctx context.Context, w http.ResponseWriter, usr *storer.User, msg string) {
+	failures, err := h.server.RecordFailedLogin(ctx, usr.ID)
+	if err != nil {
+		http.Error(w, "error recording failed login", http.StatusInternalServerError)
+		return
+	}
+
+	if delay := h.loginDelay(failures); delay > 0 {
+		err = h.server.LockUser(ctx, usr.ID, time.Now().Add(delay))
+		if err != nil {
+			http.Error(w, "error locking user", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if h.config.MaxFailedLogins > 0 && failures == h.config.MaxFailedLogins {
+		_, err = h.server.CreateSecurityEvent(ctx, &storer.SecurityEvent{
+			UserEmail: usr.Email,
+			EventType: storer.SecurityEventAccountLocked,
+		})
+		if err != nil {
+			http.Error(w, "error recording security event", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Error(w, msg, http.StatusUnauthorized)
+}
+
+// recordLogin adds a successful login to the history of usr and notifies
+// them when it comes from an IP address or user agent not seen recently.
+// The first login of a user is not reported.
+func (h *handler) recordLogin(r *http.Request, usr *storer.User) error {
+	history, err := h.server.ListLoginHistory(r.Context(), usr.ID, loginHistoryLimit)
+	if err != nil {
+		return err
+	}
+
+	l, err := h.server.CreateLoginRecord(r.Context(), &storer.LoginRecord{
+		UserID:    usr.ID,
+		ClientIP:  clientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(history) == 0 {
+		return nil
+	}
+
+	var knownIP, knownAgent bool
+	for _, past := range history {
+		knownIP = knownIP || past.ClientIP == l.ClientIP
+		knownAgent = knownAgent || past.UserAgent == l.UserAgent
+	}
+	if knownIP && knownAgent {
+		return nil
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"name":       usr.Name,
+		"client_ip":  l.ClientIP,
+		"user_agent": l.UserAgent,
+		"time":       time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = h.server.CreateNotification(r.Context(), &storer.Notification{
+		Channel:   storer.NotificationChannelEmail,
+		Recipient: usr.Email,
+		Template:  storer.NotificationNewLogin,
+		Data:      data,
+	})
+	return err
+}
+
+func (h *handler) listMyLogins(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	logins, err := h.server.ListLoginHistory(r.Context(), claims.ID, loginHistoryLimit)
+	if err != nil {
+		http.Error(w, "error listing login history", http.StatusInternalServerError)
+		return
+	}
+
+	res := ListLoginRes{Logins: []LoginRes{}}
+	for _, l := range logins {
+		res.Logins = append(res.Logins, toLoginRes(&l))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// unlockUser lifts a lockout before it expires.
+func (h *handler) unlockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.server.UnlockUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "error unlocking user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Turtel216/micro-panel/token"
+)
+
+type authKey struct{}
+
+// identify attaches the caller's claims to the request context when a bearer
+// token or an API key is present. Requests without an Authorization header
+// pass through anonymously; requests with invalid credentials are rejected.
+func (h *handler) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if _, ok := claimsFromContext(r.Context()); ok || header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fields := strings.Fields(header)
+		if len(fields) != 2 {
+			http.Error(w, "invalid authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+		switch {
+		case strings.EqualFold(fields[0], "Bearer"):
+			claims, err := h.tokenMaker.VerifyToken(fields[1], token.AccessToken)
+			if err != nil {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			denied, err := h.config.Denylist.IsTokenDenied(ctx, claims.RegisteredClaims.ID)
+			if err != nil {
+				http.Error(w, "error checking token", http.StatusInternalServerError)
+				return
+			}
+			if denied {
+				http.Error(w, "token revoked", http.StatusUnauthorized)
+				return
+			}
+
+			ctx, err = tokenTenant(ctx, claims)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if err := h.server.TouchSession(ctx, claims.RegisteredClaims.ID); err != nil {
+				http.Error(w, "error checking token", http.StatusInternalServerError)
+				return
+			}
+			ctx = context.WithValue(ctx, authKey{}, claims)
+		case strings.EqualFold(fields[0], "ApiKey"):
+			claims, k, err := h.authenticateAPIKey(ctx, fields[1])
+			if errors.Is(err, errInvalidAPIKey) {
+				http.Error(w, "invalid api key", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, "error checking api key", http.StatusInternalServerError)
+				return
+			}
+			ctx = context.WithValue(ctx, authKey{}, claims)
+			ctx = context.WithValue(ctx, apiKeyKey{}, k)
+		default:
+			http.Error(w, "invalid authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticate is identify for routes that must not be used anonymously.
+func (h *handler) authenticate(next http.Handler) http.Handler {
+	return h.identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := claimsFromContext(r.Context()); !ok {
+			http.Error(w, "missing authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// requireAdmin rejects callers that are not admins and, when the handler is
+// configured to require it, admins without two-factor authentication.
+func (h *handler) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r.Context())
+		if !ok || !claims.IsAdmin {
+			http.Error(w, "admin privileges required", http.StatusForbidden)
+			return
+		}
+
+		if h.config.RequireAdminMFA {
+			usr, err := h.server.GetUserByID(r.Context(), claims.ID)
+			if err != nil {
+				http.Error(w, "error getting user", http.StatusInternalServerError)
+				return
+			}
+
+			if !usr.TOTPEnabled {
+				http.Error(w, "two-factor authentication required", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireVerifiedEmail rejects callers whose email is not verified when the
+// handler is configured to require it for orders.
+func (h *handler) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.config.RequireVerifiedEmailForOrders {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, ok := claimsFromContext(r.Context())
+		if !ok {
+			http.Error(w, "missing authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		usr, err := h.server.GetUserByID(r.Context(), claims.ID)
+		if err != nil {
+			http.Error(w, "error getting user", http.StatusInternalServerError)
+			return
+		}
+
+		if !usr.EmailVerified {
+			http.Error(w, "email not verified", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deprecated marks a legacy route as an alias of successor.
+func deprecated(successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func claimsFromContext(ctx context.Context) (*token.UserClaims, bool) {
+	claims, ok := ctx.Value(authKey{}).(*token.UserClaims)
+	return claims, ok
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.body = append(rr.body, b...)
+	return rr.ResponseWriter.Write(b)
+}
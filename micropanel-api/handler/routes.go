@@ -10,56 +10,131 @@ var r *chi.Mux
 
 func RegisterRoutes(handler *handler) *chi.Mux {
 	r = chi.NewRouter()
+	r.Use(handler.resolveTenant)
 
 	r.Route("/products", func(r chi.Router) {
-		r.Post("/", handler.createProduct)
+		r.Use(handler.identify, handler.requireScope("products"))
+
+		r.With(handler.authenticate, handler.requireAdmin).Post("/", handler.createProduct)
 		r.Get("/", handler.listProduct)
 
 		r.Route("/{id}", func(r chi.Router) {
 			r.Get("/", handler.getProduct)
-			r.Patch("/", handler.updateProduct)
-			r.Delete("/", handler.deleteProduct)
+			r.With(handler.authenticate, handler.requireAdmin).Patch("/", handler.updateProduct)
+			r.With(handler.authenticate, handler.requireAdmin).Delete("/", handler.deleteProduct)
 		})
 	})
 
 	r.Route("/orders", func(r chi.Router) {
-		r.Post("/", handler.createOrder)
-		r.Get("/", handler.listOrders)
+		r.Use(handler.identify)
+
+		r.With(handler.authenticate, handler.requireScope("orders"), handler.requireVerifiedEmail, handler.idempotent).Post("/", handler.createOrder)
+		r.With(handler.requireScope("orders")).Get("/", handler.listOrders)
 
 		r.Route("/{id}", func(r chi.Router) {
+			r.Use(handler.requireScope("orders"))
+
 			r.Get("/", handler.getOrder)
 			r.Delete("/", handler.deleteOrder)
 		})
 
+		// Deprecated: the user and auth routes used to be nested here.
 		r.Route("/users", func(r chi.Router) {
-			r.Post("/", handler.createUser)
-			r.Get("/", handler.listUsers)
+			r.Use(handler.requireScope("users"))
+
+			r.With(deprecated("/auth/register")).Post("/", handler.registerUser)
+			r.With(deprecated("/users"), handler.authenticate, handler.requireAdmin).Get("/", handler.listUsers)
+			r.With(deprecated("/users/{id}"), handler.authenticate).Patch("/", handler.updateUserByEmail)
+			r.With(deprecated("/users/{id}"), handler.authenticate).Delete("/{id}", handler.deleteUser)
+			r.With(deprecated("/auth/login")).Post("/login", handler.loginUser)
+			r.With(deprecated("/auth/logout"), handler.authenticate).Post("/logout", handler.logoutUser)
+		})
+	})
+
+	r.Route("/auth", func(r chi.Router) {
+		r.Post("/register", handler.registerUser)
+		r.Post("/login", handler.loginUser)
+		r.Post("/login/mfa", handler.loginMFA)
+		r.Post("/refresh", handler.renewAccessToken)
+		r.With(handler.authenticate).Post("/logout", handler.logoutUser)
+		r.Post("/password/forgot", handler.forgotPassword)
+		r.Post("/password/reset", handler.resetPassword)
+		r.Post("/email/verify", handler.verifyEmail)
+		r.Post("/email/resend", handler.resendVerification)
+		r.With(handler.authenticate, handler.interactive, handler.requireAdmin).Post("/sessions/{id}/revoke", handler.revokeSession)
+	})
+
+	r.Route("/users", func(r chi.Router) {
+		r.Use(handler.authenticate, handler.requireScope("users"))
+
+		r.With(handler.requireAdmin).Post("/", handler.createUser)
+		r.With(handler.requireAdmin).Get("/", handler.listUsers)
+
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/", handler.getUser)
 			r.Patch("/", handler.updateUser)
+			r.Delete("/", handler.deleteUser)
 
-			r.Route("/{id}", func(r chi.Router) {
-				r.Delete("/", handler.deleteUser)
-			})
+			r.Route("/sessions", func(r chi.Router) {
+				r.Use(handler.interactive, handler.requireAdmin)
 
-			r.Route("/login", func(r chi.Router) {
-				r.Post("/", handler.loginUser)
+				r.Get("/", handler.listUserSessions)
+				r.Delete("/", handler.revokeUserSessions)
+				r.Delete("/{sessionID}", handler.revokeUserSession)
 			})
 
-			r.Route("/logout", func(r chi.Router) {
-				r.Post("/", handler.logoutUser)
+			r.With(handler.interactive, handler.requireAdmin).Post("/unlock", handler.unlockUser)
+
+			r.Route("/api-keys", func(r chi.Router) {
+				r.Use(handler.interactive, handler.requireAdmin)
+
+				r.Get("/", handler.listUserAPIKeys)
+				r.Delete("/{keyID}", handler.revokeUserAPIKey)
 			})
 		})
 	})
 
-	r.Route("/tokens", func(r chi.Router) {
-		r.Route("/renew", func(r chi.Router) {
-			r.Post("/", handler.renewAccessToken)
+	r.Route("/me", func(r chi.Router) {
+		r.Use(handler.authenticate)
+
+		r.With(handler.requireScope("users")).Get("/", handler.getMe)
+		r.With(handler.requireScope("users")).Patch("/", handler.updateMe)
+
+		r.Route("/sessions", func(r chi.Router) {
+			r.Use(handler.interactive)
+
+			r.Get("/", handler.listMySessions)
+			r.Delete("/", handler.revokeMySessions)
+			r.Delete("/{sessionID}", handler.revokeMySession)
 		})
 
-		r.Route("/revoke/{id}", func(r chi.Router) {
-			r.Post("/", handler.revokeSession)
+		r.With(handler.interactive).Get("/logins", handler.listMyLogins)
+
+		r.Route("/2fa", func(r chi.Router) {
+			r.Use(handler.interactive)
+
+			r.Post("/enroll", handler.enrollTOTP)
+			r.Post("/activate", handler.activateTOTP)
+			r.Delete("/", handler.disableTOTP)
 		})
+
+		r.Route("/api-keys", func(r chi.Router) {
+			r.Use(handler.interactive)
+
+			r.Post("/", handler.createAPIKey)
+			r.Get("/", handler.listMyAPIKeys)
+			r.Delete("/{keyID}", handler.revokeMyAPIKey)
+		})
+	})
+
+	// Deprecated: superseded by /auth/refresh and /auth/sessions/{id}/revoke.
+	r.Route("/tokens", func(r chi.Router) {
+		r.With(deprecated("/auth/refresh")).Post("/renew", handler.renewAccessToken)
+		r.With(deprecated("/auth/sessions/{id}/revoke"), handler.authenticate, handler.requireAdmin).Post("/revoke/{id}", handler.revokeSession)
 	})
 
+	r.Get("/.well-known/jwks.json", handler.jwks)
+
 	return r
 }
 
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/token"
+)
+
+var errTenantMismatch = errors.New("token issued for another tenant")
+
+// resolveTenant scopes the request to the tenant named by the tenant header
+// or, without one, to the tenant registered for the host name of the
+// request. Requests matching neither are scoped by their credentials, if
+// any, or else fall back to the default tenant.
+func (h *handler) resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var slug string
+		if h.config.TenantHeader != "" {
+			slug = r.Header.Get(h.config.TenantHeader)
+		}
+
+		var t *storer.Tenant
+		var err error
+		if slug != "" {
+			t, err = h.server.GetTenantBySlug(r.Context(), slug)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "unknown tenant", http.StatusNotFound)
+				return
+			}
+		} else {
+			t, err = h.server.GetTenantByHost(r.Context(), hostname(r))
+			if errors.Is(err, sql.ErrNoRows) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if err != nil {
+			http.Error(w, "error resolving tenant", http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(storer.WithTenant(r.Context(), t.ID)))
+	})
+}
+
+// tokenTenant scopes ctx to the tenant that claims were issued for. It fails
+// with errTenantMismatch if the request was resolved to another tenant.
+func tokenTenant(ctx context.Context, claims *token.UserClaims) (context.Context, error) {
+	id := claims.TenantID
+	if id == 0 {
+		id = storer.DefaultTenantID
+	}
+
+	if resolved, ok := storer.TenantFromContext(ctx); ok && resolved != id {
+		return nil, errTenantMismatch
+	}
+
+	return storer.WithTenant(ctx, id), nil
+}
+
+func hostname(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	return strings.ToLower(host)
+}
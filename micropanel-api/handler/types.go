@@ -67,10 +67,13 @@ type (
 	}
 
 	UserRes struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-		IsAdmin  bool   `json:"is_admin"`
+		ID            int64      `json:"id"`
+		Name          string     `json:"name"`
+		Email         string     `json:"email"`
+		IsAdmin       bool       `json:"is_admin"`
+		EmailVerified bool       `json:"email_verified"`
+		TOTPEnabled   bool       `json:"totp_enabled"`
+		LockedUntil   *time.Time `json:"locked_until"`
 	}
 
 	ListUserRes struct {
@@ -91,12 +94,110 @@ type (
 		User                  UserRes   `json:"user"`
 	}
 
+	MFAChallengeRes struct {
+		MFARequired bool      `json:"mfa_required"`
+		MFAToken    string    `json:"mfa_token"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}
+
+	LoginMFAReq struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	EnrollTOTPRes struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+
+	TOTPCodeReq struct {
+		Code string `json:"code"`
+	}
+
+	RecoveryCodesRes struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	CreateAPIKeyReq struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	APIKeyRes struct {
+		ID         int64      `json:"id"`
+		Name       string     `json:"name"`
+		Prefix     string     `json:"prefix"`
+		Scopes     []string   `json:"scopes"`
+		CreatedAt  time.Time  `json:"created_at"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+	}
+
+	// CreateAPIKeyRes is the only response that carries the key itself.
+	CreateAPIKeyRes struct {
+		APIKeyRes
+		Key string `json:"key"`
+	}
+
+	ListAPIKeyRes struct {
+		APIKeys []APIKeyRes `json:"api_keys"`
+	}
+
+	LoginRes struct {
+		ClientIP  string    `json:"client_ip"`
+		UserAgent string    `json:"user_agent"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	ListLoginRes struct {
+		Logins []LoginRes `json:"logins"`
+	}
+
+	LogoutUserReq struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	ForgotPasswordReq struct {
+		Email string `json:"email"`
+	}
+
+	ResetPasswordReq struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	VerifyEmailReq struct {
+		Token string `json:"token"`
+	}
+
+	ResendVerificationReq struct {
+		Email string `json:"email"`
+	}
+
+	SessionRes struct {
+		ID         string    `json:"id"`
+		UserAgent  string    `json:"user_agent"`
+		ClientIP   string    `json:"client_ip"`
+		CreatedAt  time.Time `json:"created_at"`
+		LastUsedAt time.Time `json:"last_used_at"`
+		ExpiresAt  time.Time `json:"expires_at"`
+	}
+
+	ListSessionRes struct {
+		Sessions []SessionRes `json:"sessions"`
+	}
+
 	RenewAccessTokenReq struct {
 		RefreshToken string `json:"refresh_token"`
 	}
 
 	RenewAccessTokenRes struct {
-		AccessToken          string    `json:"access_token"`
-		AccessTokenExpiresAt time.Time `json:"access_token_expires_at"`
+		SessionID             string    `json:"session_id"`
+		AccessToken           string    `json:"access_token"`
+		RefreshToken          string    `json:"refresh_token"`
+		AccessTokenExpiresAt  time.Time `json:"access_token_expires_at"`
+		RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
 	}
 )
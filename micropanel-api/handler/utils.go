@@ -1,10 +1,12 @@
 package handler
 
 import (
+	"net"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Turtel216/micro-panel/micropanel-api/storer"
-	"github.com/Turtel216/micro-panel/util"
 )
 
 func toStorerProduct(p ProductReq) *storer.Product {
@@ -127,25 +129,62 @@ func toStorerUser(u UserReq) *storer.User {
 
 func toUserRes(u *storer.User) UserRes {
 	return UserRes{
-		Name:     u.Name,
-		Email:    u.Email,
-		Password: u.Password,
+		ID:            u.ID,
+		Name:          u.Name,
+		Email:         u.Email,
+		IsAdmin:       u.IsAdmin,
+		EmailVerified: u.EmailVerified,
+		TOTPEnabled:   u.TOTPEnabled,
+		LockedUntil:   u.LockedUntil,
+	}
+}
+
+func toSessionRes(se *storer.Session) SessionRes {
+	return SessionRes{
+		ID:         se.ID,
+		UserAgent:  se.UserAgent,
+		ClientIP:   se.ClientIP,
+		CreatedAt:  se.CreatedAt,
+		LastUsedAt: se.LastUsedAt,
+		ExpiresAt:  se.ExpiresAt,
+	}
+}
+
+func toAPIKeyRes(k *storer.APIKey) APIKeyRes {
+	return APIKeyRes{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     strings.Split(k.Scopes, ","),
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+	}
+}
+
+func toLoginRes(l *storer.LoginRecord) LoginRes {
+	return LoginRes{
+		ClientIP:  l.ClientIP,
+		UserAgent: l.UserAgent,
+		CreatedAt: l.CreatedAt,
 	}
 }
 
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func patchUserReq(user *storer.User, u UserReq) {
 	if u.Name != "" {
 		user.Name = u.Name
 	}
-	if u.Email != "" {
+	if u.Email != "" && u.Email != user.Email {
 		user.Email = u.Email
-	}
-	if u.Password != "" {
-		hashed, err := util.HashPassword(u.Password)
-		if err != nil {
-			panic(err)
-		}
-		user.Password = hashed
+		user.EmailVerified = false
 	}
 	if u.IsAdmin {
 		user.IsAdmin = u.IsAdmin
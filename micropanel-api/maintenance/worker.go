@@ -0,0 +1,238 @@
+// Package maintenance purges rows that the API no longer needs.
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+)
+
+// LockName is the advisory lock that keeps concurrent API instances from
+// running maintenance at the same time.
+const LockName = "micropanel_maintenance"
+
+type Config struct {
+	Interval         time.Duration
+	BatchSize        int
+	RevokedRetention time.Duration
+	// EventRetention bounds how far back watch streams can resume. Zero
+	// keeps events forever.
+	EventRetention time.Duration
+}
+
+// Validate reports settings that would make Run panic or RunOnce spin.
+func (c Config) Validate() error {
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %s", c.Interval)
+	}
+	if c.BatchSize <= 0 {
+		return fmt.Errorf("batch size must be positive, got %d", c.BatchSize)
+	}
+
+	return nil
+}
+
+type Result struct {
+	SessionsDeleted        int64
+	Batches                int
+	DeniedTokensDeleted    int64
+	IdempotencyKeysDeleted int64
+	EventsDeleted          int64
+}
+
+type Stats struct {
+	Runs            uint64    `json:"runs"`
+	Skipped         uint64    `json:"skipped"`
+	Failures        uint64    `json:"failures"`
+	SessionsDeleted uint64    `json:"sessions_deleted"`
+	DeniedTokens    uint64    `json:"denied_tokens_deleted"`
+	IdempotencyKeys uint64    `json:"idempotency_keys_deleted"`
+	EventsDeleted   uint64    `json:"events_deleted"`
+	LastRun         time.Time `json:"last_run"`
+	LastDuration    string    `json:"last_duration"`
+}
+
+type Worker struct {
+	storer storer.Storer
+	config Config
+	now    func() time.Time
+
+	runs            atomic.Uint64
+	skipped         atomic.Uint64
+	failures        atomic.Uint64
+	sessionsDeleted atomic.Uint64
+	deniedTokens    atomic.Uint64
+	idempotencyKeys atomic.Uint64
+	eventsDeleted   atomic.Uint64
+
+	mu           sync.Mutex
+	lastRun      time.Time
+	lastDuration time.Duration
+}
+
+func NewWorker(st storer.Storer, config Config) *Worker {
+	return &Worker{
+		storer: st,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// Run calls RunOnce every Interval until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res, ran, err := w.RunOnce(ctx)
+			if err != nil {
+				log.Printf("Error running maintenance: %v", err)
+				continue
+			}
+			if ran && res.SessionsDeleted > 0 {
+				log.Printf("Purged %d sessions in %d batches", res.SessionsDeleted, res.Batches)
+			}
+			if ran && res.DeniedTokensDeleted > 0 {
+				log.Printf("Purged %d denied tokens", res.DeniedTokensDeleted)
+			}
+			if ran && res.IdempotencyKeysDeleted > 0 {
+				log.Printf("Purged %d expired idempotency keys", res.IdempotencyKeysDeleted)
+			}
+			if ran && res.EventsDeleted > 0 {
+				log.Printf("Purged %d events", res.EventsDeleted)
+			}
+		}
+	}
+}
+
+// RunOnce purges expired sessions, denylist entries, idempotency keys and
+// events in batches of BatchSize. Batches counts the session batches only. It reports false
+// without doing anything if another instance is already running maintenance.
+func (w *Worker) RunOnce(ctx context.Context) (Result, bool, error) {
+	var res Result
+	if w.config.BatchSize <= 0 {
+		// A batch deleting nothing would never be the last one.
+		return res, false, fmt.Errorf("batch size must be positive, got %d", w.config.BatchSize)
+	}
+	start := w.now()
+
+	ran, err := w.storer.WithLock(ctx, LockName, func(ctx context.Context) error {
+		expiredBefore := start
+		revokedBefore := start.Add(-w.config.RevokedRetention)
+
+		for {
+			n, err := w.storer.DeleteExpiredSessions(ctx, expiredBefore, revokedBefore, w.config.BatchSize)
+			if err != nil {
+				return err
+			}
+
+			res.Batches++
+			res.SessionsDeleted += n
+			w.sessionsDeleted.Add(uint64(n))
+
+			if n < int64(w.config.BatchSize) {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		for {
+			n, err := w.storer.DeleteExpiredDeniedTokens(ctx, expiredBefore, w.config.BatchSize)
+			if err != nil {
+				return err
+			}
+
+			res.DeniedTokensDeleted += n
+			w.deniedTokens.Add(uint64(n))
+
+			if n < int64(w.config.BatchSize) {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		for {
+			n, err := w.storer.DeleteExpiredIdempotencyKeys(ctx, expiredBefore, w.config.BatchSize)
+			if err != nil {
+				return err
+			}
+
+			res.IdempotencyKeysDeleted += n
+			w.idempotencyKeys.Add(uint64(n))
+
+			if n < int64(w.config.BatchSize) {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		if w.config.EventRetention <= 0 {
+			return nil
+		}
+
+		for {
+			n, err := w.storer.DeleteEventsBefore(ctx, start.Add(-w.config.EventRetention), w.config.BatchSize)
+			if err != nil {
+				return err
+			}
+
+			res.EventsDeleted += n
+			w.eventsDeleted.Add(uint64(n))
+
+			if n < int64(w.config.BatchSize) {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		w.failures.Add(1)
+		return res, ran, fmt.Errorf("error running maintenance: %w", err)
+	}
+	if !ran {
+		w.skipped.Add(1)
+		return res, false, nil
+	}
+
+	w.runs.Add(1)
+	w.mu.Lock()
+	w.lastRun = start
+	w.lastDuration = w.now().Sub(start)
+	w.mu.Unlock()
+
+	return res, true, nil
+}
+
+func (w *Worker) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return Stats{
+		Runs:            w.runs.Load(),
+		Skipped:         w.skipped.Load(),
+		Failures:        w.failures.Load(),
+		SessionsDeleted: w.sessionsDeleted.Load(),
+		DeniedTokens:    w.deniedTokens.Load(),
+		IdempotencyKeys: w.idempotencyKeys.Load(),
+		EventsDeleted:   w.eventsDeleted.Load(),
+		LastRun:         w.lastRun,
+		LastDuration:    w.lastDuration.String(),
+	}
+}
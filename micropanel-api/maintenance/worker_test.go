@@ -0,0 +1,182 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer/storertest"
+	"github.com/stretchr/testify/require"
+)
+
+func createSession(t *testing.T, st *storertest.Storer, id string, expiresAt time.Time, revoked bool) {
+	_, err := st.CreateSession(context.Background(), &storer.Session{
+		ID:        id,
+		FamilyID:  id,
+		UserEmail: "test@example.com",
+		ExpiresAt: expiresAt,
+	})
+	require.NoError(t, err)
+
+	if revoked {
+		require.NoError(t, st.RevokeSession(context.Background(), id))
+	}
+}
+
+func sessionExists(st *storertest.Storer, id string) bool {
+	_, err := st.GetSession(context.Background(), id)
+	return err == nil
+}
+
+func TestRunOnce(t *testing.T) {
+	st := storertest.New()
+	now := time.Now()
+	createSession(t, st, "active", now.Add(24*time.Hour), false)
+	createSession(t, st, "expired", now.Add(-time.Hour), false)
+	createSession(t, st, "revoked", now.Add(-time.Hour), true)
+
+	w := NewWorker(st, Config{BatchSize: 10, RevokedRetention: time.Hour})
+	res, ran, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.True(t, ran)
+	require.Equal(t, int64(1), res.SessionsDeleted)
+
+	require.True(t, sessionExists(st, "active"))
+	require.False(t, sessionExists(st, "expired"))
+	require.True(t, sessionExists(st, "revoked"), "revoked sessions are kept for the retention period")
+
+	w.now = func() time.Time { return now.Add(2 * time.Hour) }
+	res, _, err = w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), res.SessionsDeleted)
+	require.False(t, sessionExists(st, "revoked"))
+	require.True(t, sessionExists(st, "active"))
+
+	stats := w.Stats()
+	require.Equal(t, uint64(2), stats.Runs)
+	require.Equal(t, uint64(2), stats.SessionsDeleted)
+}
+
+func TestRunOnceBatches(t *testing.T) {
+	st := storertest.New()
+	for i := 0; i < 5; i++ {
+		createSession(t, st, fmt.Sprintf("expired-%d", i), time.Now().Add(-time.Hour), false)
+	}
+
+	w := NewWorker(st, Config{BatchSize: 2})
+	res, _, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(5), res.SessionsDeleted)
+	require.Equal(t, 3, res.Batches)
+}
+
+func TestRunOnceDeniedTokens(t *testing.T) {
+	st := storertest.New()
+	now := time.Now()
+	require.NoError(t, st.DenyToken(context.Background(), "expired", now.Add(-time.Minute)))
+	require.NoError(t, st.DenyToken(context.Background(), "live", now.Add(time.Hour)))
+
+	w := NewWorker(st, Config{BatchSize: 10})
+	res, _, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), res.DeniedTokensDeleted)
+	require.Equal(t, uint64(1), w.Stats().DeniedTokens)
+
+	denied, err := st.IsTokenDenied(context.Background(), "live")
+	require.NoError(t, err)
+	require.True(t, denied)
+}
+
+func TestRunOnceIdempotencyKeys(t *testing.T) {
+	st := storertest.New()
+	now := time.Now()
+	_, err := st.CreateIdempotencyKey(context.Background(), &storer.IdempotencyKey{UserID: 1, Key: "expired", ExpiresAt: now.Add(-time.Minute)})
+	require.NoError(t, err)
+	_, err = st.CreateIdempotencyKey(context.Background(), &storer.IdempotencyKey{UserID: 1, Key: "live", ExpiresAt: now.Add(time.Hour)})
+	require.NoError(t, err)
+
+	w := NewWorker(st, Config{BatchSize: 10})
+	res, _, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), res.IdempotencyKeysDeleted)
+	require.Equal(t, uint64(1), w.Stats().IdempotencyKeys)
+
+	_, err = st.GetIdempotencyKey(context.Background(), 1, "expired")
+	require.Error(t, err)
+	_, err = st.GetIdempotencyKey(context.Background(), 1, "live")
+	require.NoError(t, err)
+}
+
+func TestRunOnceEvents(t *testing.T) {
+	st := storertest.New()
+	p, err := st.CreateProduct(context.Background(), &storer.Product{Name: "test product", CountInStock: 1})
+	require.NoError(t, err)
+	p.CountInStock = 2
+	_, err = st.UpdateProduct(context.Background(), p, 0)
+	require.NoError(t, err)
+
+	w := NewWorker(st, Config{BatchSize: 10})
+	res, _, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, res.EventsDeleted, "events are kept without a retention")
+
+	w = NewWorker(st, Config{BatchSize: 10, EventRetention: time.Hour})
+	w.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	res, _, err = w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), res.EventsDeleted)
+	require.Equal(t, uint64(1), w.Stats().EventsDeleted)
+
+	events, err := st.ListEvents(context.Background(), 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
+
+func TestRunOnceSkipsWhenLocked(t *testing.T) {
+	st := storertest.New()
+	createSession(t, st, "expired", time.Now().Add(-time.Hour), false)
+	w := NewWorker(st, Config{BatchSize: 10})
+
+	ran, err := st.WithLock(context.Background(), LockName, func(ctx context.Context) error {
+		_, ran, err := w.RunOnce(ctx)
+		require.NoError(t, err)
+		require.False(t, ran)
+		return nil
+	})
+	require.NoError(t, err)
+	require.True(t, ran)
+
+	require.True(t, sessionExists(st, "expired"))
+	require.Equal(t, uint64(1), w.Stats().Skipped)
+}
+
+func TestRunOnceError(t *testing.T) {
+	st := storertest.New()
+	st.FailOn("DeleteExpiredSessions", fmt.Errorf("error deleting sessions"))
+
+	w := NewWorker(st, Config{BatchSize: 10})
+	_, _, err := w.RunOnce(context.Background())
+	require.Error(t, err)
+	require.Equal(t, uint64(1), w.Stats().Failures)
+}
+
+func TestRunOnceZeroBatchSize(t *testing.T) {
+	st := storertest.New()
+	createSession(t, st, "expired", time.Now().Add(-time.Hour), false)
+
+	config := Config{Interval: time.Minute, BatchSize: 0}
+	require.Error(t, config.Validate())
+
+	_, ran, err := NewWorker(st, config).RunOnce(context.Background())
+	require.Error(t, err)
+	require.False(t, ran)
+	require.True(t, sessionExists(st, "expired"))
+}
+
+func TestConfigValidate(t *testing.T) {
+	require.NoError(t, Config{Interval: time.Minute, BatchSize: 1}.Validate())
+	require.Error(t, Config{Interval: 0, BatchSize: 1}.Validate())
+	require.Error(t, Config{Interval: -time.Minute, BatchSize: 1}.Validate())
+}
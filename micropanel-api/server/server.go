@@ -2,20 +2,29 @@ package server
 
 import (
 	"context"
+	"time"
 
 	"github.com/Turtel216/micro-panel/micropanel-api/storer"
 )
 
 type Server struct {
-	storer *storer.MySQLStorer
+	storer storer.Storer
 }
 
-func NewServer(storer *storer.MySQLStorer) *Server {
+func NewServer(storer storer.Storer) *Server {
 	return &Server{
 		storer: storer,
 	}
 }
 
+func (s *Server) GetTenantBySlug(ctx context.Context, slug string) (*storer.Tenant, error) {
+	return s.storer.GetTenantBySlug(ctx, slug)
+}
+
+func (s *Server) GetTenantByHost(ctx context.Context, host string) (*storer.Tenant, error) {
+	return s.storer.GetTenantByHost(ctx, host)
+}
+
 func (s *Server) CreateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
 	return s.storer.CreateProduct(ctx, p)
 }
@@ -28,12 +37,12 @@ func (s *Server) ListProducts(ctx context.Context) ([]storer.Product, error) {
 	return s.storer.ListProducts(ctx)
 }
 
-func (s *Server) UpdateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
-	return s.storer.UpdateProduct(ctx, p)
+func (s *Server) UpdateProduct(ctx context.Context, p *storer.Product, version int64) (*storer.Product, error) {
+	return s.storer.UpdateProduct(ctx, p, version)
 }
 
-func (s *Server) DeleteProduct(ctx context.Context, id int64) error {
-	return s.storer.DeleteProduct(ctx, id)
+func (s *Server) DeleteProduct(ctx context.Context, id int64, version int64) error {
+	return s.storer.DeleteProduct(ctx, id, version)
 }
 
 func (s *Server) CreateOrder(ctx context.Context, o *storer.Order) (*storer.Order, error) {
@@ -52,6 +61,10 @@ func (s *Server) DeleteOrder(ctx context.Context, id int64) error {
 	return s.storer.DeleteOrder(ctx, id)
 }
 
+func (s *Server) UpdateOrderStatus(ctx context.Context, id int64, status storer.OrderStatus) (*storer.Order, error) {
+	return s.storer.UpdateOrderStatus(ctx, id, status)
+}
+
 func (s *Server) CreateUser(ctx context.Context, u *storer.User) (*storer.User, error) {
 	return s.storer.CreateUser(ctx, u)
 }
@@ -60,6 +73,10 @@ func (s *Server) GetUser(ctx context.Context, email string) (*storer.User, error
 	return s.storer.GetUser(ctx, email)
 }
 
+func (s *Server) GetUserByID(ctx context.Context, id int64) (*storer.User, error) {
+	return s.storer.GetUserByID(ctx, id)
+}
+
 func (s *Server) ListUsers(ctx context.Context) ([]storer.User, error) {
 	return s.storer.ListUsers(ctx)
 }
@@ -80,10 +97,146 @@ func (s *Server) GetSession(ctx context.Context, id string) (*storer.Session, er
 	return s.storer.GetSession(ctx, id)
 }
 
+func (s *Server) ListSessions(ctx context.Context, email string) ([]storer.Session, error) {
+	return s.storer.ListSessions(ctx, email)
+}
+
 func (s *Server) RevokeSession(ctx context.Context, id string) error {
 	return s.storer.RevokeSession(ctx, id)
 }
 
+func (s *Server) RotateSession(ctx context.Context, id string, next *storer.Session) (*storer.Session, error) {
+	return s.storer.RotateSession(ctx, id, next)
+}
+
+func (s *Server) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	return s.storer.RevokeSessionFamily(ctx, familyID)
+}
+
+func (s *Server) RevokeSessionsByEmail(ctx context.Context, email string) error {
+	return s.storer.RevokeSessionsByEmail(ctx, email)
+}
+
 func (s *Server) DeleteSession(ctx context.Context, id string) error {
 	return s.storer.DeleteSession(ctx, id)
 }
+
+func (s *Server) CreateSecurityEvent(ctx context.Context, e *storer.SecurityEvent) (*storer.SecurityEvent, error) {
+	return s.storer.CreateSecurityEvent(ctx, e)
+}
+
+func (s *Server) CreateIdempotencyKey(ctx context.Context, k *storer.IdempotencyKey) (*storer.IdempotencyKey, error) {
+	return s.storer.CreateIdempotencyKey(ctx, k)
+}
+
+func (s *Server) GetIdempotencyKey(ctx context.Context, userID int64, key string) (*storer.IdempotencyKey, error) {
+	return s.storer.GetIdempotencyKey(ctx, userID, key)
+}
+
+func (s *Server) SaveIdempotencyResponse(ctx context.Context, k *storer.IdempotencyKey) error {
+	return s.storer.SaveIdempotencyResponse(ctx, k)
+}
+
+func (s *Server) DeleteIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	return s.storer.DeleteIdempotencyKey(ctx, userID, key)
+}
+
+func (s *Server) CreateUserToken(ctx context.Context, t *storer.UserToken) (*storer.UserToken, error) {
+	return s.storer.CreateUserToken(ctx, t)
+}
+
+func (s *Server) ConsumeUserToken(ctx context.Context, tokenHash, purpose string) (*storer.UserToken, error) {
+	return s.storer.ConsumeUserToken(ctx, tokenHash, purpose)
+}
+
+func (s *Server) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (*storer.UserToken, error) {
+	return s.storer.ResetPassword(ctx, tokenHash, passwordHash)
+}
+
+func (s *Server) CreateNotification(ctx context.Context, n *storer.Notification) (*storer.Notification, error) {
+	return s.storer.CreateNotification(ctx, n)
+}
+
+func (s *Server) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string) error {
+	return s.storer.ReplaceRecoveryCodes(ctx, userID, hashes)
+}
+
+func (s *Server) UseRecoveryCode(ctx context.Context, userID int64, hash string) error {
+	return s.storer.UseRecoveryCode(ctx, userID, hash)
+}
+
+func (s *Server) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	return s.storer.UseTOTPStep(ctx, userID, step)
+}
+
+func (s *Server) CreateAPIKey(ctx context.Context, k *storer.APIKey) (*storer.APIKey, error) {
+	return s.storer.CreateAPIKey(ctx, k)
+}
+
+func (s *Server) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*storer.APIKey, error) {
+	return s.storer.GetAPIKeyByPrefix(ctx, prefix)
+}
+
+func (s *Server) ListAPIKeys(ctx context.Context, userID int64) ([]storer.APIKey, error) {
+	return s.storer.ListAPIKeys(ctx, userID)
+}
+
+func (s *Server) RevokeAPIKey(ctx context.Context, userID, id int64) error {
+	return s.storer.RevokeAPIKey(ctx, userID, id)
+}
+
+// sessionTouchInterval bounds how often a session's last_used_at is written,
+// so that every authenticated request does not turn into an UPDATE.
+const sessionTouchInterval = time.Minute
+
+func (s *Server) TouchSession(ctx context.Context, accessTokenID string) error {
+	return s.storer.TouchSession(ctx, accessTokenID, time.Now().Add(-sessionTouchInterval))
+}
+
+func (s *Server) TouchAPIKey(ctx context.Context, id int64) error {
+	return s.storer.TouchAPIKey(ctx, id)
+}
+
+func (s *Server) RecordFailedLogin(ctx context.Context, id int64) (int, error) {
+	return s.storer.RecordFailedLogin(ctx, id)
+}
+
+func (s *Server) LockUser(ctx context.Context, id int64, until time.Time) error {
+	return s.storer.LockUser(ctx, id, until)
+}
+
+func (s *Server) UnlockUser(ctx context.Context, id int64) error {
+	return s.storer.UnlockUser(ctx, id)
+}
+
+func (s *Server) CreateLoginRecord(ctx context.Context, l *storer.LoginRecord) (*storer.LoginRecord, error) {
+	return s.storer.CreateLoginRecord(ctx, l)
+}
+
+func (s *Server) ListLoginHistory(ctx context.Context, userID int64, limit int) ([]storer.LoginRecord, error) {
+	return s.storer.ListLoginHistory(ctx, userID, limit)
+}
+
+func (s *Server) ListLiveAccessTokens(ctx context.Context, email string) ([]storer.Session, error) {
+	return s.storer.ListLiveAccessTokens(ctx, email)
+}
+
+func (s *Server) DenyToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return s.storer.DenyToken(ctx, jti, expiresAt)
+}
+
+func (s *Server) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	return s.storer.IsTokenDenied(ctx, jti)
+}
+
+func (s *Server) ListEvents(ctx context.Context, afterID int64, limit int) ([]storer.Event, error) {
+	return s.storer.ListEvents(ctx, afterID, limit)
+}
+
+func (s *Server) GetFirstEventID(ctx context.Context) (int64, error) {
+	return s.storer.GetFirstEventID(ctx)
+}
+
+func (s *Server) GetLastEventID(ctx context.Context) (int64, error) {
+	return s.storer.GetLastEventID(ctx)
+}
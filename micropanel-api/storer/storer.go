@@ -0,0 +1,74 @@
+package storer
+
+import (
+	"context"
+	"time"
+)
+
+// Storer persists the data of all tenants. Queries on products, orders, users
+// and sessions are scoped to the tenant of the context, see WithTenant.
+type Storer interface {
+	CreateTenant(ctx context.Context, t *Tenant) (*Tenant, error)
+	GetTenantBySlug(ctx context.Context, slug string) (*Tenant, error)
+	GetTenantByHost(ctx context.Context, host string) (*Tenant, error)
+	CreateProduct(ctx context.Context, p *Product) (*Product, error)
+	GetProduct(ctx context.Context, id int64) (*Product, error)
+	ListProducts(ctx context.Context) ([]Product, error)
+	UpdateProduct(ctx context.Context, p *Product, version int64) (*Product, error)
+	DeleteProduct(ctx context.Context, id int64, version int64) error
+	CreateOrder(ctx context.Context, o *Order) (*Order, error)
+	GetOrder(ctx context.Context, id int64) (*Order, error)
+	ListOrders(ctx context.Context) ([]Order, error)
+	DeleteOrder(ctx context.Context, id int64) error
+	UpdateOrderStatus(ctx context.Context, id int64, status OrderStatus) (*Order, error)
+	CreateUser(ctx context.Context, u *User) (*User, error)
+	GetUser(ctx context.Context, email string) (*User, error)
+	GetUserByID(ctx context.Context, id int64) (*User, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	UpdateUser(ctx context.Context, u *User) (*User, error)
+	DeleteUser(ctx context.Context, id int64) error
+	RecordFailedLogin(ctx context.Context, id int64) (int, error)
+	LockUser(ctx context.Context, id int64, until time.Time) error
+	UnlockUser(ctx context.Context, id int64) error
+	CreateLoginRecord(ctx context.Context, l *LoginRecord) (*LoginRecord, error)
+	ListLoginHistory(ctx context.Context, userID int64, limit int) ([]LoginRecord, error)
+	CreateSession(ctx context.Context, s *Session) (*Session, error)
+	GetSession(ctx context.Context, id string) (*Session, error)
+	ListSessions(ctx context.Context, email string) ([]Session, error)
+	ListLiveAccessTokens(ctx context.Context, email string) ([]Session, error)
+	RevokeSession(ctx context.Context, id string) error
+	RotateSession(ctx context.Context, id string, next *Session) (*Session, error)
+	TouchSession(ctx context.Context, accessTokenID string, staleBefore time.Time) error
+	RevokeSessionFamily(ctx context.Context, familyID string) error
+	RevokeSessionsByEmail(ctx context.Context, email string) error
+	DeleteExpiredSessions(ctx context.Context, expiredBefore, revokedBefore time.Time, limit int) (int64, error)
+	DeleteSession(ctx context.Context, id string) error
+	CreateSecurityEvent(ctx context.Context, e *SecurityEvent) (*SecurityEvent, error)
+	CreateIdempotencyKey(ctx context.Context, k *IdempotencyKey) (*IdempotencyKey, error)
+	GetIdempotencyKey(ctx context.Context, userID int64, key string) (*IdempotencyKey, error)
+	SaveIdempotencyResponse(ctx context.Context, k *IdempotencyKey) error
+	DeleteIdempotencyKey(ctx context.Context, userID int64, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time, limit int) (int64, error)
+	CreateUserToken(ctx context.Context, t *UserToken) (*UserToken, error)
+	ConsumeUserToken(ctx context.Context, tokenHash, purpose string) (*UserToken, error)
+	ResetPassword(ctx context.Context, tokenHash, passwordHash string) (*UserToken, error)
+	CreateNotification(ctx context.Context, n *Notification) (*Notification, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int64, hash string) error
+	UseTOTPStep(ctx context.Context, userID, step int64) error
+	CreateAPIKey(ctx context.Context, k *APIKey) (*APIKey, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context, userID int64) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id int64) error
+	TouchAPIKey(ctx context.Context, id int64) error
+	DenyToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenDenied(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredDeniedTokens(ctx context.Context, before time.Time, limit int) (int64, error)
+	ListEvents(ctx context.Context, afterID int64, limit int) ([]Event, error)
+	GetFirstEventID(ctx context.Context) (int64, error)
+	GetLastEventID(ctx context.Context) (int64, error)
+	DeleteEventsBefore(ctx context.Context, before time.Time, limit int) (int64, error)
+	WithLock(ctx context.Context, name string, fn func(context.Context) error) (bool, error)
+}
+
+var _ Storer = (*MySQLStorer)(nil)
@@ -0,0 +1,170 @@
+package storer
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+// CachedStorer is a read-through cache for products in front of another
+// Storer. Every write that can change a product invalidates the affected
+// entries along with the cached product list. Entries are kept per tenant.
+type CachedStorer struct {
+	Storer
+
+	products *lru[productCacheKey, Product]
+	lists    *lru[int64, []Product]
+	group    singleflight.Group
+
+	// generation is bumped on every invalidation so that a read which
+	// started before a write does not repopulate the cache with stale data.
+	generation atomic.Uint64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+var _ Storer = (*CachedStorer)(nil)
+
+func NewCachedStorer(next Storer, size int, ttl time.Duration) *CachedStorer {
+	return &CachedStorer{
+		Storer:   next,
+		products: newLRU[productCacheKey, Product](size, ttl),
+		lists:    newLRU[int64, []Product](size, ttl),
+	}
+}
+
+type productCacheKey struct {
+	tenantID int64
+	id       int64
+}
+
+func (cs *CachedStorer) GetProduct(ctx context.Context, id int64) (*Product, error) {
+	key := productCacheKey{TenantID(ctx), id}
+	if p, ok := cs.products.get(key); ok {
+		cs.hits.Add(1)
+		return &p, nil
+	}
+	cs.misses.Add(1)
+
+	gen := cs.generation.Load()
+	v, err, _ := cs.group.Do(key.String(), func() (interface{}, error) {
+		p, err := cs.Storer.GetProduct(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if cs.products.addIfGeneration(&cs.generation, gen, key, *p) {
+			cs.evictions.Add(1)
+		}
+
+		return *p, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := v.(Product)
+	return &p, nil
+}
+
+func (cs *CachedStorer) ListProducts(ctx context.Context) ([]Product, error) {
+	tenantID := TenantID(ctx)
+	if ps, ok := cs.lists.get(tenantID); ok {
+		cs.hits.Add(1)
+		return append([]Product(nil), ps...), nil
+	}
+	cs.misses.Add(1)
+
+	gen := cs.generation.Load()
+	v, err, _ := cs.group.Do(productListKey(tenantID), func() (interface{}, error) {
+		ps, err := cs.Storer.ListProducts(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		cs.lists.addIfGeneration(&cs.generation, gen, tenantID, ps)
+
+		return ps, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]Product(nil), v.([]Product)...), nil
+}
+
+func (cs *CachedStorer) CreateProduct(ctx context.Context, p *Product) (*Product, error) {
+	created, err := cs.Storer.CreateProduct(ctx, p)
+	cs.invalidate(ctx)
+	return created, err
+}
+
+func (cs *CachedStorer) UpdateProduct(ctx context.Context, p *Product, version int64) (*Product, error) {
+	updated, err := cs.Storer.UpdateProduct(ctx, p, version)
+	cs.invalidate(ctx, p.ID)
+	return updated, err
+}
+
+func (cs *CachedStorer) DeleteProduct(ctx context.Context, id int64, version int64) error {
+	err := cs.Storer.DeleteProduct(ctx, id, version)
+	cs.invalidate(ctx, id)
+	return err
+}
+
+func (cs *CachedStorer) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
+	created, err := cs.Storer.CreateOrder(ctx, o)
+
+	ids := make([]int64, 0, len(o.Items))
+	for _, oi := range o.Items {
+		ids = append(ids, oi.ProductID)
+	}
+	cs.invalidate(ctx, ids...)
+
+	return created, err
+}
+
+func (cs *CachedStorer) Stats() CacheStats {
+	return CacheStats{
+		Hits:      cs.hits.Load(),
+		Misses:    cs.misses.Load(),
+		Evictions: cs.evictions.Load(),
+		Entries:   cs.products.len() + cs.lists.len(),
+	}
+}
+
+// invalidate drops the given products and the product list of the tenant
+// of ctx. It runs even when the underlying write failed since the write may
+// have partially applied.
+func (cs *CachedStorer) invalidate(ctx context.Context, ids ...int64) {
+	cs.generation.Add(1)
+
+	tenantID := TenantID(ctx)
+	for _, id := range ids {
+		key := productCacheKey{tenantID, id}
+		cs.products.remove(key)
+		cs.group.Forget(key.String())
+	}
+
+	cs.lists.remove(tenantID)
+	cs.group.Forget(productListKey(tenantID))
+}
+
+func (k productCacheKey) String() string {
+	return "product:" + strconv.FormatInt(k.tenantID, 10) + ":" + strconv.FormatInt(k.id, 10)
+}
+
+func productListKey(tenantID int64) string {
+	return "products:" + strconv.FormatInt(tenantID, 10)
+}
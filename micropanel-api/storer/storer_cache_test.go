@@ -0,0 +1,228 @@
+package storer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingStorer struct {
+	Storer
+	gets  atomic.Int64
+	lists atomic.Int64
+	delay time.Duration
+}
+
+func (cs *countingStorer) GetProduct(ctx context.Context, id int64) (*Product, error) {
+	cs.gets.Add(1)
+	time.Sleep(cs.delay)
+	if id < 0 {
+		return nil, fmt.Errorf("error getting product")
+	}
+	return &Product{ID: id, Name: "test product"}, nil
+}
+
+func (cs *countingStorer) ListProducts(ctx context.Context) ([]Product, error) {
+	cs.lists.Add(1)
+	return []Product{{ID: 1}, {ID: 2}}, nil
+}
+
+func (cs *countingStorer) UpdateProduct(ctx context.Context, p *Product, version int64) (*Product, error) {
+	return p, nil
+}
+
+func (cs *countingStorer) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
+	return o, nil
+}
+
+func TestCachedStorer(t *testing.T) {
+	tcs := []struct {
+		name string
+		test func(*testing.T, *CachedStorer, *countingStorer)
+	}{
+		{
+			name: "get product hits cache",
+			test: func(t *testing.T, cs *CachedStorer, next *countingStorer) {
+				for i := 0; i < 3; i++ {
+					p, err := cs.GetProduct(context.Background(), 1)
+					require.NoError(t, err)
+					require.Equal(t, int64(1), p.ID)
+				}
+
+				require.Equal(t, int64(1), next.gets.Load())
+				require.Equal(t, CacheStats{Hits: 2, Misses: 1, Entries: 1}, cs.Stats())
+			},
+		},
+		{
+			name: "returned products are copies",
+			test: func(t *testing.T, cs *CachedStorer, next *countingStorer) {
+				p, err := cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+				p.Name = "changed"
+
+				p, err = cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+				require.Equal(t, "test product", p.Name)
+			},
+		},
+		{
+			name: "errors are not cached",
+			test: func(t *testing.T, cs *CachedStorer, next *countingStorer) {
+				_, err := cs.GetProduct(context.Background(), -1)
+				require.Error(t, err)
+				_, err = cs.GetProduct(context.Background(), -1)
+				require.Error(t, err)
+
+				require.Equal(t, int64(2), next.gets.Load())
+			},
+		},
+		{
+			name: "concurrent misses are collapsed",
+			test: func(t *testing.T, cs *CachedStorer, next *countingStorer) {
+				next.delay = 50 * time.Millisecond
+
+				var wg sync.WaitGroup
+				for i := 0; i < 10; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						_, err := cs.GetProduct(context.Background(), 1)
+						require.NoError(t, err)
+					}()
+				}
+				wg.Wait()
+
+				require.Equal(t, int64(1), next.gets.Load())
+			},
+		},
+		{
+			name: "tenants are cached separately",
+			test: func(t *testing.T, cs *CachedStorer, next *countingStorer) {
+				other := WithTenant(context.Background(), 2)
+
+				_, err := cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+				_, err = cs.GetProduct(other, 1)
+				require.NoError(t, err)
+				_, err = cs.ListProducts(context.Background())
+				require.NoError(t, err)
+				_, err = cs.ListProducts(other)
+				require.NoError(t, err)
+
+				require.Equal(t, int64(2), next.gets.Load())
+				require.Equal(t, int64(2), next.lists.Load())
+
+				_, err = cs.UpdateProduct(other, &Product{ID: 1}, 0)
+				require.NoError(t, err)
+
+				_, err = cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+				_, err = cs.ListProducts(context.Background())
+				require.NoError(t, err)
+
+				require.Equal(t, int64(2), next.gets.Load())
+				require.Equal(t, int64(2), next.lists.Load())
+			},
+		},
+		{
+			name: "update invalidates product and list",
+			test: func(t *testing.T, cs *CachedStorer, next *countingStorer) {
+				_, err := cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+				_, err = cs.ListProducts(context.Background())
+				require.NoError(t, err)
+
+				_, err = cs.UpdateProduct(context.Background(), &Product{ID: 1}, 0)
+				require.NoError(t, err)
+
+				_, err = cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+				_, err = cs.ListProducts(context.Background())
+				require.NoError(t, err)
+
+				require.Equal(t, int64(2), next.gets.Load())
+				require.Equal(t, int64(2), next.lists.Load())
+			},
+		},
+		{
+			name: "create order invalidates ordered products",
+			test: func(t *testing.T, cs *CachedStorer, next *countingStorer) {
+				_, err := cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+				_, err = cs.GetProduct(context.Background(), 2)
+				require.NoError(t, err)
+
+				_, err = cs.CreateOrder(context.Background(), &Order{Items: []OrderItem{{ProductID: 1}}})
+				require.NoError(t, err)
+
+				_, err = cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+				_, err = cs.GetProduct(context.Background(), 2)
+				require.NoError(t, err)
+
+				require.Equal(t, int64(3), next.gets.Load())
+			},
+		},
+		{
+			name: "least recently used entry is evicted",
+			test: func(t *testing.T, cs *CachedStorer, next *countingStorer) {
+				for _, id := range []int64{1, 2, 1, 3} {
+					_, err := cs.GetProduct(context.Background(), id)
+					require.NoError(t, err)
+				}
+
+				_, err := cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+				_, err = cs.GetProduct(context.Background(), 2)
+				require.NoError(t, err)
+
+				require.Equal(t, int64(4), next.gets.Load())
+				require.Equal(t, uint64(2), cs.Stats().Evictions)
+			},
+		},
+		{
+			name: "expired entries are refetched",
+			test: func(t *testing.T, cs *CachedStorer, next *countingStorer) {
+				now := time.Now()
+				cs.products.now = func() time.Time { return now }
+
+				_, err := cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+
+				now = now.Add(2 * time.Minute)
+				_, err = cs.GetProduct(context.Background(), 1)
+				require.NoError(t, err)
+
+				require.Equal(t, int64(2), next.gets.Load())
+			},
+		},
+		{
+			name: "adds are dropped after an invalidation",
+			test: func(t *testing.T, cs *CachedStorer, next *countingStorer) {
+				key := productCacheKey{DefaultTenantID, 1}
+				gen := cs.generation.Load()
+				cs.invalidate(context.Background(), 1)
+
+				cs.products.addIfGeneration(&cs.generation, gen, key, Product{ID: 1})
+				_, ok := cs.products.get(key)
+				require.False(t, ok)
+
+				cs.products.addIfGeneration(&cs.generation, cs.generation.Load(), key, Product{ID: 1})
+				_, ok = cs.products.get(key)
+				require.True(t, ok)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			next := &countingStorer{}
+			tc.test(t, NewCachedStorer(next, 2, time.Minute), next)
+		})
+	}
+}
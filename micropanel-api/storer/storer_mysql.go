@@ -2,11 +2,18 @@ package storer
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 )
 
+const mysqlErrDuplicateEntry = 1062
+
 type MySQLStorer struct {
 	db *sqlx.DB
 }
@@ -38,8 +45,44 @@ func (ms *MySQLStorer) execTx(ctx context.Context, fn func(*sqlx.Tx) error) erro
 	return nil
 }
 
+func (ms *MySQLStorer) CreateTenant(ctx context.Context, t *Tenant) (*Tenant, error) {
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO tenants (name, slug, host) VALUES (:name, :slug, :host)", t)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting tenant: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	t.ID = id
+
+	return t, nil
+}
+
+func (ms *MySQLStorer) GetTenantBySlug(ctx context.Context, slug string) (*Tenant, error) {
+	var t Tenant
+	err := ms.db.GetContext(ctx, &t, "SELECT * FROM tenants WHERE slug=?", slug)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tenant: %w", err)
+	}
+
+	return &t, nil
+}
+
+func (ms *MySQLStorer) GetTenantByHost(ctx context.Context, host string) (*Tenant, error) {
+	var t Tenant
+	err := ms.db.GetContext(ctx, &t, "SELECT * FROM tenants WHERE host=?", host)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tenant: %w", err)
+	}
+
+	return &t, nil
+}
+
 func (ms *MySQLStorer) CreateProduct(ctx context.Context, p *Product) (*Product, error) {
-	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (:name, :image, :category, :description, :rating, :num_reviews, :price, :count_in_stock)", p)
+	p.TenantID = TenantID(ctx)
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO products (tenant_id, name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (:tenant_id, :name, :image, :category, :description, :rating, :num_reviews, :price, :count_in_stock)", p)
 	if err != nil {
 		return nil, fmt.Errorf("Error inserting product: %w", err)
 	}
@@ -50,13 +93,14 @@ func (ms *MySQLStorer) CreateProduct(ctx context.Context, p *Product) (*Product,
 	}
 
 	p.ID = id
+	p.Version = 1
 
 	return p, nil
 }
 
 func (ms *MySQLStorer) GetProduct(ctx context.Context, id int64) (*Product, error) {
 	var p Product
-	err := ms.db.GetContext(ctx, &p, "SELECT * FROM products WHERE id=?", id)
+	err := ms.db.GetContext(ctx, &p, "SELECT * FROM products WHERE id=? AND tenant_id=?", id, TenantID(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("Error getting product: %w", err)
 	}
@@ -66,7 +110,7 @@ func (ms *MySQLStorer) GetProduct(ctx context.Context, id int64) (*Product, erro
 
 func (ms *MySQLStorer) ListProducts(ctx context.Context) ([]Product, error) {
 	var p []Product
-	err := ms.db.SelectContext(ctx, &p, "SELECT * FROM products")
+	err := ms.db.SelectContext(ctx, &p, "SELECT * FROM products WHERE tenant_id=?", TenantID(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("Error listing products: %w", err)
 	}
@@ -74,8 +118,39 @@ func (ms *MySQLStorer) ListProducts(ctx context.Context) ([]Product, error) {
 	return p, nil
 }
 
-func (ms *MySQLStorer) UpdateProduct(ctx context.Context, p *Product) (*Product, error) {
-	_, err := ms.db.NamedExecContext(ctx, "UPDATE products SET name=:name, image=:image, category=:category, description=:description, rating=:rating, num_reviews=:num_reviews, price=:price, count_in_stock=:count_in_stock, updated_at=:updated_at WHERE id=:id", p)
+// ErrProductModified is returned when a product is no longer at the version
+// an update or delete was conditioned on.
+var ErrProductModified = errors.New("product was modified")
+
+// UpdateProduct increments the version of p and records an inventory event
+// when its stock changes. Unless version is zero, it fails with
+// ErrProductModified if the stored product is at another version.
+func (ms *MySQLStorer) UpdateProduct(ctx context.Context, p *Product, version int64) (*Product, error) {
+	p.TenantID = TenantID(ctx)
+	if p.UpdatedAt != nil {
+		// DATETIME rounds to the nearest second. Truncating here instead
+		// keeps the returned product as later reads see it.
+		updatedAt := p.UpdatedAt.Truncate(time.Second)
+		p.UpdatedAt = &updatedAt
+	}
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		current, err := lockProduct(ctx, tx, p.ID, version)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NamedExecContext(ctx, "UPDATE products SET name=:name, image=:image, category=:category, description=:description, rating=:rating, num_reviews=:num_reviews, price=:price, count_in_stock=:count_in_stock, updated_at=:updated_at, version=version+1 WHERE id=:id AND tenant_id=:tenant_id", p)
+		if err != nil {
+			return err
+		}
+		p.Version = current.Version + 1
+
+		if current.CountInStock == p.CountInStock {
+			return nil
+		}
+
+		return createEvent(ctx, tx, &Event{TenantID: p.TenantID, Kind: EventInventory, EntityID: p.ID, CountInStock: p.CountInStock})
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error updating product: %w", err)
 	}
@@ -83,8 +158,26 @@ func (ms *MySQLStorer) UpdateProduct(ctx context.Context, p *Product) (*Product,
 	return p, nil
 }
 
-func (ms *MySQLStorer) DeleteProduct(ctx context.Context, id int64) error {
-	_, err := ms.db.ExecContext(ctx, "DELETE FROM products WHERE id=?", id)
+// DeleteProduct fails with ErrProductModified if version is not zero and the
+// stored product is at another version.
+func (ms *MySQLStorer) DeleteProduct(ctx context.Context, id int64, version int64) error {
+	if version == 0 {
+		_, err := ms.db.ExecContext(ctx, "DELETE FROM products WHERE id=? AND tenant_id=?", id, TenantID(ctx))
+		if err != nil {
+			return fmt.Errorf("error deleting product: %w", err)
+		}
+
+		return nil
+	}
+
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := lockProduct(ctx, tx, id, version); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, "DELETE FROM products WHERE id=? AND tenant_id=?", id, TenantID(ctx))
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("error deleting product: %w", err)
 	}
@@ -92,7 +185,24 @@ func (ms *MySQLStorer) DeleteProduct(ctx context.Context, id int64) error {
 	return nil
 }
 
+// lockProduct locks a product until the end of tx, checking that it is still
+// at version unless version is zero.
+func lockProduct(ctx context.Context, tx *sqlx.Tx, id int64, version int64) (*Product, error) {
+	var p Product
+	err := tx.GetContext(ctx, &p, "SELECT count_in_stock, version FROM products WHERE id=? AND tenant_id=? FOR UPDATE", id, TenantID(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error getting product: %w", err)
+	}
+
+	if version != 0 && p.Version != version {
+		return nil, ErrProductModified
+	}
+
+	return &p, nil
+}
+
 func (ms *MySQLStorer) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
+	o.TenantID = TenantID(ctx)
 	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
 		// insert into orders
 		order, err := createOrder(ctx, tx, o)
@@ -119,7 +229,7 @@ func (ms *MySQLStorer) CreateOrder(ctx context.Context, o *Order) (*Order, error
 
 func (ms *MySQLStorer) GetOrder(ctx context.Context, id int64) (*Order, error) {
 	var o Order
-	err := ms.db.GetContext(ctx, &o, "SELECT * FROM orders WHERE id=?", id)
+	err := ms.db.GetContext(ctx, &o, "SELECT * FROM orders WHERE id=? AND tenant_id=?", id, TenantID(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("error getting order: %w", err)
 	}
@@ -136,7 +246,7 @@ func (ms *MySQLStorer) GetOrder(ctx context.Context, id int64) (*Order, error) {
 
 func (ms *MySQLStorer) ListOrders(ctx context.Context) ([]Order, error) {
 	var orders []Order
-	err := ms.db.SelectContext(ctx, &orders, "SELECT * FROM orders")
+	err := ms.db.SelectContext(ctx, &orders, "SELECT * FROM orders WHERE tenant_id=?", TenantID(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("error listing orders: %w", err)
 	}
@@ -153,14 +263,42 @@ func (ms *MySQLStorer) ListOrders(ctx context.Context) ([]Order, error) {
 	return orders, nil
 }
 
+// UpdateOrderStatus records an order status event unless the order already
+// has status.
+func (ms *MySQLStorer) UpdateOrderStatus(ctx context.Context, id int64, status OrderStatus) (*Order, error) {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var current OrderStatus
+		err := tx.GetContext(ctx, &current, "SELECT status FROM orders WHERE id=? AND tenant_id=? FOR UPDATE", id, TenantID(ctx))
+		if err != nil {
+			return fmt.Errorf("error getting order status: %w", err)
+		}
+
+		if current == status {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE orders SET status=? WHERE id=? AND tenant_id=?", status, id, TenantID(ctx))
+		if err != nil {
+			return err
+		}
+
+		return createEvent(ctx, tx, &Event{TenantID: TenantID(ctx), Kind: EventOrderStatus, EntityID: id, Status: status})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating order status: %w", err)
+	}
+
+	return ms.GetOrder(ctx, id)
+}
+
 func (ms *MySQLStorer) DeleteOrder(ctx context.Context, id int64) error {
 	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
-		_, err := tx.ExecContext(ctx, "DELETE FROM order_items WHERE order_id=?", id)
+		_, err := tx.ExecContext(ctx, "DELETE FROM order_items WHERE order_id IN (SELECT id FROM orders WHERE id=? AND tenant_id=?)", id, TenantID(ctx))
 		if err != nil {
 			return fmt.Errorf("error deleting order items: %w", err)
 		}
 
-		_, err = tx.ExecContext(ctx, "DELETE FROM orders WHERE id=?", id)
+		_, err = tx.ExecContext(ctx, "DELETE FROM orders WHERE id=? AND tenant_id=?", id, TenantID(ctx))
 		if err != nil {
 			return fmt.Errorf("error deleting order: %w", err)
 		}
@@ -175,7 +313,7 @@ func (ms *MySQLStorer) DeleteOrder(ctx context.Context, id int64) error {
 }
 
 func createOrder(ctx context.Context, tx *sqlx.Tx, o *Order) (*Order, error) {
-	res, err := tx.NamedExecContext(ctx, "INSERT INTO orders (payment_method, tax_price, shipping_price, total_price) VALUES (:payment_method, :tax_price, :shipping_price, :total_price)", o)
+	res, err := tx.NamedExecContext(ctx, "INSERT INTO orders (tenant_id, user_id, payment_method, tax_price, shipping_price, total_price) VALUES (:tenant_id, :user_id, :payment_method, :tax_price, :shipping_price, :total_price)", o)
 	if err != nil {
 		return nil, fmt.Errorf("error inserting order: %w", err)
 	}
@@ -189,7 +327,20 @@ func createOrder(ctx context.Context, tx *sqlx.Tx, o *Order) (*Order, error) {
 	return o, nil
 }
 
+// ErrUnknownProduct is returned when an order item refers to a product that
+// does not exist in the tenant of the order.
+var ErrUnknownProduct = errors.New("unknown product")
+
 func createOrderItem(ctx context.Context, tx *sqlx.Tx, oi OrderItem) error {
+	var productID int64
+	err := tx.GetContext(ctx, &productID, "SELECT id FROM products WHERE id=? AND tenant_id=?", oi.ProductID, TenantID(ctx))
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("product %d: %w", oi.ProductID, ErrUnknownProduct)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting product: %w", err)
+	}
+
 	res, err := tx.NamedExecContext(ctx, "INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (:name, :quantity, :image, :price, :product_id, :order_id)", oi)
 	if err != nil {
 		return fmt.Errorf("error inserting order item: %w", err)
@@ -205,7 +356,8 @@ func createOrderItem(ctx context.Context, tx *sqlx.Tx, oi OrderItem) error {
 }
 
 func (ms *MySQLStorer) CreateUser(ctx context.Context, u *User) (*User, error) {
-	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO users (name, email, password, is_admin) VALUES (:name, :email, :passowrd, :is_admin)", u)
+	u.TenantID = TenantID(ctx)
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO users (tenant_id, name, email, password, is_admin, email_verified) VALUES (:tenant_id, :name, :email, :password, :is_admin, :email_verified)", u)
 	if err != nil {
 		return nil, fmt.Errorf("Error inserting user %w", err)
 	}
@@ -221,7 +373,17 @@ func (ms *MySQLStorer) CreateUser(ctx context.Context, u *User) (*User, error) {
 
 func (ms *MySQLStorer) GetUser(ctx context.Context, email string) (*User, error) {
 	var u User
-	err := ms.db.GetContext(ctx, &u, "SELECT * FROM users WHERE email=?", email)
+	err := ms.db.GetContext(ctx, &u, "SELECT * FROM users WHERE email=? AND tenant_id=?", email, TenantID(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+
+	return &u, nil
+}
+
+func (ms *MySQLStorer) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	var u User
+	err := ms.db.GetContext(ctx, &u, "SELECT * FROM users WHERE id=? AND tenant_id=?", id, TenantID(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("error getting user: %w", err)
 	}
@@ -231,7 +393,7 @@ func (ms *MySQLStorer) GetUser(ctx context.Context, email string) (*User, error)
 
 func (ms *MySQLStorer) ListUsers(ctx context.Context) ([]User, error) {
 	var users []User
-	err := ms.db.SelectContext(ctx, &users, "SELECT * FROM users")
+	err := ms.db.SelectContext(ctx, &users, "SELECT * FROM users WHERE tenant_id=?", TenantID(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("error listing users: %w", err)
 	}
@@ -240,7 +402,8 @@ func (ms *MySQLStorer) ListUsers(ctx context.Context) ([]User, error) {
 }
 
 func (ms *MySQLStorer) UpdateUser(ctx context.Context, u *User) (*User, error) {
-	_, err := ms.db.NamedExecContext(ctx, "UPDATE users SET name=:name, email=:email, password=:password, is_admin=:is_admin, updated_at=:updated_at WHERE id=:id", u)
+	u.TenantID = TenantID(ctx)
+	_, err := ms.db.NamedExecContext(ctx, "UPDATE users SET name=:name, email=:email, password=:password, is_admin=:is_admin, email_verified=:email_verified, totp_secret=:totp_secret, totp_enabled=:totp_enabled WHERE id=:id AND tenant_id=:tenant_id", u)
 	if err != nil {
 		return nil, fmt.Errorf("error updating user: %w", err)
 	}
@@ -249,7 +412,7 @@ func (ms *MySQLStorer) UpdateUser(ctx context.Context, u *User) (*User, error) {
 }
 
 func (ms *MySQLStorer) DeleteUser(ctx context.Context, id int64) error {
-	_, err := ms.db.ExecContext(ctx, "DELETE FROM users WHERE id=?", id)
+	_, err := ms.db.ExecContext(ctx, "DELETE FROM users WHERE id=? AND tenant_id=?", id, TenantID(ctx))
 	if err != nil {
 		return fmt.Errorf("error deleting user: %w", err)
 	}
@@ -257,8 +420,77 @@ func (ms *MySQLStorer) DeleteUser(ctx context.Context, id int64) error {
 	return nil
 }
 
+// RecordFailedLogin increments the failed login counter of a user and
+// returns its new value.
+func (ms *MySQLStorer) RecordFailedLogin(ctx context.Context, id int64) (int, error) {
+	var failures int
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET failed_logins=failed_logins+1 WHERE id=? AND tenant_id=?", id, TenantID(ctx))
+		if err != nil {
+			return fmt.Errorf("error updating user: %w", err)
+		}
+
+		err = tx.GetContext(ctx, &failures, "SELECT failed_logins FROM users WHERE id=? AND tenant_id=?", id, TenantID(ctx))
+		if err != nil {
+			return fmt.Errorf("error getting failed logins: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error recording failed login: %w", err)
+	}
+
+	return failures, nil
+}
+
+func (ms *MySQLStorer) LockUser(ctx context.Context, id int64, until time.Time) error {
+	_, err := ms.db.ExecContext(ctx, "UPDATE users SET locked_until=? WHERE id=? AND tenant_id=?", until, id, TenantID(ctx))
+	if err != nil {
+		return fmt.Errorf("error locking user: %w", err)
+	}
+
+	return nil
+}
+
+// UnlockUser lifts a lockout and resets the failed login counter.
+func (ms *MySQLStorer) UnlockUser(ctx context.Context, id int64) error {
+	_, err := ms.db.ExecContext(ctx, "UPDATE users SET failed_logins=0, locked_until=NULL WHERE id=? AND tenant_id=?", id, TenantID(ctx))
+	if err != nil {
+		return fmt.Errorf("error unlocking user: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) CreateLoginRecord(ctx context.Context, l *LoginRecord) (*LoginRecord, error) {
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO login_history (user_id, client_ip, user_agent) VALUES (:user_id, :client_ip, :user_agent)", l)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting login record: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	l.ID = id
+
+	return l, nil
+}
+
+// ListLoginHistory returns the most recent logins of a user, newest first.
+func (ms *MySQLStorer) ListLoginHistory(ctx context.Context, userID int64, limit int) ([]LoginRecord, error) {
+	var logins []LoginRecord
+	err := ms.db.SelectContext(ctx, &logins, "SELECT * FROM login_history WHERE user_id=? ORDER BY id DESC LIMIT ?", userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing login history: %w", err)
+	}
+
+	return logins, nil
+}
+
 func (ms *MySQLStorer) CreateSession(ctx context.Context, s *Session) (*Session, error) {
-	_, err := ms.db.NamedExecContext(ctx, "INSERT INTO sessions (id, user_email, refresh_token, is_revoked, expires_at) VALUES (:id, :user_email, :refresh_token, :is_revoked, :expires_at)", s)
+	s.TenantID = TenantID(ctx)
+	_, err := ms.db.NamedExecContext(ctx, "INSERT INTO sessions (id, tenant_id, family_id, user_email, refresh_token, user_agent, client_ip, is_revoked, expires_at, access_token_id, access_expires_at) VALUES (:id, :tenant_id, :family_id, :user_email, :refresh_token, :user_agent, :client_ip, :is_revoked, :expires_at, :access_token_id, :access_expires_at)", s)
 	if err != nil {
 		return nil, fmt.Errorf("error inserting session: %w", err)
 	}
@@ -268,7 +500,7 @@ func (ms *MySQLStorer) CreateSession(ctx context.Context, s *Session) (*Session,
 
 func (ms *MySQLStorer) GetSession(ctx context.Context, id string) (*Session, error) {
 	var s Session
-	err := ms.db.GetContext(ctx, &s, "SELECT * FROM sessions WHERE id=?", id)
+	err := ms.db.GetContext(ctx, &s, "SELECT * FROM sessions WHERE id=? AND tenant_id=?", id, TenantID(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("error getting session: %w", err)
 	}
@@ -276,8 +508,32 @@ func (ms *MySQLStorer) GetSession(ctx context.Context, id string) (*Session, err
 	return &s, nil
 }
 
+// ListSessions returns the sessions of a user that can still be refreshed,
+// most recently used first.
+func (ms *MySQLStorer) ListSessions(ctx context.Context, email string) ([]Session, error) {
+	var sessions []Session
+	err := ms.db.SelectContext(ctx, &sessions, "SELECT * FROM sessions WHERE user_email=? AND tenant_id=? AND replaced_by IS NULL AND is_revoked=0 AND expires_at > NOW() ORDER BY last_used_at DESC", email, TenantID(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// ListLiveAccessTokens returns the sessions of a user whose access token
+// has not expired yet, including rotated and revoked ones.
+func (ms *MySQLStorer) ListLiveAccessTokens(ctx context.Context, email string) ([]Session, error) {
+	var sessions []Session
+	err := ms.db.SelectContext(ctx, &sessions, "SELECT * FROM sessions WHERE user_email=? AND tenant_id=? AND access_expires_at > NOW()", email, TenantID(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error listing access tokens: %w", err)
+	}
+
+	return sessions, nil
+}
+
 func (ms *MySQLStorer) RevokeSession(ctx context.Context, id string) error {
-	_, err := ms.db.NamedExecContext(ctx, "UPDATE sessions SET is_revoked=1 WHERE id=:id", map[string]interface{}{"id": id})
+	_, err := ms.db.NamedExecContext(ctx, "UPDATE sessions SET is_revoked=1, revoked_at=COALESCE(revoked_at, NOW()) WHERE id=:id AND tenant_id=:tenant_id", map[string]interface{}{"id": id, "tenant_id": TenantID(ctx)})
 	if err != nil {
 		return fmt.Errorf("error revoking session: %w", err)
 	}
@@ -285,11 +541,526 @@ func (ms *MySQLStorer) RevokeSession(ctx context.Context, id string) error {
 	return nil
 }
 
+var ErrSessionRotated = errors.New("session already rotated")
+
+// RotateSession retires the session with the given ID in favour of next,
+// which should carry over the original CreatedAt of the login. It fails with
+// ErrSessionRotated if the session was already retired or revoked, so that
+// concurrent refreshes cannot both succeed.
+func (ms *MySQLStorer) RotateSession(ctx context.Context, id string, next *Session) (*Session, error) {
+	next.TenantID = TenantID(ctx)
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE sessions SET replaced_by=? WHERE id=? AND tenant_id=? AND replaced_by IS NULL AND is_revoked=0", next.ID, id, next.TenantID)
+		if err != nil {
+			return fmt.Errorf("error retiring session: %w", err)
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error getting rows affected: %w", err)
+		}
+		if rows == 0 {
+			return ErrSessionRotated
+		}
+
+		_, err = tx.NamedExecContext(ctx, "INSERT INTO sessions (id, tenant_id, family_id, user_email, refresh_token, user_agent, client_ip, is_revoked, created_at, expires_at, access_token_id, access_expires_at) VALUES (:id, :tenant_id, :family_id, :user_email, :refresh_token, :user_agent, :client_ip, :is_revoked, :created_at, :expires_at, :access_token_id, :access_expires_at)", next)
+		if err != nil {
+			return fmt.Errorf("error inserting session: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error rotating session: %w", err)
+	}
+
+	return next, nil
+}
+
+func (ms *MySQLStorer) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	_, err := ms.db.ExecContext(ctx, "UPDATE sessions SET is_revoked=1, revoked_at=COALESCE(revoked_at, NOW()) WHERE family_id=? AND tenant_id=?", familyID, TenantID(ctx))
+	if err != nil {
+		return fmt.Errorf("error revoking session family: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) RevokeSessionsByEmail(ctx context.Context, email string) error {
+	_, err := ms.db.ExecContext(ctx, "UPDATE sessions SET is_revoked=1, revoked_at=COALESCE(revoked_at, NOW()) WHERE user_email=? AND tenant_id=?", email, TenantID(ctx))
+	if err != nil {
+		return fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredSessions deletes at most limit sessions that either expired
+// before expiredBefore or were revoked before revokedBefore. Revoked sessions
+// are kept past their expiry until revokedBefore for auditing. Unlike other
+// session queries it spans all tenants.
+func (ms *MySQLStorer) DeleteExpiredSessions(ctx context.Context, expiredBefore, revokedBefore time.Time, limit int) (int64, error) {
+	res, err := ms.db.ExecContext(ctx, "DELETE FROM sessions WHERE (is_revoked=0 AND expires_at < ?) OR (is_revoked=1 AND COALESCE(revoked_at, created_at) < ?) LIMIT ?", expiredBefore, revokedBefore, limit)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired sessions: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return n, nil
+}
+
 func (ms *MySQLStorer) DeleteSession(ctx context.Context, id string) error {
-	_, err := ms.db.ExecContext(ctx, "DELETE FROM sessions WHERE id=?", id)
+	_, err := ms.db.ExecContext(ctx, "DELETE FROM sessions WHERE id=? AND tenant_id=?", id, TenantID(ctx))
 	if err != nil {
 		return fmt.Errorf("error deleting session: %w", err)
 	}
 
 	return nil
 }
+
+func (ms *MySQLStorer) CreateSecurityEvent(ctx context.Context, e *SecurityEvent) (*SecurityEvent, error) {
+	e.TenantID = TenantID(ctx)
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO security_events (tenant_id, user_email, event_type, session_id, family_id) VALUES (:tenant_id, :user_email, :event_type, :session_id, :family_id)", e)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting security event: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	e.ID = id
+
+	return e, nil
+}
+
+var ErrIdempotencyKeyExists = errors.New("idempotency key already exists")
+
+func (ms *MySQLStorer) CreateIdempotencyKey(ctx context.Context, k *IdempotencyKey) (*IdempotencyKey, error) {
+	_, err := ms.db.NamedExecContext(ctx, "INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, expires_at) VALUES (:user_id, :idempotency_key, :request_hash, :expires_at)", k)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			return nil, ErrIdempotencyKeyExists
+		}
+		return nil, fmt.Errorf("error inserting idempotency key: %w", err)
+	}
+
+	return k, nil
+}
+
+func (ms *MySQLStorer) GetIdempotencyKey(ctx context.Context, userID int64, key string) (*IdempotencyKey, error) {
+	var k IdempotencyKey
+	err := ms.db.GetContext(ctx, &k, "SELECT * FROM idempotency_keys WHERE user_id=? AND idempotency_key=?", userID, key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting idempotency key: %w", err)
+	}
+
+	return &k, nil
+}
+
+func (ms *MySQLStorer) SaveIdempotencyResponse(ctx context.Context, k *IdempotencyKey) error {
+	_, err := ms.db.NamedExecContext(ctx, "UPDATE idempotency_keys SET response_code=:response_code, response_body=:response_body WHERE user_id=:user_id AND idempotency_key=:idempotency_key", k)
+	if err != nil {
+		return fmt.Errorf("error saving idempotency response: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) DeleteIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	_, err := ms.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id=? AND idempotency_key=?", userID, key)
+	if err != nil {
+		return fmt.Errorf("error deleting idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time, limit int) (int64, error) {
+	res, err := ms.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at < ? LIMIT ?", before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired idempotency keys: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return n, nil
+}
+
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+// CreateUserToken stores t and discards any unused token the user already
+// has for the same purpose, so that only the newest one works.
+func (ms *MySQLStorer) CreateUserToken(ctx context.Context, t *UserToken) (*UserToken, error) {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM user_tokens WHERE user_id=? AND purpose=? AND used_at IS NULL", t.UserID, t.Purpose)
+		if err != nil {
+			return fmt.Errorf("error deleting user tokens: %w", err)
+		}
+
+		_, err = tx.NamedExecContext(ctx, "INSERT INTO user_tokens (token_hash, user_id, purpose, expires_at) VALUES (:token_hash, :user_id, :purpose, :expires_at)", t)
+		if err != nil {
+			return fmt.Errorf("error inserting user token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating user token: %w", err)
+	}
+
+	return t, nil
+}
+
+// ConsumeUserToken marks the token with the given hash as used. It fails with
+// ErrInvalidUserToken if the token does not exist, was issued for another
+// purpose, has expired or was already used.
+func (ms *MySQLStorer) ConsumeUserToken(ctx context.Context, tokenHash, purpose string) (*UserToken, error) {
+	var t *UserToken
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		t, err = consumeUserToken(ctx, tx, tokenHash, purpose)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error consuming user token: %w", err)
+	}
+
+	return t, nil
+}
+
+// ResetPassword consumes the password reset token with the given hash and
+// sets the password of its user in the same transaction, so a failed update
+// leaves the token usable. It fails like ConsumeUserToken.
+func (ms *MySQLStorer) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (*UserToken, error) {
+	var t *UserToken
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		t, err = consumeUserToken(ctx, tx, tokenHash, UserTokenPasswordReset)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET password=? WHERE id=? AND tenant_id=?", passwordHash, t.UserID, TenantID(ctx))
+		if err != nil {
+			return fmt.Errorf("error updating user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error resetting password: %w", err)
+	}
+
+	return t, nil
+}
+
+func consumeUserToken(ctx context.Context, tx *sqlx.Tx, tokenHash, purpose string) (*UserToken, error) {
+	var t UserToken
+	err := tx.GetContext(ctx, &t, "SELECT user_tokens.* FROM user_tokens JOIN users ON users.id=user_tokens.user_id WHERE user_tokens.token_hash=? AND user_tokens.purpose=? AND users.tenant_id=? FOR UPDATE", tokenHash, purpose, TenantID(ctx))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidUserToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting user token: %w", err)
+	}
+
+	now := time.Now()
+	if t.UsedAt != nil || !t.ExpiresAt.After(now) {
+		return nil, ErrInvalidUserToken
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE user_tokens SET used_at=? WHERE token_hash=?", now, tokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("error updating user token: %w", err)
+	}
+	t.UsedAt = &now
+	return &t, nil
+}
+
+// CreateNotification enqueues n as a job for notification-svc. The ID of a
+// notification is that of its job.
+func (ms *MySQLStorer) CreateNotification(ctx context.Context, n *Notification) (*Notification, error) {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding notification: %w", err)
+	}
+
+	res, err := ms.db.ExecContext(ctx, "INSERT INTO jobs (tenant_id, kind, payload) VALUES (?, ?, ?)", TenantID(ctx), JobKindNotification, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting notification: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	n.ID = id
+
+	return n, nil
+}
+
+var ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+
+// ReplaceRecoveryCodes discards every recovery code of the user, used or
+// not, and stores the given hashes instead.
+func (ms *MySQLStorer) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string) error {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id=?", userID)
+		if err != nil {
+			return fmt.Errorf("error deleting recovery codes: %w", err)
+		}
+
+		for _, hash := range hashes {
+			_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash)
+			if err != nil {
+				return fmt.Errorf("error inserting recovery code: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error replacing recovery codes: %w", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used, or fails with
+// ErrInvalidRecoveryCode.
+func (ms *MySQLStorer) UseRecoveryCode(ctx context.Context, userID int64, hash string) error {
+	res, err := ms.db.ExecContext(ctx, "UPDATE recovery_codes SET used_at=NOW() WHERE user_id=? AND code_hash=? AND used_at IS NULL", userID, hash)
+	if err != nil {
+		return fmt.Errorf("error using recovery code: %w", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrInvalidRecoveryCode
+	}
+
+	return nil
+}
+
+var ErrTOTPStepUsed = errors.New("TOTP code already used")
+
+// UseTOTPStep records step as the last accepted TOTP time step of the user,
+// or fails with ErrTOTPStepUsed if a code for that step or a later one has
+// already been accepted.
+func (ms *MySQLStorer) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	res, err := ms.db.ExecContext(ctx, "UPDATE users SET totp_last_step=? WHERE id=? AND tenant_id=? AND totp_last_step<?", step, userID, TenantID(ctx), step)
+	if err != nil {
+		return fmt.Errorf("error using TOTP step: %w", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrTOTPStepUsed
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) CreateAPIKey(ctx context.Context, k *APIKey) (*APIKey, error) {
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) VALUES (:user_id, :name, :prefix, :key_hash, :scopes, :expires_at)", k)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting api key: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	k.ID = id
+
+	return k, nil
+}
+
+func (ms *MySQLStorer) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	var k APIKey
+	err := ms.db.GetContext(ctx, &k, "SELECT * FROM api_keys WHERE prefix=?", prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error getting api key: %w", err)
+	}
+
+	return &k, nil
+}
+
+// ListAPIKeys returns the keys of a user that have not been revoked, newest
+// first. Expired keys are included so that they can be told apart from
+// revoked ones.
+func (ms *MySQLStorer) ListAPIKeys(ctx context.Context, userID int64) ([]APIKey, error) {
+	var keys []APIKey
+	err := ms.db.SelectContext(ctx, &keys, "SELECT * FROM api_keys WHERE user_id=? AND revoked_at IS NULL ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes a key of the given user. Keys of other users are
+// reported as missing.
+func (ms *MySQLStorer) RevokeAPIKey(ctx context.Context, userID, id int64) error {
+	res, err := ms.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at=NOW() WHERE id=? AND user_id=? AND revoked_at IS NULL", id, userID)
+	if err != nil {
+		return fmt.Errorf("error revoking api key: %w", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("error revoking api key: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// TouchSession records the use of the access token with the given ID on its
+// session, unless the session was already used since staleBefore.
+func (ms *MySQLStorer) TouchSession(ctx context.Context, accessTokenID string, staleBefore time.Time) error {
+	_, err := ms.db.ExecContext(ctx, "UPDATE sessions SET last_used_at=NOW() WHERE access_token_id=? AND tenant_id=? AND last_used_at<?", accessTokenID, TenantID(ctx), staleBefore)
+	if err != nil {
+		return fmt.Errorf("error updating session: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) TouchAPIKey(ctx context.Context, id int64) error {
+	_, err := ms.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at=NOW() WHERE id=?", id)
+	if err != nil {
+		return fmt.Errorf("error updating api key: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) DenyToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := ms.db.ExecContext(ctx, "INSERT INTO denied_tokens (jti, expires_at) VALUES (?, ?) ON DUPLICATE KEY UPDATE expires_at=VALUES(expires_at)", jti, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error denying token: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	var denied bool
+	err := ms.db.GetContext(ctx, &denied, "SELECT EXISTS(SELECT 1 FROM denied_tokens WHERE jti=? AND expires_at > NOW())", jti)
+	if err != nil {
+		return false, fmt.Errorf("error checking denied token: %w", err)
+	}
+
+	return denied, nil
+}
+
+// DeleteExpiredDeniedTokens deletes at most limit entries of tokens that
+// expired before the given time and would be rejected anyway.
+func (ms *MySQLStorer) DeleteExpiredDeniedTokens(ctx context.Context, before time.Time, limit int) (int64, error) {
+	res, err := ms.db.ExecContext(ctx, "DELETE FROM denied_tokens WHERE expires_at < ? LIMIT ?", before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting denied tokens: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return n, nil
+}
+
+func createEvent(ctx context.Context, tx *sqlx.Tx, e *Event) error {
+	_, err := tx.NamedExecContext(ctx, "INSERT INTO events (tenant_id, kind, entity_id, status, count_in_stock) VALUES (:tenant_id, :kind, :entity_id, :status, :count_in_stock)", e)
+	if err != nil {
+		return fmt.Errorf("error inserting event: %w", err)
+	}
+
+	return nil
+}
+
+// ListEvents returns up to limit events of all tenants with an ID above
+// afterID, oldest first.
+func (ms *MySQLStorer) ListEvents(ctx context.Context, afterID int64, limit int) ([]Event, error) {
+	var events []Event
+	err := ms.db.SelectContext(ctx, &events, "SELECT * FROM events WHERE id > ? ORDER BY id LIMIT ?", afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing events: %w", err)
+	}
+
+	return events, nil
+}
+
+// GetFirstEventID returns the ID of the oldest event that was not purged, or 0
+// if there is none.
+func (ms *MySQLStorer) GetFirstEventID(ctx context.Context) (int64, error) {
+	var id int64
+	err := ms.db.GetContext(ctx, &id, "SELECT COALESCE(MIN(id), 0) FROM events")
+	if err != nil {
+		return 0, fmt.Errorf("error getting first event ID: %w", err)
+	}
+
+	return id, nil
+}
+
+func (ms *MySQLStorer) GetLastEventID(ctx context.Context) (int64, error) {
+	var id int64
+	err := ms.db.GetContext(ctx, &id, "SELECT COALESCE(MAX(id), 0) FROM events")
+	if err != nil {
+		return 0, fmt.Errorf("error getting last event ID: %w", err)
+	}
+
+	return id, nil
+}
+
+func (ms *MySQLStorer) DeleteEventsBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	res, err := ms.db.ExecContext(ctx, "DELETE FROM events WHERE created_at < ? LIMIT ?", before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting events: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return n, nil
+}
+
+// WithLock runs fn while holding the named MySQL advisory lock, so that only
+// one of several API instances runs it at a time. It returns false without
+// calling fn if another connection holds the lock.
+func (ms *MySQLStorer) WithLock(ctx context.Context, name string, fn func(context.Context) error) (bool, error) {
+	conn, err := ms.db.Connx(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error getting connection: %w", err)
+	}
+	defer conn.Close()
+
+	var acquired sql.NullInt64
+	err = conn.GetContext(ctx, &acquired, "SELECT GET_LOCK(?, 0)", name)
+	if err != nil {
+		return false, fmt.Errorf("error acquiring lock: %w", err)
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return false, nil
+	}
+
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name)
+
+	return true, fn(ctx)
+}
@@ -2,10 +2,13 @@ package storer
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 )
@@ -40,7 +43,7 @@ func TestCreateProduct(t *testing.T) {
 		{
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO products (tenant_id, name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
 				cp, err := st.CreateProduct(context.Background(), p)
 				require.NoError(t, err)
 				require.Equal(t, int64(1), cp.ID)
@@ -51,7 +54,7 @@ func TestCreateProduct(t *testing.T) {
 		{
 			name: "failed inserting product",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error inserting product"))
+				mock.ExpectExec("INSERT INTO products (tenant_id, name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error inserting product"))
 				_, err := st.CreateProduct(context.Background(), p)
 				require.Error(t, err)
 				err = mock.ExpectationsWereMet()
@@ -61,7 +64,7 @@ func TestCreateProduct(t *testing.T) {
 		{
 			name: "failed getting last insert ID",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("error getting last insert ID")))
+				mock.ExpectExec("INSERT INTO products (tenant_id, name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("error getting last insert ID")))
 				_, err := st.CreateProduct(context.Background(), p)
 				require.Error(t, err)
 				err = mock.ExpectationsWereMet()
@@ -102,7 +105,7 @@ func TestGetProduct(t *testing.T) {
 				rows := sqlmock.NewRows([]string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}).
 					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price, p.CountInStock, p.CreatedAt, p.UpdatedAt)
 
-				mock.ExpectQuery("SELECT * FROM products WHERE id=?").WithArgs(1).WillReturnRows(rows)
+				mock.ExpectQuery("SELECT * FROM products WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnRows(rows)
 
 				gp, err := st.GetProduct(context.Background(), 1)
 				require.NoError(t, err)
@@ -115,7 +118,7 @@ func TestGetProduct(t *testing.T) {
 		{
 			name: "failed getting product",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT * FROM products WHERE id=?").WithArgs(1).WillReturnError(fmt.Errorf("error getting product"))
+				mock.ExpectQuery("SELECT * FROM products WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnError(fmt.Errorf("error getting product"))
 
 				_, err := st.GetProduct(context.Background(), 1)
 				require.Error(t, err)
@@ -157,7 +160,7 @@ func TestListProducts(t *testing.T) {
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				rows := sqlmock.NewRows([]string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}).
 					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price, p.CountInStock, p.CreatedAt, p.UpdatedAt)
-				mock.ExpectQuery("SELECT * FROM products").WillReturnRows(rows)
+				mock.ExpectQuery("SELECT * FROM products WHERE tenant_id=?").WithArgs(DefaultTenantID).WillReturnRows(rows)
 
 				products, err := st.ListProducts(context.Background())
 				require.NoError(t, err)
@@ -170,7 +173,7 @@ func TestListProducts(t *testing.T) {
 		{
 			name: "failed querying products",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT * FROM products").WillReturnError(fmt.Errorf("error querying products"))
+				mock.ExpectQuery("SELECT * FROM products WHERE tenant_id=?").WithArgs(DefaultTenantID).WillReturnError(fmt.Errorf("error querying products"))
 
 				_, err := st.ListProducts(context.Background())
 				require.Error(t, err)
@@ -221,18 +224,77 @@ func TestUpdateProduct(t *testing.T) {
 		{
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").
+				mock.ExpectExec("INSERT INTO products (tenant_id, name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)").
 					WillReturnResult(sqlmock.NewResult(1, 1))
 				cp, err := st.CreateProduct(context.Background(), p)
 				require.NoError(t, err)
 				require.Equal(t, int64(1), cp.ID)
 
-				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, count_in_stock=?, updated_at=? WHERE id=?").
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT count_in_stock, version FROM products WHERE id=? AND tenant_id=? FOR UPDATE").WithArgs(1, DefaultTenantID).
+					WillReturnRows(sqlmock.NewRows([]string{"count_in_stock", "version"}).AddRow(10, 1))
+				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, count_in_stock=?, updated_at=?, version=version+1 WHERE id=? AND tenant_id=?").
 					WillReturnResult(sqlmock.NewResult(1, 1))
-				up, err := st.UpdateProduct(context.Background(), np)
+				mock.ExpectCommit()
+				up, err := st.UpdateProduct(context.Background(), np, 0)
 				require.NoError(t, err)
 				require.Equal(t, int64(1), up.ID)
 				require.Equal(t, np.Name, up.Name)
+				require.Equal(t, int64(2), up.Version)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "stock change records event",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT count_in_stock, version FROM products WHERE id=? AND tenant_id=? FOR UPDATE").WithArgs(1, DefaultTenantID).
+					WillReturnRows(sqlmock.NewRows([]string{"count_in_stock", "version"}).AddRow(12, 1))
+				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, count_in_stock=?, updated_at=?, version=version+1 WHERE id=? AND tenant_id=?").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO events (tenant_id, kind, entity_id, status, count_in_stock) VALUES (?, ?, ?, ?, ?)").
+					WithArgs(DefaultTenantID, EventInventory, 1, OrderStatus(""), 10).WillReturnResult(sqlmock.NewResult(7, 1))
+				mock.ExpectCommit()
+				_, err := st.UpdateProduct(context.Background(), np, 0)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "truncates updated_at to seconds",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 700_000_000, time.UTC)
+				up := *np
+				up.UpdatedAt = &updatedAt
+
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT count_in_stock, version FROM products WHERE id=? AND tenant_id=? FOR UPDATE").WithArgs(1, DefaultTenantID).
+					WillReturnRows(sqlmock.NewRows([]string{"count_in_stock", "version"}).AddRow(10, 1))
+				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, count_in_stock=?, updated_at=?, version=version+1 WHERE id=? AND tenant_id=?").
+					WithArgs(np.Name, np.Image, np.Category, np.Description, np.Rating, np.NumReviews, np.Price, np.CountInStock, updatedAt.Truncate(time.Second), np.ID, DefaultTenantID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+				got, err := st.UpdateProduct(context.Background(), &up, 0)
+				require.NoError(t, err)
+				require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), *got.UpdatedAt)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "modified since version",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT count_in_stock, version FROM products WHERE id=? AND tenant_id=? FOR UPDATE").WithArgs(1, DefaultTenantID).
+					WillReturnRows(sqlmock.NewRows([]string{"count_in_stock", "version"}).AddRow(10, 3))
+				mock.ExpectRollback()
+				_, err := st.UpdateProduct(context.Background(), np, 2)
+				require.ErrorIs(t, err, ErrProductModified)
 
 				err = mock.ExpectationsWereMet()
 				require.NoError(t, err)
@@ -241,9 +303,13 @@ func TestUpdateProduct(t *testing.T) {
 		{
 			name: "failed updating product",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, count_in_stock=?, updated_at=? WHERE id=?").
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT count_in_stock, version FROM products WHERE id=? AND tenant_id=? FOR UPDATE").WithArgs(1, DefaultTenantID).
+					WillReturnRows(sqlmock.NewRows([]string{"count_in_stock", "version"}).AddRow(10, 1))
+				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, count_in_stock=?, updated_at=?, version=version+1 WHERE id=? AND tenant_id=?").
 					WillReturnError(fmt.Errorf("error updating product"))
-				_, err := st.UpdateProduct(context.Background(), p)
+				mock.ExpectRollback()
+				_, err := st.UpdateProduct(context.Background(), p, 0)
 				require.Error(t, err)
 
 				err = mock.ExpectationsWereMet()
@@ -268,9 +334,38 @@ func TestDeleteProduct(t *testing.T) {
 		{
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("DELETE FROM products WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
-				err := st.DeleteProduct(context.Background(), 1)
+				mock.ExpectExec("DELETE FROM products WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnResult(sqlmock.NewResult(1, 1))
+				err := st.DeleteProduct(context.Background(), 1, 0)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "at version",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT count_in_stock, version FROM products WHERE id=? AND tenant_id=? FOR UPDATE").WithArgs(1, DefaultTenantID).
+					WillReturnRows(sqlmock.NewRows([]string{"count_in_stock", "version"}).AddRow(10, 2))
+				mock.ExpectExec("DELETE FROM products WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+				err := st.DeleteProduct(context.Background(), 1, 2)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
 				require.NoError(t, err)
+			},
+		},
+		{
+			name: "modified since version",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT count_in_stock, version FROM products WHERE id=? AND tenant_id=? FOR UPDATE").WithArgs(1, DefaultTenantID).
+					WillReturnRows(sqlmock.NewRows([]string{"count_in_stock", "version"}).AddRow(10, 3))
+				mock.ExpectRollback()
+				err := st.DeleteProduct(context.Background(), 1, 2)
+				require.ErrorIs(t, err, ErrProductModified)
 
 				err = mock.ExpectationsWereMet()
 				require.NoError(t, err)
@@ -279,8 +374,8 @@ func TestDeleteProduct(t *testing.T) {
 		{
 			name: "failed deleting product",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("DELETE FROM products WHERE id=?").WithArgs(1).WillReturnError(fmt.Errorf("error deleting product"))
-				err := st.DeleteProduct(context.Background(), 1)
+				mock.ExpectExec("DELETE FROM products WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnError(fmt.Errorf("error deleting product"))
+				err := st.DeleteProduct(context.Background(), 1, 0)
 				require.Error(t, err)
 
 				err = mock.ExpectationsWereMet()
@@ -320,6 +415,7 @@ func TestCreateOrder(t *testing.T) {
 		TaxPrice:      10.0,
 		ShippingPrice: 20.0,
 		TotalPrice:    129.99,
+		UserID:        3,
 		Items:         ois,
 	}
 
@@ -331,8 +427,10 @@ func TestCreateOrder(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO orders (tenant_id, user_id, payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?, ?, ?)").WithArgs(DefaultTenantID, 3, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("SELECT id FROM products WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("SELECT id FROM products WHERE id=? AND tenant_id=?").WithArgs(2, DefaultTenantID).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(2, 1))
 				mock.ExpectCommit()
 
@@ -348,7 +446,7 @@ func TestCreateOrder(t *testing.T) {
 			name: "failed creating order",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?)").WillReturnError(fmt.Errorf("error creating order"))
+				mock.ExpectExec("INSERT INTO orders (tenant_id, user_id, payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error creating order"))
 				mock.ExpectRollback()
 
 				_, err := st.CreateOrder(context.Background(), o)
@@ -358,11 +456,27 @@ func TestCreateOrder(t *testing.T) {
 				require.NoError(t, err)
 			},
 		},
+		{
+			name: "product of another tenant",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO orders (tenant_id, user_id, payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("SELECT id FROM products WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+
+				_, err := st.CreateOrder(context.Background(), o)
+				require.ErrorIs(t, err, ErrUnknownProduct)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
 		{
 			name: "failed creating order item",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO orders (tenant_id, user_id, payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("SELECT id FROM products WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error creating order item"))
 				mock.ExpectRollback()
 
@@ -419,7 +533,7 @@ func TestGetOrder(t *testing.T) {
 				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
 					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
 
-				mock.ExpectQuery("SELECT * FROM orders WHERE id=?").WithArgs(1).WillReturnRows(orows)
+				mock.ExpectQuery("SELECT * FROM orders WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnRows(orows)
 
 				oirows := sqlmock.NewRows([]string{"id", "name", "quantity", "image", "price", "product_id", "order_id"}).
 					AddRow(1, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price, ois[0].ProductID, 1).
@@ -446,7 +560,7 @@ func TestGetOrder(t *testing.T) {
 		{
 			name: "failed getting order",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT * FROM orders WHERE id=?").WithArgs(1).WillReturnError(fmt.Errorf("error getting order"))
+				mock.ExpectQuery("SELECT * FROM orders WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnError(fmt.Errorf("error getting order"))
 
 				_, err := st.GetOrder(context.Background(), 1)
 				require.Error(t, err)
@@ -461,7 +575,7 @@ func TestGetOrder(t *testing.T) {
 				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
 					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
 
-				mock.ExpectQuery("SELECT * FROM orders WHERE id=?").WithArgs(1).WillReturnRows(orows)
+				mock.ExpectQuery("SELECT * FROM orders WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnRows(orows)
 
 				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).WillReturnError(fmt.Errorf("error getting order items"))
 
@@ -476,8 +590,10 @@ func TestGetOrder(t *testing.T) {
 			name: "failed committing transaction",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO orders (tenant_id, user_id, payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("SELECT id FROM products WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("SELECT id FROM products WHERE id=? AND tenant_id=?").WithArgs(2, DefaultTenantID).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(2, 1))
 				mock.ExpectCommit().WillReturnError(fmt.Errorf("error committing transaction"))
 
@@ -534,7 +650,7 @@ func TestListOrders(t *testing.T) {
 				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
 					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
 
-				mock.ExpectQuery("SELECT * FROM orders").WillReturnRows(orows)
+				mock.ExpectQuery("SELECT * FROM orders WHERE tenant_id=?").WithArgs(DefaultTenantID).WillReturnRows(orows)
 
 				oirows := sqlmock.NewRows([]string{"id", "name", "quantity", "image", "price", "product_id", "order_id"}).
 					AddRow(1, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price, ois[0].ProductID, 1).
@@ -553,7 +669,7 @@ func TestListOrders(t *testing.T) {
 		{
 			name: "failed querying orders",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT * FROM orders").WillReturnError(fmt.Errorf("error querying orders"))
+				mock.ExpectQuery("SELECT * FROM orders WHERE tenant_id=?").WithArgs(DefaultTenantID).WillReturnError(fmt.Errorf("error querying orders"))
 
 				_, err := st.ListOrders(context.Background())
 				require.Error(t, err)
@@ -568,7 +684,7 @@ func TestListOrders(t *testing.T) {
 				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
 					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
 
-				mock.ExpectQuery("SELECT * FROM orders").WillReturnRows(orows)
+				mock.ExpectQuery("SELECT * FROM orders WHERE tenant_id=?").WithArgs(DefaultTenantID).WillReturnRows(orows)
 
 				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).WillReturnError(fmt.Errorf("error querying order items"))
 
@@ -598,8 +714,8 @@ func TestDeleteOrder(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectExec("DELETE FROM orders WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM order_items WHERE order_id IN (SELECT id FROM orders WHERE id=? AND tenant_id=?)").WithArgs(1, DefaultTenantID).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM orders WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 
 				err := st.DeleteOrder(context.Background(), 1)
@@ -613,7 +729,7 @@ func TestDeleteOrder(t *testing.T) {
 			name: "failed deleting order item",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnError(fmt.Errorf("error deleting order item"))
+				mock.ExpectExec("DELETE FROM order_items WHERE order_id IN (SELECT id FROM orders WHERE id=? AND tenant_id=?)").WithArgs(1, DefaultTenantID).WillReturnError(fmt.Errorf("error deleting order item"))
 				mock.ExpectRollback()
 
 				err := st.DeleteOrder(context.Background(), 1)
@@ -627,8 +743,8 @@ func TestDeleteOrder(t *testing.T) {
 			name: "failed deleting order",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectExec("DELETE FROM orders WHERE id=?").WithArgs(1).WillReturnError(fmt.Errorf("error deleting order"))
+				mock.ExpectExec("DELETE FROM order_items WHERE order_id IN (SELECT id FROM orders WHERE id=? AND tenant_id=?)").WithArgs(1, DefaultTenantID).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM orders WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnError(fmt.Errorf("error deleting order"))
 				mock.ExpectRollback()
 
 				err := st.DeleteOrder(context.Background(), 1)
@@ -647,3 +763,1033 @@ func TestDeleteOrder(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateUser(t *testing.T) {
+	u := &User{
+		Name:     "test user",
+		Email:    "test@example.com",
+		Password: "hashed password",
+		IsAdmin:  false,
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO users (tenant_id, name, email, password, is_admin, email_verified) VALUES (?, ?, ?, ?, ?, ?)").
+					WithArgs(DefaultTenantID, u.Name, u.Email, u.Password, u.IsAdmin, u.EmailVerified).WillReturnResult(sqlmock.NewResult(1, 1))
+
+				cu, err := st.CreateUser(context.Background(), u)
+				require.NoError(t, err)
+				require.Equal(t, int64(1), cu.ID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed inserting user",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO users (tenant_id, name, email, password, is_admin, email_verified) VALUES (?, ?, ?, ?, ?, ?)").
+					WillReturnError(fmt.Errorf("error inserting user"))
+
+				_, err := st.CreateUser(context.Background(), u)
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestGetUserByID(t *testing.T) {
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "name", "email", "password", "is_admin"}).
+					AddRow(1, "test user", "test@example.com", "hashed password", true)
+				mock.ExpectQuery("SELECT * FROM users WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnRows(rows)
+
+				u, err := st.GetUserByID(context.Background(), 1)
+				require.NoError(t, err)
+				require.Equal(t, "test@example.com", u.Email)
+				require.True(t, u.IsAdmin)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed getting user",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT * FROM users WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnError(fmt.Errorf("error getting user"))
+
+				_, err := st.GetUserByID(context.Background(), 1)
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	u := &User{
+		ID:       1,
+		Name:     "new name",
+		Email:    "test@example.com",
+		Password: "hashed password",
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE users SET name=?, email=?, password=?, is_admin=?, email_verified=?, totp_secret=?, totp_enabled=? WHERE id=? AND tenant_id=?").
+					WithArgs(u.Name, u.Email, u.Password, u.IsAdmin, u.EmailVerified, u.TOTPSecret, u.TOTPEnabled, u.ID, DefaultTenantID).WillReturnResult(sqlmock.NewResult(1, 1))
+
+				uu, err := st.UpdateUser(context.Background(), u)
+				require.NoError(t, err)
+				require.Equal(t, u.Name, uu.Name)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed updating user",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE users SET name=?, email=?, password=?, is_admin=?, email_verified=?, totp_secret=?, totp_enabled=? WHERE id=? AND tenant_id=?").
+					WillReturnError(fmt.Errorf("error updating user"))
+
+				_, err := st.UpdateUser(context.Background(), u)
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestCreateIdempotencyKey(t *testing.T) {
+	k := &IdempotencyKey{
+		UserID:      1,
+		Key:         "test-key",
+		RequestHash: "test-hash",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, expires_at) VALUES (?, ?, ?, ?)").
+					WithArgs(k.UserID, k.Key, k.RequestHash, k.ExpiresAt).WillReturnResult(sqlmock.NewResult(0, 1))
+
+				ck, err := st.CreateIdempotencyKey(context.Background(), k)
+				require.NoError(t, err)
+				require.Equal(t, k.Key, ck.Key)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "duplicate key",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, expires_at) VALUES (?, ?, ?, ?)").
+					WillReturnError(&mysql.MySQLError{Number: mysqlErrDuplicateEntry})
+
+				_, err := st.CreateIdempotencyKey(context.Background(), k)
+				require.ErrorIs(t, err, ErrIdempotencyKeyExists)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed inserting idempotency key",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, expires_at) VALUES (?, ?, ?, ?)").
+					WillReturnError(fmt.Errorf("error inserting idempotency key"))
+
+				_, err := st.CreateIdempotencyKey(context.Background(), k)
+				require.Error(t, err)
+				require.NotErrorIs(t, err, ErrIdempotencyKeyExists)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestDeleteExpiredIdempotencyKeys(t *testing.T) {
+	now := time.Now()
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM idempotency_keys WHERE expires_at < ? LIMIT ?").WithArgs(now, 100).WillReturnResult(sqlmock.NewResult(0, 3))
+
+				n, err := st.DeleteExpiredIdempotencyKeys(context.Background(), now, 100)
+				require.NoError(t, err)
+				require.Equal(t, int64(3), n)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed deleting idempotency keys",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM idempotency_keys WHERE expires_at < ? LIMIT ?").WithArgs(now, 100).WillReturnError(fmt.Errorf("error deleting idempotency keys"))
+
+				_, err := st.DeleteExpiredIdempotencyKeys(context.Background(), now, 100)
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestRotateSession(t *testing.T) {
+	next := &Session{
+		ID:           "next",
+		FamilyID:     "first",
+		UserEmail:    "test@example.com",
+		RefreshToken: "token",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("UPDATE sessions SET replaced_by=? WHERE id=? AND tenant_id=? AND replaced_by IS NULL AND is_revoked=0").WithArgs("next", "first", DefaultTenantID).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO sessions (id, tenant_id, family_id, user_email, refresh_token, user_agent, client_ip, is_revoked, created_at, expires_at, access_token_id, access_expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				se, err := st.RotateSession(context.Background(), "first", next)
+				require.NoError(t, err)
+				require.Equal(t, "next", se.ID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "already rotated",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("UPDATE sessions SET replaced_by=? WHERE id=? AND tenant_id=? AND replaced_by IS NULL AND is_revoked=0").WithArgs("next", "first", DefaultTenantID).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+
+				_, err := st.RotateSession(context.Background(), "first", next)
+				require.ErrorIs(t, err, ErrSessionRotated)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed inserting session",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("UPDATE sessions SET replaced_by=? WHERE id=? AND tenant_id=? AND replaced_by IS NULL AND is_revoked=0").WithArgs("next", "first", DefaultTenantID).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO sessions (id, tenant_id, family_id, user_email, refresh_token, user_agent, client_ip, is_revoked, created_at, expires_at, access_token_id, access_expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error inserting session"))
+				mock.ExpectRollback()
+
+				_, err := st.RotateSession(context.Background(), "first", next)
+				require.Error(t, err)
+				require.NotErrorIs(t, err, ErrSessionRotated)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestRevokeSessionFamily(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("UPDATE sessions SET is_revoked=1, revoked_at=COALESCE(revoked_at, NOW()) WHERE family_id=? AND tenant_id=?").WithArgs("first", DefaultTenantID).WillReturnResult(sqlmock.NewResult(0, 3))
+
+		err := st.RevokeSessionFamily(context.Background(), "first")
+		require.NoError(t, err)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestCreateSecurityEvent(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		familyID := "first"
+		mock.ExpectExec("INSERT INTO security_events (tenant_id, user_email, event_type, session_id, family_id) VALUES (?, ?, ?, ?, ?)").
+			WithArgs(int64(2), "test@example.com", SecurityEventRefreshTokenReuse, &familyID, &familyID).WillReturnResult(sqlmock.NewResult(4, 1))
+
+		e, err := st.CreateSecurityEvent(WithTenant(context.Background(), 2), &SecurityEvent{
+			UserEmail: "test@example.com",
+			EventType: SecurityEventRefreshTokenReuse,
+			SessionID: &familyID,
+			FamilyID:  &familyID,
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(4), e.ID)
+		require.Equal(t, int64(2), e.TenantID)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestListSessions(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		rows := sqlmock.NewRows([]string{"id", "family_id", "replaced_by", "user_email", "refresh_token", "user_agent", "client_ip", "is_revoked", "revoked_at", "created_at", "last_used_at", "expires_at"}).
+			AddRow("second", "first", nil, "test@example.com", "token", "curl/8.0", "127.0.0.1", false, nil, time.Now(), time.Now(), time.Now().Add(time.Hour))
+		mock.ExpectQuery("SELECT * FROM sessions WHERE user_email=? AND tenant_id=? AND replaced_by IS NULL AND is_revoked=0 AND expires_at > NOW() ORDER BY last_used_at DESC").WithArgs("test@example.com", DefaultTenantID).WillReturnRows(rows)
+
+		sessions, err := st.ListSessions(context.Background(), "test@example.com")
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		require.Equal(t, "first", sessions[0].FamilyID)
+		require.Equal(t, "curl/8.0", sessions[0].UserAgent)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestRevokeSessionsByEmail(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("UPDATE sessions SET is_revoked=1, revoked_at=COALESCE(revoked_at, NOW()) WHERE user_email=? AND tenant_id=?").WithArgs("test@example.com", DefaultTenantID).WillReturnResult(sqlmock.NewResult(0, 2))
+
+		err := st.RevokeSessionsByEmail(context.Background(), "test@example.com")
+		require.NoError(t, err)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+	now := time.Now()
+	revokedBefore := now.Add(-time.Hour)
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("DELETE FROM sessions WHERE (is_revoked=0 AND expires_at < ?) OR (is_revoked=1 AND COALESCE(revoked_at, created_at) < ?) LIMIT ?").WithArgs(now, revokedBefore, 100).WillReturnResult(sqlmock.NewResult(0, 42))
+
+		n, err := st.DeleteExpiredSessions(context.Background(), now, revokedBefore, 100)
+		require.NoError(t, err)
+		require.Equal(t, int64(42), n)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestWithLock(t *testing.T) {
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "acquired",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT GET_LOCK(?, 0)").WithArgs("test").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+				mock.ExpectExec("SELECT RELEASE_LOCK(?)").WithArgs("test").WillReturnResult(sqlmock.NewResult(0, 0))
+
+				called := false
+				ran, err := st.WithLock(context.Background(), "test", func(ctx context.Context) error {
+					called = true
+					return nil
+				})
+				require.NoError(t, err)
+				require.True(t, ran)
+				require.True(t, called)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "held elsewhere",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT GET_LOCK(?, 0)").WithArgs("test").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(0))
+
+				ran, err := st.WithLock(context.Background(), "test", func(ctx context.Context) error {
+					t.Fatal("fn must not be called without the lock")
+					return nil
+				})
+				require.NoError(t, err)
+				require.False(t, ran)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestCreateUserToken(t *testing.T) {
+	ut := &UserToken{
+		TokenHash: "hash",
+		UserID:    1,
+		Purpose:   UserTokenPasswordReset,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM user_tokens WHERE user_id=? AND purpose=? AND used_at IS NULL").WithArgs(1, UserTokenPasswordReset).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO user_tokens (token_hash, user_id, purpose, expires_at) VALUES (?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		_, err := st.CreateUserToken(context.Background(), ut)
+		require.NoError(t, err)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestConsumeUserToken(t *testing.T) {
+	columns := []string{"token_hash", "user_id", "purpose", "created_at", "expires_at", "used_at"}
+	query := "SELECT user_tokens.* FROM user_tokens JOIN users ON users.id=user_tokens.user_id WHERE user_tokens.token_hash=? AND user_tokens.purpose=? AND users.tenant_id=? FOR UPDATE"
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).AddRow("hash", 1, UserTokenPasswordReset, time.Now(), time.Now().Add(time.Hour), nil)
+				mock.ExpectBegin()
+				mock.ExpectQuery(query).WithArgs("hash", UserTokenPasswordReset, DefaultTenantID).WillReturnRows(rows)
+				mock.ExpectExec("UPDATE user_tokens SET used_at=? WHERE token_hash=?").WithArgs(sqlmock.AnyArg(), "hash").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				ut, err := st.ConsumeUserToken(context.Background(), "hash", UserTokenPasswordReset)
+				require.NoError(t, err)
+				require.Equal(t, int64(1), ut.UserID)
+				require.NotNil(t, ut.UsedAt)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "not found in tenant",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(query).WithArgs("hash", UserTokenPasswordReset, DefaultTenantID).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+
+				_, err := st.ConsumeUserToken(context.Background(), "hash", UserTokenPasswordReset)
+				require.ErrorIs(t, err, ErrInvalidUserToken)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "already used",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).AddRow("hash", 1, UserTokenPasswordReset, time.Now(), time.Now().Add(time.Hour), time.Now())
+				mock.ExpectBegin()
+				mock.ExpectQuery(query).WithArgs("hash", UserTokenPasswordReset, DefaultTenantID).WillReturnRows(rows)
+				mock.ExpectRollback()
+
+				_, err := st.ConsumeUserToken(context.Background(), "hash", UserTokenPasswordReset)
+				require.ErrorIs(t, err, ErrInvalidUserToken)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "expired",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).AddRow("hash", 1, UserTokenPasswordReset, time.Now(), time.Now().Add(-time.Minute), nil)
+				mock.ExpectBegin()
+				mock.ExpectQuery(query).WithArgs("hash", UserTokenPasswordReset, DefaultTenantID).WillReturnRows(rows)
+				mock.ExpectRollback()
+
+				_, err := st.ConsumeUserToken(context.Background(), "hash", UserTokenPasswordReset)
+				require.ErrorIs(t, err, ErrInvalidUserToken)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	columns := []string{"token_hash", "user_id", "purpose", "created_at", "expires_at", "used_at"}
+	query := "SELECT user_tokens.* FROM user_tokens JOIN users ON users.id=user_tokens.user_id WHERE user_tokens.token_hash=? AND user_tokens.purpose=? AND users.tenant_id=? FOR UPDATE"
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).AddRow("hash", 1, UserTokenPasswordReset, time.Now(), time.Now().Add(time.Hour), nil)
+				mock.ExpectBegin()
+				mock.ExpectQuery(query).WithArgs("hash", UserTokenPasswordReset, DefaultTenantID).WillReturnRows(rows)
+				mock.ExpectExec("UPDATE user_tokens SET used_at=? WHERE token_hash=?").WithArgs(sqlmock.AnyArg(), "hash").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE users SET password=? WHERE id=? AND tenant_id=?").WithArgs("password hash", 1, DefaultTenantID).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				ut, err := st.ResetPassword(context.Background(), "hash", "password hash")
+				require.NoError(t, err)
+				require.Equal(t, int64(1), ut.UserID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "invalid token",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(query).WithArgs("hash", UserTokenPasswordReset, DefaultTenantID).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+
+				_, err := st.ResetPassword(context.Background(), "hash", "password hash")
+				require.ErrorIs(t, err, ErrInvalidUserToken)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed update keeps token",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).AddRow("hash", 1, UserTokenPasswordReset, time.Now(), time.Now().Add(time.Hour), nil)
+				mock.ExpectBegin()
+				mock.ExpectQuery(query).WithArgs("hash", UserTokenPasswordReset, DefaultTenantID).WillReturnRows(rows)
+				mock.ExpectExec("UPDATE user_tokens SET used_at=? WHERE token_hash=?").WithArgs(sqlmock.AnyArg(), "hash").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE users SET password=? WHERE id=? AND tenant_id=?").WithArgs("password hash", 1, DefaultTenantID).WillReturnError(fmt.Errorf("error updating user"))
+				mock.ExpectRollback()
+
+				_, err := st.ResetPassword(context.Background(), "hash", "password hash")
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+			st := NewMySQLStorer(db)
+			tc.test(t, st, mock)
+		})
+	}
+}
+
+func TestCreateNotification(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("INSERT INTO jobs (tenant_id, kind, payload) VALUES (?, ?, ?)").
+			WithArgs(DefaultTenantID, JobKindNotification, []byte(`{"channel":"email","recipient":"test@example.com","template":"password_reset","data":{}}`)).
+			WillReturnResult(sqlmock.NewResult(9, 1))
+
+		n, err := st.CreateNotification(context.Background(), &Notification{
+			Channel:   NotificationChannelEmail,
+			Recipient: "test@example.com",
+			Template:  UserTokenPasswordReset,
+			Data:      []byte(`{}`),
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(9), n.ID)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestReplaceRecoveryCodes(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM recovery_codes WHERE user_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 10))
+		mock.ExpectExec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)").WithArgs(1, "a").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)").WithArgs(1, "b").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := st.ReplaceRecoveryCodes(context.Background(), 1, []string{"a", "b"})
+		require.NoError(t, err)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	query := "UPDATE recovery_codes SET used_at=NOW() WHERE user_id=? AND code_hash=? AND used_at IS NULL"
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec(query).WithArgs(1, "a").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(query).WithArgs(1, "a").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.NoError(t, st.UseRecoveryCode(context.Background(), 1, "a"))
+		require.ErrorIs(t, st.UseRecoveryCode(context.Background(), 1, "a"), ErrInvalidRecoveryCode)
+
+		err := mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	query := "UPDATE users SET totp_last_step=? WHERE id=? AND tenant_id=? AND totp_last_step<?"
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec(query).WithArgs(100, 1, DefaultTenantID, 100).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(query).WithArgs(100, 1, DefaultTenantID, 100).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.NoError(t, st.UseTOTPStep(context.Background(), 1, 100))
+		require.ErrorIs(t, st.UseTOTPStep(context.Background(), 1, 100), ErrTOTPStepUsed)
+
+		err := mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	k := &APIKey{
+		UserID:  1,
+		Name:    "erp",
+		Prefix:  "abcd1234",
+		KeyHash: "hash",
+		Scopes:  "products:read,orders:write",
+	}
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)").
+			WithArgs(k.UserID, k.Name, k.Prefix, k.KeyHash, k.Scopes, k.ExpiresAt).WillReturnResult(sqlmock.NewResult(3, 1))
+
+		ck, err := st.CreateAPIKey(context.Background(), k)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), ck.ID)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestGetAPIKeyByPrefix(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		rows := sqlmock.NewRows([]string{"id", "user_id", "name", "prefix", "key_hash", "scopes", "created_at", "expires_at", "last_used_at", "revoked_at"}).
+			AddRow(3, 1, "erp", "abcd1234", "hash", "orders:write", time.Now(), nil, nil, nil)
+		mock.ExpectQuery("SELECT * FROM api_keys WHERE prefix=?").WithArgs("abcd1234").WillReturnRows(rows)
+		mock.ExpectQuery("SELECT * FROM api_keys WHERE prefix=?").WithArgs("missing").WillReturnError(sql.ErrNoRows)
+
+		k, err := st.GetAPIKeyByPrefix(context.Background(), "abcd1234")
+		require.NoError(t, err)
+		require.Equal(t, int64(3), k.ID)
+		require.Equal(t, "orders:write", k.Scopes)
+		require.Nil(t, k.ExpiresAt)
+
+		_, err = st.GetAPIKeyByPrefix(context.Background(), "missing")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestListAPIKeys(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		rows := sqlmock.NewRows([]string{"id", "user_id", "name", "prefix", "key_hash", "scopes", "created_at", "expires_at", "last_used_at", "revoked_at"}).
+			AddRow(4, 1, "b", "bbbbbbbb", "hash", "orders:read", time.Now(), nil, time.Now(), nil).
+			AddRow(3, 1, "a", "aaaaaaaa", "hash", "orders:write", time.Now(), nil, nil, nil)
+		mock.ExpectQuery("SELECT * FROM api_keys WHERE user_id=? AND revoked_at IS NULL ORDER BY id DESC").WithArgs(1).WillReturnRows(rows)
+
+		keys, err := st.ListAPIKeys(context.Background(), 1)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		require.NotNil(t, keys[0].LastUsedAt)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	query := "UPDATE api_keys SET revoked_at=NOW() WHERE id=? AND user_id=? AND revoked_at IS NULL"
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec(query).WithArgs(3, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(query).WithArgs(3, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.NoError(t, st.RevokeAPIKey(context.Background(), 1, 3))
+		require.ErrorIs(t, st.RevokeAPIKey(context.Background(), 2, 3), sql.ErrNoRows)
+
+		err := mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestTouchAPIKey(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("UPDATE api_keys SET last_used_at=NOW() WHERE id=?").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, st.TouchAPIKey(context.Background(), 3))
+
+		err := mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestTouchSession(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		staleBefore := time.Now().Add(-time.Minute)
+		mock.ExpectExec("UPDATE sessions SET last_used_at=NOW() WHERE access_token_id=? AND tenant_id=? AND last_used_at<?").WithArgs("jti", DefaultTenantID, staleBefore).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, st.TouchSession(context.Background(), "jti", staleBefore))
+
+		err := mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestRecordFailedLogin(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE users SET failed_logins=failed_logins+1 WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT failed_logins FROM users WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnRows(sqlmock.NewRows([]string{"failed_logins"}).AddRow(3))
+		mock.ExpectCommit()
+
+		failures, err := st.RecordFailedLogin(context.Background(), 1)
+		require.NoError(t, err)
+		require.Equal(t, 3, failures)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestLockUser(t *testing.T) {
+	until := time.Now().Add(time.Minute)
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("UPDATE users SET locked_until=? WHERE id=? AND tenant_id=?").WithArgs(until, 1, DefaultTenantID).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE users SET failed_logins=0, locked_until=NULL WHERE id=? AND tenant_id=?").WithArgs(1, DefaultTenantID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, st.LockUser(context.Background(), 1, until))
+		require.NoError(t, st.UnlockUser(context.Background(), 1))
+
+		err := mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestLoginHistory(t *testing.T) {
+	l := &LoginRecord{UserID: 1, ClientIP: "127.0.0.1", UserAgent: "test"}
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("INSERT INTO login_history (user_id, client_ip, user_agent) VALUES (?, ?, ?)").
+			WithArgs(l.UserID, l.ClientIP, l.UserAgent).WillReturnResult(sqlmock.NewResult(5, 1))
+		rows := sqlmock.NewRows([]string{"id", "user_id", "client_ip", "user_agent", "created_at"}).
+			AddRow(5, 1, "127.0.0.1", "test", time.Now())
+		mock.ExpectQuery("SELECT * FROM login_history WHERE user_id=? ORDER BY id DESC LIMIT ?").WithArgs(1, 10).WillReturnRows(rows)
+
+		cl, err := st.CreateLoginRecord(context.Background(), l)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), cl.ID)
+
+		logins, err := st.ListLoginHistory(context.Background(), 1, 10)
+		require.NoError(t, err)
+		require.Len(t, logins, 1)
+		require.Equal(t, "test", logins[0].UserAgent)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestListLiveAccessTokens(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		rows := sqlmock.NewRows([]string{"id", "family_id", "user_email", "access_token_id", "access_expires_at"}).
+			AddRow("b", "a", "test@example.com", "jti", time.Now().Add(time.Minute))
+		mock.ExpectQuery("SELECT * FROM sessions WHERE user_email=? AND tenant_id=? AND access_expires_at > NOW()").WithArgs("test@example.com", DefaultTenantID).WillReturnRows(rows)
+
+		sessions, err := st.ListLiveAccessTokens(context.Background(), "test@example.com")
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		require.Equal(t, "jti", *sessions[0].AccessTokenID)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestDenyToken(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("INSERT INTO denied_tokens (jti, expires_at) VALUES (?, ?) ON DUPLICATE KEY UPDATE expires_at=VALUES(expires_at)").
+			WithArgs("jti", expiresAt).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT EXISTS(SELECT 1 FROM denied_tokens WHERE jti=? AND expires_at > NOW())").
+			WithArgs("jti").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		require.NoError(t, st.DenyToken(context.Background(), "jti", expiresAt))
+
+		denied, err := st.IsTokenDenied(context.Background(), "jti")
+		require.NoError(t, err)
+		require.True(t, denied)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestDeleteExpiredDeniedTokens(t *testing.T) {
+	now := time.Now()
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("DELETE FROM denied_tokens WHERE expires_at < ? LIMIT ?").WithArgs(now, 100).WillReturnResult(sqlmock.NewResult(0, 7))
+
+		n, err := st.DeleteExpiredDeniedTokens(context.Background(), now, 100)
+		require.NoError(t, err)
+		require.Equal(t, int64(7), n)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestTenants(t *testing.T) {
+	host := "shop.example"
+	tenant := &Tenant{Name: "Shop", Slug: "shop", Host: &host}
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("INSERT INTO tenants (name, slug, host) VALUES (?, ?, ?)").
+			WithArgs("Shop", "shop", "shop.example").WillReturnResult(sqlmock.NewResult(2, 1))
+		rows := sqlmock.NewRows([]string{"id", "name", "slug", "host", "created_at"}).
+			AddRow(2, "Shop", "shop", "shop.example", time.Now())
+		mock.ExpectQuery("SELECT * FROM tenants WHERE slug=?").WithArgs("shop").WillReturnRows(rows)
+		mock.ExpectQuery("SELECT * FROM tenants WHERE host=?").WithArgs("missing.example").WillReturnError(sql.ErrNoRows)
+
+		ct, err := st.CreateTenant(context.Background(), tenant)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), ct.ID)
+
+		gt, err := st.GetTenantBySlug(context.Background(), "shop")
+		require.NoError(t, err)
+		require.Equal(t, int64(2), gt.ID)
+		require.Equal(t, "shop.example", *gt.Host)
+
+		_, err = st.GetTenantByHost(context.Background(), "missing.example")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestTenantScope(t *testing.T) {
+	ctx := WithTenant(context.Background(), 2)
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectExec("INSERT INTO users (tenant_id, name, email, password, is_admin, email_verified) VALUES (?, ?, ?, ?, ?, ?)").
+			WithArgs(int64(2), "test", "test@example.com", "hash", false, false).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT * FROM users WHERE email=? AND tenant_id=?").WithArgs("test@example.com", int64(2)).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT * FROM products WHERE tenant_id=?").WithArgs(int64(2)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery("SELECT * FROM orders WHERE tenant_id=?").WithArgs(int64(2)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		u, err := st.CreateUser(ctx, &User{Name: "test", Email: "test@example.com", Password: "hash"})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), u.TenantID)
+
+		_, err = st.GetUser(ctx, "test@example.com")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		_, err = st.ListProducts(ctx)
+		require.NoError(t, err)
+
+		_, err = st.ListOrders(ctx)
+		require.NoError(t, err)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM orders WHERE id=? AND tenant_id=? FOR UPDATE").WithArgs(4, DefaultTenantID).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+		mock.ExpectExec("UPDATE orders SET status=? WHERE id=? AND tenant_id=?").WithArgs(Shipped, 4, DefaultTenantID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO events (tenant_id, kind, entity_id, status, count_in_stock) VALUES (?, ?, ?, ?, ?)").
+			WithArgs(DefaultTenantID, EventOrderStatus, 4, Shipped, 0).WillReturnResult(sqlmock.NewResult(8, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery("SELECT * FROM orders WHERE id=? AND tenant_id=?").WithArgs(4, DefaultTenantID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(4, "shipped"))
+		mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		o, err := st.UpdateOrderStatus(context.Background(), 4, Shipped)
+		require.NoError(t, err)
+		require.Equal(t, Shipped, o.Status)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM orders WHERE id=? AND tenant_id=? FOR UPDATE").WithArgs(5, DefaultTenantID).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err = st.UpdateOrderStatus(context.Background(), 5, Shipped)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestEvents(t *testing.T) {
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+		rows := sqlmock.NewRows([]string{"id", "tenant_id", "kind", "entity_id", "status", "count_in_stock", "created_at"}).
+			AddRow(8, 1, "order_status", 4, "shipped", 0, time.Now()).
+			AddRow(9, 2, "inventory", 3, "", 7, time.Now())
+		mock.ExpectQuery("SELECT * FROM events WHERE id > ? ORDER BY id LIMIT ?").WithArgs(7, 100).WillReturnRows(rows)
+		mock.ExpectQuery("SELECT COALESCE(MIN(id), 0) FROM events").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+		mock.ExpectQuery("SELECT COALESCE(MAX(id), 0) FROM events").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+		mock.ExpectExec("DELETE FROM events WHERE created_at < ? LIMIT ?").WithArgs(sqlmock.AnyArg(), 1000).WillReturnResult(sqlmock.NewResult(0, 2))
+
+		events, err := st.ListEvents(context.Background(), 7, 100)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		require.Equal(t, EventOrderStatus, events[0].Kind)
+		require.Equal(t, int64(7), events[1].CountInStock)
+
+		id, err := st.GetFirstEventID(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(8), id)
+
+		id, err = st.GetLastEventID(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(9), id)
+
+		n, err := st.DeleteEventsBefore(context.Background(), time.Now(), 1000)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), n)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,1194 @@
+// Package storertest provides an in-memory storer.Storer for tests.
+package storertest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+)
+
+type idempotencyKeyID struct {
+	userID int64
+	key    string
+}
+
+// Storer keeps all records in maps guarded by a single mutex. Lookups of
+// missing records return errors wrapping sql.ErrNoRows, as the MySQL storer
+// does, and any method can be made to fail with FailOn. Records of another
+// tenant than that of the context are treated as missing.
+type Storer struct {
+	mu              sync.Mutex
+	nextID          int64
+	tenants         map[int64]storer.Tenant
+	products        map[int64]storer.Product
+	orders          map[int64]storer.Order
+	users           map[int64]storer.User
+	sessions        map[string]storer.Session
+	securityEvents  []storer.SecurityEvent
+	userTokens      map[string]storer.UserToken
+	notifications   []storer.Notification
+	recoveryCodes   map[int64]map[string]bool
+	apiKeys         map[int64]storer.APIKey
+	logins          []storer.LoginRecord
+	deniedTokens    map[string]time.Time
+	events          []storer.Event
+	lastEventID     int64
+	locks           map[string]bool
+	idempotencyKeys map[idempotencyKeyID]storer.IdempotencyKey
+	failures        map[string]error
+}
+
+var _ storer.Storer = (*Storer)(nil)
+
+func New() *Storer {
+	return &Storer{
+		tenants: map[int64]storer.Tenant{
+			storer.DefaultTenantID: {ID: storer.DefaultTenantID, Name: "Default", Slug: "default"},
+		},
+		products:        make(map[int64]storer.Product),
+		orders:          make(map[int64]storer.Order),
+		users:           make(map[int64]storer.User),
+		sessions:        make(map[string]storer.Session),
+		idempotencyKeys: make(map[idempotencyKeyID]storer.IdempotencyKey),
+		userTokens:      make(map[string]storer.UserToken),
+		recoveryCodes:   make(map[int64]map[string]bool),
+		apiKeys:         make(map[int64]storer.APIKey),
+		deniedTokens:    make(map[string]time.Time),
+		locks:           make(map[string]bool),
+		failures:        make(map[string]error),
+	}
+}
+
+// FailOn makes every subsequent call to the named method return err.
+func (s *Storer) FailOn(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[method] = err
+}
+
+func (s *Storer) fail(method string) error {
+	if err, ok := s.failures[method]; ok {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	return nil
+}
+
+func (s *Storer) id() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+func notFound(what string) error {
+	return fmt.Errorf("error getting %s: %w", what, sql.ErrNoRows)
+}
+
+func (s *Storer) CreateTenant(ctx context.Context, t *storer.Tenant) (*storer.Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateTenant"); err != nil {
+		return nil, err
+	}
+
+	for _, existing := range s.tenants {
+		if existing.Slug == t.Slug || (t.Host != nil && existing.Host != nil && *existing.Host == *t.Host) {
+			return nil, fmt.Errorf("error inserting tenant: duplicate slug or host")
+		}
+	}
+
+	t.ID = int64(len(s.tenants)) + 1
+	t.CreatedAt = time.Now().Truncate(time.Second)
+	s.tenants[t.ID] = *t
+	return t, nil
+}
+
+func (s *Storer) GetTenantBySlug(ctx context.Context, slug string) (*storer.Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetTenantBySlug"); err != nil {
+		return nil, err
+	}
+
+	for _, t := range s.tenants {
+		if t.Slug == slug {
+			return &t, nil
+		}
+	}
+	return nil, notFound("tenant")
+}
+
+func (s *Storer) GetTenantByHost(ctx context.Context, host string) (*storer.Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetTenantByHost"); err != nil {
+		return nil, err
+	}
+
+	for _, t := range s.tenants {
+		if t.Host != nil && *t.Host == host {
+			return &t, nil
+		}
+	}
+	return nil, notFound("tenant")
+}
+
+func (s *Storer) CreateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateProduct"); err != nil {
+		return nil, err
+	}
+
+	p.ID = s.id()
+	p.TenantID = storer.TenantID(ctx)
+	p.Version = 1
+	p.CreatedAt = time.Now().Truncate(time.Second)
+	s.products[p.ID] = *p
+	return p, nil
+}
+
+func (s *Storer) GetProduct(ctx context.Context, id int64) (*storer.Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetProduct"); err != nil {
+		return nil, err
+	}
+
+	p, ok := s.products[id]
+	if !ok || p.TenantID != storer.TenantID(ctx) {
+		return nil, notFound("product")
+	}
+	return &p, nil
+}
+
+func (s *Storer) ListProducts(ctx context.Context) ([]storer.Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ListProducts"); err != nil {
+		return nil, err
+	}
+
+	var ps []storer.Product
+	for _, p := range s.products {
+		if p.TenantID == storer.TenantID(ctx) {
+			ps = append(ps, p)
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].ID < ps[j].ID })
+	return ps, nil
+}
+
+func (s *Storer) UpdateProduct(ctx context.Context, p *storer.Product, version int64) (*storer.Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("UpdateProduct"); err != nil {
+		return nil, err
+	}
+
+	p.TenantID = storer.TenantID(ctx)
+	if p.UpdatedAt != nil {
+		updatedAt := p.UpdatedAt.Truncate(time.Second)
+		p.UpdatedAt = &updatedAt
+	}
+	if existing, ok := s.products[p.ID]; ok && existing.TenantID == p.TenantID {
+		if version != 0 && existing.Version != version {
+			return nil, storer.ErrProductModified
+		}
+		p.Version = existing.Version + 1
+		s.products[p.ID] = *p
+		if existing.CountInStock != p.CountInStock {
+			s.event(storer.Event{TenantID: p.TenantID, Kind: storer.EventInventory, EntityID: p.ID, CountInStock: p.CountInStock})
+		}
+	}
+	return p, nil
+}
+
+func (s *Storer) DeleteProduct(ctx context.Context, id int64, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("DeleteProduct"); err != nil {
+		return err
+	}
+
+	if p, ok := s.products[id]; ok && p.TenantID == storer.TenantID(ctx) {
+		if version != 0 && p.Version != version {
+			return storer.ErrProductModified
+		}
+		delete(s.products, id)
+	}
+	return nil
+}
+
+func (s *Storer) CreateOrder(ctx context.Context, o *storer.Order) (*storer.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateOrder"); err != nil {
+		return nil, err
+	}
+
+	for _, oi := range o.Items {
+		if p, ok := s.products[oi.ProductID]; !ok || p.TenantID != storer.TenantID(ctx) {
+			return nil, fmt.Errorf("product %d: %w", oi.ProductID, storer.ErrUnknownProduct)
+		}
+	}
+
+	o.ID = s.id()
+	o.TenantID = storer.TenantID(ctx)
+	if o.Status == "" {
+		o.Status = storer.Pending
+	}
+	o.CreatedAt = time.Now().Truncate(time.Second)
+	for i := range o.Items {
+		o.Items[i].ID = s.id()
+		o.Items[i].OrderID = o.ID
+	}
+	s.orders[o.ID] = *o
+	return o, nil
+}
+
+func (s *Storer) GetOrder(ctx context.Context, id int64) (*storer.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetOrder"); err != nil {
+		return nil, err
+	}
+
+	o, ok := s.orders[id]
+	if !ok || o.TenantID != storer.TenantID(ctx) {
+		return nil, notFound("order")
+	}
+	return &o, nil
+}
+
+func (s *Storer) ListOrders(ctx context.Context) ([]storer.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ListOrders"); err != nil {
+		return nil, err
+	}
+
+	var os []storer.Order
+	for _, o := range s.orders {
+		if o.TenantID == storer.TenantID(ctx) {
+			os = append(os, o)
+		}
+	}
+	sort.Slice(os, func(i, j int) bool { return os[i].ID < os[j].ID })
+	return os, nil
+}
+
+func (s *Storer) UpdateOrderStatus(ctx context.Context, id int64, status storer.OrderStatus) (*storer.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("UpdateOrderStatus"); err != nil {
+		return nil, err
+	}
+
+	o, ok := s.orders[id]
+	if !ok || o.TenantID != storer.TenantID(ctx) {
+		return nil, notFound("order")
+	}
+
+	if o.Status != status {
+		o.Status = status
+		s.orders[id] = o
+		s.event(storer.Event{TenantID: o.TenantID, Kind: storer.EventOrderStatus, EntityID: id, Status: status})
+	}
+	return &o, nil
+}
+
+func (s *Storer) DeleteOrder(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("DeleteOrder"); err != nil {
+		return err
+	}
+
+	if o, ok := s.orders[id]; ok && o.TenantID == storer.TenantID(ctx) {
+		delete(s.orders, id)
+	}
+	return nil
+}
+
+func (s *Storer) CreateUser(ctx context.Context, u *storer.User) (*storer.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateUser"); err != nil {
+		return nil, err
+	}
+
+	u.TenantID = storer.TenantID(ctx)
+	for _, existing := range s.users {
+		if existing.TenantID == u.TenantID && existing.Email == u.Email {
+			return nil, fmt.Errorf("error inserting user: duplicate email %q", u.Email)
+		}
+	}
+
+	u.ID = s.id()
+	s.users[u.ID] = *u
+	return u, nil
+}
+
+func (s *Storer) GetUser(ctx context.Context, email string) (*storer.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetUser"); err != nil {
+		return nil, err
+	}
+
+	for _, u := range s.users {
+		if u.TenantID == storer.TenantID(ctx) && u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, notFound("user")
+}
+
+func (s *Storer) GetUserByID(ctx context.Context, id int64) (*storer.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetUserByID"); err != nil {
+		return nil, err
+	}
+
+	u, ok := s.user(ctx, id)
+	if !ok {
+		return nil, notFound("user")
+	}
+	return &u, nil
+}
+
+func (s *Storer) ListUsers(ctx context.Context) ([]storer.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ListUsers"); err != nil {
+		return nil, err
+	}
+
+	var us []storer.User
+	for _, u := range s.users {
+		if u.TenantID == storer.TenantID(ctx) {
+			us = append(us, u)
+		}
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].ID < us[j].ID })
+	return us, nil
+}
+
+func (s *Storer) UpdateUser(ctx context.Context, u *storer.User) (*storer.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("UpdateUser"); err != nil {
+		return nil, err
+	}
+
+	// Like the MySQL storer, leave the lockout and TOTP replay state to
+	// their own methods.
+	u.TenantID = storer.TenantID(ctx)
+	if existing, ok := s.user(ctx, u.ID); ok {
+		u.FailedLogins = existing.FailedLogins
+		u.LockedUntil = existing.LockedUntil
+		u.TOTPLastStep = existing.TOTPLastStep
+		s.users[u.ID] = *u
+	}
+	return u, nil
+}
+
+func (s *Storer) DeleteUser(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("DeleteUser"); err != nil {
+		return err
+	}
+
+	if _, ok := s.user(ctx, id); ok {
+		delete(s.users, id)
+	}
+	return nil
+}
+
+// user returns the user with the given ID if it belongs to the tenant of ctx.
+func (s *Storer) user(ctx context.Context, id int64) (storer.User, bool) {
+	u, ok := s.users[id]
+	return u, ok && u.TenantID == storer.TenantID(ctx)
+}
+
+func (s *Storer) RecordFailedLogin(ctx context.Context, id int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("RecordFailedLogin"); err != nil {
+		return 0, err
+	}
+
+	u, ok := s.user(ctx, id)
+	if !ok {
+		return 0, notFound("user")
+	}
+	u.FailedLogins++
+	s.users[id] = u
+	return u.FailedLogins, nil
+}
+
+func (s *Storer) LockUser(ctx context.Context, id int64, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("LockUser"); err != nil {
+		return err
+	}
+
+	if u, ok := s.user(ctx, id); ok {
+		u.LockedUntil = &until
+		s.users[id] = u
+	}
+	return nil
+}
+
+func (s *Storer) UnlockUser(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("UnlockUser"); err != nil {
+		return err
+	}
+
+	if u, ok := s.user(ctx, id); ok {
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+		s.users[id] = u
+	}
+	return nil
+}
+
+func (s *Storer) CreateLoginRecord(ctx context.Context, l *storer.LoginRecord) (*storer.LoginRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateLoginRecord"); err != nil {
+		return nil, err
+	}
+
+	l.ID = s.id()
+	l.CreatedAt = time.Now().Truncate(time.Second)
+	s.logins = append(s.logins, *l)
+	return l, nil
+}
+
+func (s *Storer) ListLoginHistory(ctx context.Context, userID int64, limit int) ([]storer.LoginRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ListLoginHistory"); err != nil {
+		return nil, err
+	}
+
+	var logins []storer.LoginRecord
+	for i := len(s.logins) - 1; i >= 0 && len(logins) < limit; i-- {
+		if s.logins[i].UserID == userID {
+			logins = append(logins, s.logins[i])
+		}
+	}
+	return logins, nil
+}
+
+func (s *Storer) CreateSession(ctx context.Context, se *storer.Session) (*storer.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateSession"); err != nil {
+		return nil, err
+	}
+
+	se.TenantID = storer.TenantID(ctx)
+	se.CreatedAt = time.Now().Truncate(time.Second)
+	se.LastUsedAt = se.CreatedAt
+	s.sessions[se.ID] = *se
+	return se, nil
+}
+
+func (s *Storer) GetSession(ctx context.Context, id string) (*storer.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetSession"); err != nil {
+		return nil, err
+	}
+
+	se, ok := s.session(ctx, id)
+	if !ok {
+		return nil, notFound("session")
+	}
+	return &se, nil
+}
+
+func (s *Storer) ListSessions(ctx context.Context, email string) ([]storer.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ListSessions"); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var sessions []storer.Session
+	for _, se := range s.sessions {
+		if se.TenantID == storer.TenantID(ctx) && se.UserEmail == email && se.ReplacedBy == nil && !se.IsRevoked && se.ExpiresAt.After(now) {
+			sessions = append(sessions, se)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt) })
+	return sessions, nil
+}
+
+func (s *Storer) ListLiveAccessTokens(ctx context.Context, email string) ([]storer.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ListLiveAccessTokens"); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var sessions []storer.Session
+	for _, se := range s.sessions {
+		if se.TenantID == storer.TenantID(ctx) && se.UserEmail == email && se.AccessExpiresAt != nil && se.AccessExpiresAt.After(now) {
+			sessions = append(sessions, se)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *Storer) RevokeSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("RevokeSession"); err != nil {
+		return err
+	}
+
+	if se, ok := s.session(ctx, id); ok {
+		s.sessions[id] = revoke(se)
+	}
+	return nil
+}
+
+func (s *Storer) RotateSession(ctx context.Context, id string, next *storer.Session) (*storer.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("RotateSession"); err != nil {
+		return nil, err
+	}
+
+	se, ok := s.session(ctx, id)
+	if !ok || se.ReplacedBy != nil || se.IsRevoked {
+		return nil, fmt.Errorf("error rotating session: %w", storer.ErrSessionRotated)
+	}
+
+	se.ReplacedBy = &next.ID
+	s.sessions[id] = se
+
+	next.TenantID = se.TenantID
+	next.LastUsedAt = time.Now().Truncate(time.Second)
+	s.sessions[next.ID] = *next
+	return next, nil
+}
+
+func (s *Storer) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("RevokeSessionFamily"); err != nil {
+		return err
+	}
+
+	for id, se := range s.sessions {
+		if se.TenantID == storer.TenantID(ctx) && se.FamilyID == familyID {
+			s.sessions[id] = revoke(se)
+		}
+	}
+	return nil
+}
+
+func (s *Storer) RevokeSessionsByEmail(ctx context.Context, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("RevokeSessionsByEmail"); err != nil {
+		return err
+	}
+
+	for id, se := range s.sessions {
+		if se.TenantID == storer.TenantID(ctx) && se.UserEmail == email {
+			s.sessions[id] = revoke(se)
+		}
+	}
+	return nil
+}
+
+// session returns the session with the given ID if it belongs to the tenant
+// of ctx.
+func (s *Storer) session(ctx context.Context, id string) (storer.Session, bool) {
+	se, ok := s.sessions[id]
+	return se, ok && se.TenantID == storer.TenantID(ctx)
+}
+
+func revoke(se storer.Session) storer.Session {
+	if se.RevokedAt == nil {
+		now := time.Now().Truncate(time.Second)
+		se.RevokedAt = &now
+	}
+	se.IsRevoked = true
+	return se
+}
+
+func (s *Storer) DeleteExpiredSessions(ctx context.Context, expiredBefore, revokedBefore time.Time, limit int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("DeleteExpiredSessions"); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for id, se := range s.sessions {
+		if n >= int64(limit) {
+			break
+		}
+
+		expired := !se.IsRevoked && se.ExpiresAt.Before(expiredBefore)
+		retired := se.IsRevoked && se.RevokedAt != nil && se.RevokedAt.Before(revokedBefore)
+		if expired || retired {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Storer) DeleteSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("DeleteSession"); err != nil {
+		return err
+	}
+
+	if _, ok := s.session(ctx, id); ok {
+		delete(s.sessions, id)
+	}
+	return nil
+}
+
+func (s *Storer) CreateSecurityEvent(ctx context.Context, e *storer.SecurityEvent) (*storer.SecurityEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateSecurityEvent"); err != nil {
+		return nil, err
+	}
+
+	e.ID = s.id()
+	e.TenantID = storer.TenantID(ctx)
+	e.CreatedAt = time.Now().Truncate(time.Second)
+	s.securityEvents = append(s.securityEvents, *e)
+	return e, nil
+}
+
+// SecurityEvents returns every recorded security event in insertion order.
+func (s *Storer) SecurityEvents() []storer.SecurityEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]storer.SecurityEvent(nil), s.securityEvents...)
+}
+
+func (s *Storer) CreateIdempotencyKey(ctx context.Context, k *storer.IdempotencyKey) (*storer.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateIdempotencyKey"); err != nil {
+		return nil, err
+	}
+
+	id := idempotencyKeyID{k.UserID, k.Key}
+	if _, ok := s.idempotencyKeys[id]; ok {
+		return nil, storer.ErrIdempotencyKeyExists
+	}
+
+	k.CreatedAt = time.Now().Truncate(time.Second)
+	s.idempotencyKeys[id] = *k
+	return k, nil
+}
+
+func (s *Storer) GetIdempotencyKey(ctx context.Context, userID int64, key string) (*storer.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetIdempotencyKey"); err != nil {
+		return nil, err
+	}
+
+	k, ok := s.idempotencyKeys[idempotencyKeyID{userID, key}]
+	if !ok {
+		return nil, notFound("idempotency key")
+	}
+	return &k, nil
+}
+
+func (s *Storer) SaveIdempotencyResponse(ctx context.Context, k *storer.IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("SaveIdempotencyResponse"); err != nil {
+		return err
+	}
+
+	id := idempotencyKeyID{k.UserID, k.Key}
+	if existing, ok := s.idempotencyKeys[id]; ok {
+		existing.ResponseCode = k.ResponseCode
+		existing.ResponseBody = k.ResponseBody
+		s.idempotencyKeys[id] = existing
+	}
+	return nil
+}
+
+func (s *Storer) DeleteIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("DeleteIdempotencyKey"); err != nil {
+		return err
+	}
+
+	delete(s.idempotencyKeys, idempotencyKeyID{userID, key})
+	return nil
+}
+
+func (s *Storer) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time, limit int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("DeleteExpiredIdempotencyKeys"); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for id, k := range s.idempotencyKeys {
+		if n == int64(limit) {
+			break
+		}
+		if k.ExpiresAt.Before(before) {
+			delete(s.idempotencyKeys, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Storer) CreateUserToken(ctx context.Context, t *storer.UserToken) (*storer.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateUserToken"); err != nil {
+		return nil, err
+	}
+
+	for hash, existing := range s.userTokens {
+		if existing.UserID == t.UserID && existing.Purpose == t.Purpose && existing.UsedAt == nil {
+			delete(s.userTokens, hash)
+		}
+	}
+
+	t.CreatedAt = time.Now().Truncate(time.Second)
+	s.userTokens[t.TokenHash] = *t
+	return t, nil
+}
+
+func (s *Storer) ConsumeUserToken(ctx context.Context, tokenHash, purpose string) (*storer.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ConsumeUserToken"); err != nil {
+		return nil, err
+	}
+
+	t, err := s.consumeUserToken(ctx, tokenHash, purpose)
+	if err != nil {
+		return nil, fmt.Errorf("error consuming user token: %w", err)
+	}
+	return t, nil
+}
+
+func (s *Storer) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (*storer.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ResetPassword"); err != nil {
+		return nil, err
+	}
+
+	t, err := s.consumeUserToken(ctx, tokenHash, storer.UserTokenPasswordReset)
+	if err != nil {
+		return nil, fmt.Errorf("error resetting password: %w", err)
+	}
+
+	u := s.users[t.UserID]
+	u.Password = passwordHash
+	s.users[t.UserID] = u
+	return t, nil
+}
+
+func (s *Storer) consumeUserToken(ctx context.Context, tokenHash, purpose string) (*storer.UserToken, error) {
+	now := time.Now()
+	t, ok := s.userTokens[tokenHash]
+	if ok && s.users[t.UserID].TenantID != storer.TenantID(ctx) {
+		ok = false
+	}
+	if !ok || t.Purpose != purpose || t.UsedAt != nil || !t.ExpiresAt.After(now) {
+		return nil, storer.ErrInvalidUserToken
+	}
+
+	t.UsedAt = &now
+	s.userTokens[tokenHash] = t
+	return &t, nil
+}
+
+func (s *Storer) CreateNotification(ctx context.Context, n *storer.Notification) (*storer.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateNotification"); err != nil {
+		return nil, err
+	}
+
+	n.ID = s.id()
+	n.CreatedAt = time.Now().Truncate(time.Second)
+	s.notifications = append(s.notifications, *n)
+	return n, nil
+}
+
+// Notifications returns every enqueued notification in insertion order.
+func (s *Storer) Notifications() []storer.Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]storer.Notification(nil), s.notifications...)
+}
+
+func (s *Storer) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ReplaceRecoveryCodes"); err != nil {
+		return err
+	}
+
+	codes := make(map[string]bool)
+	for _, hash := range hashes {
+		codes[hash] = false
+	}
+	s.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (s *Storer) UseRecoveryCode(ctx context.Context, userID int64, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("UseRecoveryCode"); err != nil {
+		return err
+	}
+
+	used, ok := s.recoveryCodes[userID][hash]
+	if !ok || used {
+		return storer.ErrInvalidRecoveryCode
+	}
+	s.recoveryCodes[userID][hash] = true
+	return nil
+}
+
+func (s *Storer) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("UseTOTPStep"); err != nil {
+		return err
+	}
+
+	u, ok := s.user(ctx, userID)
+	if !ok || u.TOTPLastStep >= step {
+		return storer.ErrTOTPStepUsed
+	}
+	u.TOTPLastStep = step
+	s.users[userID] = u
+	return nil
+}
+
+func (s *Storer) CreateAPIKey(ctx context.Context, k *storer.APIKey) (*storer.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("CreateAPIKey"); err != nil {
+		return nil, err
+	}
+
+	for _, existing := range s.apiKeys {
+		if existing.Prefix == k.Prefix {
+			return nil, fmt.Errorf("error inserting api key: duplicate prefix %q", k.Prefix)
+		}
+	}
+
+	k.ID = s.id()
+	k.CreatedAt = time.Now().Truncate(time.Second)
+	s.apiKeys[k.ID] = *k
+	return k, nil
+}
+
+func (s *Storer) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*storer.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetAPIKeyByPrefix"); err != nil {
+		return nil, err
+	}
+
+	for _, k := range s.apiKeys {
+		if k.Prefix == prefix {
+			return &k, nil
+		}
+	}
+	return nil, notFound("api key")
+}
+
+func (s *Storer) ListAPIKeys(ctx context.Context, userID int64) ([]storer.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ListAPIKeys"); err != nil {
+		return nil, err
+	}
+
+	var keys []storer.APIKey
+	for _, k := range s.apiKeys {
+		if k.UserID == userID && k.RevokedAt == nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, nil
+}
+
+func (s *Storer) RevokeAPIKey(ctx context.Context, userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("RevokeAPIKey"); err != nil {
+		return err
+	}
+
+	k, ok := s.apiKeys[id]
+	if !ok || k.UserID != userID || k.RevokedAt != nil {
+		return notFound("api key")
+	}
+
+	now := time.Now()
+	k.RevokedAt = &now
+	s.apiKeys[id] = k
+	return nil
+}
+
+func (s *Storer) TouchSession(ctx context.Context, accessTokenID string, staleBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("TouchSession"); err != nil {
+		return err
+	}
+
+	for id, se := range s.sessions {
+		if se.AccessTokenID != nil && *se.AccessTokenID == accessTokenID && se.TenantID == storer.TenantID(ctx) && se.LastUsedAt.Before(staleBefore) {
+			se.LastUsedAt = time.Now().Truncate(time.Second)
+			s.sessions[id] = se
+		}
+	}
+	return nil
+}
+
+func (s *Storer) TouchAPIKey(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("TouchAPIKey"); err != nil {
+		return err
+	}
+
+	if k, ok := s.apiKeys[id]; ok {
+		now := time.Now()
+		k.LastUsedAt = &now
+		s.apiKeys[id] = k
+	}
+	return nil
+}
+
+func (s *Storer) DenyToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("DenyToken"); err != nil {
+		return err
+	}
+
+	s.deniedTokens[jti] = expiresAt
+	return nil
+}
+
+func (s *Storer) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("IsTokenDenied"); err != nil {
+		return false, err
+	}
+
+	expiresAt, ok := s.deniedTokens[jti]
+	return ok && expiresAt.After(time.Now()), nil
+}
+
+func (s *Storer) DeleteExpiredDeniedTokens(ctx context.Context, before time.Time, limit int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("DeleteExpiredDeniedTokens"); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for jti, expiresAt := range s.deniedTokens {
+		if n == int64(limit) {
+			break
+		}
+		if expiresAt.Before(before) {
+			delete(s.deniedTokens, jti)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Storer) event(e storer.Event) {
+	s.lastEventID++
+	e.ID = s.lastEventID
+	e.CreatedAt = time.Now()
+	s.events = append(s.events, e)
+}
+
+func (s *Storer) ListEvents(ctx context.Context, afterID int64, limit int) ([]storer.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("ListEvents"); err != nil {
+		return nil, err
+	}
+
+	var es []storer.Event
+	for _, e := range s.events {
+		if len(es) == limit {
+			break
+		}
+		if e.ID > afterID {
+			es = append(es, e)
+		}
+	}
+	return es, nil
+}
+
+func (s *Storer) GetFirstEventID(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetFirstEventID"); err != nil {
+		return 0, err
+	}
+
+	if len(s.events) == 0 {
+		return 0, nil
+	}
+	return s.events[0].ID, nil
+}
+
+func (s *Storer) GetLastEventID(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("GetLastEventID"); err != nil {
+		return 0, err
+	}
+
+	return s.lastEventID, nil
+}
+
+func (s *Storer) DeleteEventsBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail("DeleteEventsBefore"); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for len(s.events) > 0 && n < int64(limit) && s.events[0].CreatedAt.Before(before) {
+		s.events = s.events[1:]
+		n++
+	}
+	return n, nil
+}
+
+// WithLock behaves like the MySQL advisory lock: it reports false without
+// calling fn while another caller holds the lock.
+func (s *Storer) WithLock(ctx context.Context, name string, fn func(context.Context) error) (bool, error) {
+	s.mu.Lock()
+	if err := s.fail("WithLock"); err != nil {
+		s.mu.Unlock()
+		return false, err
+	}
+	if s.locks[name] {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.locks[name] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.locks, name)
+		s.mu.Unlock()
+	}()
+
+	return true, fn(ctx)
+}
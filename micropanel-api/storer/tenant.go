@@ -0,0 +1,29 @@
+package storer
+
+import "context"
+
+// DefaultTenantID is the tenant of requests that were not resolved to any
+// other, and the tenant of all data in single store deployments.
+const DefaultTenantID int64 = 1
+
+type tenantKey struct{}
+
+// WithTenant scopes every storer call made with the returned context to the
+// given tenant.
+func WithTenant(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// TenantFromContext returns the tenant set with WithTenant, if any.
+func TenantFromContext(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(tenantKey{}).(int64)
+	return id, ok
+}
+
+// TenantID returns the tenant that storer calls made with ctx are scoped to.
+func TenantID(ctx context.Context) int64 {
+	if id, ok := TenantFromContext(ctx); ok {
+		return id
+	}
+	return DefaultTenantID
+}
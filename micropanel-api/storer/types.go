@@ -1,9 +1,23 @@
 package storer
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// Tenant is an independent storefront. Products, orders, users and sessions
+// belong to exactly one tenant, which the storer sets from the context.
+type Tenant struct {
+	ID        int64     `db:"id"`
+	Name      string    `db:"name"`
+	Slug      string    `db:"slug"`
+	Host      *string   `db:"host"`
+	CreatedAt time.Time `db:"created_at"`
+}
 
 type Product struct {
 	ID           int64      `db:"id"`
+	TenantID     int64      `db:"tenant_id"`
 	Name         string     `db:"name"`
 	Image        string     `db:"image"`
 	Category     string     `db:"category"`
@@ -12,6 +26,7 @@ type Product struct {
 	NumReviews   int64      `db:"num_reviews"`
 	Price        float32    `db:"price"`
 	CountInStock int64      `db:"count_in_stock"`
+	Version      int64      `db:"version"`
 	CreatedAt    time.Time  `db:"created_at"`
 	UpdatedAt    *time.Time `db:"updated_at"`
 }
@@ -26,6 +41,7 @@ const (
 
 type Order struct {
 	ID            int64       `db:"id"`
+	TenantID      int64       `db:"tenant_id"`
 	PaymentMethod string      `db:"payment_method"`
 	TaxPrice      float32     `db:"tax_price"`
 	ShippingPrice float32     `db:"shipping_price"`
@@ -48,18 +64,146 @@ type OrderItem struct {
 }
 
 type User struct {
-	ID       int64  `db:"id"`
-	Name     string `db:"name"`
-	Email    string `db:"email"`
-	Password string `db:"password"`
-	IsAdmin  bool   `db:"is_admin"`
+	ID            int64      `db:"id"`
+	TenantID      int64      `db:"tenant_id"`
+	Name          string     `db:"name"`
+	Email         string     `db:"email"`
+	Password      string     `db:"password"`
+	IsAdmin       bool       `db:"is_admin"`
+	EmailVerified bool       `db:"email_verified"`
+	TOTPSecret    *string    `db:"totp_secret"`
+	TOTPEnabled   bool       `db:"totp_enabled"`
+	TOTPLastStep  int64      `db:"totp_last_step"`
+	FailedLogins  int        `db:"failed_logins"`
+	LockedUntil   *time.Time `db:"locked_until"`
 }
 
+// Session is a refresh token. AccessTokenID is the jti of the access token
+// issued alongside it, so that the access token can be denied when the
+// session is revoked.
 type Session struct {
-	ID           string    `db:"id"`
-	UserEmail    string    `db:"user_email"`
-	RefreshToken string    `db:"refresh_token"`
-	IsRevoked    bool      `db:"is_revoked"`
+	ID              string     `db:"id"`
+	TenantID        int64      `db:"tenant_id"`
+	FamilyID        string     `db:"family_id"`
+	ReplacedBy      *string    `db:"replaced_by"`
+	UserEmail       string     `db:"user_email"`
+	RefreshToken    string     `db:"refresh_token"`
+	UserAgent       string     `db:"user_agent"`
+	ClientIP        string     `db:"client_ip"`
+	IsRevoked       bool       `db:"is_revoked"`
+	RevokedAt       *time.Time `db:"revoked_at"`
+	CreatedAt       time.Time  `db:"created_at"`
+	LastUsedAt      time.Time  `db:"last_used_at"`
+	ExpiresAt       time.Time  `db:"expires_at"`
+	AccessTokenID   *string    `db:"access_token_id"`
+	AccessExpiresAt *time.Time `db:"access_expires_at"`
+}
+
+const (
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+	SecurityEventAccountLocked     = "account_locked"
+)
+
+type SecurityEvent struct {
+	ID        int64     `db:"id"`
+	TenantID  int64     `db:"tenant_id"`
+	UserEmail string    `db:"user_email"`
+	EventType string    `db:"event_type"`
+	SessionID *string   `db:"session_id"`
+	FamilyID  *string   `db:"family_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type IdempotencyKey struct {
+	UserID       int64     `db:"user_id"`
+	Key          string    `db:"idempotency_key"`
+	RequestHash  string    `db:"request_hash"`
+	ResponseCode *int      `db:"response_code"`
+	ResponseBody []byte    `db:"response_body"`
 	CreatedAt    time.Time `db:"created_at"`
 	ExpiresAt    time.Time `db:"expires_at"`
 }
+
+const (
+	UserTokenPasswordReset     = "password_reset"
+	UserTokenEmailVerification = "email_verification"
+)
+
+// UserToken is a single-use token mailed to a user. Only its hash is stored.
+type UserToken struct {
+	TokenHash string     `db:"token_hash"`
+	UserID    int64      `db:"user_id"`
+	Purpose   string     `db:"purpose"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+const (
+	NotificationChannelEmail   = "email"
+	NotificationChannelSMS     = "sms"
+	NotificationChannelWebhook = "webhook"
+)
+
+// NotificationNewLogin is the template of the notifications of logins from
+// unknown locations. The other templates are named after the purpose of the
+// user token they carry.
+const NotificationNewLogin = "new_login"
+
+// JobKindNotification is the kind of the jobs that notification-svc
+// delivers. Their payload is the JSON encoding of a Notification.
+const JobKindNotification = "notification"
+
+// Notification is a message for a user, delivered by notification-svc.
+type Notification struct {
+	ID        int64           `json:"-"`
+	Channel   string          `json:"channel"`
+	Recipient string          `json:"recipient"`
+	Template  string          `json:"template"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"-"`
+}
+
+// APIKey is a long-lived credential of a user. Only the hash of the key is
+// stored; the prefix is kept in the clear to look keys up and tell them apart.
+// Scopes is a comma separated list.
+type APIKey struct {
+	ID         int64      `db:"id"`
+	UserID     int64      `db:"user_id"`
+	Name       string     `db:"name"`
+	Prefix     string     `db:"prefix"`
+	KeyHash    string     `db:"key_hash"`
+	Scopes     string     `db:"scopes"`
+	CreatedAt  time.Time  `db:"created_at"`
+	ExpiresAt  *time.Time `db:"expires_at"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
+
+type LoginRecord struct {
+	ID        int64     `db:"id"`
+	UserID    int64     `db:"user_id"`
+	ClientIP  string    `db:"client_ip"`
+	UserAgent string    `db:"user_agent"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type EventKind string
+
+const (
+	EventOrderStatus EventKind = "order_status"
+	EventInventory   EventKind = "inventory"
+)
+
+// Event records a change of an order status or of the stock of a product in
+// the same transaction as the change itself. EntityID is the ID of the order
+// or product. IDs increase in the order in which events are inserted.
+type Event struct {
+	ID           int64       `db:"id"`
+	TenantID     int64       `db:"tenant_id"`
+	Kind         EventKind   `db:"kind"`
+	EntityID     int64       `db:"entity_id"`
+	Status       OrderStatus `db:"status"`
+	CountInStock int64       `db:"count_in_stock"`
+	CreatedAt    time.Time   `db:"created_at"`
+}
@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type BrokerConfig struct {
+	// PollInterval is the time between two reads of the event log.
+	PollInterval time.Duration
+	// BatchSize bounds the number of events read at once.
+	BatchSize int
+	// Buffer is the number of events queued for a stream. A stream that
+	// falls further behind reads the event log at its own pace instead.
+	Buffer int
+	// GapTimeout is how long a missing event ID holds back the events after
+	// it. IDs are assigned on insert but become visible on commit, so gaps
+	// usually close quickly; rolled back inserts leave them for good.
+	GapTimeout time.Duration
+}
+
+var DefaultBrokerConfig = BrokerConfig{
+	PollInterval: 500 * time.Millisecond,
+	BatchSize:    500,
+	Buffer:       64,
+	GapTimeout:   5 * time.Second,
+}
+
+var errInvalidResumeToken = errors.New("invalid resume token")
+
+// errResumeTokenExpired is returned for resume tokens older than the event
+// log, whose later events may have been purged. Clients have to watch again
+// without a token to get a fresh snapshot.
+var errResumeTokenExpired = status.Error(codes.OutOfRange, "resume token expired")
+
+// errBrokerStopped ends the streams of a broker that is shutting down.
+// Clients can resume them on another instance.
+var errBrokerStopped = status.Error(codes.Unavailable, "server shutting down")
+
+// Broker polls the event log and fans new events out to watch streams, so
+// that the database is read once per process however many clients watch.
+type Broker struct {
+	server *server.Server
+	config BrokerConfig
+
+	ready    chan struct{}
+	done     chan struct{}
+	mu       sync.Mutex
+	cursor   int64
+	gapSince time.Time
+	subs     map[*subscription]struct{}
+}
+
+func NewBroker(srv *server.Server, config BrokerConfig) *Broker {
+	return &Broker{
+		server: srv,
+		config: config,
+		ready:  make(chan struct{}),
+		done:   make(chan struct{}),
+		subs:   make(map[*subscription]struct{}),
+	}
+}
+
+// Run polls the event log every PollInterval until ctx is cancelled, and
+// then ends all streams. Events written before Run started are only
+// available to resumed streams.
+func (b *Broker) Run(ctx context.Context) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.poll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error polling events: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Broker) poll(ctx context.Context) error {
+	select {
+	case <-b.ready:
+	default:
+		id, err := b.server.GetLastEventID(ctx)
+		if err != nil {
+			return err
+		}
+
+		b.mu.Lock()
+		b.cursor = id
+		b.mu.Unlock()
+		close(b.ready)
+		return nil
+	}
+
+	for {
+		b.mu.Lock()
+		after := b.cursor
+		b.mu.Unlock()
+
+		events, err := b.server.ListEvents(ctx, after, b.config.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		if b.publish(events) < len(events) || len(events) < b.config.BatchSize {
+			return nil
+		}
+	}
+}
+
+// publish hands events to the subscriptions in order and returns how many
+// it got through before stopping at a gap that has not timed out yet.
+func (b *Broker) publish(events []storer.Event) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for i, e := range events {
+		if e.ID != b.cursor+1 {
+			if b.gapSince.IsZero() {
+				b.gapSince = now
+			}
+			if now.Sub(b.gapSince) < b.config.GapTimeout {
+				return i
+			}
+		}
+
+		b.gapSince = time.Time{}
+		b.cursor = e.ID
+		for sub := range b.subs {
+			if e.TenantID != sub.tenantID || !sub.match(&e) {
+				continue
+			}
+
+			select {
+			case sub.events <- e:
+			default:
+				// Never block the broker on a slow stream; it catches up
+				// from the event log once it drains its queue.
+				delete(b.subs, sub)
+				close(sub.events)
+			}
+		}
+	}
+
+	return len(events)
+}
+
+type subscription struct {
+	tenantID int64
+	match    func(*storer.Event) bool
+	events   chan storer.Event
+	// from is the last event published before the subscription started.
+	from int64
+}
+
+func (b *Broker) subscribe(ctx context.Context, tenantID int64, match func(*storer.Event) bool) (*subscription, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-b.done:
+		return nil, errBrokerStopped
+	case <-b.ready:
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscription{
+		tenantID: tenantID,
+		match:    match,
+		events:   make(chan storer.Event, b.config.Buffer),
+		from:     b.cursor,
+	}
+	b.subs[sub] = struct{}{}
+
+	return sub, nil
+}
+
+func (b *Broker) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs, sub)
+}
+
+// watch sends the events of tenantID that match until ctx is cancelled or
+// send fails. Without a resume token it first calls snapshot with the token
+// that the current state corresponds to; with one it sends the events after
+// it instead.
+func (b *Broker) watch(ctx context.Context, tenantID int64, resumeToken string, match func(*storer.Event) bool, snapshot func(resumeToken string) error, send func(*storer.Event) error) error {
+	after, err := decodeResumeToken(resumeToken)
+	if err != nil {
+		return err
+	}
+
+	for {
+		sub, err := b.subscribe(ctx, tenantID, match)
+		if err != nil {
+			return err
+		}
+
+		if resumeToken == "" {
+			resumeToken = encodeResumeToken(sub.from)
+			after = sub.from
+			err = snapshot(resumeToken)
+		}
+		if err == nil {
+			after, err = b.catchUp(ctx, tenantID, after, sub.from, match, send)
+		}
+		if err == nil {
+			after, err = b.follow(ctx, sub, after, send)
+		}
+		b.unsubscribe(sub)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// catchUp sends the matching events after the given ID up to and including
+// until from the event log. It fails with errResumeTokenExpired if events
+// after the given ID were already purged.
+func (b *Broker) catchUp(ctx context.Context, tenantID, after, until int64, match func(*storer.Event) bool, send func(*storer.Event) error) (int64, error) {
+	if after < until {
+		first, err := b.server.GetFirstEventID(ctx)
+		if err != nil {
+			return after, err
+		}
+		if first == 0 || after+1 < first {
+			return after, errResumeTokenExpired
+		}
+	}
+
+	for after < until {
+		events, err := b.server.ListEvents(ctx, after, b.config.BatchSize)
+		if err != nil {
+			return after, err
+		}
+		if len(events) == 0 {
+			return until, nil
+		}
+
+		for _, e := range events {
+			if e.ID > until {
+				return until, nil
+			}
+
+			if e.TenantID == tenantID && match(&e) {
+				if err := send(&e); err != nil {
+					return after, err
+				}
+			}
+			after = e.ID
+		}
+	}
+
+	return after, nil
+}
+
+// follow sends the events of sub until the broker drops it for falling
+// behind, which it reports with a nil error.
+func (b *Broker) follow(ctx context.Context, sub *subscription, after int64, send func(*storer.Event) error) (int64, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return after, ctx.Err()
+		case <-b.done:
+			return after, errBrokerStopped
+		case e, ok := <-sub.events:
+			if !ok {
+				return after, nil
+			}
+			if e.ID <= after {
+				continue
+			}
+
+			if err := send(&e); err != nil {
+				return after, err
+			}
+			after = e.ID
+		}
+	}
+}
+
+// Resume tokens are opaque to clients so that their format can change.
+func encodeResumeToken(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeResumeToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, errInvalidResumeToken
+	}
+
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || id < 0 {
+		return 0, errInvalidResumeToken
+	}
+
+	return id, nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer/storertest"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// gapStorer hides the events in hidden from ListEvents, as if their
+// transactions had not committed yet.
+type gapStorer struct {
+	*storertest.Storer
+	hidden map[int64]bool
+}
+
+func (gs *gapStorer) ListEvents(ctx context.Context, afterID int64, limit int) ([]storer.Event, error) {
+	events, err := gs.Storer.ListEvents(ctx, afterID, limit)
+	var visible []storer.Event
+	for _, e := range events {
+		if !gs.hidden[e.ID] {
+			visible = append(visible, e)
+		}
+	}
+	return visible, err
+}
+
+func setStock(t *testing.T, st storer.Storer, p *storer.Product, count int64) {
+	p.CountInStock = count
+	_, err := st.UpdateProduct(context.Background(), p, 0)
+	require.NoError(t, err)
+}
+
+func newTestBroker(t *testing.T, st storer.Storer, config BrokerConfig) *Broker {
+	b := NewBroker(server.NewServer(st), config)
+	require.NoError(t, b.poll(context.Background()))
+	return b
+}
+
+func anyInventory(e *storer.Event) bool { return e.Kind == storer.EventInventory }
+
+func TestBrokerSlowStreamCatchesUp(t *testing.T) {
+	st := storertest.New()
+	p, err := st.CreateProduct(context.Background(), &storer.Product{Name: "test product"})
+	require.NoError(t, err)
+
+	b := newTestBroker(t, st, BrokerConfig{BatchSize: 2, Buffer: 1, GapTimeout: time.Minute})
+	sub, err := b.subscribe(context.Background(), storer.DefaultTenantID, anyInventory)
+	require.NoError(t, err)
+
+	for i := int64(1); i <= 5; i++ {
+		setStock(t, st, p, i)
+	}
+	require.NoError(t, b.poll(context.Background()))
+
+	// The queue overflowed, so the broker dropped the subscription rather
+	// than block on it.
+	var queued []int64
+	for e := range sub.events {
+		queued = append(queued, e.CountInStock)
+	}
+	require.Equal(t, []int64{1}, queued)
+
+	var counts []int64
+	send := func(e *storer.Event) error {
+		counts = append(counts, e.CountInStock)
+		return nil
+	}
+	after, err := b.catchUp(context.Background(), storer.DefaultTenantID, 1, b.cursor, anyInventory, send)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), after)
+	require.Equal(t, []int64{2, 3, 4, 5}, counts)
+}
+
+func TestBrokerWaitsForGaps(t *testing.T) {
+	gs := &gapStorer{Storer: storertest.New(), hidden: map[int64]bool{2: true}}
+	p, err := gs.CreateProduct(context.Background(), &storer.Product{Name: "test product"})
+	require.NoError(t, err)
+
+	b := newTestBroker(t, gs, BrokerConfig{BatchSize: 10, Buffer: 10, GapTimeout: time.Hour})
+	sub, err := b.subscribe(context.Background(), storer.DefaultTenantID, anyInventory)
+	require.NoError(t, err)
+
+	for i := int64(1); i <= 3; i++ {
+		setStock(t, gs, p, i)
+	}
+
+	require.NoError(t, b.poll(context.Background()))
+	require.Equal(t, int64(1), b.cursor, "event 3 is held back until event 2 commits")
+
+	delete(gs.hidden, 2)
+	require.NoError(t, b.poll(context.Background()))
+	require.Equal(t, int64(3), b.cursor)
+
+	var counts []int64
+	for len(sub.events) > 0 {
+		counts = append(counts, (<-sub.events).CountInStock)
+	}
+	require.Equal(t, []int64{1, 2, 3}, counts)
+
+	// Gaps that never close are skipped after GapTimeout.
+	gs.hidden[4] = true
+	setStock(t, gs, p, 4)
+	setStock(t, gs, p, 5)
+	require.NoError(t, b.poll(context.Background()))
+	require.Equal(t, int64(3), b.cursor)
+
+	b.config.GapTimeout = 0
+	require.NoError(t, b.poll(context.Background()))
+	require.Equal(t, int64(5), b.cursor)
+	require.Equal(t, int64(5), (<-sub.events).CountInStock)
+}
+
+func TestBrokerCatchUpAfterPurge(t *testing.T) {
+	st := storertest.New()
+	p, err := st.CreateProduct(context.Background(), &storer.Product{Name: "test product"})
+	require.NoError(t, err)
+
+	for i := int64(1); i <= 3; i++ {
+		setStock(t, st, p, i)
+	}
+	events, err := st.ListEvents(context.Background(), 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	b := newTestBroker(t, st, BrokerConfig{BatchSize: 10, Buffer: 10, GapTimeout: time.Minute})
+	n, err := st.DeleteEventsBefore(context.Background(), time.Now().Add(time.Second), 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	var counts []int64
+	send := func(e *storer.Event) error {
+		counts = append(counts, e.CountInStock)
+		return nil
+	}
+
+	// The event after the first one is gone, so resuming there would
+	// silently skip it.
+	_, err = b.catchUp(context.Background(), storer.DefaultTenantID, events[0].ID, b.cursor, anyInventory, send)
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+	require.Empty(t, counts)
+
+	after, err := b.catchUp(context.Background(), storer.DefaultTenantID, events[1].ID, b.cursor, anyInventory, send)
+	require.NoError(t, err)
+	require.Equal(t, events[2].ID, after)
+	require.Equal(t, []int64{3}, counts)
+}
+
+func TestBrokerStopEndsStreams(t *testing.T) {
+	b := NewBroker(server.NewServer(storertest.New()), BrokerConfig{PollInterval: time.Millisecond, BatchSize: 10, Buffer: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	go b.Run(ctx)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- b.watch(context.Background(), storer.DefaultTenantID, "", anyInventory,
+			func(string) error { return nil },
+			func(*storer.Event) error { return nil })
+	}()
+
+	cancel()
+	select {
+	case err := <-errc:
+		require.ErrorIs(t, err, errBrokerStopped)
+	case <-time.After(time.Second):
+		t.Fatal("watch did not return after the broker stopped")
+	}
+}
+
+func TestResumeToken(t *testing.T) {
+	id, err := decodeResumeToken(encodeResumeToken(42))
+	require.NoError(t, err)
+	require.Equal(t, int64(42), id)
+
+	for _, token := range []string{"!", encodeResumeToken(-1), "YWJj"} {
+		_, err = decodeResumeToken(token)
+		require.ErrorIs(t, err, errInvalidResumeToken, token)
+	}
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-grpc/pb"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// access is the least a caller needs to be to call a method.
+type access int
+
+const (
+	accessPublic access = iota
+	accessAuthenticated
+	accessAdmin
+)
+
+// methodAccess lists the access of every method of the micropanel services.
+// Methods of other services are public if their service is listed in
+// publicServices and admin only otherwise, so that methods added without an
+// entry here fail closed.
+var methodAccess = map[string]access{
+	pb.ProductService_CreateProduct_FullMethodName:  accessAdmin,
+	pb.ProductService_GetProduct_FullMethodName:     accessPublic,
+	pb.ProductService_ListProducts_FullMethodName:   accessPublic,
+	pb.ProductService_UpdateProduct_FullMethodName:  accessAdmin,
+	pb.ProductService_DeleteProduct_FullMethodName:  accessAdmin,
+	pb.ProductService_WatchInventory_FullMethodName: accessPublic,
+
+	// Users other than admins may only get, list, delete and watch their own
+	// orders.
+	pb.OrderService_CreateOrder_FullMethodName:       accessAuthenticated,
+	pb.OrderService_GetOrder_FullMethodName:          accessAuthenticated,
+	pb.OrderService_ListOrders_FullMethodName:        accessAuthenticated,
+	pb.OrderService_DeleteOrder_FullMethodName:       accessAuthenticated,
+	pb.OrderService_UpdateOrderStatus_FullMethodName: accessAdmin,
+	pb.OrderService_WatchOrder_FullMethodName:        accessAuthenticated,
+
+	// Users other than admins may only get, update and delete themselves.
+	pb.UserService_CreateUser_FullMethodName: accessAdmin,
+	pb.UserService_GetUser_FullMethodName:    accessAuthenticated,
+	pb.UserService_ListUsers_FullMethodName:  accessAdmin,
+	pb.UserService_UpdateUser_FullMethodName: accessAuthenticated,
+	pb.UserService_DeleteUser_FullMethodName: accessAuthenticated,
+}
+
+var publicServices = []string{
+	"grpc.health.v1.Health",
+	"grpc.reflection.v1.ServerReflection",
+	"grpc.reflection.v1alpha.ServerReflection",
+}
+
+func accessOf(fullMethod string) access {
+	if a, ok := methodAccess[fullMethod]; ok {
+		return a
+	}
+
+	service, _, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	for _, s := range publicServices {
+		if service == s {
+			return accessPublic
+		}
+	}
+	return accessAdmin
+}
+
+const requestIDKey = "x-request-id"
+
+type (
+	authKey      struct{}
+	requestIDCtx struct{}
+)
+
+func claimsFromContext(ctx context.Context) (*token.UserClaims, bool) {
+	claims, ok := ctx.Value(authKey{}).(*token.UserClaims)
+	return claims, ok
+}
+
+// RequestID returns the ID that the calls made with ctx are logged with.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtx{}).(string)
+	return id
+}
+
+// interceptor holds what the unary and the stream interceptors of a server
+// share.
+type interceptor struct {
+	server  *server.Server
+	config  Config
+	metrics *metrics
+}
+
+func (i *interceptor) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var res interface{}
+	err := i.handle(ctx, info.FullMethod, grpc.SetHeader, func(ctx context.Context) error {
+		var err error
+		res, err = handler(ctx, req)
+		return err
+	})
+
+	return res, err
+}
+
+func (i *interceptor) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	setHeader := func(_ context.Context, md metadata.MD) error { return ss.SetHeader(md) }
+	return i.handle(ss.Context(), info.FullMethod, setHeader, func(ctx context.Context) error {
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	})
+}
+
+// handle tags the call with a request ID, records and logs its outcome,
+// recovers from panics and authenticates the caller before calling call.
+func (i *interceptor) handle(ctx context.Context, method string, setHeader func(context.Context, metadata.MD) error, call func(context.Context) error) error {
+	start := time.Now()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := first(md, requestIDKey)
+	if id == "" {
+		id = uuid.NewString()
+	}
+	ctx = context.WithValue(ctx, requestIDCtx{}, id)
+	setHeader(ctx, metadata.Pairs(requestIDKey, id))
+
+	done := i.metrics.start(method)
+	err := i.recover(ctx, method, func() error {
+		ctx, err := i.authenticate(ctx, method, md)
+		if err != nil {
+			return err
+		}
+
+		return call(ctx)
+	})
+	code := status.Code(err)
+	done(code, time.Since(start))
+
+	log.Printf("[%s] %s %s %s", id, method, code, time.Since(start).Round(time.Microsecond))
+	return err
+}
+
+func (i *interceptor) recover(ctx context.Context, method string, call func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[%s] Panic in %s: %v\n%s", RequestID(ctx), method, r, debug.Stack())
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+
+	return call()
+}
+
+// authenticate verifies the bearer token in the authorization metadata, if
+// any, scopes ctx to a tenant the same way the REST API does and checks that
+// the caller may call method.
+func (i *interceptor) authenticate(ctx context.Context, method string, md metadata.MD) (context.Context, error) {
+	ctx, err := i.resolveTenant(ctx, md)
+	if err != nil {
+		return nil, err
+	}
+
+	if header := first(md, "authorization"); header != "" {
+		fields := strings.Fields(header)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") || i.config.TokenMaker == nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid authorization metadata")
+		}
+
+		claims, err := i.config.TokenMaker.VerifyToken(fields[1], token.AccessToken)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		denied, err := i.config.Denylist.IsTokenDenied(ctx, claims.RegisteredClaims.ID)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "error checking token")
+		}
+		if denied {
+			return nil, status.Error(codes.Unauthenticated, "token revoked")
+		}
+
+		ctx, err = tokenTenant(ctx, claims)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		if err := i.server.TouchSession(ctx, claims.RegisteredClaims.ID); err != nil {
+			return nil, status.Error(codes.Internal, "error checking token")
+		}
+		ctx = context.WithValue(ctx, authKey{}, claims)
+	}
+
+	claims, ok := claimsFromContext(ctx)
+	switch accessOf(method) {
+	case accessAuthenticated:
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "missing authorization metadata")
+		}
+	case accessAdmin:
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "missing authorization metadata")
+		}
+		if !claims.IsAdmin {
+			return nil, status.Error(codes.PermissionDenied, "admin privileges required")
+		}
+	}
+
+	return ctx, nil
+}
+
+var errTenantMismatch = errors.New("token issued for another tenant")
+
+// resolveTenant scopes ctx to the tenant named by the tenant metadata or,
+// without one, to the tenant registered for the authority of the call.
+func (i *interceptor) resolveTenant(ctx context.Context, md metadata.MD) (context.Context, error) {
+	var slug string
+	if i.config.TenantHeader != "" {
+		slug = first(md, strings.ToLower(i.config.TenantHeader))
+	}
+
+	var t *storer.Tenant
+	var err error
+	if slug != "" {
+		t, err = i.server.GetTenantBySlug(ctx, slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "unknown tenant")
+		}
+	} else {
+		host, _, _ := strings.Cut(first(md, ":authority"), ":")
+		t, err = i.server.GetTenantByHost(ctx, strings.ToLower(host))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ctx, nil
+		}
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "error resolving tenant")
+	}
+
+	return storer.WithTenant(ctx, t.ID), nil
+}
+
+// tokenTenant scopes ctx to the tenant that claims were issued for. It fails
+// with errTenantMismatch if the call was resolved to another tenant.
+func tokenTenant(ctx context.Context, claims *token.UserClaims) (context.Context, error) {
+	id := claims.TenantID
+	if id == 0 {
+		id = storer.DefaultTenantID
+	}
+
+	if resolved, ok := storer.TenantFromContext(ctx); ok && resolved != id {
+		return nil, errTenantMismatch
+	}
+
+	return storer.WithTenant(ctx, id), nil
+}
+
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// MethodStats are the calls of a method since the server started. Streams
+// count as a single call that lasts until the stream ends.
+type MethodStats struct {
+	Calls      uint64            `json:"calls"`
+	Active     int64             `json:"active"`
+	Codes      map[string]uint64 `json:"codes"`
+	DurationMS int64             `json:"duration_ms"`
+}
+
+// metrics counts the calls of every method, by status code.
+type metrics struct {
+	mu      sync.Mutex
+	methods map[string]*MethodStats
+}
+
+func newMetrics() *metrics {
+	return &metrics{methods: make(map[string]*MethodStats)}
+}
+
+func (m *metrics) start(method string) func(codes.Code, time.Duration) {
+	m.mu.Lock()
+	stats, ok := m.methods[method]
+	if !ok {
+		stats = &MethodStats{Codes: make(map[string]uint64)}
+		m.methods[method] = stats
+	}
+	stats.Active++
+	m.mu.Unlock()
+
+	return func(code codes.Code, d time.Duration) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		stats.Active--
+		stats.Calls++
+		stats.Codes[code.String()]++
+		stats.DurationMS += d.Milliseconds()
+	}
+}
+
+func (m *metrics) stats() map[string]MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make(map[string]MethodStats, len(m.methods))
+	for method, stats := range m.methods {
+		s := *stats
+		s.Codes = make(map[string]uint64, len(stats.Codes))
+		for code, n := range stats.Codes {
+			s.Codes[code] = n
+		}
+		res[method] = s
+	}
+
+	return res
+}
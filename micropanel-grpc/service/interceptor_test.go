@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer/storertest"
+	"github.com/Turtel216/micro-panel/micropanel-grpc/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInterceptorRecovers(t *testing.T) {
+	i := &interceptor{server: server.NewServer(storertest.New()), metrics: newMetrics()}
+	info := &grpc.UnaryServerInfo{FullMethod: pb.ProductService_ListProducts_FullMethodName}
+
+	_, err := i.unary(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("test panic")
+	})
+	require.Equal(t, codes.Internal, status.Code(err))
+
+	stats := i.metrics.stats()[info.FullMethod]
+	require.Equal(t, map[string]uint64{"Internal": 1}, stats.Codes)
+	require.Zero(t, stats.Active)
+}
+
+func TestAccessOf(t *testing.T) {
+	require.Equal(t, accessPublic, accessOf(pb.ProductService_ListProducts_FullMethodName))
+	require.Equal(t, accessAdmin, accessOf(pb.OrderService_UpdateOrderStatus_FullMethodName))
+	require.Equal(t, accessPublic, accessOf("/grpc.health.v1.Health/Check"))
+	require.Equal(t, accessAdmin, accessOf("/micropanel.OrderService/Unlisted"))
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-grpc/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var statusInvalidOrderStatus = status.Error(codes.InvalidArgument, "status must be pending, shipped or delivered")
+
+type orderService struct {
+	pb.UnimplementedOrderServiceServer
+	server *server.Server
+	broker *Broker
+}
+
+func (s *orderService) CreateOrder(ctx context.Context, req *pb.OrderReq) (*pb.OrderRes, error) {
+	if len(req.GetItems()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "missing items")
+	}
+
+	claims, _ := claimsFromContext(ctx)
+	order := toStorerOrder(req)
+	order.UserID = claims.ID
+
+	order, err := s.server.CreateOrder(ctx, order)
+	if err != nil {
+		return nil, toStatus(err, "error creating order")
+	}
+
+	return toOrderRes(order), nil
+}
+
+func (s *orderService) GetOrder(ctx context.Context, req *pb.GetOrderReq) (*pb.OrderRes, error) {
+	order, err := s.server.GetOrder(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err, "error getting order")
+	}
+
+	if err := checkSelf(ctx, order.UserID); err != nil {
+		return nil, err
+	}
+
+	return toOrderRes(order), nil
+}
+
+func (s *orderService) ListOrders(ctx context.Context, _ *emptypb.Empty) (*pb.ListOrderRes, error) {
+	orders, err := s.server.ListOrder(ctx)
+	if err != nil {
+		return nil, toStatus(err, "error listing orders")
+	}
+
+	// Users other than admins only see the orders they placed.
+	claims, _ := claimsFromContext(ctx)
+	res := &pb.ListOrderRes{}
+	for _, o := range orders {
+		if !claims.IsAdmin && o.UserID != claims.ID {
+			continue
+		}
+		res.Orders = append(res.Orders, toOrderRes(&o))
+	}
+
+	return res, nil
+}
+
+func (s *orderService) DeleteOrder(ctx context.Context, req *pb.DeleteOrderReq) (*emptypb.Empty, error) {
+	order, err := s.server.GetOrder(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err, "error getting order")
+	}
+
+	if err := checkSelf(ctx, order.UserID); err != nil {
+		return nil, err
+	}
+
+	if err := s.server.DeleteOrder(ctx, req.GetId()); err != nil {
+		return nil, toStatus(err, "error deleting order")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *orderService) UpdateOrderStatus(ctx context.Context, req *pb.UpdateOrderStatusReq) (*pb.OrderRes, error) {
+	orderStatus := storer.OrderStatus(req.GetStatus())
+	if !validOrderStatus(orderStatus) {
+		return nil, statusInvalidOrderStatus
+	}
+
+	order, err := s.server.UpdateOrderStatus(ctx, req.GetId(), orderStatus)
+	if err != nil {
+		return nil, toStatus(err, "error updating order status")
+	}
+
+	return toOrderRes(order), nil
+}
+
+func (s *orderService) WatchOrder(req *pb.WatchOrderReq, stream pb.OrderService_WatchOrderServer) error {
+	if s.broker == nil {
+		return errWatchDisabled
+	}
+
+	ctx := stream.Context()
+	order, err := s.server.GetOrder(ctx, req.GetOrderId())
+	if err != nil {
+		return toStatus(err, "error getting order")
+	}
+
+	if err := checkSelf(ctx, order.UserID); err != nil {
+		return err
+	}
+
+	match := func(e *storer.Event) bool {
+		return e.Kind == storer.EventOrderStatus && e.EntityID == order.ID
+	}
+	snapshot := func(resumeToken string) error {
+		order, err := s.server.GetOrder(ctx, order.ID)
+		if err != nil {
+			return err
+		}
+
+		return stream.Send(&pb.OrderEvent{
+			OrderId:     order.ID,
+			Status:      string(order.Status),
+			Time:        timestamppb.Now(),
+			ResumeToken: resumeToken,
+		})
+	}
+
+	err = s.broker.watch(ctx, storer.TenantID(ctx), req.GetResumeToken(), match, snapshot, func(e *storer.Event) error {
+		return stream.Send(toOrderEvent(e))
+	})
+	return toStatus(err, "error watching order")
+}
+
+func validOrderStatus(status storer.OrderStatus) bool {
+	switch status {
+	case storer.Pending, storer.Shipped, storer.Delivered:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-grpc/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type productService struct {
+	pb.UnimplementedProductServiceServer
+	server *server.Server
+	broker *Broker
+}
+
+func (s *productService) CreateProduct(ctx context.Context, req *pb.ProductReq) (*pb.ProductRes, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing name")
+	}
+
+	product, err := s.server.CreateProduct(ctx, toStorerProduct(req))
+	if err != nil {
+		return nil, toStatus(err, "error creating product")
+	}
+
+	return toProductRes(product), nil
+}
+
+func (s *productService) GetProduct(ctx context.Context, req *pb.GetProductReq) (*pb.ProductRes, error) {
+	product, err := s.server.GetProduct(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err, "error getting product")
+	}
+
+	return toProductRes(product), nil
+}
+
+func (s *productService) ListProducts(ctx context.Context, _ *emptypb.Empty) (*pb.ListProductRes, error) {
+	products, err := s.server.ListProducts(ctx)
+	if err != nil {
+		return nil, toStatus(err, "error listing products")
+	}
+
+	res := &pb.ListProductRes{}
+	for _, p := range products {
+		res.Products = append(res.Products, toProductRes(&p))
+	}
+
+	return res, nil
+}
+
+func (s *productService) UpdateProduct(ctx context.Context, req *pb.ProductReq) (*pb.ProductRes, error) {
+	product, err := s.server.GetProduct(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err, "error getting product")
+	}
+
+	patchProductReq(product, req)
+
+	updated, err := s.server.UpdateProduct(ctx, product, 0)
+	if err != nil {
+		return nil, toStatus(err, "error updating product")
+	}
+
+	return toProductRes(updated), nil
+}
+
+func (s *productService) DeleteProduct(ctx context.Context, req *pb.DeleteProductReq) (*emptypb.Empty, error) {
+	if _, err := s.server.GetProduct(ctx, req.GetId()); err != nil {
+		return nil, toStatus(err, "error getting product")
+	}
+
+	if err := s.server.DeleteProduct(ctx, req.GetId(), 0); err != nil {
+		return nil, toStatus(err, "error deleting product")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *productService) WatchInventory(req *pb.WatchInventoryReq, stream pb.ProductService_WatchInventoryServer) error {
+	if s.broker == nil {
+		return errWatchDisabled
+	}
+
+	ids := make(map[int64]bool)
+	for _, id := range req.GetProductIds() {
+		ids[id] = true
+	}
+
+	ctx := stream.Context()
+	match := func(e *storer.Event) bool {
+		return e.Kind == storer.EventInventory && (len(ids) == 0 || ids[e.EntityID])
+	}
+	snapshot := func(resumeToken string) error {
+		products, err := s.server.ListProducts(ctx)
+		if err != nil {
+			return err
+		}
+
+		now := timestamppb.Now()
+		for _, p := range products {
+			if len(ids) > 0 && !ids[p.ID] {
+				continue
+			}
+
+			err := stream.Send(&pb.InventoryEvent{
+				ProductId:    p.ID,
+				CountInStock: p.CountInStock,
+				Time:         now,
+				ResumeToken:  resumeToken,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := s.broker.watch(ctx, storer.TenantID(ctx), req.GetResumeToken(), match, snapshot, func(e *storer.Event) error {
+		return stream.Send(toInventoryEvent(e))
+	})
+	return toStatus(err, "error watching inventory")
+}
@@ -0,0 +1,132 @@
+// Package service implements the micropanel gRPC services on top of the same
+// server.Server that backs the REST API.
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-grpc/pb"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/Turtel216/micro-panel/util"
+	"github.com/go-sql-driver/mysql"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+type Config struct {
+	// TokenMaker verifies the access tokens sent as "authorization: Bearer"
+	// metadata. Without one only public methods can be called.
+	TokenMaker token.Maker
+	// Denylist holds access tokens revoked before they expire. Defaults to
+	// a token.MemoryDenylist, which is not shared with the REST API.
+	Denylist token.Denylist
+	// TenantHeader is the metadata key selecting the tenant by slug ahead of
+	// the authority of a call. Empty disables it.
+	TenantHeader string
+
+	// PasswordHasher hashes the passwords of created and updated users.
+	// Defaults to util.DefaultPasswordHasher.
+	PasswordHasher util.PasswordHasher
+	PasswordPolicy util.PasswordPolicy
+	// EmailVerificationTTL is the lifetime of the verification tokens sent
+	// to created users. Defaults to DefaultEmailVerificationTTL.
+	EmailVerificationTTL time.Duration
+
+	// Broker serves the watch streams, which are unavailable without one.
+	Broker *Broker
+}
+
+// Server is a gRPC server with the product, order and user services, health
+// checking and reflection. Every call is authenticated, logged and counted.
+type Server struct {
+	*grpc.Server
+	health  *health.Server
+	metrics *metrics
+}
+
+func NewServer(srv *server.Server, config Config, opts ...grpc.ServerOption) *Server {
+	if config.PasswordHasher == nil {
+		config.PasswordHasher = util.DefaultPasswordHasher
+	}
+	if config.Denylist == nil {
+		config.Denylist = token.NewMemoryDenylist()
+	}
+	if config.EmailVerificationTTL <= 0 {
+		config.EmailVerificationTTL = DefaultEmailVerificationTTL
+	}
+
+	i := &interceptor{server: srv, config: config, metrics: newMetrics()}
+	opts = append(opts, grpc.ChainUnaryInterceptor(i.unary), grpc.ChainStreamInterceptor(i.stream))
+	s := &Server{
+		Server:  grpc.NewServer(opts...),
+		health:  health.NewServer(),
+		metrics: i.metrics,
+	}
+
+	pb.RegisterProductServiceServer(s, &productService{server: srv, broker: config.Broker})
+	pb.RegisterOrderServiceServer(s, &orderService{server: srv, broker: config.Broker})
+	pb.RegisterUserServiceServer(s, &userService{server: srv, config: config})
+	healthpb.RegisterHealthServer(s, s.health)
+	reflection.Register(s)
+
+	for name := range s.GetServiceInfo() {
+		s.health.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+
+	return s
+}
+
+// Stats returns the calls of every method that was called so far.
+func (s *Server) Stats() map[string]MethodStats {
+	return s.metrics.stats()
+}
+
+// GracefulStop reports the server as not serving to health checks before it
+// stops accepting calls and waits for the pending ones.
+func (s *Server) GracefulStop() {
+	s.health.Shutdown()
+	s.Server.GracefulStop()
+}
+
+// DefaultEmailVerificationTTL matches the default of the REST API.
+const DefaultEmailVerificationTTL = 48 * time.Hour
+
+const mysqlDuplicateEntry = 1062
+
+var errWatchDisabled = status.Error(codes.Unavailable, "watching is disabled")
+
+// toStatus converts a storer error into a status with a matching code. msg
+// describes the failed operation and is all that is sent for internal
+// errors. Errors that already carry a status are returned as they are.
+func toStatus(err error, msg string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case errors.Is(err, errInvalidResumeToken):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storer.ErrUnknownProduct):
+		return status.Error(codes.InvalidArgument, "unknown product")
+	case errors.Is(err, sql.ErrNoRows):
+		return status.Error(codes.NotFound, "not found")
+	case errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry:
+		return status.Error(codes.AlreadyExists, "already exists")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, msg)
+	}
+}
@@ -0,0 +1,775 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer/storertest"
+	"github.com/Turtel216/micro-panel/micropanel-grpc/pb"
+	"github.com/Turtel216/micro-panel/micropanel-grpc/service"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/Turtel216/micro-panel/util"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+var tokenMaker = token.NewJWTMaker("01234567890123456789012345678901")
+
+func newToken(t *testing.T, id int64, isAdmin bool, tenantID int64) (string, *token.UserClaims) {
+	t.Helper()
+
+	tok, claims, err := tokenMaker.CreateToken(id, "user@example.com", isAdmin, tenantID, token.AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	return tok, claims
+}
+
+type anonymousKey struct{}
+
+// anonymous makes the calls made with ctx without authorization metadata.
+func anonymous(ctx context.Context) context.Context {
+	return context.WithValue(ctx, anonymousKey{}, true)
+}
+
+// withToken authenticates the calls made with ctx as the given user.
+func withToken(t *testing.T, ctx context.Context, id int64, isAdmin bool, tenantID int64) context.Context {
+	t.Helper()
+
+	tok, _ := newToken(t, id, isAdmin, tenantID)
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+tok)
+}
+
+// dial serves the services over an in-memory listener backed by a fresh
+// storertest.Storer, with a broker that polls for events every 10ms. Calls
+// made without authorization metadata are made as an admin of the default
+// tenant.
+func dial(t *testing.T) (*grpc.ClientConn, *storertest.Storer) {
+	t.Helper()
+
+	conn, st, _ := dialServer(t, service.Config{})
+	return conn, st
+}
+
+func dialServer(t *testing.T, config service.Config) (*grpc.ClientConn, *storertest.Storer, *service.Server) {
+	t.Helper()
+
+	st := storertest.New()
+	srv := server.NewServer(st)
+
+	broker := service.NewBroker(srv, service.BrokerConfig{
+		PollInterval: 10 * time.Millisecond,
+		BatchSize:    100,
+		Buffer:       16,
+		GapTimeout:   time.Second,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go broker.Run(ctx)
+	t.Cleanup(cancel)
+
+	config.TokenMaker = tokenMaker
+	config.PasswordPolicy = util.PasswordPolicy{MinLength: 8}
+	config.Broker = broker
+	gs := service.NewServer(srv, config)
+
+	lis := bufconn.Listen(1 << 20)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	admin, _ := newToken(t, 1, true, storer.DefaultTenantID)
+	authorize := func(ctx context.Context) context.Context {
+		if ctx.Value(anonymousKey{}) != nil {
+			return ctx
+		}
+		if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get("authorization")) == 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+admin)
+		}
+		return ctx
+	}
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(authorize(ctx), method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(authorize(ctx), desc, cc, method, opts...)
+		}),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, st, gs
+}
+
+func requireCode(t *testing.T, code codes.Code, err error) {
+	t.Helper()
+
+	require.Error(t, err)
+	require.Equal(t, code, status.Code(err), err.Error())
+}
+
+func TestProductService(t *testing.T) {
+	conn, st := dial(t)
+	client := pb.NewProductServiceClient(conn)
+	ctx := context.Background()
+
+	created, err := client.CreateProduct(ctx, &pb.ProductReq{Name: "test product", Price: 9.99, CountInStock: 5})
+	require.NoError(t, err)
+	require.NotZero(t, created.GetId())
+
+	_, err = client.CreateProduct(ctx, &pb.ProductReq{})
+	requireCode(t, codes.InvalidArgument, err)
+
+	got, err := client.GetProduct(ctx, &pb.GetProductReq{Id: created.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, "test product", got.GetName())
+	require.Equal(t, int64(5), got.GetCountInStock())
+
+	updated, err := client.UpdateProduct(ctx, &pb.ProductReq{Id: created.GetId(), CountInStock: 3})
+	require.NoError(t, err)
+	require.Equal(t, "test product", updated.GetName())
+	require.Equal(t, int64(3), updated.GetCountInStock())
+	require.NotNil(t, updated.GetUpdatedAt())
+
+	list, err := client.ListProducts(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, list.GetProducts(), 1)
+
+	_, err = client.DeleteProduct(ctx, &pb.DeleteProductReq{Id: created.GetId()})
+	require.NoError(t, err)
+
+	_, err = client.GetProduct(ctx, &pb.GetProductReq{Id: created.GetId()})
+	requireCode(t, codes.NotFound, err)
+	_, err = client.UpdateProduct(ctx, &pb.ProductReq{Id: created.GetId(), Name: "gone"})
+	requireCode(t, codes.NotFound, err)
+	_, err = client.DeleteProduct(ctx, &pb.DeleteProductReq{Id: created.GetId()})
+	requireCode(t, codes.NotFound, err)
+
+	st.FailOn("ListProducts", errors.New("connection refused"))
+	_, err = client.ListProducts(ctx, &emptypb.Empty{})
+	requireCode(t, codes.Internal, err)
+	require.NotContains(t, err.Error(), "connection refused")
+}
+
+func TestOrderService(t *testing.T) {
+	conn, st := dial(t)
+	client := pb.NewOrderServiceClient(conn)
+	ctx := context.Background()
+
+	_, err := client.CreateOrder(ctx, &pb.OrderReq{PaymentMethod: "card"})
+	requireCode(t, codes.InvalidArgument, err)
+
+	_, err = client.CreateOrder(ctx, &pb.OrderReq{
+		PaymentMethod: "card",
+		Items:         []*pb.OrderItem{{Name: "test product", Quantity: 1, Price: 9.99, ProductId: 99}},
+	})
+	requireCode(t, codes.InvalidArgument, err)
+
+	p, err := st.CreateProduct(ctx, &storer.Product{Name: "test product", Price: 9.99})
+	require.NoError(t, err)
+
+	created, err := client.CreateOrder(ctx, &pb.OrderReq{
+		PaymentMethod: "card",
+		TotalPrice:    19.98,
+		Items:         []*pb.OrderItem{{Name: "test product", Quantity: 2, Price: 9.99, ProductId: p.ID}},
+	})
+	require.NoError(t, err)
+
+	got, err := client.GetOrder(ctx, &pb.GetOrderReq{Id: created.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, "card", got.GetPaymentMethod())
+	require.Len(t, got.GetItems(), 1)
+	require.Equal(t, int64(2), got.GetItems()[0].GetQuantity())
+
+	stored, err := st.GetOrder(ctx, created.GetId())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stored.UserID)
+
+	list, err := client.ListOrders(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, list.GetOrders(), 1)
+
+	_, err = client.DeleteOrder(ctx, &pb.DeleteOrderReq{Id: created.GetId()})
+	require.NoError(t, err)
+
+	_, err = client.GetOrder(ctx, &pb.GetOrderReq{Id: created.GetId()})
+	requireCode(t, codes.NotFound, err)
+}
+
+func TestUserService(t *testing.T) {
+	conn, st := dial(t)
+	client := pb.NewUserServiceClient(conn)
+	ctx := context.Background()
+
+	_, err := client.CreateUser(ctx, &pb.UserReq{Email: "test@example.com", Password: "short"})
+	requireCode(t, codes.InvalidArgument, err)
+
+	created, err := client.CreateUser(ctx, &pb.UserReq{Name: "test", Email: "test@example.com", Password: "password"})
+	require.NoError(t, err)
+
+	u, err := st.GetUserByID(ctx, created.GetId())
+	require.NoError(t, err)
+	require.NoError(t, util.CheckPassword("password", u.Password))
+
+	notifications := st.Notifications()
+	require.Len(t, notifications, 1)
+	require.Equal(t, storer.UserTokenEmailVerification, notifications[0].Template)
+	require.Equal(t, "test@example.com", notifications[0].Recipient)
+
+	updated, err := client.UpdateUser(ctx, &pb.UpdateUserReq{Id: created.GetId(), User: &pb.UserReq{Name: "renamed", Password: "new password"}})
+	require.NoError(t, err)
+	require.Equal(t, "renamed", updated.GetName())
+	require.Equal(t, "test@example.com", updated.GetEmail())
+
+	u, err = st.GetUserByID(ctx, created.GetId())
+	require.NoError(t, err)
+	require.NoError(t, util.CheckPassword("new password", u.Password))
+
+	list, err := client.ListUsers(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, list.GetUsers(), 1)
+
+	st.FailOn("CreateUser", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	_, err = client.CreateUser(ctx, &pb.UserReq{Email: "test@example.com", Password: "password"})
+	requireCode(t, codes.AlreadyExists, err)
+
+	_, err = client.DeleteUser(ctx, &pb.DeleteUserReq{Id: created.GetId()})
+	require.NoError(t, err)
+
+	_, err = client.GetUser(ctx, &pb.GetUserReq{Id: created.GetId()})
+	requireCode(t, codes.NotFound, err)
+}
+
+// createSession stores a session of the user with email whose access token
+// has the ID jti.
+func createSession(t *testing.T, st *storertest.Storer, email, jti string) *storer.Session {
+	t.Helper()
+
+	expiresAt := time.Now().Add(time.Minute)
+	se, err := st.CreateSession(context.Background(), &storer.Session{
+		ID:              "session-" + jti,
+		FamilyID:        "family-" + jti,
+		UserEmail:       email,
+		RefreshToken:    "refresh-" + jti,
+		ExpiresAt:       time.Now().Add(time.Hour),
+		AccessTokenID:   &jti,
+		AccessExpiresAt: &expiresAt,
+	})
+	require.NoError(t, err)
+
+	return se
+}
+
+func TestUpdateUser(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("demote admin with update mask", func(t *testing.T) {
+		denylist := token.NewMemoryDenylist()
+		conn, st, _ := dialServer(t, service.Config{Denylist: denylist})
+		client := pb.NewUserServiceClient(conn)
+
+		u, err := st.CreateUser(ctx, &storer.User{Name: "admin", Email: "admin@example.com", IsAdmin: true})
+		require.NoError(t, err)
+		se := createSession(t, st, u.Email, "jti")
+
+		updated, err := client.UpdateUser(ctx, &pb.UpdateUserReq{
+			Id:         u.ID,
+			User:       &pb.UserReq{IsAdmin: false},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"is_admin"}},
+		})
+		require.NoError(t, err)
+		require.False(t, updated.GetIsAdmin())
+		require.Equal(t, "admin", updated.GetName())
+
+		denied, err := denylist.IsTokenDenied(ctx, "jti")
+		require.NoError(t, err)
+		require.True(t, denied)
+
+		se, err = st.GetSession(ctx, se.ID)
+		require.NoError(t, err)
+		require.False(t, se.IsRevoked)
+	})
+
+	t.Run("without update mask", func(t *testing.T) {
+		conn, st, _ := dialServer(t, service.Config{})
+		client := pb.NewUserServiceClient(conn)
+
+		u, err := st.CreateUser(ctx, &storer.User{Name: "admin", Email: "admin@example.com", IsAdmin: true})
+		require.NoError(t, err)
+
+		updated, err := client.UpdateUser(ctx, &pb.UpdateUserReq{Id: u.ID, User: &pb.UserReq{Name: "renamed"}})
+		require.NoError(t, err)
+		require.True(t, updated.GetIsAdmin())
+		require.Equal(t, "renamed", updated.GetName())
+	})
+
+	t.Run("invalid update mask", func(t *testing.T) {
+		conn, st, _ := dialServer(t, service.Config{})
+		client := pb.NewUserServiceClient(conn)
+
+		u, err := st.CreateUser(ctx, &storer.User{Email: "test@example.com"})
+		require.NoError(t, err)
+
+		_, err = client.UpdateUser(ctx, &pb.UpdateUserReq{
+			Id:         u.ID,
+			User:       &pb.UserReq{},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"id"}},
+		})
+		requireCode(t, codes.InvalidArgument, err)
+
+		_, err = client.UpdateUser(ctx, &pb.UpdateUserReq{
+			Id:         u.ID,
+			User:       &pb.UserReq{},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+		})
+		requireCode(t, codes.InvalidArgument, err)
+	})
+
+	t.Run("is_admin in update mask requires admin", func(t *testing.T) {
+		conn, st, _ := dialServer(t, service.Config{})
+		client := pb.NewUserServiceClient(conn)
+
+		u, err := st.CreateUser(ctx, &storer.User{Email: "test@example.com"})
+		require.NoError(t, err)
+
+		_, err = client.UpdateUser(withToken(t, ctx, u.ID, false, storer.DefaultTenantID), &pb.UpdateUserReq{
+			Id:         u.ID,
+			User:       &pb.UserReq{},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"is_admin"}},
+		})
+		requireCode(t, codes.PermissionDenied, err)
+	})
+
+	for name, req := range map[string]*pb.UserReq{
+		"password change revokes sessions": {Password: "new password"},
+		"email change revokes sessions":    {Email: "new@example.com"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			denylist := token.NewMemoryDenylist()
+			conn, st, _ := dialServer(t, service.Config{Denylist: denylist})
+			client := pb.NewUserServiceClient(conn)
+
+			u, err := st.CreateUser(ctx, &storer.User{Email: "test@example.com"})
+			require.NoError(t, err)
+			se := createSession(t, st, u.Email, "jti")
+
+			_, err = client.UpdateUser(ctx, &pb.UpdateUserReq{Id: u.ID, User: req})
+			require.NoError(t, err)
+
+			se, err = st.GetSession(ctx, se.ID)
+			require.NoError(t, err)
+			require.True(t, se.IsRevoked)
+
+			denied, err := denylist.IsTokenDenied(ctx, "jti")
+			require.NoError(t, err)
+			require.True(t, denied)
+		})
+	}
+
+	t.Run("rename keeps sessions", func(t *testing.T) {
+		denylist := token.NewMemoryDenylist()
+		conn, st, _ := dialServer(t, service.Config{Denylist: denylist})
+		client := pb.NewUserServiceClient(conn)
+
+		u, err := st.CreateUser(ctx, &storer.User{Email: "test@example.com"})
+		require.NoError(t, err)
+		se := createSession(t, st, u.Email, "jti")
+
+		_, err = client.UpdateUser(ctx, &pb.UpdateUserReq{Id: u.ID, User: &pb.UserReq{Name: "renamed", Email: u.Email}})
+		require.NoError(t, err)
+
+		se, err = st.GetSession(ctx, se.ID)
+		require.NoError(t, err)
+		require.False(t, se.IsRevoked)
+
+		denied, err := denylist.IsTokenDenied(ctx, "jti")
+		require.NoError(t, err)
+		require.False(t, denied)
+	})
+}
+
+func TestWatchOrder(t *testing.T) {
+	conn, st := dial(t)
+	client := pb.NewOrderServiceClient(conn)
+
+	o, err := st.CreateOrder(context.Background(), &storer.Order{PaymentMethod: "card"})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.WatchOrder(ctx, &pb.WatchOrderReq{OrderId: o.ID})
+	require.NoError(t, err)
+
+	e, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "pending", e.GetStatus())
+	require.NotEmpty(t, e.GetResumeToken())
+
+	_, err = client.UpdateOrderStatus(context.Background(), &pb.UpdateOrderStatusReq{Id: o.ID, Status: "shipped"})
+	require.NoError(t, err)
+
+	e, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, o.ID, e.GetOrderId())
+	require.Equal(t, "shipped", e.GetStatus())
+	cancel()
+
+	// Changes made while disconnected are sent on resume.
+	_, err = client.UpdateOrderStatus(context.Background(), &pb.UpdateOrderStatusReq{Id: o.ID, Status: "delivered"})
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	stream, err = client.WatchOrder(context.Background(), &pb.WatchOrderReq{OrderId: o.ID, ResumeToken: e.GetResumeToken()})
+	require.NoError(t, err)
+
+	e, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "delivered", e.GetStatus())
+
+	_, err = client.UpdateOrderStatus(context.Background(), &pb.UpdateOrderStatusReq{Id: o.ID, Status: "lost"})
+	requireCode(t, codes.InvalidArgument, err)
+
+	stream, err = client.WatchOrder(context.Background(), &pb.WatchOrderReq{OrderId: o.ID + 100})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	requireCode(t, codes.NotFound, err)
+
+	stream, err = client.WatchOrder(context.Background(), &pb.WatchOrderReq{OrderId: o.ID, ResumeToken: "not a token"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	requireCode(t, codes.InvalidArgument, err)
+}
+
+func TestWatchInventory(t *testing.T) {
+	conn, st := dial(t)
+	client := pb.NewProductServiceClient(conn)
+
+	watched, err := client.CreateProduct(context.Background(), &pb.ProductReq{Name: "watched", CountInStock: 5})
+	require.NoError(t, err)
+	other, err := client.CreateProduct(context.Background(), &pb.ProductReq{Name: "other", CountInStock: 5})
+	require.NoError(t, err)
+
+	stream, err := client.WatchInventory(context.Background(), &pb.WatchInventoryReq{ProductIds: []int64{watched.GetId()}})
+	require.NoError(t, err)
+
+	e, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, watched.GetId(), e.GetProductId())
+	require.Equal(t, int64(5), e.GetCountInStock())
+
+	_, err = client.UpdateProduct(context.Background(), &pb.ProductReq{Id: other.GetId(), CountInStock: 1})
+	require.NoError(t, err)
+	_, err = client.UpdateProduct(context.Background(), &pb.ProductReq{Id: watched.GetId(), Name: "renamed"})
+	require.NoError(t, err)
+	_, err = client.UpdateProduct(context.Background(), &pb.ProductReq{Id: watched.GetId(), CountInStock: 4})
+	require.NoError(t, err)
+
+	e, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, watched.GetId(), e.GetProductId())
+	require.Equal(t, int64(4), e.GetCountInStock())
+
+	// Watching all products still leaves out those of other tenants.
+	all, err := client.WatchInventory(context.Background(), &pb.WatchInventoryReq{})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = all.Recv()
+		require.NoError(t, err)
+	}
+
+	otherTenant := storer.WithTenant(context.Background(), 2)
+	p, err := st.CreateProduct(otherTenant, &storer.Product{Name: "foreign", CountInStock: 1})
+	require.NoError(t, err)
+	p.CountInStock = 9
+	_, err = st.UpdateProduct(otherTenant, p, 0)
+	require.NoError(t, err)
+
+	_, err = client.UpdateProduct(context.Background(), &pb.ProductReq{Id: watched.GetId(), CountInStock: 3})
+	require.NoError(t, err)
+
+	e, err = all.Recv()
+	require.NoError(t, err)
+	require.Equal(t, watched.GetId(), e.GetProductId())
+	require.Equal(t, int64(3), e.GetCountInStock())
+}
+
+func TestAuthorization(t *testing.T) {
+	conn, st, _ := dialServer(t, service.Config{Denylist: token.NewMemoryDenylist()})
+	products := pb.NewProductServiceClient(conn)
+	orders := pb.NewOrderServiceClient(conn)
+	users := pb.NewUserServiceClient(conn)
+
+	u, err := st.CreateUser(context.Background(), &storer.User{Name: "user", Email: "user@example.com"})
+	require.NoError(t, err)
+	other, err := st.CreateUser(context.Background(), &storer.User{Name: "other", Email: "other@example.com"})
+	require.NoError(t, err)
+	p, err := st.CreateProduct(context.Background(), &storer.Product{Name: "test product"})
+	require.NoError(t, err)
+	own, err := st.CreateOrder(context.Background(), &storer.Order{UserID: u.ID, PaymentMethod: "card"})
+	require.NoError(t, err)
+	others, err := st.CreateOrder(context.Background(), &storer.Order{UserID: other.ID, PaymentMethod: "card"})
+	require.NoError(t, err)
+
+	user := withToken(t, context.Background(), u.ID, false, storer.DefaultTenantID)
+
+	tcs := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{
+			name: "anonymous reads products",
+			call: func() error {
+				_, err := products.GetProduct(anonymous(context.Background()), &pb.GetProductReq{Id: p.ID})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "anonymous cannot list orders",
+			call: func() error {
+				_, err := orders.ListOrders(anonymous(context.Background()), &emptypb.Empty{})
+				return err
+			},
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "user lists only their own orders",
+			call: func() error {
+				res, err := orders.ListOrders(user, &emptypb.Empty{})
+				if err != nil {
+					return err
+				}
+				require.Len(t, res.GetOrders(), 1)
+				require.Equal(t, own.ID, res.GetOrders()[0].GetId())
+				return nil
+			},
+			code: codes.OK,
+		},
+		{
+			name: "user gets their own order",
+			call: func() error {
+				_, err := orders.GetOrder(user, &pb.GetOrderReq{Id: own.ID})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "user cannot get orders of other users",
+			call: func() error {
+				_, err := orders.GetOrder(user, &pb.GetOrderReq{Id: others.ID})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "user cannot delete orders of other users",
+			call: func() error {
+				_, err := orders.DeleteOrder(user, &pb.DeleteOrderReq{Id: others.ID})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "user cannot watch orders of other users",
+			call: func() error {
+				stream, err := orders.WatchOrder(user, &pb.WatchOrderReq{OrderId: others.ID})
+				if err != nil {
+					return err
+				}
+				_, err = stream.Recv()
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "admin gets orders of other users",
+			call: func() error {
+				_, err := orders.GetOrder(context.Background(), &pb.GetOrderReq{Id: others.ID})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "user cannot create products",
+			call: func() error {
+				_, err := products.CreateProduct(user, &pb.ProductReq{Name: "test product"})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "user gets themselves",
+			call: func() error {
+				_, err := users.GetUser(user, &pb.GetUserReq{Id: u.ID})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "user cannot get other users",
+			call: func() error {
+				_, err := users.GetUser(user, &pb.GetUserReq{Id: other.ID})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "user cannot make themselves admin",
+			call: func() error {
+				_, err := users.UpdateUser(user, &pb.UpdateUserReq{Id: u.ID, User: &pb.UserReq{IsAdmin: true}})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "stream methods are checked",
+			call: func() error {
+				stream, err := orders.WatchOrder(anonymous(context.Background()), &pb.WatchOrderReq{OrderId: 1})
+				if err != nil {
+					return err
+				}
+				_, err = stream.Recv()
+				return err
+			},
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "invalid token",
+			call: func() error {
+				ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer invalid")
+				_, err := products.ListProducts(ctx, &emptypb.Empty{})
+				return err
+			},
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "unknown scheme",
+			call: func() error {
+				ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic dXNlcg==")
+				_, err := products.ListProducts(ctx, &emptypb.Empty{})
+				return err
+			},
+			code: codes.Unauthenticated,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.call()
+			if tc.code == codes.OK {
+				require.NoError(t, err)
+				return
+			}
+			requireCode(t, tc.code, err)
+		})
+	}
+}
+
+func TestRevokedToken(t *testing.T) {
+	denylist := token.NewMemoryDenylist()
+	conn, _, _ := dialServer(t, service.Config{Denylist: denylist})
+	client := pb.NewOrderServiceClient(conn)
+
+	tok, claims := newToken(t, 1, false, storer.DefaultTenantID)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+tok)
+
+	_, err := client.ListOrders(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+
+	require.NoError(t, denylist.DenyToken(context.Background(), claims.RegisteredClaims.ID, claims.ExpiresAt.Time))
+
+	_, err = client.ListOrders(ctx, &emptypb.Empty{})
+	requireCode(t, codes.Unauthenticated, err)
+}
+
+func TestTenantMetadata(t *testing.T) {
+	conn, st, _ := dialServer(t, service.Config{TenantHeader: "X-Tenant"})
+	client := pb.NewProductServiceClient(conn)
+
+	tenant, err := st.CreateTenant(context.Background(), &storer.Tenant{Name: "acme", Slug: "acme"})
+	require.NoError(t, err)
+	_, err = st.CreateProduct(storer.WithTenant(context.Background(), tenant.ID), &storer.Product{Name: "acme product"})
+	require.NoError(t, err)
+
+	acme := metadata.AppendToOutgoingContext(anonymous(context.Background()), "x-tenant", "acme")
+	list, err := client.ListProducts(acme, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, list.GetProducts(), 1)
+	require.Equal(t, "acme product", list.GetProducts()[0].GetName())
+
+	list, err = client.ListProducts(anonymous(context.Background()), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Empty(t, list.GetProducts())
+
+	// Tokens only work within the tenant they were issued for.
+	_, err = client.ListProducts(metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme"), &emptypb.Empty{})
+	requireCode(t, codes.Unauthenticated, err)
+
+	_, err = client.ListProducts(metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "unknown"), &emptypb.Empty{})
+	requireCode(t, codes.NotFound, err)
+}
+
+func TestRequestIDAndStats(t *testing.T) {
+	conn, _, gs := dialServer(t, service.Config{})
+	client := pb.NewProductServiceClient(conn)
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "test-request")
+	_, err := client.ListProducts(ctx, &emptypb.Empty{}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Equal(t, []string{"test-request"}, header.Get("x-request-id"))
+
+	_, err = client.ListProducts(context.Background(), &emptypb.Empty{}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Len(t, header.Get("x-request-id"), 1)
+	require.NotEqual(t, "test-request", header.Get("x-request-id")[0])
+
+	_, err = client.GetProduct(context.Background(), &pb.GetProductReq{Id: 42})
+	requireCode(t, codes.NotFound, err)
+
+	stats := gs.Stats()
+	require.Equal(t, uint64(2), stats[pb.ProductService_ListProducts_FullMethodName].Calls)
+	require.Equal(t, map[string]uint64{"OK": 2}, stats[pb.ProductService_ListProducts_FullMethodName].Codes)
+	require.Equal(t, map[string]uint64{"NotFound": 1}, stats[pb.ProductService_GetProduct_FullMethodName].Codes)
+	require.Zero(t, stats[pb.ProductService_GetProduct_FullMethodName].Active)
+}
+
+func TestHealthAndReflection(t *testing.T) {
+	conn, _ := dial(t)
+	ctx := anonymous(context.Background())
+
+	res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: "micropanel.ProductService"})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.GetStatus())
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	refl, err := stream.Recv()
+	require.NoError(t, err)
+
+	var names []string
+	for _, s := range refl.GetListServicesResponse().GetService() {
+		names = append(names, s.GetName())
+	}
+	require.Contains(t, names, "micropanel.OrderService")
+	require.NoError(t, stream.CloseSend())
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/server"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-grpc/pb"
+	"github.com/Turtel216/micro-panel/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type userService struct {
+	pb.UnimplementedUserServiceServer
+	server *server.Server
+	config Config
+}
+
+func (s *userService) CreateUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes, error) {
+	if req.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing email")
+	}
+
+	hashed, err := s.hashPassword(req.GetPassword())
+	if err != nil {
+		return nil, err
+	}
+
+	u := toStorerUser(req)
+	u.Password = hashed
+
+	created, err := s.server.CreateUser(ctx, u)
+	if err != nil {
+		return nil, toStatus(err, "error creating user")
+	}
+
+	err = s.sendUserToken(ctx, created, storer.UserTokenEmailVerification, s.config.EmailVerificationTTL)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "error sending verification token")
+	}
+
+	return toUserRes(created), nil
+}
+
+func (s *userService) GetUser(ctx context.Context, req *pb.GetUserReq) (*pb.UserRes, error) {
+	if err := checkSelf(ctx, req.GetId()); err != nil {
+		return nil, err
+	}
+
+	user, err := s.server.GetUserByID(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err, "error getting user")
+	}
+
+	return toUserRes(user), nil
+}
+
+func (s *userService) ListUsers(ctx context.Context, _ *emptypb.Empty) (*pb.ListUserRes, error) {
+	users, err := s.server.ListUsers(ctx)
+	if err != nil {
+		return nil, toStatus(err, "error listing users")
+	}
+
+	res := &pb.ListUserRes{}
+	for _, u := range users {
+		res.Users = append(res.Users, toUserRes(&u))
+	}
+
+	return res, nil
+}
+
+func (s *userService) UpdateUser(ctx context.Context, req *pb.UpdateUserReq) (*pb.UserRes, error) {
+	if err := checkSelf(ctx, req.GetId()); err != nil {
+		return nil, err
+	}
+
+	fields, err := updatedFields(req)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, _ := claimsFromContext(ctx)
+	if fields["is_admin"] && !claims.IsAdmin {
+		return nil, status.Error(codes.PermissionDenied, "admin privileges required")
+	}
+	if fields["email"] && req.GetUser().GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing email")
+	}
+
+	user, err := s.server.GetUserByID(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err, "error getting user")
+	}
+
+	if fields["password"] {
+		hashed, err := s.hashPassword(req.GetUser().GetPassword())
+		if err != nil {
+			return nil, err
+		}
+		user.Password = hashed
+	}
+
+	email, wasAdmin := user.Email, user.IsAdmin
+	patchUserReq(user, req.GetUser(), fields)
+
+	updated, err := s.server.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, toStatus(err, "error updating user")
+	}
+
+	// Sessions are looked up by the email they were created with, so they
+	// are revoked under the old one.
+	switch {
+	case fields["password"] || updated.Email != email:
+		if err := s.revokeSessions(ctx, email); err != nil {
+			return nil, status.Error(codes.Internal, "error revoking sessions")
+		}
+	case updated.IsAdmin != wasAdmin:
+		// Access tokens carry the role, so make the user refresh them.
+		if err := s.denyAccessTokens(ctx, email); err != nil {
+			return nil, status.Error(codes.Internal, "error denying access tokens")
+		}
+	}
+
+	return toUserRes(updated), nil
+}
+
+func (s *userService) DeleteUser(ctx context.Context, req *pb.DeleteUserReq) (*emptypb.Empty, error) {
+	if err := checkSelf(ctx, req.GetId()); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.server.GetUserByID(ctx, req.GetId()); err != nil {
+		return nil, toStatus(err, "error getting user")
+	}
+
+	if err := s.server.DeleteUser(ctx, req.GetId()); err != nil {
+		return nil, toStatus(err, "error deleting user")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// updatedFields returns the fields of the user that req changes: the ones in
+// its update mask, or the ones that are set without a mask.
+func updatedFields(req *pb.UpdateUserReq) (map[string]bool, error) {
+	u := req.GetUser()
+	mask := req.GetUpdateMask()
+	if mask == nil {
+		return map[string]bool{
+			"name":     u.GetName() != "",
+			"email":    u.GetEmail() != "",
+			"password": u.GetPassword() != "",
+			"is_admin": u.GetIsAdmin(),
+		}, nil
+	}
+
+	if !mask.IsValid(u) {
+		return nil, status.Error(codes.InvalidArgument, "invalid update mask")
+	}
+
+	fields := make(map[string]bool)
+	for _, path := range mask.GetPaths() {
+		fields[path] = true
+	}
+	return fields, nil
+}
+
+// checkSelf allows admins to act on any user and everyone else only on
+// themselves.
+func checkSelf(ctx context.Context, id int64) error {
+	claims, ok := claimsFromContext(ctx)
+	if !ok || (!claims.IsAdmin && claims.ID != id) {
+		return status.Error(codes.PermissionDenied, "forbidden")
+	}
+
+	return nil
+}
+
+func (s *userService) hashPassword(password string) (string, error) {
+	if err := s.config.PasswordPolicy.Validate(password); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	hashed, err := s.config.PasswordHasher.Hash(password)
+	if err != nil {
+		return "", status.Error(codes.Internal, "error hashing password")
+	}
+
+	return hashed, nil
+}
+
+// sendUserToken issues a one-time token for purpose and enqueues an email
+// that carries it to usr, like the REST API does.
+func (s *userService) sendUserToken(ctx context.Context, usr *storer.User, purpose string, ttl time.Duration) error {
+	tok, hash, err := util.NewOneTimeToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	_, err = s.server.CreateUserToken(ctx, &storer.UserToken{
+		TokenHash: hash,
+		UserID:    usr.ID,
+		Purpose:   purpose,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"name":       usr.Name,
+		"token":      tok,
+		"expires_at": expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.server.CreateNotification(ctx, &storer.Notification{
+		Channel:   storer.NotificationChannelEmail,
+		Recipient: usr.Email,
+		Template:  purpose,
+		Data:      data,
+	})
+	return err
+}
+
+// revokeSessions revokes every session of a user, along with their access
+// tokens.
+func (s *userService) revokeSessions(ctx context.Context, email string) error {
+	err := s.server.RevokeSessionsByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	return s.denyAccessTokens(ctx, email)
+}
+
+// denyAccessTokens adds the unexpired access tokens of the user's sessions to
+// the denylist.
+func (s *userService) denyAccessTokens(ctx context.Context, email string) error {
+	sessions, err := s.server.ListLiveAccessTokens(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	for _, se := range sessions {
+		err = s.config.Denylist.DenyToken(ctx, *se.AccessTokenID, *se.AccessExpiresAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/micropanel-grpc/pb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func toStorerProduct(p *pb.ProductReq) *storer.Product {
+	return &storer.Product{
+		Name:         p.GetName(),
+		Image:        p.GetImage(),
+		Category:     p.GetCategory(),
+		Description:  p.GetDescription(),
+		Rating:       p.GetRating(),
+		NumReviews:   p.GetNumReviews(),
+		Price:        p.GetPrice(),
+		CountInStock: p.GetCountInStock(),
+	}
+}
+
+func toProductRes(p *storer.Product) *pb.ProductRes {
+	return &pb.ProductRes{
+		Id:           p.ID,
+		Name:         p.Name,
+		Image:        p.Image,
+		Category:     p.Category,
+		Description:  p.Description,
+		Rating:       p.Rating,
+		NumReviews:   p.NumReviews,
+		Price:        p.Price,
+		CountInStock: p.CountInStock,
+		CreatedAt:    toTimestamp(&p.CreatedAt),
+		UpdatedAt:    toTimestamp(p.UpdatedAt),
+	}
+}
+
+func patchProductReq(product *storer.Product, p *pb.ProductReq) {
+	if p.GetName() != "" {
+		product.Name = p.GetName()
+	}
+	if p.GetImage() != "" {
+		product.Image = p.GetImage()
+	}
+	if p.GetCategory() != "" {
+		product.Category = p.GetCategory()
+	}
+	if p.GetDescription() != "" {
+		product.Description = p.GetDescription()
+	}
+	if p.GetRating() != 0 {
+		product.Rating = p.GetRating()
+	}
+	if p.GetNumReviews() != 0 {
+		product.NumReviews = p.GetNumReviews()
+	}
+	if p.GetPrice() != 0 {
+		product.Price = p.GetPrice()
+	}
+	if p.GetCountInStock() != 0 {
+		product.CountInStock = p.GetCountInStock()
+	}
+	now := time.Now()
+	product.UpdatedAt = &now
+}
+
+func toStorerOrder(o *pb.OrderReq) *storer.Order {
+	order := &storer.Order{
+		PaymentMethod: o.GetPaymentMethod(),
+		TaxPrice:      o.GetTaxPrice(),
+		ShippingPrice: o.GetShippingPrice(),
+		TotalPrice:    o.GetTotalPrice(),
+	}
+	for _, i := range o.GetItems() {
+		order.Items = append(order.Items, storer.OrderItem{
+			Name:      i.GetName(),
+			Quantity:  i.GetQuantity(),
+			Image:     i.GetImage(),
+			Price:     i.GetPrice(),
+			ProductID: i.GetProductId(),
+		})
+	}
+	return order
+}
+
+func toOrderRes(o *storer.Order) *pb.OrderRes {
+	res := &pb.OrderRes{
+		Id:            o.ID,
+		PaymentMethod: o.PaymentMethod,
+		TaxPrice:      o.TaxPrice,
+		ShippingPrice: o.ShippingPrice,
+		TotalPrice:    o.TotalPrice,
+		Status:        string(o.Status),
+		CreatedAt:     toTimestamp(&o.CreatedAt),
+		UpdatedAt:     toTimestamp(o.UpdatedAt),
+	}
+	for _, i := range o.Items {
+		res.Items = append(res.Items, &pb.OrderItem{
+			Name:      i.Name,
+			Quantity:  i.Quantity,
+			Image:     i.Image,
+			Price:     i.Price,
+			ProductId: i.ProductID,
+		})
+	}
+	return res
+}
+
+func toStorerUser(u *pb.UserReq) *storer.User {
+	return &storer.User{
+		Name:     u.GetName(),
+		Email:    u.GetEmail(),
+		Password: u.GetPassword(),
+		IsAdmin:  u.GetIsAdmin(),
+	}
+}
+
+func toUserRes(u *storer.User) *pb.UserRes {
+	return &pb.UserRes{
+		Id:            u.ID,
+		Name:          u.Name,
+		Email:         u.Email,
+		IsAdmin:       u.IsAdmin,
+		EmailVerified: u.EmailVerified,
+		TotpEnabled:   u.TOTPEnabled,
+		LockedUntil:   toTimestamp(u.LockedUntil),
+	}
+}
+
+// patchUserReq applies the given fields of u, as returned by updatedFields,
+// to user.
+func patchUserReq(user *storer.User, u *pb.UserReq, fields map[string]bool) {
+	if fields["name"] {
+		user.Name = u.GetName()
+	}
+	if fields["email"] && u.GetEmail() != user.Email {
+		user.Email = u.GetEmail()
+		user.EmailVerified = false
+	}
+	if fields["is_admin"] {
+		user.IsAdmin = u.GetIsAdmin()
+	}
+}
+
+func toOrderEvent(e *storer.Event) *pb.OrderEvent {
+	return &pb.OrderEvent{
+		OrderId:     e.EntityID,
+		Status:      string(e.Status),
+		Time:        timestamppb.New(e.CreatedAt),
+		ResumeToken: encodeResumeToken(e.ID),
+	}
+}
+
+func toInventoryEvent(e *storer.Event) *pb.InventoryEvent {
+	return &pb.InventoryEvent{
+		ProductId:    e.EntityID,
+		CountInStock: e.CountInStock,
+		Time:         timestamppb.New(e.CreatedAt),
+		ResumeToken:  encodeResumeToken(e.ID),
+	}
+}
+
+// toTimestamp leaves unset times unset rather than sending the zero time.
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
@@ -0,0 +1,267 @@
+// Package admin serves the HTTP API that admins inspect the job queue and
+// requeue dead jobs with. It accepts the access tokens of the REST API and
+// scopes every request to the tenant of the token.
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/notification-svc/queue"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/go-chi/chi"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+type Config struct {
+	TokenMaker token.Maker
+	Denylist   token.Denylist
+}
+
+type JobRes struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      queue.Status    `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	VisibleAt   time.Time       `json:"visible_at"`
+	LastError   *string         `json:"last_error,omitempty"`
+	Result      *string         `json:"result,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// redactedFields are the fields of notification data that are secret to the
+// recipient, such as the one-time tokens of password reset emails.
+var redactedFields = []string{"token"}
+
+func toJobRes(j *queue.Job) JobRes {
+	return JobRes{
+		ID:          j.ID,
+		Kind:        j.Kind,
+		Payload:     redactPayload(j.Payload),
+		Status:      j.Status,
+		Attempts:    j.Attempts,
+		MaxAttempts: j.MaxAttempts,
+		VisibleAt:   j.VisibleAt,
+		LastError:   j.LastError,
+		Result:      j.Result,
+		CreatedAt:   j.CreatedAt,
+		UpdatedAt:   j.UpdatedAt,
+	}
+}
+
+// redactPayload replaces the redactedFields in the data of a payload.
+// Payloads whose data cannot be parsed are left out, since they may hold
+// secrets too.
+func redactPayload(payload []byte) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil
+	}
+
+	raw, ok := fields["data"]
+	if !ok || string(raw) == "null" {
+		return payload
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil
+	}
+
+	for _, f := range redactedFields {
+		if _, ok := data[f]; ok {
+			data[f] = json.RawMessage(`"[redacted]"`)
+		}
+	}
+
+	var err error
+	if fields["data"], err = json.Marshal(data); err != nil {
+		return nil
+	}
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+
+	return redacted
+}
+
+type handler struct {
+	queue  queue.Queue
+	config Config
+}
+
+func NewHandler(q queue.Queue, config Config) http.Handler {
+	if config.Denylist == nil {
+		config.Denylist = token.NewMemoryDenylist()
+	}
+	h := &handler{queue: q, config: config}
+
+	r := chi.NewRouter()
+	r.Route("/jobs", func(r chi.Router) {
+		r.Use(h.requireAdmin)
+
+		r.Get("/", h.listJobs)
+		r.Get("/{id}", h.getJob)
+		r.Post("/{id}/requeue", h.requeueJob)
+	})
+	r.Handle("/debug/vars", expvar.Handler())
+
+	return r
+}
+
+// requireAdmin verifies the bearer token of the request and rejects callers
+// that are not admins.
+func (h *handler) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := strings.Fields(r.Header.Get("Authorization"))
+		if len(fields) == 0 {
+			http.Error(w, "missing authorization header", http.StatusUnauthorized)
+			return
+		}
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+			http.Error(w, "invalid authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := h.config.TokenMaker.VerifyToken(fields[1], token.AccessToken)
+		if err != nil {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		denied, err := h.config.Denylist.IsTokenDenied(r.Context(), claims.RegisteredClaims.ID)
+		if err != nil {
+			http.Error(w, "error checking token", http.StatusInternalServerError)
+			return
+		}
+		if denied {
+			http.Error(w, "token revoked", http.StatusUnauthorized)
+			return
+		}
+
+		if !claims.IsAdmin {
+			http.Error(w, "admin privileges required", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withTokenTenant(r.Context(), claims)))
+	})
+}
+
+func withTokenTenant(ctx context.Context, claims *token.UserClaims) context.Context {
+	id := claims.TenantID
+	if id == 0 {
+		id = storer.DefaultTenantID
+	}
+
+	return storer.WithTenant(ctx, id)
+}
+
+func (h *handler) listJobs(w http.ResponseWriter, r *http.Request) {
+	status := queue.Status(r.URL.Query().Get("status"))
+	switch status {
+	case "", queue.StatusPending, queue.StatusRunning, queue.StatusDone, queue.StatusDead:
+	default:
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	jobs, err := h.queue.ListJobs(r.Context(), status, limit)
+	if err != nil {
+		http.Error(w, "error listing jobs", http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]JobRes, 0, len(jobs))
+	for i := range jobs {
+		res = append(res, toJobRes(&jobs[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) getJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	j, err := h.queue.GetJob(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error getting job", http.StatusInternalServerError)
+		return
+	}
+
+	res := toJobRes(j)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) requeueJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	j, err := h.queue.Requeue(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, queue.ErrNotDead) {
+		http.Error(w, "only dead jobs can be requeued", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error requeueing job", http.StatusInternalServerError)
+		return
+	}
+
+	res := toJobRes(j)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func jobIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return id, true
+}
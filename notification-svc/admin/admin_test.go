@@ -0,0 +1,175 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/notification-svc/admin"
+	"github.com/Turtel216/micro-panel/notification-svc/queue"
+	"github.com/Turtel216/micro-panel/notification-svc/queue/queuetest"
+	"github.com/Turtel216/micro-panel/token"
+	"github.com/stretchr/testify/require"
+)
+
+var tokenMaker = token.NewJWTMaker("01234567890123456789012345678901")
+
+type harness struct {
+	queue    *queuetest.Queue
+	denylist *token.MemoryDenylist
+	server   *httptest.Server
+}
+
+func newHarness(t *testing.T) *harness {
+	h := &harness{
+		queue:    queuetest.New(),
+		denylist: token.NewMemoryDenylist(),
+	}
+	h.server = httptest.NewServer(admin.NewHandler(h.queue, admin.Config{TokenMaker: tokenMaker, Denylist: h.denylist}))
+	t.Cleanup(h.server.Close)
+
+	return h
+}
+
+func newToken(t *testing.T, isAdmin bool, tenantID int64) (string, *token.UserClaims) {
+	t.Helper()
+
+	tok, claims, err := tokenMaker.CreateToken(1, "admin@example.com", isAdmin, tenantID, token.AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	return tok, claims
+}
+
+func (h *harness) do(t *testing.T, method, path, tok string, out interface{}) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, h.server.URL+path, nil)
+	require.NoError(t, err)
+	if tok != "" {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	if out != nil && res.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(out))
+	}
+
+	return res.StatusCode
+}
+
+// deadJob enqueues a job and buries it.
+func (h *harness) deadJob(t *testing.T, ctx context.Context) *queue.Job {
+	t.Helper()
+
+	j, err := h.queue.Enqueue(ctx, &queue.Job{Kind: "notification", Payload: []byte(`{"recipient":"user@example.com"}`), MaxAttempts: 1})
+	require.NoError(t, err)
+
+	jobs, err := h.queue.Claim(ctx, 1, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, h.queue.Bury(ctx, &jobs[0], "unavailable"))
+
+	return j
+}
+
+func TestJobs(t *testing.T) {
+	h := newHarness(t)
+	tok, _ := newToken(t, true, 0)
+
+	dead := h.deadJob(t, context.Background())
+	pending, err := h.queue.Enqueue(context.Background(), &queue.Job{Kind: "notification", Payload: []byte(`{}`)})
+	require.NoError(t, err)
+
+	var jobs []admin.JobRes
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, "/jobs", tok, &jobs))
+	require.Len(t, jobs, 2)
+	require.Equal(t, pending.ID, jobs[0].ID)
+
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, "/jobs?status=dead", tok, &jobs))
+	require.Len(t, jobs, 1)
+	require.Equal(t, dead.ID, jobs[0].ID)
+	require.Equal(t, "unavailable", *jobs[0].LastError)
+	require.JSONEq(t, `{"recipient":"user@example.com"}`, string(jobs[0].Payload))
+
+	require.Equal(t, http.StatusBadRequest, h.do(t, http.MethodGet, "/jobs?status=lost", tok, nil))
+	require.Equal(t, http.StatusBadRequest, h.do(t, http.MethodGet, "/jobs?limit=0", tok, nil))
+
+	var job admin.JobRes
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, "/jobs/1", tok, &job))
+	require.Equal(t, queue.StatusDead, job.Status)
+	require.Equal(t, http.StatusNotFound, h.do(t, http.MethodGet, "/jobs/99", tok, nil))
+	require.Equal(t, http.StatusBadRequest, h.do(t, http.MethodGet, "/jobs/x", tok, nil))
+}
+
+func TestJobPayloadRedaction(t *testing.T) {
+	h := newHarness(t)
+	tok, _ := newToken(t, true, 0)
+
+	reset, err := h.queue.Enqueue(context.Background(), &queue.Job{
+		Kind:    storer.JobKindNotification,
+		Payload: []byte(`{"recipient":"user@example.com","template":"password_reset","data":{"name":"Test","token":"abc123"}}`),
+	})
+	require.NoError(t, err)
+	invalid, err := h.queue.Enqueue(context.Background(), &queue.Job{Kind: storer.JobKindNotification, Payload: []byte(`{"data":"abc123"}`)})
+	require.NoError(t, err)
+
+	var job admin.JobRes
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, fmt.Sprintf("/jobs/%d", reset.ID), tok, &job))
+	require.JSONEq(t, `{"recipient":"user@example.com","template":"password_reset","data":{"name":"Test","token":"[redacted]"}}`, string(job.Payload))
+
+	job = admin.JobRes{}
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, fmt.Sprintf("/jobs/%d", invalid.ID), tok, &job))
+	require.NotContains(t, string(job.Payload), "abc123")
+}
+
+func TestRequeue(t *testing.T) {
+	h := newHarness(t)
+	tok, _ := newToken(t, true, 0)
+	dead := h.deadJob(t, context.Background())
+
+	var job admin.JobRes
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodPost, "/jobs/1/requeue", tok, &job))
+	require.Equal(t, dead.ID, job.ID)
+	require.Equal(t, queue.StatusPending, job.Status)
+	require.Zero(t, job.Attempts)
+
+	require.Equal(t, http.StatusConflict, h.do(t, http.MethodPost, "/jobs/1/requeue", tok, nil))
+	require.Equal(t, http.StatusNotFound, h.do(t, http.MethodPost, "/jobs/99/requeue", tok, nil))
+
+	jobs, err := h.queue.Claim(context.Background(), 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+}
+
+func TestAuthorization(t *testing.T) {
+	h := newHarness(t)
+	h.deadJob(t, storer.WithTenant(context.Background(), 2))
+
+	require.Equal(t, http.StatusUnauthorized, h.do(t, http.MethodGet, "/jobs", "", nil))
+	require.Equal(t, http.StatusUnauthorized, h.do(t, http.MethodGet, "/jobs", "invalid", nil))
+
+	userToken, _ := newToken(t, false, 0)
+	require.Equal(t, http.StatusForbidden, h.do(t, http.MethodGet, "/jobs", userToken, nil))
+
+	revoked, claims := newToken(t, true, 0)
+	require.NoError(t, h.denylist.DenyToken(context.Background(), claims.RegisteredClaims.ID, claims.ExpiresAt.Time))
+	require.Equal(t, http.StatusUnauthorized, h.do(t, http.MethodGet, "/jobs", revoked, nil))
+
+	// Jobs of other tenants are out of sight.
+	tok, _ := newToken(t, true, 0)
+	var jobs []admin.JobRes
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, "/jobs", tok, &jobs))
+	require.Empty(t, jobs)
+	require.Equal(t, http.StatusNotFound, h.do(t, http.MethodPost, "/jobs/1/requeue", tok, nil))
+
+	tenantToken, _ := newToken(t, true, 2)
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, "/jobs", tenantToken, &jobs))
+	require.Len(t, jobs, 1)
+}
@@ -0,0 +1,55 @@
+package notifier
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket. Callers that find it empty are told when the
+// next token is due instead of waiting for it, so that a throttled channel
+// does not hold up workers that could deliver over other channels.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newLimiter returns nil, which allows everything, for a zero rate.
+func newLimiter(l Limit) *limiter {
+	if l.Rate <= 0 {
+		return nil
+	}
+
+	burst := float64(max(l.Burst, 1))
+	return &limiter{
+		rate:   l.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// allow takes a token and returns zero if one is available. Otherwise it
+// returns how long until one is, leaving the bucket as it is.
+func (l *limiter) allow() time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
@@ -0,0 +1,44 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogNotifier writes messages to a file or log instead of delivering them.
+// It stands in for channels without a provider in development, such as text
+// messages.
+type LogNotifier struct {
+	mu   sync.Mutex
+	w    io.Writer
+	name string
+	now  func() time.Time
+}
+
+// NewLogNotifier writes messages to w, which the results of deliveries
+// refer to by name.
+func NewLogNotifier(w io.Writer, name string) *LogNotifier {
+	return &LogNotifier{
+		w:    w,
+		name: name,
+		now:  time.Now,
+	}
+}
+
+// Notify writes m as a single line.
+func (ln *LogNotifier) Notify(_ context.Context, m *Message) (string, error) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	body := strings.Join(strings.Fields(m.Body), " ")
+	_, err := fmt.Fprintf(ln.w, "%s %s to %s: %s\n", ln.now().Format(time.RFC3339), m.Channel, m.Recipient, body)
+	if err != nil {
+		return "", fmt.Errorf("error writing to %s: %w", ln.name, err)
+	}
+
+	return fmt.Sprintf("written to %s", ln.name), nil
+}
@@ -0,0 +1,121 @@
+// Package notifier delivers the notifications that the API enqueues over
+// email, webhooks and text messages.
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/notification-svc/queue"
+	"github.com/Turtel216/micro-panel/notification-svc/worker"
+)
+
+// Message is a notification rendered for delivery.
+type Message struct {
+	Channel   string
+	Recipient string
+	Template  string
+	Subject   string
+	Body      string
+	Data      json.RawMessage
+}
+
+// Notifier delivers messages over a channel. Notify returns a short
+// description of the delivery, such as the response of the server, that is
+// recorded with the job. Errors that retrying cannot fix are
+// worker.Permanent.
+type Notifier interface {
+	Notify(ctx context.Context, m *Message) (string, error)
+}
+
+// Limit is the rate limit of a channel, a token bucket that holds Burst
+// messages and refills at Rate messages per second. A zero Rate disables it.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type ChannelStats struct {
+	Sent   uint64 `json:"sent"`
+	Failed uint64 `json:"failed"`
+	// Throttled counts the times that messages were deferred by the rate
+	// limit.
+	Throttled uint64 `json:"throttled"`
+}
+
+type channel struct {
+	notifier Notifier
+	limiter  *limiter
+
+	sent      atomic.Uint64
+	failed    atomic.Uint64
+	throttled atomic.Uint64
+}
+
+// Dispatcher routes notifications to the notifier of their channel.
+type Dispatcher struct {
+	channels map[string]*channel
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{channels: make(map[string]*channel)}
+}
+
+// Register sets the notifier of a channel. It must not be called once jobs
+// are handled.
+func (d *Dispatcher) Register(name string, n Notifier, limit Limit) {
+	d.channels[name] = &channel{
+		notifier: n,
+		limiter:  newLimiter(limit),
+	}
+}
+
+// Handle is the worker.Handler of storer.JobKindNotification jobs. Jobs over
+// the rate limit of their channel are deferred until it allows them.
+func (d *Dispatcher) Handle(ctx context.Context, j *queue.Job) (string, error) {
+	var n storer.Notification
+	if err := json.Unmarshal(j.Payload, &n); err != nil {
+		return "", worker.Permanent(fmt.Errorf("error decoding notification: %w", err))
+	}
+
+	ch, ok := d.channels[n.Channel]
+	if !ok {
+		return "", worker.Permanent(fmt.Errorf("no notifier for channel %q", n.Channel))
+	}
+
+	m, err := render(&n)
+	if err != nil {
+		return "", worker.Permanent(err)
+	}
+
+	if d := ch.limiter.allow(); d > 0 {
+		ch.throttled.Add(1)
+		return "", worker.Defer(time.Now().Add(d))
+	}
+
+	result, err := ch.notifier.Notify(ctx, m)
+	if err != nil {
+		ch.failed.Add(1)
+		return "", fmt.Errorf("error delivering %s notification: %w", n.Channel, err)
+	}
+	ch.sent.Add(1)
+
+	return result, nil
+}
+
+func (d *Dispatcher) Stats() map[string]ChannelStats {
+	stats := make(map[string]ChannelStats, len(d.channels))
+	for name, ch := range d.channels {
+		stats[name] = ChannelStats{
+			Sent:      ch.sent.Load(),
+			Failed:    ch.failed.Load(),
+			Throttled: ch.throttled.Load(),
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,213 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/notification-svc/queue"
+	"github.com/Turtel216/micro-panel/notification-svc/queue/queuetest"
+	"github.com/Turtel216/micro-panel/notification-svc/worker"
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	mu       sync.Mutex
+	messages []*Message
+	err      error
+}
+
+func (r *recorder) Notify(_ context.Context, m *Message) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return "", r.err
+	}
+	r.messages = append(r.messages, m)
+	return "recorded", nil
+}
+
+func notificationJob(t *testing.T, n storer.Notification) *queue.Job {
+	t.Helper()
+
+	payload, err := json.Marshal(n)
+	require.NoError(t, err)
+
+	return &queue.Job{Kind: storer.JobKindNotification, Payload: payload}
+}
+
+func TestDispatcher(t *testing.T) {
+	reset := storer.Notification{
+		Channel:   storer.NotificationChannelEmail,
+		Recipient: "user@example.com",
+		Template:  storer.UserTokenPasswordReset,
+		Data:      json.RawMessage(`{"name":"Test","token":"abc123","expires_at":"2024-01-01T00:00:00Z"}`),
+	}
+
+	t.Run("renders and delivers notifications", func(t *testing.T) {
+		email := &recorder{}
+		d := NewDispatcher()
+		d.Register(storer.NotificationChannelEmail, email, Limit{})
+
+		result, err := d.Handle(context.Background(), notificationJob(t, reset))
+		require.NoError(t, err)
+		require.Equal(t, "recorded", result)
+
+		require.Len(t, email.messages, 1)
+		require.Equal(t, "Reset your password", email.messages[0].Subject)
+		require.Contains(t, email.messages[0].Body, "Hi Test,")
+		require.Contains(t, email.messages[0].Body, "abc123")
+		require.Equal(t, ChannelStats{Sent: 1}, d.Stats()[storer.NotificationChannelEmail])
+	})
+
+	t.Run("counts failed deliveries", func(t *testing.T) {
+		d := NewDispatcher()
+		d.Register(storer.NotificationChannelEmail, &recorder{err: errors.New("unavailable")}, Limit{})
+
+		_, err := d.Handle(context.Background(), notificationJob(t, reset))
+		require.Error(t, err)
+		require.False(t, worker.IsPermanent(err))
+		require.Equal(t, ChannelStats{Failed: 1}, d.Stats()[storer.NotificationChannelEmail])
+	})
+
+	t.Run("fails permanently on what retrying cannot fix", func(t *testing.T) {
+		d := NewDispatcher()
+		d.Register(storer.NotificationChannelEmail, &recorder{}, Limit{})
+
+		sms := reset
+		sms.Channel = storer.NotificationChannelSMS
+		unknown := reset
+		unknown.Template = "unknown"
+
+		for _, j := range []*queue.Job{
+			{Kind: storer.JobKindNotification, Payload: []byte(`not json`)},
+			notificationJob(t, sms),
+			notificationJob(t, unknown),
+		} {
+			_, err := d.Handle(context.Background(), j)
+			require.True(t, worker.IsPermanent(err), err)
+		}
+	})
+
+	t.Run("defers messages over the rate limit", func(t *testing.T) {
+		email := &recorder{}
+		d := NewDispatcher()
+		d.Register(storer.NotificationChannelEmail, email, Limit{Rate: 1, Burst: 1})
+
+		_, err := d.Handle(context.Background(), notificationJob(t, reset))
+		require.NoError(t, err)
+
+		start := time.Now()
+		_, err = d.Handle(context.Background(), notificationJob(t, reset))
+		require.Error(t, err)
+		require.Less(t, time.Since(start), 100*time.Millisecond, "Handle must not wait for the rate limit")
+		require.False(t, worker.IsPermanent(err))
+		require.Len(t, email.messages, 1)
+		require.Equal(t, ChannelStats{Sent: 1, Throttled: 1}, d.Stats()[storer.NotificationChannelEmail])
+	})
+}
+
+func TestThrottledChannelDoesNotBlockOthers(t *testing.T) {
+	email := &recorder{}
+	sms := &recorder{}
+	d := NewDispatcher()
+	d.Register(storer.NotificationChannelEmail, email, Limit{Rate: 0.1, Burst: 1})
+	d.Register(storer.NotificationChannelSMS, sms, Limit{})
+
+	q := queuetest.New()
+	ctx := context.Background()
+	enqueue := func(channel string) *queue.Job {
+		n := storer.Notification{Channel: channel, Recipient: "user", Template: storer.NotificationNewLogin, Data: json.RawMessage(`{}`)}
+		j, err := q.Enqueue(ctx, notificationJob(t, n))
+		require.NoError(t, err)
+		return j
+	}
+	enqueue(storer.NotificationChannelEmail)
+	throttled := enqueue(storer.NotificationChannelEmail)
+	for i := 0; i < 3; i++ {
+		enqueue(storer.NotificationChannelSMS)
+	}
+
+	// A single worker slot: the throttled email must give it up for the
+	// text messages to go out.
+	w := worker.NewWorker(q, worker.Config{
+		Workers:         1,
+		PollInterval:    time.Millisecond,
+		BatchSize:       1,
+		Visibility:      time.Minute,
+		BaseBackoff:     time.Second,
+		MaxBackoff:      time.Second,
+		ShutdownTimeout: time.Second,
+	})
+	w.Handle(storer.JobKindNotification, d.Handle)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		w.Run(runCtx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return w.Stats().Completed == 4 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	sms.mu.Lock()
+	require.Len(t, sms.messages, 3)
+	sms.mu.Unlock()
+	require.Len(t, email.messages, 1)
+
+	j, err := q.GetJob(ctx, throttled.ID)
+	require.NoError(t, err)
+	require.Equal(t, queue.StatusPending, j.Status)
+	require.Zero(t, j.Attempts, "deferring does not count an attempt")
+	require.True(t, j.VisibleAt.After(time.Now()))
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := newLimiter(Limit{Rate: 2, Burst: 2})
+	l.last = now
+	l.now = func() time.Time { return now }
+
+	require.Zero(t, l.allow())
+	require.Zero(t, l.allow())
+	require.Equal(t, 500*time.Millisecond, l.allow())
+	// Refusing a token does not take it.
+	require.Equal(t, 500*time.Millisecond, l.allow())
+
+	now = now.Add(250 * time.Millisecond)
+	require.Equal(t, 250*time.Millisecond, l.allow())
+	now = now.Add(250 * time.Millisecond)
+	require.Zero(t, l.allow())
+	require.Equal(t, 500*time.Millisecond, l.allow())
+
+	// Idle time does not build up more than the burst.
+	now = now.Add(time.Hour)
+	require.Zero(t, l.allow())
+	require.Zero(t, l.allow())
+	require.NotZero(t, l.allow())
+
+	var unlimited *limiter
+	require.Nil(t, newLimiter(Limit{}))
+	require.Zero(t, unlimited.allow())
+}
+
+func TestLogNotifier(t *testing.T) {
+	var b strings.Builder
+	n := NewLogNotifier(&b, "sms.log")
+	n.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	m := testMessage("+15550100")
+	m.Channel = storer.NotificationChannelSMS
+	result, err := n.Notify(context.Background(), m)
+	require.NoError(t, err)
+	require.Equal(t, "written to sms.log", result)
+	require.Equal(t, "2024-01-01T00:00:00Z sms to +15550100: Hi, Use this code: 123\n", b.String())
+}
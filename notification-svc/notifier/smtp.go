@@ -0,0 +1,138 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/Turtel216/micro-panel/notification-svc/worker"
+)
+
+type SMTPConfig struct {
+	// Addr is the host:port of the server.
+	Addr string
+	From string
+	// Username and Password authenticate with PLAIN auth if set, which
+	// net/smtp only allows over TLS or to localhost.
+	Username string
+	Password string
+	// TLSConfig is used for STARTTLS, which is used whenever the server
+	// offers it. Defaults to verifying the host of Addr.
+	TLSConfig *tls.Config
+}
+
+type SMTPNotifier struct {
+	config SMTPConfig
+	now    func() time.Time
+}
+
+func NewSMTPNotifier(config SMTPConfig) *SMTPNotifier {
+	return &SMTPNotifier{
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// Notify sends m as a plain text email. Addresses that do not parse and 5xx
+// replies of the server are permanent errors.
+func (s *SMTPNotifier) Notify(ctx context.Context, m *Message) (string, error) {
+	to, err := mail.ParseAddress(m.Recipient)
+	if err != nil {
+		return "", worker.Permanent(fmt.Errorf("invalid recipient: %w", err))
+	}
+
+	err = s.send(ctx, to.Address, s.message(to, m))
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return "", worker.Permanent(err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("accepted by %s", s.config.Addr), nil
+}
+
+func (s *SMTPNotifier) send(ctx context.Context, to string, msg []byte) error {
+	host, _, err := net.SplitHostPort(s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address: %w", err)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+	// net/smtp knows nothing of contexts; expiring the connection unblocks
+	// it instead.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error greeting SMTP server: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		config := s.config.TLSConfig
+		if config == nil {
+			config = &tls.Config{ServerName: host}
+		}
+		if err := c.StartTLS(config); err != nil {
+			return fmt.Errorf("error starting TLS: %w", err)
+		}
+	}
+
+	if s.config.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.config.Username, s.config.Password, host)); err != nil {
+			return fmt.Errorf("error authenticating: %w", err)
+		}
+	}
+
+	if err := c.Mail(s.config.From); err != nil {
+		return fmt.Errorf("error setting sender: %w", err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("error setting recipient: %w", err)
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("error starting message: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error sending message: %w", err)
+	}
+
+	return c.Quit()
+}
+
+func (s *SMTPNotifier) message(to *mail.Address, m *Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", to.String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", s.now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return b.Bytes()
+}
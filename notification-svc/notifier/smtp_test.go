@@ -0,0 +1,177 @@
+package notifier
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Turtel216/micro-panel/notification-svc/worker"
+	"github.com/stretchr/testify/require"
+)
+
+type smtpMessage struct {
+	auth string
+	from string
+	to   string
+	data string
+}
+
+// smtpServer is a local stand-in for an SMTP server that accepts every
+// message, except for recipients in reject.
+type smtpServer struct {
+	ln     net.Listener
+	reject map[string]bool
+
+	mu       sync.Mutex
+	messages []smtpMessage
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	s := &smtpServer{ln: ln, reject: make(map[string]bool)}
+	go s.serve()
+
+	return s
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+
+	var msg smtpMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_, resp, _ := strings.Cut(arg, " ")
+			auth, _ := base64.StdEncoding.DecodeString(resp)
+			msg.auth = string(auth)
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			msg.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			msg.to = strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if s.reject[msg.to] {
+				tp.PrintfLine("550 5.1.1 No such user")
+				continue
+			}
+			tp.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			msg.data = strings.Join(lines, "\n")
+
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			tp.PrintfLine("250 2.0.0 OK queued")
+		case "QUIT":
+			tp.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpServer) sent() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]smtpMessage(nil), s.messages...)
+}
+
+func testMessage(recipient string) *Message {
+	return &Message{
+		Channel:   "email",
+		Recipient: recipient,
+		Template:  "password_reset",
+		Subject:   "Réinitialiser",
+		Body:      "Hi,\n\nUse this code: 123\n",
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	t.Run("sends messages", func(t *testing.T) {
+		s := newSMTPServer(t)
+		n := NewSMTPNotifier(SMTPConfig{
+			Addr:     s.ln.Addr().String(),
+			From:     "micropanel@example.com",
+			Username: "user",
+			Password: "secret",
+		})
+
+		result, err := n.Notify(context.Background(), testMessage("Test User <user@example.com>"))
+		require.NoError(t, err)
+		require.Equal(t, "accepted by "+s.ln.Addr().String(), result)
+
+		sent := s.sent()
+		require.Len(t, sent, 1)
+		require.Equal(t, "\x00user\x00secret", sent[0].auth)
+		require.Equal(t, "micropanel@example.com", sent[0].from)
+		require.Equal(t, "user@example.com", sent[0].to)
+		require.Contains(t, sent[0].data, `To: "Test User" <user@example.com>`)
+		require.Contains(t, sent[0].data, "Subject: =?utf-8?q?R=C3=A9initialiser?=")
+		require.Contains(t, sent[0].data, "\n\nHi,\n\nUse this code: 123")
+	})
+
+	t.Run("rejected recipients are permanent failures", func(t *testing.T) {
+		s := newSMTPServer(t)
+		s.reject["gone@example.com"] = true
+		n := NewSMTPNotifier(SMTPConfig{Addr: s.ln.Addr().String(), From: "micropanel@example.com"})
+
+		_, err := n.Notify(context.Background(), testMessage("gone@example.com"))
+		require.Error(t, err)
+		require.True(t, worker.IsPermanent(err))
+		require.Empty(t, s.sent())
+	})
+
+	t.Run("invalid recipients are permanent failures", func(t *testing.T) {
+		n := NewSMTPNotifier(SMTPConfig{Addr: "127.0.0.1:1", From: "micropanel@example.com"})
+
+		_, err := n.Notify(context.Background(), testMessage("not an address\r\nBcc: x@example.com"))
+		require.True(t, worker.IsPermanent(err))
+	})
+
+	t.Run("unreachable servers are retried", func(t *testing.T) {
+		s := newSMTPServer(t)
+		addr := s.ln.Addr().String()
+		s.ln.Close()
+		n := NewSMTPNotifier(SMTPConfig{Addr: addr, From: "micropanel@example.com"})
+
+		_, err := n.Notify(context.Background(), testMessage("user@example.com"))
+		require.Error(t, err)
+		require.False(t, worker.IsPermanent(err))
+	})
+}
@@ -0,0 +1,75 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+)
+
+type messageTemplate struct {
+	subject string
+	body    *template.Template
+}
+
+// templates renders the notifications of the API. Their data is that
+// marshalled by the handlers that enqueue them.
+var templates = map[string]messageTemplate{
+	storer.UserTokenPasswordReset: {
+		subject: "Reset your password",
+		body: template.Must(template.New(storer.UserTokenPasswordReset).Parse(`Hi {{.name}},
+
+Use this code to reset your password: {{.token}}
+
+It expires at {{.expires_at}}. If you did not ask to reset your password, you can ignore this message.
+`)),
+	},
+	storer.UserTokenEmailVerification: {
+		subject: "Verify your email address",
+		body: template.Must(template.New(storer.UserTokenEmailVerification).Parse(`Hi {{.name}},
+
+Use this code to verify your email address: {{.token}}
+
+It expires at {{.expires_at}}.
+`)),
+	},
+	storer.NotificationNewLogin: {
+		subject: "New sign-in to your account",
+		body: template.Must(template.New(storer.NotificationNewLogin).Parse(`Hi {{.name}},
+
+Your account was signed in to at {{.time}} from {{.client_ip}} ({{.user_agent}}).
+
+If this was not you, reset your password.
+`)),
+	},
+}
+
+func render(n *storer.Notification) (*Message, error) {
+	t, ok := templates[n.Template]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q", n.Template)
+	}
+
+	var data map[string]interface{}
+	if len(n.Data) > 0 {
+		if err := json.Unmarshal(n.Data, &data); err != nil {
+			return nil, fmt.Errorf("error decoding data: %w", err)
+		}
+	}
+
+	var body strings.Builder
+	if err := t.body.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("error rendering %s: %w", n.Template, err)
+	}
+
+	return &Message{
+		Channel:   n.Channel,
+		Recipient: n.Recipient,
+		Template:  n.Template,
+		Subject:   t.subject,
+		Body:      body.String(),
+		Data:      n.Data,
+	}, nil
+}
@@ -0,0 +1,95 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Turtel216/micro-panel/notification-svc/worker"
+)
+
+type WebhookConfig struct {
+	URL string
+	// Secret signs the body with HMAC-SHA256 in the X-Signature header as
+	// "sha256=<hex>", if set.
+	Secret string
+	Client *http.Client
+}
+
+// WebhookPayload is the body that webhooks receive.
+type WebhookPayload struct {
+	Channel   string          `json:"channel"`
+	Recipient string          `json:"recipient"`
+	Template  string          `json:"template"`
+	Subject   string          `json:"subject"`
+	Body      string          `json:"body"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// WebhookNotifier posts messages as JSON to a URL, leaving delivery to
+// whatever listens there.
+type WebhookNotifier struct {
+	config WebhookConfig
+}
+
+func NewWebhookNotifier(config WebhookConfig) *WebhookNotifier {
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+
+	return &WebhookNotifier{config: config}
+}
+
+// Notify posts m and expects a 2xx response. Other 4xx responses than 408
+// and 429 are permanent errors.
+func (wn *WebhookNotifier) Notify(ctx context.Context, m *Message) (string, error) {
+	body, err := json.Marshal(WebhookPayload{
+		Channel:   m.Channel,
+		Recipient: m.Recipient,
+		Template:  m.Template,
+		Subject:   m.Subject,
+		Body:      m.Body,
+		Data:      m.Data,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error encoding payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", worker.Permanent(fmt.Errorf("error creating request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wn.config.Secret != "" {
+		req.Header.Set("X-Signature", "sha256="+Sign(wn.config.Secret, body))
+	}
+
+	res, err := wn.config.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error posting webhook: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return fmt.Sprintf("webhook responded %s", res.Status), nil
+	case res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests:
+		return "", worker.Permanent(fmt.Errorf("webhook responded %s", res.Status))
+	default:
+		return "", fmt.Errorf("webhook responded %s", res.Status)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of body that webhooks are signed with.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
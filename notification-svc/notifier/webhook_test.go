@@ -0,0 +1,60 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Turtel216/micro-panel/notification-svc/worker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	t.Run("posts signed payloads", func(t *testing.T) {
+		var payload WebhookPayload
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, "sha256="+Sign("secret", body), r.Header.Get("X-Signature"))
+			require.NoError(t, json.Unmarshal(body, &payload))
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer srv.Close()
+
+		n := NewWebhookNotifier(WebhookConfig{URL: srv.URL, Secret: "secret"})
+		m := testMessage("user@example.com")
+		m.Data = json.RawMessage(`{"token":"123"}`)
+
+		result, err := n.Notify(context.Background(), m)
+		require.NoError(t, err)
+		require.Equal(t, "webhook responded 202 Accepted", result)
+		require.Equal(t, "user@example.com", payload.Recipient)
+		require.Equal(t, m.Body, payload.Body)
+		require.JSONEq(t, `{"token":"123"}`, string(payload.Data))
+	})
+
+	tcs := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusGone, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, tc := range tcs {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			_, err := NewWebhookNotifier(WebhookConfig{URL: srv.URL}).Notify(context.Background(), testMessage("user@example.com"))
+			require.Error(t, err)
+			require.Equal(t, tc.permanent, worker.IsPermanent(err))
+		})
+	}
+}
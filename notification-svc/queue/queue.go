@@ -0,0 +1,77 @@
+// Package queue is the durable job queue of notification-svc. Jobs are rows
+// of the jobs table that workers lease by claiming them: a claimed job stays
+// invisible to other workers until its visibility timeout passes, after which
+// it is handed out again as if its worker had crashed.
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	// StatusDead is the dead-letter state of jobs that ran out of attempts.
+	// Dead jobs are kept until they are requeued.
+	StatusDead Status = "dead"
+)
+
+type Job struct {
+	ID          int64     `db:"id"`
+	TenantID    int64     `db:"tenant_id"`
+	Kind        string    `db:"kind"`
+	Payload     []byte    `db:"payload"`
+	Status      Status    `db:"status"`
+	Attempts    int       `db:"attempts"`
+	MaxAttempts int       `db:"max_attempts"`
+	VisibleAt   time.Time `db:"visible_at"`
+	LastError   *string   `db:"last_error"`
+	// Result describes how a done job was carried out, such as the
+	// response of the server a notification was delivered to.
+	Result    *string   `db:"result"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+var (
+	// ErrLeaseLost is returned when a job is finished by a worker whose
+	// claim has expired and been taken over by another worker.
+	ErrLeaseLost = errors.New("job lease lost")
+	// ErrNotDead is returned when requeueing a job that is not dead.
+	ErrNotDead = errors.New("job is not dead")
+)
+
+// Queue is implemented by MySQLQueue and by the in-memory fake in queuetest.
+// Complete, Retry, Defer and Bury take the job as returned by Claim and fail
+// with ErrLeaseLost if it has been claimed again since.
+type Queue interface {
+	Enqueue(ctx context.Context, j *Job) (*Job, error)
+	// Claim leases up to limit visible jobs for visibility, counting an
+	// attempt for each.
+	Claim(ctx context.Context, limit int, visibility time.Duration) ([]Job, error)
+	Complete(ctx context.Context, j *Job, result string) error
+	// Retry makes the job visible again at visibleAt.
+	Retry(ctx context.Context, j *Job, visibleAt time.Time, lastErr string) error
+	// Defer makes the job visible again at visibleAt without counting the
+	// attempt, for jobs that could not run yet.
+	Defer(ctx context.Context, j *Job, visibleAt time.Time) error
+	Bury(ctx context.Context, j *Job, lastErr string) error
+
+	// The admin methods below are scoped to the tenant of ctx. Lookups of
+	// missing jobs return errors wrapping sql.ErrNoRows.
+	GetJob(ctx context.Context, id int64) (*Job, error)
+	// ListJobs lists the jobs with the given status, or all jobs if status
+	// is empty, newest first.
+	ListJobs(ctx context.Context, status Status, limit int) ([]Job, error)
+	// Requeue makes a dead job pending again with its attempts reset.
+	Requeue(ctx context.Context, id int64) (*Job, error)
+
+	// Purge deletes up to limit done jobs of all tenants that finished
+	// before the given time, and returns how many it deleted.
+	Purge(ctx context.Context, before time.Time, limit int) (int64, error)
+}
@@ -0,0 +1,220 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultMaxAttempts is the max_attempts of jobs enqueued without one. It
+// matches the default of the column, which applies to jobs enqueued by the
+// API.
+const DefaultMaxAttempts = 10
+
+type MySQLQueue struct {
+	db  *sqlx.DB
+	now func() time.Time
+}
+
+var _ Queue = (*MySQLQueue)(nil)
+
+func NewMySQLQueue(db *sqlx.DB) *MySQLQueue {
+	return &MySQLQueue{
+		db:  db,
+		now: time.Now,
+	}
+}
+
+func (mq *MySQLQueue) execTx(ctx context.Context, fn func(*sqlx.Tx) error) error {
+	tx, err := mq.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	err = fn(tx)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("error rolling back transaction: %w", rbErr)
+		}
+		return fmt.Errorf("error in transaction: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (mq *MySQLQueue) Enqueue(ctx context.Context, j *Job) (*Job, error) {
+	j.TenantID = storer.TenantID(ctx)
+	j.Status = StatusPending
+	if j.MaxAttempts == 0 {
+		j.MaxAttempts = DefaultMaxAttempts
+	}
+	if j.VisibleAt.IsZero() {
+		j.VisibleAt = mq.now()
+	}
+
+	res, err := mq.db.ExecContext(ctx, "INSERT INTO jobs (tenant_id, kind, payload, max_attempts, visible_at) VALUES (?, ?, ?, ?, ?)", j.TenantID, j.Kind, j.Payload, j.MaxAttempts, j.VisibleAt)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting job: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	j.ID = id
+
+	return j, nil
+}
+
+// Claim locks the visible jobs with SKIP LOCKED, so that concurrent workers
+// claim disjoint batches instead of waiting on each other. Running jobs whose
+// visibility timeout has passed are claimed again.
+func (mq *MySQLQueue) Claim(ctx context.Context, limit int, visibility time.Duration) ([]Job, error) {
+	var jobs []Job
+	err := mq.execTx(ctx, func(tx *sqlx.Tx) error {
+		now := mq.now()
+		err := tx.SelectContext(ctx, &jobs, "SELECT * FROM jobs WHERE status IN (?, ?) AND visible_at <= ? ORDER BY visible_at, id LIMIT ? FOR UPDATE SKIP LOCKED", StatusPending, StatusRunning, now, limit)
+		if err != nil {
+			return fmt.Errorf("error selecting jobs: %w", err)
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, len(jobs))
+		for i, j := range jobs {
+			ids[i] = j.ID
+		}
+
+		visibleAt := now.Add(visibility)
+		query, args, err := sqlx.In("UPDATE jobs SET status=?, attempts=attempts+1, visible_at=? WHERE id IN (?)", StatusRunning, visibleAt, ids)
+		if err != nil {
+			return fmt.Errorf("error building query: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, tx.Rebind(query), args...); err != nil {
+			return fmt.Errorf("error claiming jobs: %w", err)
+		}
+
+		for i := range jobs {
+			jobs[i].Status = StatusRunning
+			jobs[i].Attempts++
+			jobs[i].VisibleAt = visibleAt
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+func (mq *MySQLQueue) Complete(ctx context.Context, j *Job, result string) error {
+	return mq.finish(ctx, "UPDATE jobs SET status=?, last_error=NULL, result=? WHERE id=? AND status=? AND attempts=?", StatusDone, result, j.ID, StatusRunning, j.Attempts)
+}
+
+func (mq *MySQLQueue) Retry(ctx context.Context, j *Job, visibleAt time.Time, lastErr string) error {
+	return mq.finish(ctx, "UPDATE jobs SET status=?, visible_at=?, last_error=? WHERE id=? AND status=? AND attempts=?", StatusPending, visibleAt, lastErr, j.ID, StatusRunning, j.Attempts)
+}
+
+func (mq *MySQLQueue) Defer(ctx context.Context, j *Job, visibleAt time.Time) error {
+	return mq.finish(ctx, "UPDATE jobs SET status=?, visible_at=?, attempts=attempts-1 WHERE id=? AND status=? AND attempts=?", StatusPending, visibleAt, j.ID, StatusRunning, j.Attempts)
+}
+
+func (mq *MySQLQueue) Bury(ctx context.Context, j *Job, lastErr string) error {
+	return mq.finish(ctx, "UPDATE jobs SET status=?, last_error=? WHERE id=? AND status=? AND attempts=?", StatusDead, lastErr, j.ID, StatusRunning, j.Attempts)
+}
+
+// finish runs an update of a job that only matches while the job is still
+// claimed by the caller: claiming a job again counts another attempt.
+func (mq *MySQLQueue) finish(ctx context.Context, query string, args ...interface{}) error {
+	res, err := mq.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating job: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+func (mq *MySQLQueue) GetJob(ctx context.Context, id int64) (*Job, error) {
+	var j Job
+	err := mq.db.GetContext(ctx, &j, "SELECT * FROM jobs WHERE tenant_id=? AND id=?", storer.TenantID(ctx), id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting job: %w", err)
+	}
+
+	return &j, nil
+}
+
+func (mq *MySQLQueue) ListJobs(ctx context.Context, status Status, limit int) ([]Job, error) {
+	var jobs []Job
+	var err error
+	if status == "" {
+		err = mq.db.SelectContext(ctx, &jobs, "SELECT * FROM jobs WHERE tenant_id=? ORDER BY id DESC LIMIT ?", storer.TenantID(ctx), limit)
+	} else {
+		err = mq.db.SelectContext(ctx, &jobs, "SELECT * FROM jobs WHERE tenant_id=? AND status=? ORDER BY id DESC LIMIT ?", storer.TenantID(ctx), status, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+func (mq *MySQLQueue) Requeue(ctx context.Context, id int64) (*Job, error) {
+	var j Job
+	err := mq.execTx(ctx, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(ctx, &j, "SELECT * FROM jobs WHERE tenant_id=? AND id=? FOR UPDATE", storer.TenantID(ctx), id)
+		if err != nil {
+			return fmt.Errorf("error getting job: %w", err)
+		}
+		if j.Status != StatusDead {
+			return ErrNotDead
+		}
+
+		j.Status = StatusPending
+		j.Attempts = 0
+		j.VisibleAt = mq.now()
+		_, err = tx.ExecContext(ctx, "UPDATE jobs SET status=?, attempts=0, visible_at=? WHERE id=?", j.Status, j.VisibleAt, j.ID)
+		if err != nil {
+			return fmt.Errorf("error requeueing job: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &j, nil
+}
+
+func (mq *MySQLQueue) Purge(ctx context.Context, before time.Time, limit int) (int64, error) {
+	res, err := mq.db.ExecContext(ctx, "DELETE FROM jobs WHERE status=? AND updated_at<? LIMIT ?", StatusDone, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("error purging jobs: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,213 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+var columns = []string{"id", "tenant_id", "kind", "payload", "status", "attempts", "max_attempts", "visible_at", "last_error", "result", "created_at", "updated_at"}
+
+func withTestQueue(t *testing.T, fn func(*MySQLQueue, sqlmock.Sqlmock)) {
+	mockDB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer mockDB.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mq := NewMySQLQueue(sqlx.NewDb(mockDB, "sqlmock"))
+	mq.now = func() time.Time { return now }
+	fn(mq, mock)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEnqueue(t *testing.T) {
+	withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+		mock.ExpectExec("INSERT INTO jobs (tenant_id, kind, payload, max_attempts, visible_at) VALUES (?, ?, ?, ?, ?)").
+			WithArgs(int64(2), "notification", []byte(`{}`), DefaultMaxAttempts, mq.now()).
+			WillReturnResult(sqlmock.NewResult(3, 1))
+
+		j, err := mq.Enqueue(storer.WithTenant(context.Background(), 2), &Job{Kind: "notification", Payload: []byte(`{}`)})
+		require.NoError(t, err)
+		require.Equal(t, int64(3), j.ID)
+		require.Equal(t, StatusPending, j.Status)
+	})
+}
+
+func TestClaim(t *testing.T) {
+	selectQuery := "SELECT * FROM jobs WHERE status IN (?, ?) AND visible_at <= ? ORDER BY visible_at, id LIMIT ? FOR UPDATE SKIP LOCKED"
+
+	t.Run("success", func(t *testing.T) {
+		withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+			now := mq.now()
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectQuery).WithArgs(StatusPending, StatusRunning, now, 10).
+				WillReturnRows(sqlmock.NewRows(columns).
+					AddRow(1, 1, "notification", []byte(`{}`), StatusPending, 0, 10, now, nil, nil, now, now).
+					AddRow(2, 1, "notification", []byte(`{}`), StatusRunning, 3, 10, now, nil, nil, now, now))
+			mock.ExpectExec("UPDATE jobs SET status=?, attempts=attempts+1, visible_at=? WHERE id IN (?, ?)").
+				WithArgs(StatusRunning, now.Add(time.Minute), 1, 2).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectCommit()
+
+			jobs, err := mq.Claim(context.Background(), 10, time.Minute)
+			require.NoError(t, err)
+			require.Len(t, jobs, 2)
+			require.Equal(t, StatusRunning, jobs[0].Status)
+			require.Equal(t, 1, jobs[0].Attempts)
+			require.Equal(t, 4, jobs[1].Attempts)
+			require.Equal(t, now.Add(time.Minute), jobs[1].VisibleAt)
+		})
+	})
+
+	t.Run("no jobs", func(t *testing.T) {
+		withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectQuery).WillReturnRows(sqlmock.NewRows(columns))
+			mock.ExpectCommit()
+
+			jobs, err := mq.Claim(context.Background(), 10, time.Minute)
+			require.NoError(t, err)
+			require.Empty(t, jobs)
+		})
+	})
+
+	t.Run("failed selecting jobs", func(t *testing.T) {
+		withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectQuery).WillReturnError(errors.New("lock wait timeout"))
+			mock.ExpectRollback()
+
+			_, err := mq.Claim(context.Background(), 10, time.Minute)
+			require.Error(t, err)
+		})
+	})
+}
+
+func TestFinish(t *testing.T) {
+	j := &Job{ID: 1, Attempts: 2}
+
+	t.Run("complete", func(t *testing.T) {
+		withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+			mock.ExpectExec("UPDATE jobs SET status=?, last_error=NULL, result=? WHERE id=? AND status=? AND attempts=?").
+				WithArgs(StatusDone, "250 OK", 1, StatusRunning, 2).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			require.NoError(t, mq.Complete(context.Background(), j, "250 OK"))
+		})
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+			visibleAt := mq.now().Add(time.Second)
+			mock.ExpectExec("UPDATE jobs SET status=?, visible_at=?, last_error=? WHERE id=? AND status=? AND attempts=?").
+				WithArgs(StatusPending, visibleAt, "timeout", 1, StatusRunning, 2).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			require.NoError(t, mq.Retry(context.Background(), j, visibleAt, "timeout"))
+		})
+	})
+
+	t.Run("defer", func(t *testing.T) {
+		withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+			visibleAt := mq.now().Add(time.Second)
+			mock.ExpectExec("UPDATE jobs SET status=?, visible_at=?, attempts=attempts-1 WHERE id=? AND status=? AND attempts=?").
+				WithArgs(StatusPending, visibleAt, 1, StatusRunning, 2).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			require.NoError(t, mq.Defer(context.Background(), j, visibleAt))
+		})
+	})
+
+	t.Run("bury after the lease was lost", func(t *testing.T) {
+		withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+			mock.ExpectExec("UPDATE jobs SET status=?, last_error=? WHERE id=? AND status=? AND attempts=?").
+				WithArgs(StatusDead, "timeout", 1, StatusRunning, 2).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			require.ErrorIs(t, mq.Bury(context.Background(), j, "timeout"), ErrLeaseLost)
+		})
+	})
+}
+
+func TestListJobs(t *testing.T) {
+	withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+		now := mq.now()
+		mock.ExpectQuery("SELECT * FROM jobs WHERE tenant_id=? AND status=? ORDER BY id DESC LIMIT ?").
+			WithArgs(storer.DefaultTenantID, StatusDead, 50).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 1, "notification", []byte(`{}`), StatusDead, 10, 10, now, "timeout", nil, now, now))
+
+		jobs, err := mq.ListJobs(context.Background(), StatusDead, 50)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		require.Equal(t, "timeout", *jobs[0].LastError)
+	})
+}
+
+func TestRequeue(t *testing.T) {
+	selectQuery := "SELECT * FROM jobs WHERE tenant_id=? AND id=? FOR UPDATE"
+
+	t.Run("success", func(t *testing.T) {
+		withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+			now := mq.now()
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectQuery).WithArgs(storer.DefaultTenantID, 1).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 1, "notification", []byte(`{}`), StatusDead, 10, 10, now, "timeout", nil, now, now))
+			mock.ExpectExec("UPDATE jobs SET status=?, attempts=0, visible_at=? WHERE id=?").
+				WithArgs(StatusPending, now, 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			j, err := mq.Requeue(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, StatusPending, j.Status)
+			require.Zero(t, j.Attempts)
+		})
+	})
+
+	t.Run("not dead", func(t *testing.T) {
+		withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+			now := mq.now()
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectQuery).WithArgs(storer.DefaultTenantID, 1).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 1, "notification", []byte(`{}`), StatusPending, 1, 10, now, nil, nil, now, now))
+			mock.ExpectRollback()
+
+			_, err := mq.Requeue(context.Background(), 1)
+			require.ErrorIs(t, err, ErrNotDead)
+		})
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectQuery).WithArgs(storer.DefaultTenantID, 1).WillReturnError(sql.ErrNoRows)
+			mock.ExpectRollback()
+
+			_, err := mq.Requeue(context.Background(), 1)
+			require.ErrorIs(t, err, sql.ErrNoRows)
+		})
+	})
+}
+
+func TestPurge(t *testing.T) {
+	withTestQueue(t, func(mq *MySQLQueue, mock sqlmock.Sqlmock) {
+		before := mq.now().Add(-time.Hour)
+		mock.ExpectExec("DELETE FROM jobs WHERE status=? AND updated_at<? LIMIT ?").
+			WithArgs(StatusDone, before, 100).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+
+		n, err := mq.Purge(context.Background(), before, 100)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), n)
+	})
+}
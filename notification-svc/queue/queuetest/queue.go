@@ -0,0 +1,254 @@
+// Package queuetest provides an in-memory queue.Queue for tests.
+package queuetest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/notification-svc/queue"
+)
+
+// Queue keeps jobs in a map guarded by a mutex and follows the semantics of
+// queue.MySQLQueue, down to the fencing of claims by attempts. Any method can
+// be made to fail with FailOn.
+type Queue struct {
+	mu       sync.Mutex
+	nextID   int64
+	jobs     map[int64]queue.Job
+	failures map[string]error
+
+	// Now is the clock that visibility is measured with.
+	Now func() time.Time
+}
+
+var _ queue.Queue = (*Queue)(nil)
+
+func New() *Queue {
+	return &Queue{
+		jobs:     make(map[int64]queue.Job),
+		failures: make(map[string]error),
+		Now:      time.Now,
+	}
+}
+
+// FailOn makes every subsequent call to the named method return err.
+func (q *Queue) FailOn(method string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.failures[method] = err
+}
+
+func (q *Queue) fail(method string) error {
+	if err, ok := q.failures[method]; ok {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	return nil
+}
+
+func notFound(id int64) error {
+	return fmt.Errorf("job %d: %w", id, sql.ErrNoRows)
+}
+
+func (q *Queue) Enqueue(ctx context.Context, j *queue.Job) (*queue.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.fail("Enqueue"); err != nil {
+		return nil, err
+	}
+
+	now := q.Now()
+	q.nextID++
+	j.ID = q.nextID
+	j.TenantID = storer.TenantID(ctx)
+	j.Status = queue.StatusPending
+	if j.MaxAttempts == 0 {
+		j.MaxAttempts = queue.DefaultMaxAttempts
+	}
+	if j.VisibleAt.IsZero() {
+		j.VisibleAt = now
+	}
+	j.CreatedAt = now
+	j.UpdatedAt = now
+	q.jobs[j.ID] = *j
+
+	return j, nil
+}
+
+func (q *Queue) Claim(_ context.Context, limit int, visibility time.Duration) ([]queue.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.fail("Claim"); err != nil {
+		return nil, err
+	}
+
+	now := q.Now()
+	var jobs []queue.Job
+	for _, j := range q.jobs {
+		if (j.Status == queue.StatusPending || j.Status == queue.StatusRunning) && !j.VisibleAt.After(now) {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		if !jobs[a].VisibleAt.Equal(jobs[b].VisibleAt) {
+			return jobs[a].VisibleAt.Before(jobs[b].VisibleAt)
+		}
+		return jobs[a].ID < jobs[b].ID
+	})
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+
+	for i := range jobs {
+		jobs[i].Status = queue.StatusRunning
+		jobs[i].Attempts++
+		jobs[i].VisibleAt = now.Add(visibility)
+		jobs[i].UpdatedAt = now
+		q.jobs[jobs[i].ID] = jobs[i]
+	}
+
+	return jobs, nil
+}
+
+func (q *Queue) Complete(_ context.Context, j *queue.Job, result string) error {
+	return q.finish("Complete", j, func(stored *queue.Job) {
+		stored.Status = queue.StatusDone
+		stored.LastError = nil
+		stored.Result = &result
+	})
+}
+
+func (q *Queue) Retry(_ context.Context, j *queue.Job, visibleAt time.Time, lastErr string) error {
+	return q.finish("Retry", j, func(stored *queue.Job) {
+		stored.Status = queue.StatusPending
+		stored.VisibleAt = visibleAt
+		stored.LastError = &lastErr
+	})
+}
+
+func (q *Queue) Defer(_ context.Context, j *queue.Job, visibleAt time.Time) error {
+	return q.finish("Defer", j, func(stored *queue.Job) {
+		stored.Status = queue.StatusPending
+		stored.VisibleAt = visibleAt
+		stored.Attempts--
+	})
+}
+
+func (q *Queue) Bury(_ context.Context, j *queue.Job, lastErr string) error {
+	return q.finish("Bury", j, func(stored *queue.Job) {
+		stored.Status = queue.StatusDead
+		stored.LastError = &lastErr
+	})
+}
+
+func (q *Queue) finish(method string, j *queue.Job, update func(*queue.Job)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.fail(method); err != nil {
+		return err
+	}
+
+	stored, ok := q.jobs[j.ID]
+	if !ok || stored.Status != queue.StatusRunning || stored.Attempts != j.Attempts {
+		return queue.ErrLeaseLost
+	}
+
+	update(&stored)
+	stored.UpdatedAt = q.Now()
+	q.jobs[j.ID] = stored
+
+	return nil
+}
+
+func (q *Queue) GetJob(ctx context.Context, id int64) (*queue.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.fail("GetJob"); err != nil {
+		return nil, err
+	}
+
+	j, ok := q.jobs[id]
+	if !ok || j.TenantID != storer.TenantID(ctx) {
+		return nil, notFound(id)
+	}
+
+	return &j, nil
+}
+
+func (q *Queue) ListJobs(ctx context.Context, status queue.Status, limit int) ([]queue.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.fail("ListJobs"); err != nil {
+		return nil, err
+	}
+
+	var jobs []queue.Job
+	for _, j := range q.jobs {
+		if j.TenantID == storer.TenantID(ctx) && (status == "" || j.Status == status) {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID > jobs[b].ID })
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+
+	return jobs, nil
+}
+
+func (q *Queue) Requeue(ctx context.Context, id int64) (*queue.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.fail("Requeue"); err != nil {
+		return nil, err
+	}
+
+	j, ok := q.jobs[id]
+	if !ok || j.TenantID != storer.TenantID(ctx) {
+		return nil, notFound(id)
+	}
+	if j.Status != queue.StatusDead {
+		return nil, queue.ErrNotDead
+	}
+
+	j.Status = queue.StatusPending
+	j.Attempts = 0
+	j.VisibleAt = q.Now()
+	j.UpdatedAt = j.VisibleAt
+	q.jobs[id] = j
+
+	return &j, nil
+}
+
+func (q *Queue) Purge(_ context.Context, before time.Time, limit int) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.fail("Purge"); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for id, j := range q.jobs {
+		if n == int64(limit) {
+			break
+		}
+		if j.Status == queue.StatusDone && j.UpdatedAt.Before(before) {
+			delete(q.jobs, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
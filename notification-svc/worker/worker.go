@@ -0,0 +1,342 @@
+// Package worker runs the jobs of the notification-svc queue.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/notification-svc/queue"
+)
+
+type Config struct {
+	// Workers is how many jobs run at the same time.
+	Workers      int
+	PollInterval time.Duration
+	// BatchSize caps the jobs claimed at once.
+	BatchSize int
+	// Visibility is how long a claimed job is hidden from other workers. A
+	// job is cancelled when it runs longer, since it may already have been
+	// claimed again.
+	Visibility time.Duration
+	// A failed job is retried after BaseBackoff, doubled for every attempt
+	// before the last one, up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// ShutdownTimeout is how long running jobs may take to finish once Run
+	// stops claiming jobs, before they are cancelled.
+	ShutdownTimeout time.Duration
+	// Done jobs are deleted every PurgeInterval once they finished more than
+	// Retention ago. Either being zero keeps them forever.
+	Retention     time.Duration
+	PurgeInterval time.Duration
+}
+
+var DefaultConfig = Config{
+	Workers:         4,
+	PollInterval:    time.Second,
+	BatchSize:       10,
+	Visibility:      5 * time.Minute,
+	BaseBackoff:     10 * time.Second,
+	MaxBackoff:      time.Hour,
+	ShutdownTimeout: 30 * time.Second,
+	Retention:       7 * 24 * time.Hour,
+	PurgeInterval:   time.Hour,
+}
+
+// purgeBatchSize caps the jobs deleted by a single statement, so that purging
+// a large backlog does not hold locks for long.
+const purgeBatchSize = 1000
+
+// Handler runs a job and returns the result that is recorded with it. Jobs
+// whose handler returns an error are retried until they run out of attempts,
+// unless the error is Permanent.
+type Handler func(ctx context.Context, j *queue.Job) (string, error)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as a failure that retrying cannot fix, so that the job
+// goes straight to the dead-letter state.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var perm *permanentError
+	return errors.As(err, &perm)
+}
+
+type deferError struct {
+	until time.Time
+}
+
+func (e *deferError) Error() string {
+	return fmt.Sprintf("deferred until %s", e.until.Format(time.RFC3339))
+}
+
+// Defer returns the job to the queue until the given time without counting
+// the attempt, for handlers that cannot run it yet, such as when the rate
+// limit of a channel is exhausted.
+func Defer(until time.Time) error {
+	return &deferError{until: until}
+}
+
+type Stats struct {
+	Claimed   uint64 `json:"claimed"`
+	Completed uint64 `json:"completed"`
+	Retried   uint64 `json:"retried"`
+	Deferred  uint64 `json:"deferred"`
+	Buried    uint64 `json:"buried"`
+	Purged    uint64 `json:"purged"`
+	// Failures counts the errors of the queue itself.
+	Failures uint64 `json:"failures"`
+	Active   int64  `json:"active"`
+}
+
+type Worker struct {
+	queue    queue.Queue
+	config   Config
+	handlers map[string]Handler
+	now      func() time.Time
+
+	claimed   atomic.Uint64
+	completed atomic.Uint64
+	retried   atomic.Uint64
+	deferred  atomic.Uint64
+	buried    atomic.Uint64
+	purged    atomic.Uint64
+	failures  atomic.Uint64
+	active    atomic.Int64
+}
+
+func NewWorker(q queue.Queue, config Config) *Worker {
+	return &Worker{
+		queue:    q,
+		config:   config,
+		handlers: make(map[string]Handler),
+		now:      time.Now,
+	}
+}
+
+// Handle registers the handler of the jobs of a kind. Jobs of kinds without
+// a handler are buried. It must not be called once Run has started.
+func (w *Worker) Handle(kind string, h Handler) {
+	w.handlers[kind] = h
+}
+
+// Run claims and runs jobs until ctx is cancelled. It then stops claiming
+// jobs and waits up to ShutdownTimeout for the running ones, cancelling them
+// if they take longer. Cancelled jobs are made visible again right away.
+func (w *Worker) Run(ctx context.Context) {
+	// Jobs outlive ctx for the shutdown timeout.
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, w.config.Workers)
+
+	if w.config.Retention > 0 && w.config.PurgeInterval > 0 {
+		purged := make(chan struct{})
+		go func() {
+			w.purgeEvery(ctx)
+			close(purged)
+		}()
+		defer func() { <-purged }()
+	}
+
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		n := min(w.config.Workers-len(slots), w.config.BatchSize)
+		if n > 0 {
+			jobs, err := w.queue.Claim(ctx, n, w.config.Visibility)
+			if err != nil && ctx.Err() == nil {
+				w.failures.Add(1)
+				log.Printf("Error claiming jobs: %v", err)
+			}
+
+			w.claimed.Add(uint64(len(jobs)))
+			for _, j := range jobs {
+				// Only this loop fills slots, so there is room for n jobs.
+				slots <- struct{}{}
+				wg.Add(1)
+				go func(j queue.Job) {
+					defer wg.Done()
+					defer func() { <-slots }()
+
+					w.process(jobCtx, &j)
+				}(j)
+			}
+
+			// A full batch suggests that more jobs are waiting.
+			if len(jobs) == n {
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(w.config.ShutdownTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Printf("Cancelling %d jobs still running after %s", w.active.Load(), w.config.ShutdownTimeout)
+		cancelJobs()
+		<-done
+	}
+}
+
+// purgeEvery deletes the done jobs past their retention every PurgeInterval
+// until ctx is cancelled.
+func (w *Worker) purgeEvery(ctx context.Context) {
+	ticker := time.NewTicker(w.config.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		w.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) purge(ctx context.Context) {
+	before := w.now().Add(-w.config.Retention)
+	for ctx.Err() == nil {
+		n, err := w.queue.Purge(ctx, before, purgeBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.failures.Add(1)
+				log.Printf("Error purging jobs: %v", err)
+			}
+			return
+		}
+
+		w.purged.Add(uint64(n))
+		if n < purgeBatchSize {
+			return
+		}
+	}
+}
+
+// process runs j and records the outcome. ctx is cancelled on shutdown.
+func (w *Worker) process(ctx context.Context, j *queue.Job) {
+	w.active.Add(1)
+	defer w.active.Add(-1)
+
+	// The outcome is recorded even if the job was cancelled.
+	qctx := storer.WithTenant(context.WithoutCancel(ctx), j.TenantID)
+
+	if j.Attempts > j.MaxAttempts {
+		// The job was claimed again after its visibility timeout: whatever
+		// ran its last attempt never recorded the outcome.
+		w.bury(qctx, j, "max attempts exceeded")
+		return
+	}
+
+	h, ok := w.handlers[j.Kind]
+	if !ok {
+		w.bury(qctx, j, fmt.Sprintf("no handler for kind %q", j.Kind))
+		return
+	}
+
+	result, err := w.run(ctx, h, j)
+
+	var deferred *deferError
+	switch {
+	case err == nil:
+		w.record(j, w.queue.Complete(qctx, j, result), &w.completed)
+	case errors.As(err, &deferred):
+		w.record(j, w.queue.Defer(qctx, j, deferred.until), &w.deferred)
+	case ctx.Err() != nil:
+		w.record(j, w.queue.Retry(qctx, j, w.now(), "interrupted by shutdown"), &w.retried)
+	case IsPermanent(err) || j.Attempts >= j.MaxAttempts:
+		w.bury(qctx, j, err.Error())
+	default:
+		log.Printf("Job %d failed on attempt %d of %d: %v", j.ID, j.Attempts, j.MaxAttempts, err)
+		w.record(j, w.queue.Retry(qctx, j, w.now().Add(w.backoff(j.Attempts)), err.Error()), &w.retried)
+	}
+}
+
+// run calls h within the visibility timeout of j and in its tenant.
+func (w *Worker) run(ctx context.Context, h Handler, j *queue.Job) (result string, err error) {
+	ctx, cancel := context.WithTimeout(storer.WithTenant(ctx, j.TenantID), w.config.Visibility)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in job %d: %v\n%s", j.ID, r, debug.Stack())
+			result, err = "", fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return h(ctx, j)
+}
+
+func (w *Worker) bury(ctx context.Context, j *queue.Job, reason string) {
+	log.Printf("Job %d is dead after %d attempts: %s", j.ID, j.Attempts, reason)
+	w.record(j, w.queue.Bury(ctx, j, reason), &w.buried)
+}
+
+func (w *Worker) record(j *queue.Job, err error, counter *atomic.Uint64) {
+	switch {
+	case errors.Is(err, queue.ErrLeaseLost):
+		log.Printf("Job %d was claimed again before attempt %d finished", j.ID, j.Attempts)
+	case err != nil:
+		w.failures.Add(1)
+		log.Printf("Error updating job %d: %v", j.ID, err)
+	default:
+		counter.Add(1)
+	}
+}
+
+// backoff returns the delay before the attempt after the given one.
+func (w *Worker) backoff(attempt int) time.Duration {
+	d := w.config.BaseBackoff
+	for i := 1; i < attempt && d < w.config.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, w.config.MaxBackoff)
+}
+
+func (w *Worker) Stats() Stats {
+	return Stats{
+		Claimed:   w.claimed.Load(),
+		Completed: w.completed.Load(),
+		Retried:   w.retried.Load(),
+		Deferred:  w.deferred.Load(),
+		Buried:    w.buried.Load(),
+		Purged:    w.purged.Load(),
+		Failures:  w.failures.Load(),
+		Active:    w.active.Load(),
+	}
+}
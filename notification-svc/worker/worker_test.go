@@ -0,0 +1,301 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Turtel216/micro-panel/micropanel-api/storer"
+	"github.com/Turtel216/micro-panel/notification-svc/queue"
+	"github.com/Turtel216/micro-panel/notification-svc/queue/queuetest"
+	"github.com/stretchr/testify/require"
+)
+
+var testConfig = Config{
+	Workers:         2,
+	PollInterval:    time.Millisecond,
+	BatchSize:       10,
+	Visibility:      time.Minute,
+	BaseBackoff:     time.Second,
+	MaxBackoff:      4 * time.Second,
+	ShutdownTimeout: time.Second,
+}
+
+func enqueue(t *testing.T, q *queuetest.Queue, kind string, maxAttempts int) *queue.Job {
+	t.Helper()
+
+	j, err := q.Enqueue(context.Background(), &queue.Job{Kind: kind, Payload: []byte(`{}`), MaxAttempts: maxAttempts})
+	require.NoError(t, err)
+
+	return j
+}
+
+func getJob(t *testing.T, q *queuetest.Queue, id int64) *queue.Job {
+	t.Helper()
+
+	j, err := q.GetJob(context.Background(), id)
+	require.NoError(t, err)
+
+	return j
+}
+
+// claim runs the jobs of a single claim through process, as Run does.
+func claim(t *testing.T, w *Worker, q *queuetest.Queue) {
+	t.Helper()
+
+	jobs, err := q.Claim(context.Background(), 10, w.config.Visibility)
+	require.NoError(t, err)
+	for _, j := range jobs {
+		w.process(context.Background(), &j)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	t.Run("completes jobs", func(t *testing.T) {
+		q := queuetest.New()
+		j := enqueue(t, q, "test", 3)
+
+		w := NewWorker(q, testConfig)
+		var tenant int64
+		w.Handle("test", func(ctx context.Context, j *queue.Job) (string, error) {
+			tenant = storer.TenantID(ctx)
+			return "sent", nil
+		})
+
+		claim(t, w, q)
+		got := getJob(t, q, j.ID)
+		require.Equal(t, queue.StatusDone, got.Status)
+		require.Equal(t, "sent", *got.Result)
+		require.Equal(t, j.TenantID, tenant)
+		require.Equal(t, uint64(1), w.Stats().Completed)
+	})
+
+	t.Run("retries with backoff until the job is dead", func(t *testing.T) {
+		q := queuetest.New()
+		now := time.Now()
+		q.Now = func() time.Time { return now }
+		j := enqueue(t, q, "test", 3)
+
+		w := NewWorker(q, testConfig)
+		w.now = q.Now
+		w.Handle("test", func(context.Context, *queue.Job) (string, error) { return "", errors.New("unavailable") })
+
+		claim(t, w, q)
+		got := getJob(t, q, j.ID)
+		require.Equal(t, queue.StatusPending, got.Status)
+		require.Equal(t, now.Add(time.Second), got.VisibleAt)
+		require.Equal(t, "unavailable", *got.LastError)
+
+		now = got.VisibleAt
+		claim(t, w, q)
+		got = getJob(t, q, j.ID)
+		require.Equal(t, now.Add(2*time.Second), got.VisibleAt)
+
+		now = got.VisibleAt
+		claim(t, w, q)
+		got = getJob(t, q, j.ID)
+		require.Equal(t, queue.StatusDead, got.Status)
+		require.Equal(t, 3, got.Attempts)
+		require.Equal(t, uint64(2), w.Stats().Retried)
+		require.Equal(t, uint64(1), w.Stats().Buried)
+	})
+
+	t.Run("buries permanent failures", func(t *testing.T) {
+		q := queuetest.New()
+		j := enqueue(t, q, "test", 3)
+
+		w := NewWorker(q, testConfig)
+		w.Handle("test", func(context.Context, *queue.Job) (string, error) {
+			return "", Permanent(errors.New("invalid recipient"))
+		})
+
+		claim(t, w, q)
+		got := getJob(t, q, j.ID)
+		require.Equal(t, queue.StatusDead, got.Status)
+		require.Equal(t, "invalid recipient", *got.LastError)
+	})
+
+	t.Run("defers jobs without counting the attempt", func(t *testing.T) {
+		q := queuetest.New()
+		now := time.Now()
+		q.Now = func() time.Time { return now }
+		j := enqueue(t, q, "test", 1)
+
+		w := NewWorker(q, testConfig)
+		w.Handle("test", func(context.Context, *queue.Job) (string, error) { return "", Defer(now.Add(time.Minute)) })
+
+		claim(t, w, q)
+		got := getJob(t, q, j.ID)
+		require.Equal(t, queue.StatusPending, got.Status)
+		require.Zero(t, got.Attempts)
+		require.Equal(t, now.Add(time.Minute), got.VisibleAt)
+		require.Equal(t, uint64(1), w.Stats().Deferred)
+	})
+
+	t.Run("buries jobs without a handler", func(t *testing.T) {
+		q := queuetest.New()
+		j := enqueue(t, q, "unknown", 3)
+
+		claim(t, NewWorker(q, testConfig), q)
+		require.Equal(t, queue.StatusDead, getJob(t, q, j.ID).Status)
+	})
+
+	t.Run("buries jobs reclaimed past their attempts", func(t *testing.T) {
+		q := queuetest.New()
+		now := time.Now()
+		q.Now = func() time.Time { return now }
+		j := enqueue(t, q, "test", 1)
+
+		// The first claim is never finished, as if its worker crashed.
+		_, err := q.Claim(context.Background(), 10, time.Minute)
+		require.NoError(t, err)
+		now = now.Add(time.Minute)
+
+		var calls atomic.Int64
+		w := NewWorker(q, testConfig)
+		w.Handle("test", func(context.Context, *queue.Job) (string, error) {
+			calls.Add(1)
+			return "", nil
+		})
+
+		claim(t, w, q)
+		require.Equal(t, queue.StatusDead, getJob(t, q, j.ID).Status)
+		require.Zero(t, calls.Load())
+	})
+
+	t.Run("recovers from panics", func(t *testing.T) {
+		q := queuetest.New()
+		j := enqueue(t, q, "test", 3)
+
+		w := NewWorker(q, testConfig)
+		w.Handle("test", func(context.Context, *queue.Job) (string, error) { panic("boom") })
+
+		claim(t, w, q)
+		got := getJob(t, q, j.ID)
+		require.Equal(t, queue.StatusPending, got.Status)
+		require.Equal(t, "panic: boom", *got.LastError)
+	})
+}
+
+func TestPurge(t *testing.T) {
+	q := queuetest.New()
+	now := time.Now()
+	q.Now = func() time.Time { return now }
+
+	old := enqueue(t, q, "test", 3)
+	dead := enqueue(t, q, "fail", 1)
+	w := NewWorker(q, testConfig)
+	w.now = q.Now
+	w.Handle("test", func(context.Context, *queue.Job) (string, error) { return "", nil })
+	w.Handle("fail", func(context.Context, *queue.Job) (string, error) { return "", errors.New("unavailable") })
+	claim(t, w, q)
+
+	now = now.Add(time.Hour)
+	recent := enqueue(t, q, "test", 3)
+	claim(t, w, q)
+
+	w.config.Retention = 30 * time.Minute
+	w.purge(context.Background())
+
+	_, err := q.GetJob(context.Background(), old.ID)
+	require.Error(t, err)
+	require.Equal(t, queue.StatusDead, getJob(t, q, dead.ID).Status)
+	require.Equal(t, queue.StatusDone, getJob(t, q, recent.ID).Status)
+	require.Equal(t, uint64(1), w.Stats().Purged)
+}
+
+func TestBackoff(t *testing.T) {
+	w := NewWorker(nil, testConfig)
+	require.Equal(t, time.Second, w.backoff(1))
+	require.Equal(t, 2*time.Second, w.backoff(2))
+	require.Equal(t, 4*time.Second, w.backoff(3))
+	require.Equal(t, 4*time.Second, w.backoff(100))
+}
+
+func TestRun(t *testing.T) {
+	t.Run("runs jobs until cancelled", func(t *testing.T) {
+		q := queuetest.New()
+		for i := 0; i < 5; i++ {
+			enqueue(t, q, "test", 3)
+		}
+
+		w := NewWorker(q, testConfig)
+		w.Handle("test", func(context.Context, *queue.Job) (string, error) { return "", nil })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			w.Run(ctx)
+			close(done)
+		}()
+
+		require.Eventually(t, func() bool { return w.Stats().Completed == 5 }, time.Second, time.Millisecond)
+		cancel()
+		<-done
+	})
+
+	t.Run("waits for running jobs on shutdown", func(t *testing.T) {
+		q := queuetest.New()
+		j := enqueue(t, q, "test", 3)
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		w := NewWorker(q, testConfig)
+		w.Handle("test", func(context.Context, *queue.Job) (string, error) {
+			close(started)
+			<-release
+			return "", nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			w.Run(ctx)
+			close(done)
+		}()
+
+		<-started
+		cancel()
+		select {
+		case <-done:
+			t.Fatal("Run returned before the running job finished")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		close(release)
+		<-done
+		require.Equal(t, queue.StatusDone, getJob(t, q, j.ID).Status)
+	})
+
+	t.Run("cancels jobs after the shutdown timeout", func(t *testing.T) {
+		q := queuetest.New()
+		j := enqueue(t, q, "test", 3)
+
+		config := testConfig
+		config.ShutdownTimeout = 10 * time.Millisecond
+		started := make(chan struct{})
+		w := NewWorker(q, config)
+		w.Handle("test", func(ctx context.Context, _ *queue.Job) (string, error) {
+			close(started)
+			<-ctx.Done()
+			return "", ctx.Err()
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			w.Run(ctx)
+			close(done)
+		}()
+
+		<-started
+		cancel()
+		<-done
+
+		got := getJob(t, q, j.ID)
+		require.Equal(t, queue.StatusPending, got.Status)
+		require.Equal(t, "interrupted by shutdown", *got.LastError)
+	})
+}
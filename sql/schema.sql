@@ -2,8 +2,21 @@ CREATE DATABASE IF NOT EXISTS micropanel;
 
 USE micropanel;
 
+CREATE TABLE `tenants` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `slug` varchar(64) NOT NULL,
+  `host` varchar(255),
+  `created_at` datetime DEFAULT (now()),
+  UNIQUE(slug),
+  UNIQUE(host)
+);
+
+INSERT INTO `tenants` (`id`, `name`, `slug`) VALUES (1, 'Default', 'default');
+
 CREATE TABLE `products` (
   `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `tenant_id` int NOT NULL DEFAULT 1,
   `name` varchar(255) NOT NULL,
   `image` varchar(255) NOT NULL,
   `category` varchar(255) NOT NULL,
@@ -12,29 +25,44 @@ CREATE TABLE `products` (
   `num_reviews` int NOT NULL DEFAULT 0,
   `price` decimal(10,2) NOT NULL,
   `count_in_stock` int NOT NULL,
+  `version` int NOT NULL DEFAULT 1,
   `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
-  `updated_at` datetime
+  `updated_at` datetime,
+  INDEX (`tenant_id`),
+  FOREIGN KEY (`tenant_id`) REFERENCES `tenants` (`id`)
 );
 
 CREATE TABLE `users` (
   `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `tenant_id` int NOT NULL DEFAULT 1,
   `name` varchar(255) NOT NULL,
   `email` varchar(255) NOT NULL,
   `password` varchar(255) NOT NULL,
   `is_admin` bool NOT NULL DEFAULT false,
-  UNIQUE(email)
+  `email_verified` bool NOT NULL DEFAULT false,
+  `totp_secret` varchar(64),
+  `totp_enabled` bool NOT NULL DEFAULT false,
+  `totp_last_step` bigint NOT NULL DEFAULT 0,
+  `failed_logins` int NOT NULL DEFAULT 0,
+  `locked_until` datetime,
+  UNIQUE(tenant_id, email),
+  FOREIGN KEY (`tenant_id`) REFERENCES `tenants` (`id`)
 );
 
 CREATE TABLE `orders` (
   `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `tenant_id` int NOT NULL DEFAULT 1,
   `user_id` int NOT NULL,
   `payment_method` varchar(255) NOT NULL,
   `tax_price` decimal(10,2) NOT NULL,
   `shipping_price` decimal(10,2) NOT NULL,
   `total_price` decimal(10,2) NOT NULL,
+  `status` varchar(32) NOT NULL DEFAULT 'pending',
   `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
   `updated_at` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-  CONSTRAINT `user_id_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`)
+  INDEX (`tenant_id`),
+  CONSTRAINT `user_id_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`),
+  FOREIGN KEY (`tenant_id`) REFERENCES `tenants` (`id`)
 );
 
 CREATE TABLE `order_items` (
@@ -49,9 +77,129 @@ CREATE TABLE `order_items` (
 
 CREATE TABLE `sessions` (
   `id` varchar(255) PRIMARY KEY NOT NULL,
+  `tenant_id` int NOT NULL DEFAULT 1,
+  `family_id` varchar(255) NOT NULL,
+  `replaced_by` varchar(255),
   `user_email` varchar(255) NOT NULL,
   `refresh_token` varchar(512) NOT NULL,
+  `user_agent` varchar(512) NOT NULL DEFAULT '',
+  `client_ip` varchar(45) NOT NULL DEFAULT '',
   `is_revoked` bool NOT NULL DEFAULT false,
+  `revoked_at` datetime,
+  `created_at` datetime DEFAULT (now()),
+  `last_used_at` datetime DEFAULT (now()),
+  `expires_at` datetime,
+  `access_token_id` varchar(255),
+  `access_expires_at` datetime,
+  INDEX (`family_id`),
+  INDEX (`tenant_id`, `user_email`),
+  INDEX (`expires_at`),
+  INDEX (`access_token_id`),
+  FOREIGN KEY (`tenant_id`) REFERENCES `tenants` (`id`)
+);
+
+CREATE TABLE `security_events` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `tenant_id` int NOT NULL DEFAULT 1,
+  `user_email` varchar(255) NOT NULL,
+  `event_type` varchar(64) NOT NULL,
+  `session_id` varchar(255),
+  `family_id` varchar(255),
+  `created_at` datetime DEFAULT (now()),
+  INDEX (`tenant_id`, `user_email`),
+  FOREIGN KEY (`tenant_id`) REFERENCES `tenants` (`id`)
+);
+
+CREATE TABLE `idempotency_keys` (
+  `user_id` int NOT NULL,
+  `idempotency_key` varchar(255) NOT NULL,
+  `request_hash` char(64) NOT NULL,
+  `response_code` int,
+  `response_body` mediumblob,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  `expires_at` datetime NOT NULL,
+  PRIMARY KEY (`user_id`, `idempotency_key`),
+  INDEX (`expires_at`)
+);
+
+CREATE TABLE `user_tokens` (
+  `token_hash` char(64) PRIMARY KEY NOT NULL,
+  `user_id` int NOT NULL,
+  `purpose` varchar(32) NOT NULL,
+  `created_at` datetime DEFAULT (now()),
+  `expires_at` datetime NOT NULL,
+  `used_at` datetime,
+  INDEX (`user_id`, `purpose`),
+  FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `jobs` (
+  `id` bigint PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `tenant_id` int NOT NULL DEFAULT 1,
+  `kind` varchar(32) NOT NULL,
+  `payload` json NOT NULL,
+  `status` varchar(16) NOT NULL DEFAULT 'pending',
+  `attempts` int NOT NULL DEFAULT 0,
+  `max_attempts` int NOT NULL DEFAULT 10,
+  `visible_at` datetime NOT NULL DEFAULT (now()),
+  `last_error` text,
+  `result` text,
+  `created_at` datetime DEFAULT (now()),
+  `updated_at` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  INDEX (`status`, `visible_at`),
+  INDEX (`status`, `updated_at`),
+  INDEX (`tenant_id`, `status`),
+  FOREIGN KEY (`tenant_id`) REFERENCES `tenants` (`id`)
+);
+
+CREATE TABLE `recovery_codes` (
+  `user_id` int NOT NULL,
+  `code_hash` char(64) NOT NULL,
+  `used_at` datetime,
+  PRIMARY KEY (`user_id`, `code_hash`),
+  FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `api_keys` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `user_id` int NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `prefix` varchar(16) NOT NULL,
+  `key_hash` char(64) NOT NULL,
+  `scopes` varchar(255) NOT NULL,
+  `created_at` datetime DEFAULT (now()),
+  `expires_at` datetime,
+  `last_used_at` datetime,
+  `revoked_at` datetime,
+  UNIQUE(prefix),
+  INDEX (`user_id`),
+  FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `login_history` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `user_id` int NOT NULL,
+  `client_ip` varchar(45) NOT NULL,
+  `user_agent` varchar(512) NOT NULL,
+  `created_at` datetime DEFAULT (now()),
+  INDEX (`user_id`),
+  FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `denied_tokens` (
+  `jti` varchar(255) PRIMARY KEY NOT NULL,
+  `expires_at` datetime NOT NULL,
+  INDEX (`expires_at`)
+);
+
+CREATE TABLE `events` (
+  `id` bigint PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `tenant_id` int NOT NULL DEFAULT 1,
+  `kind` varchar(32) NOT NULL,
+  `entity_id` int NOT NULL,
+  `status` varchar(32) NOT NULL DEFAULT '',
+  `count_in_stock` int NOT NULL DEFAULT 0,
   `created_at` datetime DEFAULT (now()),
-  `expires_at` datetime
+  INDEX (`created_at`),
+  FOREIGN KEY (`tenant_id`) REFERENCES `tenants` (`id`)
 );
@@ -8,28 +8,54 @@ import (
 	"github.com/google/uuid"
 )
 
+// Type distinguishes short lived access tokens, which authenticate requests,
+// from refresh tokens, which may only be exchanged for new tokens, and from
+// MFA tokens, which only prove that a password check has passed.
+type Type string
+
+const (
+	AccessToken  Type = "access"
+	RefreshToken Type = "refresh"
+	MFAToken     Type = "mfa"
+)
+
+// UserClaims identify a user within a tenant. Tokens issued before tenants
+// were introduced have a zero TenantID.
 type UserClaims struct {
-	ID      int64  `json:"id"`
-	Email   string `json:"email"`
-	IsAdmin bool   `json:"is_admin"`
+	ID       int64  `json:"id"`
+	Email    string `json:"email"`
+	IsAdmin  bool   `json:"is_admin"`
+	TenantID int64  `json:"tenant_id"`
+	Type     Type   `json:"typ"`
 	jwt.RegisteredClaims
 }
 
-func NewUserClaims(id int64, email string, isAdmin bool, duration time.Duration) (*UserClaims, error) {
+func NewUserClaims(id int64, email string, isAdmin bool, tenantID int64, tokenType Type, duration time.Duration) (*UserClaims, error) {
 	tokenID, err := uuid.NewRandom()
 	if err != nil {
 		return nil, fmt.Errorf("error generating token ID: %w", err)
 	}
 
 	return &UserClaims{
-		Email:   email,
-		ID:      id,
-		IsAdmin: isAdmin,
+		Email:    email,
+		ID:       id,
+		IsAdmin:  isAdmin,
+		TenantID: tenantID,
+		Type:     tokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        tokenID.String(),
 			Subject:   email,
+			Audience:  jwt.ClaimStrings{string(tokenType)},
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
 		},
 	}, nil
 }
+
+func (c *UserClaims) checkType(tokenType Type) error {
+	if c.Type != tokenType {
+		return fmt.Errorf("%w: expected %s token", ErrWrongTokenType, tokenType)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package token
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Denylist holds the IDs (jti) of tokens that were revoked before they
+// expired. Entries only need to be kept until expiresAt, after which the
+// token is rejected anyway. storer.Storer implements it on top of MySQL for
+// deployments with several instances.
+type Denylist interface {
+	DenyToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenDenied(ctx context.Context, jti string) (bool, error)
+}
+
+// MemoryDenylist is a Denylist for a single instance. Expired entries are
+// dropped every sweepInterval.
+type MemoryDenylist struct {
+	mu        sync.Mutex
+	entries   map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+const sweepInterval = time.Minute
+
+var _ Denylist = (*MemoryDenylist)(nil)
+
+func NewMemoryDenylist() *MemoryDenylist {
+	return &MemoryDenylist{
+		entries: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+func (d *MemoryDenylist) DenyToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if now.Sub(d.lastSweep) >= sweepInterval {
+		for id, exp := range d.entries {
+			if !exp.After(now) {
+				delete(d.entries, id)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	if expiresAt.After(now) {
+		d.entries[jti] = expiresAt
+	}
+	return nil
+}
+
+func (d *MemoryDenylist) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	exp, ok := d.entries[jti]
+	return ok && exp.After(d.now()), nil
+}
+
+// Len returns the number of entries, including expired ones that have not
+// been swept yet.
+func (d *MemoryDenylist) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.entries)
+}
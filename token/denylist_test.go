@@ -0,0 +1,43 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryDenylist(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	d := NewMemoryDenylist()
+	d.now = func() time.Time { return now }
+
+	require.NoError(t, d.DenyToken(ctx, "a", now.Add(time.Minute)))
+	require.NoError(t, d.DenyToken(ctx, "b", now.Add(time.Hour)))
+	require.NoError(t, d.DenyToken(ctx, "expired", now.Add(-time.Second)))
+	require.Equal(t, 2, d.Len())
+
+	denied, err := d.IsTokenDenied(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, denied)
+
+	denied, err = d.IsTokenDenied(ctx, "unknown")
+	require.NoError(t, err)
+	require.False(t, denied)
+
+	now = now.Add(2 * time.Minute)
+	denied, err = d.IsTokenDenied(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, denied)
+	require.Equal(t, 2, d.Len())
+
+	require.NoError(t, d.DenyToken(ctx, "c", now.Add(time.Minute)))
+	require.Equal(t, 2, d.Len())
+
+	denied, err = d.IsTokenDenied(ctx, "b")
+	require.NoError(t, err)
+	require.True(t, denied)
+}
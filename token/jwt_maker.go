@@ -7,22 +7,34 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const defaultKeyID = "default"
+
 type JWTMaker struct {
-	secretKey string
+	keys *KeySet
 }
 
+// NewJWTMaker returns a maker that signs and verifies HS256 tokens with a
+// single shared secret.
 func NewJWTMaker(secretKey string) *JWTMaker {
-	return &JWTMaker{secretKey}
+	keys, _ := NewKeySet(NewHMACKey(defaultKeyID, []byte(secretKey)))
+	return &JWTMaker{keys}
+}
+
+func NewJWTMakerWithKeys(keys *KeySet) *JWTMaker {
+	return &JWTMaker{keys}
 }
 
-func (maker *JWTMaker) CreateToken(id int64, email string, isAdmin bool, duration time.Duration) (string, *UserClaims, error) {
-	claims, err := NewUserClaims(id, email, isAdmin, duration)
+func (maker *JWTMaker) CreateToken(id int64, email string, isAdmin bool, tenantID int64, tokenType Type, duration time.Duration) (string, *UserClaims, error) {
+	claims, err := NewUserClaims(id, email, isAdmin, tenantID, tokenType, duration)
 	if err != nil {
 		return "", nil, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
-	tokenStr, err := token.SignedString([]byte(maker.secretKey))
+	key := maker.keys.signing
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	tokenStr, err := token.SignedString(key.signKey)
 	if err != nil {
 		return "", nil, fmt.Errorf("error signing token: %w", err)
 	}
@@ -30,15 +42,29 @@ func (maker *JWTMaker) CreateToken(id int64, email string, isAdmin bool, duratio
 	return tokenStr, claims, nil
 }
 
-func (maker *JWTMaker) VerifyToken(tokenStr string) (*UserClaims, error) {
+func (maker *JWTMaker) VerifyToken(tokenStr string, tokenType Type) (*UserClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
+		// Tokens issued before key IDs were introduced are checked against
+		// the current signing key.
+		key := maker.keys.signing
+		if kid, ok := token.Header["kid"]; ok {
+			kidStr, ok := kid.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid token key ID")
+			}
+
+			key, ok = maker.keys.lookup(kidStr)
+			if !ok {
+				return nil, fmt.Errorf("unknown token key ID %q", kidStr)
+			}
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("invalid token signing method")
 		}
 
-		return []byte(maker.secretKey), nil
-	})
+		return key.verifyKey, nil
+	}, jwt.WithValidMethods(maker.keys.methods))
 
 	if err != nil {
 		return nil, fmt.Errorf("error parsing token: %w", err)
@@ -49,5 +75,13 @@ func (maker *JWTMaker) VerifyToken(tokenStr string) (*UserClaims, error) {
 		return nil, fmt.Errorf("invalid token claims")
 	}
 
+	if err := claims.checkType(tokenType); err != nil {
+		return nil, err
+	}
+
 	return claims, nil
 }
+
+func (maker *JWTMaker) JWKS() JWKS {
+	return maker.keys.JWKS()
+}
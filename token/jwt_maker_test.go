@@ -0,0 +1,192 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func ecdsaKeyPEM(t *testing.T) (private, public []byte) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return marshalKeyPEM(t, k, &k.PublicKey)
+}
+
+func ed25519KeyPEM(t *testing.T) (private, public []byte) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return marshalKeyPEM(t, priv, pub)
+}
+
+func marshalKeyPEM(t *testing.T, priv, pub interface{}) ([]byte, []byte) {
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+}
+
+func newKeySet(t *testing.T, signing *Key, verification ...*Key) *KeySet {
+	ks, err := NewKeySet(signing, verification...)
+	require.NoError(t, err)
+	return ks
+}
+
+func parseKey(t *testing.T, id string, data []byte) *Key {
+	k, err := ParseKeyPEM(id, data)
+	require.NoError(t, err)
+	return k
+}
+
+func TestJWTMakerKeyTypes(t *testing.T) {
+	ecPriv, _ := ecdsaKeyPEM(t)
+	edPriv, _ := ed25519KeyPEM(t)
+
+	tcs := []struct {
+		name string
+		key  *Key
+		alg  string
+	}{
+		{name: "HS256", key: NewHMACKey("hmac", []byte("01234567890123456789012345678901")), alg: "HS256"},
+		{name: "ES256", key: parseKey(t, "ec", ecPriv), alg: "ES256"},
+		{name: "EdDSA", key: parseKey(t, "ed", edPriv), alg: "EdDSA"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			maker := NewJWTMakerWithKeys(newKeySet(t, tc.key))
+
+			tokenStr, claims, err := maker.CreateToken(1, "test@example.com", true, 1, AccessToken, time.Minute)
+			require.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(tokenStr, &UserClaims{})
+			require.NoError(t, err)
+			require.Equal(t, tc.alg, parsed.Method.Alg())
+			require.Equal(t, tc.key.ID, parsed.Header["kid"])
+
+			verified, err := maker.VerifyToken(tokenStr, AccessToken)
+			require.NoError(t, err)
+			require.Equal(t, claims.RegisteredClaims.ID, verified.RegisteredClaims.ID)
+			require.Equal(t, "test@example.com", verified.Email)
+			require.True(t, verified.IsAdmin)
+		})
+	}
+}
+
+func TestJWTMakerKeyRotation(t *testing.T) {
+	oldPriv, oldPub := ecdsaKeyPEM(t)
+	newPriv, _ := ed25519KeyPEM(t)
+
+	oldMaker := NewJWTMakerWithKeys(newKeySet(t, parseKey(t, "old", oldPriv)))
+	oldToken, _, err := oldMaker.CreateToken(1, "test@example.com", false, 1, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	rotated := NewJWTMakerWithKeys(newKeySet(t, parseKey(t, "new", newPriv), parseKey(t, "old", oldPub)))
+	newToken, _, err := rotated.CreateToken(1, "test@example.com", false, 1, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	_, err = rotated.VerifyToken(oldToken, AccessToken)
+	require.NoError(t, err)
+	_, err = rotated.VerifyToken(newToken, AccessToken)
+	require.NoError(t, err)
+
+	_, err = oldMaker.VerifyToken(newToken, AccessToken)
+	require.Error(t, err)
+
+	retired := NewJWTMakerWithKeys(newKeySet(t, parseKey(t, "new", newPriv)))
+	_, err = retired.VerifyToken(oldToken, AccessToken)
+	require.Error(t, err)
+}
+
+func TestLoadKeySetKeepsHMACKey(t *testing.T) {
+	secret := "01234567890123456789012345678901"
+	priv, _ := ecdsaKeyPEM(t)
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	require.NoError(t, os.WriteFile(path, priv, 0o600))
+
+	hmacKeys, err := LoadKeySet(secret, "", "default", "", "")
+	require.NoError(t, err)
+	hmacToken, _, err := NewJWTMakerWithKeys(hmacKeys).CreateToken(1, "test@example.com", false, 1, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	switched, err := LoadKeySet(secret, path, "ec", "default", "")
+	require.NoError(t, err)
+	maker := NewJWTMakerWithKeys(switched)
+
+	_, err = maker.VerifyToken(hmacToken, AccessToken)
+	require.NoError(t, err)
+
+	ecToken, _, err := maker.CreateToken(1, "test@example.com", false, 1, AccessToken, time.Minute)
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(ecToken, &UserClaims{})
+	require.NoError(t, err)
+	require.Equal(t, "ES256", parsed.Method.Alg())
+	require.Len(t, maker.JWKS().Keys, 1)
+
+	dropped, err := LoadKeySet(secret, path, "ec", "", "")
+	require.NoError(t, err)
+	_, err = NewJWTMakerWithKeys(dropped).VerifyToken(hmacToken, AccessToken)
+	require.Error(t, err)
+}
+
+func TestJWTMakerRejectsAlgorithmConfusion(t *testing.T) {
+	_, pub := ecdsaKeyPEM(t)
+	priv, _ := ecdsaKeyPEM(t)
+	maker := NewJWTMakerWithKeys(newKeySet(t, parseKey(t, "signing", priv), parseKey(t, "ec", pub)))
+
+	claims, err := NewUserClaims(1, "test@example.com", true, 1, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	// An HS256 token keyed with the public key PEM must not verify.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "ec"
+	tokenStr, err := forged.SignedString(pub)
+	require.NoError(t, err)
+
+	_, err = maker.VerifyToken(tokenStr, AccessToken)
+	require.Error(t, err)
+}
+
+func TestNewKeySet(t *testing.T) {
+	_, pub := ecdsaKeyPEM(t)
+
+	_, err := NewKeySet(parseKey(t, "public", pub))
+	require.Error(t, err)
+
+	hmac := NewHMACKey("dup", []byte("secret"))
+	_, err = NewKeySet(hmac, parseKey(t, "dup", pub))
+	require.Error(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	ecPriv, _ := ecdsaKeyPEM(t)
+	_, edPub := ed25519KeyPEM(t)
+
+	ks := newKeySet(t, parseKey(t, "ec", ecPriv), parseKey(t, "ed", edPub), NewHMACKey("hmac", []byte("secret")))
+	jwks := ks.JWKS()
+
+	require.Len(t, jwks.Keys, 2)
+	require.Equal(t, "ec", jwks.Keys[0].KeyID)
+	require.Equal(t, "EC", jwks.Keys[0].KeyType)
+	require.Equal(t, "P-256", jwks.Keys[0].Curve)
+	require.Equal(t, "ES256", jwks.Keys[0].Algorithm)
+	require.Len(t, jwks.Keys[0].X, 43)
+	require.Len(t, jwks.Keys[0].Y, 43)
+
+	require.Equal(t, "ed", jwks.Keys[1].KeyID)
+	require.Equal(t, "OKP", jwks.Keys[1].KeyType)
+	require.Equal(t, "Ed25519", jwks.Keys[1].Curve)
+	require.Equal(t, "EdDSA", jwks.Keys[1].Algorithm)
+}
@@ -0,0 +1,237 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is a named JWT signing or verification key. Keys parsed from a public
+// key PEM can only verify tokens.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// ParseKeyPEM accepts ECDSA (P-256, P-384, P-521) and Ed25519 keys, either
+// private keys in PKCS#8 or SEC 1 form or public keys in PKIX form.
+func ParseKeyPEM(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("error decoding PEM for key %q", id)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q for key %q", block.Type, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key %q: %w", id, err)
+	}
+
+	switch k := parsed.(type) {
+	case *ecdsa.PrivateKey:
+		method, err := ecdsaMethod(k.Curve)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing key %q: %w", id, err)
+		}
+		return &Key{ID: id, Method: method, signKey: k, verifyKey: &k.PublicKey}, nil
+	case *ecdsa.PublicKey:
+		method, err := ecdsaMethod(k.Curve)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing key %q: %w", id, err)
+		}
+		return &Key{ID: id, Method: method, verifyKey: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, signKey: k, verifyKey: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, verifyKey: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T for key %q", parsed, id)
+	}
+}
+
+func LoadKeyFile(id, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %w", err)
+	}
+
+	return ParseKeyPEM(id, data)
+}
+
+// LoadKeySet builds the key set of a service from its configuration: tokens
+// are signed with the key in signingKeyFile or, without one, with secretKey,
+// and verificationKeys is a comma separated kid=path list of further keys
+// accepted for verification. When signing with a key file, a non-empty
+// hmacKeyID keeps secretKey under that kid for verifying HS256 tokens issued
+// before the switch.
+func LoadKeySet(secretKey, signingKeyFile, signingKeyID, hmacKeyID, verificationKeys string) (*KeySet, error) {
+	signing := NewHMACKey(signingKeyID, []byte(secretKey))
+	var verification []*Key
+	if signingKeyFile != "" {
+		var err error
+		signing, err = LoadKeyFile(signingKeyID, signingKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if hmacKeyID != "" {
+			verification = append(verification, NewHMACKey(hmacKeyID, []byte(secretKey)))
+		}
+	}
+
+	for _, entry := range strings.Split(verificationKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kid, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid verification key %q, expected kid=path", entry)
+		}
+
+		k, err := LoadKeyFile(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		verification = append(verification, k)
+	}
+
+	return NewKeySet(signing, verification...)
+}
+
+func ecdsaMethod(curve elliptic.Curve) (jwt.SigningMethod, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	default:
+		return nil, fmt.Errorf("unsupported curve %s", curve.Params().Name)
+	}
+}
+
+func (k *Key) canSign() bool {
+	return k.signKey != nil
+}
+
+// KeySet holds the key new tokens are signed with together with every key
+// that is still accepted for verification, so that keys can be rotated
+// without invalidating tokens that are already issued.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	methods []string
+}
+
+func NewKeySet(signing *Key, verification ...*Key) (*KeySet, error) {
+	if signing == nil || !signing.canSign() {
+		return nil, fmt.Errorf("signing key must include a private key")
+	}
+
+	ks := &KeySet{
+		signing: signing,
+		keys:    make(map[string]*Key),
+	}
+
+	seen := make(map[string]bool)
+	for _, k := range append([]*Key{signing}, verification...) {
+		if _, ok := ks.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", k.ID)
+		}
+		ks.keys[k.ID] = k
+
+		if !seen[k.Method.Alg()] {
+			seen[k.Method.Alg()] = true
+			ks.methods = append(ks.methods, k.Method.Alg())
+		}
+	}
+
+	return ks, nil
+}
+
+func (ks *KeySet) lookup(kid string) (*Key, bool) {
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every asymmetric key in the set. HMAC
+// secrets are never published.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		if jwk, ok := toJWK(k); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+
+	return set
+}
+
+func toJWK(k *Key) (JWK, bool) {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Method.Alg()}
+
+	switch pub := k.verifyKey.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+		return jwk, true
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		return jwk, true
+	default:
+		return JWK{}, false
+	}
+}
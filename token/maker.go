@@ -0,0 +1,20 @@
+package token
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrWrongTokenType = errors.New("wrong token type")
+
+// Maker issues and verifies the tokens handed out at login. VerifyToken
+// rejects tokens of any type other than tokenType.
+type Maker interface {
+	CreateToken(id int64, email string, isAdmin bool, tenantID int64, tokenType Type, duration time.Duration) (string, *UserClaims, error)
+	VerifyToken(token string, tokenType Type) (*UserClaims, error)
+}
+
+var (
+	_ Maker = (*JWTMaker)(nil)
+	_ Maker = (*PasetoMaker)(nil)
+)
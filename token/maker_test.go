@@ -0,0 +1,132 @@
+package token
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testKey  = "0123456789abcdef0123456789abcdef"
+	otherKey = "fedcba9876543210fedcba9876543210"
+)
+
+func newPasetoMaker(t *testing.T, key string) *PasetoMaker {
+	maker, err := NewPasetoMaker(key)
+	require.NoError(t, err)
+	return maker
+}
+
+func TestMakers(t *testing.T) {
+	makers := []struct {
+		name  string
+		maker func(t *testing.T, key string) Maker
+	}{
+		{
+			name:  "jwt",
+			maker: func(t *testing.T, key string) Maker { return NewJWTMaker(key) },
+		},
+		{
+			name:  "paseto",
+			maker: func(t *testing.T, key string) Maker { return newPasetoMaker(t, key) },
+		},
+	}
+
+	for _, mk := range makers {
+		t.Run(mk.name, func(t *testing.T) {
+			maker := mk.maker(t, testKey)
+
+			t.Run("round trip", func(t *testing.T) {
+				tokenStr, claims, err := maker.CreateToken(7, "test@example.com", true, 3, AccessToken, time.Minute)
+				require.NoError(t, err)
+
+				got, err := maker.VerifyToken(tokenStr, AccessToken)
+				require.NoError(t, err)
+				require.Equal(t, int64(7), got.ID)
+				require.Equal(t, "test@example.com", got.Email)
+				require.Equal(t, "test@example.com", got.Subject)
+				require.True(t, got.IsAdmin)
+				require.Equal(t, int64(3), got.TenantID)
+				require.Equal(t, AccessToken, got.Type)
+				require.Equal(t, claims.Audience, got.Audience)
+				require.Equal(t, claims.RegisteredClaims.ID, got.RegisteredClaims.ID)
+				require.WithinDuration(t, claims.ExpiresAt.Time, got.ExpiresAt.Time, time.Second)
+				require.WithinDuration(t, claims.IssuedAt.Time, got.IssuedAt.Time, time.Second)
+			})
+
+			t.Run("expired", func(t *testing.T) {
+				tokenStr, _, err := maker.CreateToken(7, "test@example.com", false, 1, AccessToken, -time.Minute)
+				require.NoError(t, err)
+
+				_, err = maker.VerifyToken(tokenStr, AccessToken)
+				require.Error(t, err)
+			})
+
+			t.Run("tampered", func(t *testing.T) {
+				tokenStr, _, err := maker.CreateToken(7, "test@example.com", false, 1, AccessToken, time.Minute)
+				require.NoError(t, err)
+
+				i := len(tokenStr) / 2
+				c := byte('A')
+				if tokenStr[i] == c {
+					c = 'B'
+				}
+				tampered := tokenStr[:i] + string(c) + tokenStr[i+1:]
+
+				_, err = maker.VerifyToken(tampered, AccessToken)
+				require.Error(t, err)
+			})
+
+			t.Run("wrong type", func(t *testing.T) {
+				refreshStr, _, err := maker.CreateToken(7, "test@example.com", false, 1, RefreshToken, time.Minute)
+				require.NoError(t, err)
+
+				claims, err := maker.VerifyToken(refreshStr, RefreshToken)
+				require.NoError(t, err)
+				require.Equal(t, RefreshToken, claims.Type)
+
+				_, err = maker.VerifyToken(refreshStr, AccessToken)
+				require.ErrorIs(t, err, ErrWrongTokenType)
+
+				accessStr, _, err := maker.CreateToken(7, "test@example.com", false, 1, AccessToken, time.Minute)
+				require.NoError(t, err)
+
+				_, err = maker.VerifyToken(accessStr, RefreshToken)
+				require.ErrorIs(t, err, ErrWrongTokenType)
+			})
+
+			t.Run("wrong key", func(t *testing.T) {
+				tokenStr, _, err := mk.maker(t, otherKey).CreateToken(7, "test@example.com", false, 1, AccessToken, time.Minute)
+				require.NoError(t, err)
+
+				_, err = maker.VerifyToken(tokenStr, AccessToken)
+				require.Error(t, err)
+			})
+		})
+	}
+}
+
+func TestPasetoMakerRejectsOtherFormats(t *testing.T) {
+	tokenStr, _, err := NewJWTMaker(testKey).CreateToken(7, "test@example.com", false, 1, AccessToken, time.Minute)
+	require.NoError(t, err)
+
+	_, err = newPasetoMaker(t, testKey).VerifyToken(tokenStr, AccessToken)
+	require.Error(t, err)
+
+	pasetoStr, _, err := newPasetoMaker(t, testKey).CreateToken(7, "test@example.com", false, 1, AccessToken, time.Minute)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(pasetoStr, "v4.local."))
+
+	_, err = NewJWTMaker(testKey).VerifyToken(pasetoStr, AccessToken)
+	require.Error(t, err)
+}
+
+func TestNewPasetoMakerKeySize(t *testing.T) {
+	_, err := NewPasetoMaker("too-short")
+	require.Error(t, err)
+
+	_, err = NewPasetoMaker(testKey + "x")
+	require.Error(t, err)
+}
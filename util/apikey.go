@@ -0,0 +1,40 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// apiKeyTag starts every API key so that leaked keys are easy to recognise.
+const apiKeyTag = "mpk"
+
+// NewAPIKey returns a key of the form mpk_<prefix>_<secret> together with its
+// prefix and the hash that should be stored in its place.
+func NewAPIKey() (key, prefix, hash string, err error) {
+	p := make([]byte, 4)
+	if _, err := rand.Read(p); err != nil {
+		return "", "", "", fmt.Errorf("error generating api key prefix: %w", err)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", fmt.Errorf("error generating api key: %w", err)
+	}
+
+	prefix = hex.EncodeToString(p)
+	key = apiKeyTag + "_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(b)
+	return key, prefix, HashToken(key), nil
+}
+
+// APIKeyPrefix extracts the prefix of a key created by NewAPIKey.
+func APIKeyPrefix(key string) (string, bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyTag || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+
+	return parts[1], true
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	key, prefix, hash, err := NewAPIKey()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(key, "mpk_"+prefix+"_"))
+	require.Len(t, prefix, 8)
+	require.Equal(t, HashToken(key), hash)
+
+	p, ok := APIKeyPrefix(key)
+	require.True(t, ok)
+	require.Equal(t, prefix, p)
+
+	other, _, _, err := NewAPIKey()
+	require.NoError(t, err)
+	require.NotEqual(t, key, other)
+}
+
+func TestAPIKeyPrefix(t *testing.T) {
+	for _, key := range []string{"", "mpk", "mpk_abc", "mpk__secret", "mpk_abc_", "xyz_abc_secret"} {
+		_, ok := APIKeyPrefix(key)
+		require.False(t, ok, key)
+	}
+}
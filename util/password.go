@@ -1,13 +1,98 @@
 package util
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+var (
+	ErrPasswordMismatch      = errors.New("password does not match")
+	ErrUnknownPasswordFormat = errors.New("unknown password hash format")
+)
+
+// PasswordHasher hashes passwords into self-describing strings. Every hasher
+// can check hashes made by any other, so that the algorithm and its cost can
+// be changed without invalidating stored passwords.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether hash was made with another algorithm or
+	// other parameters than Hash would use now.
+	NeedsRehash(hash string) bool
+}
+
+// Argon2idParams are recorded in every hash in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>.
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follow the second recommendation of RFC 9106.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Validate rejects parameters that would make hashes too cheap to compute
+// or too short to be safe.
+func (p Argon2idParams) Validate() error {
+	if p.Memory == 0 {
+		return errors.New("memory must be positive")
+	}
+	if p.Iterations == 0 {
+		return errors.New("iterations must be positive")
+	}
+	if p.Parallelism == 0 {
+		return errors.New("parallelism must be positive")
+	}
+	if p.SaltLength < 16 {
+		return fmt.Errorf("salt length must be at least 16 bytes, got %d", p.SaltLength)
+	}
+	if p.KeyLength < 16 {
+		return fmt.Errorf("key length must be at least 16 bytes, got %d", p.KeyLength)
+	}
+
+	return nil
+}
+
+type Argon2idHasher struct {
+	Params Argon2idParams
+}
+
+func (a Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error generating salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Params.Iterations, a.Params.Memory, a.Params.Parallelism, a.Params.KeyLength)
+	return encodeArgon2id(a.Params, salt, key), nil
+}
+
+func (a Argon2idHasher) NeedsRehash(hash string) bool {
+	p, _, _, err := decodeArgon2id(hash)
+	return err != nil || p != a.Params
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (b BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
 	if err != nil {
 		return "", fmt.Errorf("error hashing password %w", err)
 	}
@@ -15,6 +100,82 @@ func HashPassword(password string) (string, error) {
 	return string(hashed), nil
 }
 
+func (b BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.Cost
+}
+
+// DefaultPasswordHasher is used by HashPassword.
+var DefaultPasswordHasher PasswordHasher = Argon2idHasher{Params: DefaultArgon2idParams}
+
+func HashPassword(password string) (string, error) {
+	return DefaultPasswordHasher.Hash(password)
+}
+
+// CheckPassword compares password with an argon2id or bcrypt hash.
 func CheckPassword(password, hashedPassword string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	if strings.HasPrefix(hashedPassword, "$argon2id$") {
+		p, salt, key, err := decodeArgon2id(hashedPassword)
+		if err != nil {
+			return err
+		}
+
+		other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	}
+
+	if _, err := bcrypt.Cost([]byte(hashedPassword)); err == nil {
+		return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	}
+
+	return ErrUnknownPasswordFormat
+}
+
+var argon2Encoding = base64.RawStdEncoding
+
+func encodeArgon2id(p Argon2idParams, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		argon2Encoding.EncodeToString(salt), argon2Encoding.EncodeToString(key))
+}
+
+func decodeArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	var p Argon2idParams
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrUnknownPasswordFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, fmt.Errorf("error parsing argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, fmt.Errorf("error parsing argon2id parameters: %w", err)
+	}
+	if p.Iterations == 0 || p.Parallelism == 0 {
+		return p, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	salt, err := argon2Encoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("error decoding argon2id salt: %w", err)
+	}
+
+	key, err := argon2Encoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("error decoding argon2id key: %w", err)
+	}
+
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
 }
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2idParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	h := Argon2idHasher{Params: testArgon2idParams}
+
+	hash, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	require.NoError(t, CheckPassword("correct horse", hash))
+	require.ErrorIs(t, CheckPassword("wrong horse", hash), ErrPasswordMismatch)
+	require.False(t, h.NeedsRehash(hash))
+
+	other, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	require.NotEqual(t, hash, other)
+
+	stronger := testArgon2idParams
+	stronger.Iterations = 2
+	require.True(t, Argon2idHasher{Params: stronger}.NeedsRehash(hash))
+	require.True(t, BcryptHasher{Cost: bcrypt.MinCost}.NeedsRehash(hash))
+}
+
+func TestBcryptHasher(t *testing.T) {
+	h := BcryptHasher{Cost: bcrypt.MinCost}
+
+	hash, err := h.Hash("correct horse")
+	require.NoError(t, err)
+
+	require.NoError(t, CheckPassword("correct horse", hash))
+	require.Error(t, CheckPassword("wrong horse", hash))
+	require.False(t, h.NeedsRehash(hash))
+	require.True(t, BcryptHasher{Cost: bcrypt.MinCost + 1}.NeedsRehash(hash))
+	require.True(t, Argon2idHasher{Params: testArgon2idParams}.NeedsRehash(hash))
+}
+
+func TestArgon2idParamsValidate(t *testing.T) {
+	require.NoError(t, DefaultArgon2idParams.Validate())
+	require.NoError(t, testArgon2idParams.Validate())
+
+	for _, change := range []func(*Argon2idParams){
+		func(p *Argon2idParams) { p.Memory = 0 },
+		func(p *Argon2idParams) { p.Iterations = 0 },
+		func(p *Argon2idParams) { p.Parallelism = 0 },
+		func(p *Argon2idParams) { p.SaltLength = 8 },
+		func(p *Argon2idParams) { p.KeyLength = 8 },
+	} {
+		p := DefaultArgon2idParams
+		change(&p)
+		require.Error(t, p.Validate(), "%+v", p)
+	}
+}
+
+func TestCheckPasswordInvalidHash(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2id$v=19$m=1024,t=1,p=1$salt",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=0$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+	} {
+		require.Error(t, CheckPassword("password", hash), hash)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	p := PasswordPolicy{MinLength: 10, MaxLength: 20, RejectCommon: true}
+
+	require.NoError(t, p.Validate("correct horse battery"[:20]))
+	require.ErrorIs(t, p.Validate("short"), ErrPasswordTooShort)
+	require.ErrorIs(t, p.Validate(strings.Repeat("x", 21)), ErrPasswordTooLong)
+	require.ErrorIs(t, p.Validate("Password123"), ErrPasswordTooCommon)
+	require.ErrorIs(t, p.Validate("1234567890"), ErrPasswordTooCommon)
+	require.NoError(t, p.Validate("äöüäöüäöüä"))
+
+	require.NoError(t, PasswordPolicy{}.Validate("password"))
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, p := range strings.Fields(commonPasswordList) {
+		m[strings.ToLower(p)] = true
+	}
+	return m
+}()
+
+var (
+	ErrPasswordTooShort  = errors.New("password too short")
+	ErrPasswordTooLong   = errors.New("password too long")
+	ErrPasswordTooCommon = errors.New("password too common")
+)
+
+// PasswordPolicy is checked against new passwords. Lengths are counted in
+// characters and zero values disable the respective check.
+type PasswordPolicy struct {
+	MinLength    int
+	MaxLength    int
+	RejectCommon bool
+}
+
+func (p PasswordPolicy) Validate(password string) error {
+	n := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && n < p.MinLength {
+		return fmt.Errorf("%w: at least %d characters required", ErrPasswordTooShort, p.MinLength)
+	}
+	if p.MaxLength > 0 && n > p.MaxLength {
+		return fmt.Errorf("%w: at most %d characters allowed", ErrPasswordTooLong, p.MaxLength)
+	}
+	if p.RejectCommon && commonPasswords[strings.ToLower(password)] {
+		return ErrPasswordTooCommon
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 with 8 digits.
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	for ts, code := range vectors {
+		require.Equal(t, code, hotp(key, uint64(ts/30), 8), "time %d", ts)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := TOTPCode(secret, now)
+	require.NoError(t, err)
+	require.Len(t, code, 6)
+
+	valid := func(code string, t time.Time, lastStep int64) bool {
+		_, ok := ValidateTOTP(secret, code, t, lastStep)
+		return ok
+	}
+	require.True(t, valid(code, now, 0))
+	require.True(t, valid(code, now.Add(30*time.Second), 0))
+	require.True(t, valid(code, now.Add(-30*time.Second), 0))
+	require.False(t, valid(code, now.Add(90*time.Second), 0))
+	require.False(t, valid("000000"+code, now, 0))
+
+	_, ok := ValidateTOTP("not base32!", code, now, 0)
+	require.False(t, ok)
+
+	rfcSecret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	step, ok := ValidateTOTP(rfcSecret, "287082", time.Unix(59, 0), 0)
+	require.True(t, ok)
+	require.Equal(t, int64(1), step)
+}
+
+func TestValidateTOTPReplay(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := TOTPCode(secret, now)
+	require.NoError(t, err)
+
+	step, ok := ValidateTOTP(secret, code, now, 0)
+	require.True(t, ok)
+	require.Equal(t, now.Unix()/30, step)
+
+	// The same code stays within the drift window for another period, but
+	// once its step is recorded it must not be accepted again.
+	_, ok = ValidateTOTP(secret, code, now, step)
+	require.False(t, ok)
+	_, ok = ValidateTOTP(secret, code, now.Add(30*time.Second), step)
+	require.False(t, ok)
+
+	next, err := TOTPCode(secret, now.Add(30*time.Second))
+	require.NoError(t, err)
+	_, ok = ValidateTOTP(secret, next, now.Add(30*time.Second), step)
+	require.True(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("micropanel", "test@example.com", "JBSWY3DPEHPK3PXP")
+	require.True(t, strings.HasPrefix(uri, "otpauth://totp/micropanel:test@example.com?"))
+	require.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	require.Contains(t, uri, "issuer=micropanel")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	code, err := NewRecoveryCode()
+	require.NoError(t, err)
+	require.Len(t, code, 11)
+	require.Equal(t, byte('-'), code[5])
+
+	require.Equal(t, code, NormalizeRecoveryCode(strings.ToUpper(code)))
+	require.Equal(t, code, NormalizeRecoveryCode(" "+strings.ReplaceAll(code, "-", "")+" "))
+}